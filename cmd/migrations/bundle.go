@@ -0,0 +1,101 @@
+// Copyright 2021 The Swarm Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package migrations
+
+import (
+	"fmt"
+
+	"github.com/ethersphere/bee-repair/internal/exporter"
+	cmdfile "github.com/ethersphere/bee-repair/pkg/file"
+	"github.com/spf13/cobra"
+)
+
+var bundleDst string // flag variable, bundle written by export-bundle
+
+var exportBundle = &cobra.Command{
+	Use:   "export-bundle <reference>",
+	Short: "Export the chunks of a reference as a single bundle file",
+	Long: `Traverses a file or directory reference, e.g. a repaired manifest, and writes the chunks reachable from it to a bundle file along with the reference, to move the content to a node of another network with import-bundle. The chunks are read through the api.
+
+Example:
+
+	$ bee-repair himalaya export-bundle 94434d3312320fab70428c39b79dffb4abc3dbedf3e1562384a61ceaf8a7e36b --destination-file site.bundle
+
+Unlike export-reference the bundle is not a tar archive and every chunk must be readable.`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		addr, err := parseReference(args[0])
+		if err != nil {
+			return err
+		}
+		dst := bundleDst
+		if dst == "" {
+			dst = addr.String() + ".bundle"
+		}
+
+		opts := []exporter.Option{
+			exporter.WithVerifyChunks(verify, false),
+			exporter.WithLogger(logger),
+		}
+		if !scriptOutput() {
+			progress := newPercentUpdater(cmd)
+			progress.start(cmd.Context())
+			opts = append(opts, exporter.WithProgressUpdater(progress))
+		}
+
+		err = exporter.ExportBundle(cmd.Context(), cmdfile.NewAPIStore(host, port, ssl, storeOpts...), addr, dst, opts...)
+		if err != nil {
+			return err
+		}
+		return printResult(
+			cmd,
+			fmt.Sprintf("Exported bundle to %s", dst),
+			dst,
+			exportOutput{DestinationFile: dst},
+		)
+	},
+}
+
+var importBundle = &cobra.Command{
+	Use:   "import-bundle <bundle>",
+	Short: "Upload the chunks of a bundle file",
+	Long: `Uploads the chunks of a bundle written by export-bundle through the api and prints the reference recorded in the bundle, which serves the content once all of its chunks are uploaded.
+
+Example:
+
+	$ bee-repair himalaya import-bundle site.bundle --postage-batch 6ad6f23dcdd2dffc2a0b2e1d4dd7bd5f4ed2a78a0e7d61e83c2f7a0e35fd1b3c
+	> Imported reference 94434d3312320fab70428c39b79dffb4abc3dbedf3e1562384a61ceaf8a7e36b`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		opts := []exporter.Option{
+			exporter.WithVerifyChunks(verify, false),
+			exporter.WithLogger(logger),
+		}
+		if !scriptOutput() {
+			progress := newPercentUpdater(cmd)
+			progress.start(cmd.Context())
+			opts = append(opts, exporter.WithProgressUpdater(progress))
+		}
+
+		root, err := exporter.ImportBundle(cmd.Context(), args[0], cmdfile.NewAPIStore(host, port, ssl, storeOpts...), opts...)
+		if err != nil {
+			return err
+		}
+		return printReference(cmd, "Imported reference ", root)
+	},
+}
+
+func addBundleCommands(root *cobra.Command) {
+	addAPIFlags(exportBundle)
+	exportBundle.Flags().StringVar(&bundleDst, "destination-file", "", "bundle file to create, named after the reference by default")
+	exportBundle.Flags().BoolVar(&verify, "verify", false, "verify that chunk data matches the chunk address")
+	root.AddCommand(exportBundle)
+
+	addAPIFlags(importBundle)
+	importBundle.Flags().StringVar(&postBatch, "postage-batch", "", "id of the postage batch the uploaded chunks are stamped with")
+	importBundle.Flags().BoolVar(&verify, "verify", false, "verify that chunk data matches the chunk address before it is uploaded")
+	addLinkFlags(importBundle)
+	root.AddCommand(importBundle)
+}
@@ -0,0 +1,108 @@
+// Copyright 2021 The Swarm Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package migrations
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v2"
+)
+
+// defaultConfigName is the configuration file in the home directory which is read
+// when no other one is given
+const defaultConfigName = ".bee-repair.yaml"
+
+var configFile string // flag variable, yaml file with the defaults of the flags
+
+// envFlags are the environment variables read as defaults of the api flags
+var envFlags = map[string]string{
+	"BEE_API_HOST": "host",
+	"BEE_API_PORT": "port",
+	"BEE_API_SSL":  "ssl",
+}
+
+// applyConfig sets the flags of the command which are not given on the command
+// line to the values of the configuration file, keyed by the flag names. Keys of
+// flags the command does not have are ignored, so that one file can serve all the
+// commands. A missing default file is not an error
+func applyConfig(cmd *cobra.Command) error {
+	fname := configFile
+	if fname == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return nil
+		}
+		fname = filepath.Join(home, defaultConfigName)
+	}
+	b, err := ioutil.ReadFile(fname)
+	if err != nil {
+		if configFile == "" && os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+
+	var values map[string]interface{}
+	if err := yaml.Unmarshal(b, &values); err != nil {
+		return fmt.Errorf("invalid config file %s: %w", fname, err)
+	}
+	for name, v := range values {
+		f := cmd.Flags().Lookup(name)
+		if f == nil || f.Changed {
+			continue
+		}
+		if err := setConfigValue(f.Value, v); err != nil {
+			return fmt.Errorf("config file %s: invalid value of %s: %w", fname, name, err)
+		}
+	}
+	return nil
+}
+
+// setConfigValue sets the flag value to the value of the configuration file. The
+// elements of a list replace the default of a slice flag, as if every one of them
+// was given on the command line
+func setConfigValue(value interface{ Set(string) error }, v interface{}) error {
+	list, ok := v.([]interface{})
+	if !ok {
+		return value.Set(fmt.Sprint(v))
+	}
+	elems := make([]string, len(list))
+	for i, e := range list {
+		elems[i] = fmt.Sprint(e)
+	}
+	if s, ok := value.(interface{ Replace([]string) error }); ok {
+		return s.Replace(elems)
+	}
+	for _, e := range elems {
+		if err := value.Set(e); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// applyEnv sets the api flags of the command which are not given on the command
+// line to the values of the environment variables which are set. It is applied
+// after the configuration file, so that the environment overrides the file
+func applyEnv(cmd *cobra.Command) error {
+	for env, name := range envFlags {
+		v, ok := os.LookupEnv(env)
+		if !ok || v == "" {
+			continue
+		}
+		f := cmd.Flags().Lookup(name)
+		if f == nil || f.Changed {
+			continue
+		}
+		if err := f.Value.Set(v); err != nil {
+			return fmt.Errorf("invalid value of %s: %w", env, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,112 @@
+// Copyright 2021 The Swarm Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package migrations
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/spf13/cobra"
+)
+
+func TestApplyConfig(t *testing.T) {
+	fname := filepath.Join(t.TempDir(), "config.yaml")
+	data := "host: node.example.com\nport: 1733\nssl: true\nunknown: 1\n"
+	if err := ioutil.WriteFile(fname, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	var (
+		host string
+		port int
+		ssl  bool
+	)
+	cmd := &cobra.Command{Use: "test"}
+	cmd.Flags().StringVar(&host, "host", "127.0.0.1", "")
+	cmd.Flags().IntVar(&port, "port", 1633, "")
+	cmd.Flags().BoolVar(&ssl, "ssl", false, "")
+	if err := cmd.ParseFlags([]string{"--port", "1833"}); err != nil {
+		t.Fatal(err)
+	}
+
+	configFile = fname
+	defer func() { configFile = "" }()
+	if err := applyConfig(cmd); err != nil {
+		t.Fatal(err)
+	}
+	// the command line overrides the file
+	if host != "node.example.com" || port != 1833 || !ssl {
+		t.Fatalf("unexpected flags host %s port %d ssl %t", host, port, ssl)
+	}
+
+	// the elements of a list are the values of a slice flag
+	fname = filepath.Join(t.TempDir(), "list.yaml")
+	data = "exclude: [.DS_Store, '*.tmp']\n"
+	if err := ioutil.WriteFile(fname, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+	var excludes []string
+	cmd = &cobra.Command{Use: "test"}
+	cmd.Flags().StringSliceVar(&excludes, "exclude", []string{"default"}, "")
+	configFile = fname
+	if err := applyConfig(cmd); err != nil {
+		t.Fatal(err)
+	}
+	if len(excludes) != 2 || excludes[0] != ".DS_Store" || excludes[1] != "*.tmp" {
+		t.Fatalf("unexpected excludes %q", excludes)
+	}
+
+	configFile = filepath.Join(t.TempDir(), "missing.yaml")
+	if err := applyConfig(cmd); err == nil {
+		t.Fatal("expected error for missing config file")
+	}
+}
+
+func TestApplyEnv(t *testing.T) {
+	for env, v := range map[string]string{
+		"BEE_API_HOST": "node.example.com",
+		"BEE_API_PORT": "1733",
+		"BEE_API_SSL":  "true",
+	} {
+		old, ok := os.LookupEnv(env)
+		if err := os.Setenv(env, v); err != nil {
+			t.Fatal(err)
+		}
+		defer func(env string) {
+			if ok {
+				os.Setenv(env, old)
+			} else {
+				os.Unsetenv(env)
+			}
+		}(env)
+	}
+
+	var (
+		host string
+		port int
+		ssl  bool
+	)
+	cmd := &cobra.Command{Use: "test"}
+	cmd.Flags().StringVar(&host, "host", "127.0.0.1", "")
+	cmd.Flags().IntVar(&port, "port", 1633, "")
+	cmd.Flags().BoolVar(&ssl, "ssl", false, "")
+	if err := cmd.ParseFlags([]string{"--port", "1833"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := applyEnv(cmd); err != nil {
+		t.Fatal(err)
+	}
+	// the command line overrides the environment
+	if host != "node.example.com" || port != 1833 || !ssl {
+		t.Fatalf("unexpected flags host %s port %d ssl %t", host, port, ssl)
+	}
+
+	os.Setenv("BEE_API_SSL", "maybe")
+	if err := applyEnv(cmd); err == nil {
+		t.Fatal("expected error for invalid BEE_API_SSL")
+	}
+}
@@ -0,0 +1,202 @@
+// Copyright 2021 The Swarm Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package migrations
+
+import (
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/ethersphere/bee-repair/internal/repair"
+	"github.com/ethersphere/bee/pkg/swarm"
+	"github.com/spf13/cobra"
+)
+
+const (
+	csvTypeFile      = "file"
+	csvTypeDirectory = "directory"
+
+	// columns of the csv rows
+	csvReference = 0
+	csvType      = 1
+	csvNewRef    = 2
+)
+
+// csvRow is a row of the csv file of a batch repair
+type csvRow struct {
+	addr   swarm.Address
+	repair repairFunc
+	fields []string
+}
+
+var repairCSV = &cobra.Command{
+	Use:   "csv <csv file>",
+	Short: "Repair the references listed with their type in a csv file",
+	Long: `Reads a csv file of reference,type rows, where the type is either file or directory, repairs every reference as its type says and writes the new reference back to the file as a third new_reference column.
+
+Example:
+
+	$ cat refs.csv
+	reference,type
+	2387e8e7d8a48c2a9339c97c1dc3461a9a7aa07e994c5cb8b38fd7c1b3e6ea48,directory
+	94434d3312320fab70428c39b79dffb4abc3dbedf3e1562384a61ceaf8a7e36b,file
+	$ bee-repair himalaya csv refs.csv
+
+The header row is optional. The types of all the rows are checked before any repair starts. Rows which already have a new reference are left as they are, so the command can be run again after a failure until it succeeds.`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return withMappingDB(func() error {
+			return repairCSVFile(cmd, args[0])
+		})
+	},
+}
+
+// readCSV reads the rows of the csv file, with the header row if any, and checks
+// their references and types
+func readCSV(fname string) (header []string, rows []*csvRow, err error) {
+	f, err := os.Open(fname)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer f.Close()
+
+	r := csv.NewReader(f)
+	r.FieldsPerRecord = -1
+	r.TrimLeadingSpace = true
+	records, err := r.ReadAll()
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(records) > 0 && strings.EqualFold(records[0][csvReference], "reference") {
+		header, records = records[0], records[1:]
+		if len(header) < 2 {
+			return nil, nil, fmt.Errorf("%s:1: expected reference,type header", fname)
+		}
+	}
+
+	for i, fields := range records {
+		line := i + 1
+		if header != nil {
+			line++
+		}
+		if len(fields) < 2 || len(fields) > 3 {
+			return nil, nil, fmt.Errorf("%s:%d: expected reference,type[,new_reference], got %d columns", fname, line, len(fields))
+		}
+		addr, err := parseReference(fields[csvReference])
+		if err != nil {
+			return nil, nil, fmt.Errorf("%s:%d: %w", fname, line, err)
+		}
+		row := &csvRow{addr: addr, fields: fields}
+		switch strings.ToLower(strings.TrimSpace(fields[csvType])) {
+		case csvTypeFile:
+			row.repair = repairFileReference
+		case csvTypeDirectory:
+			row.repair = repairDirectoryReference
+		default:
+			return nil, nil, fmt.Errorf("%s:%d: invalid type %q, expected %s or %s", fname, line, fields[csvType], csvTypeFile, csvTypeDirectory)
+		}
+		rows = append(rows, row)
+	}
+	return header, rows, nil
+}
+
+// writeCSV replaces the csv file with the rows, each of them with the new reference
+// column
+func writeCSV(fname string, header []string, rows []*csvRow) error {
+	tmp := fname + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	w := csv.NewWriter(f)
+	if header != nil {
+		w.Write(append(header[:csvNewRef:csvNewRef], "new_reference"))
+	}
+	for _, row := range rows {
+		w.Write(row.record())
+	}
+	w.Flush()
+	if err := w.Error(); err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, fname)
+}
+
+// record returns the fields of the row with the new reference column
+func (r *csvRow) record() []string {
+	if len(r.fields) > csvNewRef {
+		return r.fields
+	}
+	return append(r.fields, "")
+}
+
+// newReference returns the new reference of the row, if it was repaired
+func (r *csvRow) newReference() string {
+	if len(r.fields) > csvNewRef {
+		return strings.TrimSpace(r.fields[csvNewRef])
+	}
+	return ""
+}
+
+func (r *csvRow) setNewReference(addr swarm.Address) {
+	r.fields = r.record()
+	r.fields[csvNewRef] = addr.String()
+}
+
+// repairCSVFile repairs the references of the csv file which have no new reference
+// yet and writes the new references back to it
+func repairCSVFile(cmd *cobra.Command, fname string) (err error) {
+	header, rows, err := readCSV(fname)
+	if err != nil {
+		return err
+	}
+
+	batchRepair = true
+	defer func() { batchRepair = false }()
+
+	// the new references are kept even when the repair is interrupted
+	defer func() {
+		if werr := writeCSV(fname, header, rows); err == nil {
+			err = werr
+		}
+	}()
+
+	repaired, failed := 0, 0
+	for _, row := range rows {
+		if row.newReference() != "" {
+			continue
+		}
+		filePath = ""
+		newReference, err := row.repair(cmd, row.addr)
+		if errors.Is(err, context.Canceled) {
+			cmd.PrintErrf("Interrupted after repairing %d references\n", repaired)
+			return err
+		}
+		var skipped *repair.SkippedError
+		if err != nil && !errors.As(err, &skipped) {
+			cmd.PrintErrf("Failed repairing %s: %v\n", row.addr, err)
+			failed++
+			continue
+		}
+		row.setNewReference(newReference)
+		repaired++
+	}
+	if failed > 0 {
+		return fmt.Errorf("failed repairing %d of %d references, run again to retry them", failed, repaired+failed)
+	}
+	if !scriptOutput() {
+		cmd.Printf("Repaired %d references\n", repaired)
+	}
+	return nil
+}
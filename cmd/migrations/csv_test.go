@@ -0,0 +1,64 @@
+// Copyright 2021 The Swarm Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package migrations
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/ethersphere/bee/pkg/swarm"
+)
+
+func TestCSV(t *testing.T) {
+	const (
+		fileRef = "2387e8e7d8a48c2a9339c97c1dc3461a9a7aa07e994c5cb8b38fd7c1b3e6ea48"
+		dirRef  = "94434d3312320fab70428c39b79dffb4abc3dbedf3e1562384a61ceaf8a7e36b"
+	)
+	dir := t.TempDir()
+	fname := filepath.Join(dir, "refs.csv")
+	data := "reference,type\n" + fileRef + ",file\n" + dirRef + ",Directory," + fileRef + "\n"
+	if err := ioutil.WriteFile(fname, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	header, rows, err := readCSV(fname)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(header) != 2 || len(rows) != 2 {
+		t.Fatalf("unexpected header %v and %d rows", header, len(rows))
+	}
+	if rows[0].newReference() != "" || rows[1].newReference() != fileRef {
+		t.Fatalf("unexpected new references %q %q", rows[0].newReference(), rows[1].newReference())
+	}
+
+	rows[0].setNewReference(swarm.MustParseHexAddress(dirRef))
+	if err := writeCSV(fname, header, rows); err != nil {
+		t.Fatal(err)
+	}
+	b, err := ioutil.ReadFile(fname)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "reference,type,new_reference\n" + fileRef + ",file," + dirRef + "\n" + dirRef + ",Directory," + fileRef + "\n"
+	if string(b) != want {
+		t.Fatalf("got %q, want %q", b, want)
+	}
+
+	for _, invalid := range []string{
+		fileRef + ",symlink\n",
+		fileRef + "\n",
+		"not a reference,file\n",
+	} {
+		fname := filepath.Join(dir, "invalid.csv")
+		if err := ioutil.WriteFile(fname, []byte(invalid), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if _, _, err := readCSV(fname); err == nil {
+			t.Fatalf("expected error for %q", invalid)
+		}
+	}
+}
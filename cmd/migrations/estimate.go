@@ -0,0 +1,142 @@
+// Copyright 2021 The Swarm Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package migrations
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+
+	"github.com/ethersphere/bee-repair/internal/repair"
+	"github.com/ethersphere/bee/pkg/swarm"
+	"github.com/spf13/cobra"
+)
+
+// minBatchDepth is the smallest depth of a postage batch bee accepts, one more
+// than its bucket depth
+const minBatchDepth = 17
+
+var chunkPrice uint64 // flag variable, price of stamping a single chunk
+
+type estimateOutput struct {
+	Reference    string `json:"reference"`
+	NewReference string `json:"new_reference"`
+	Chunks       int    `json:"chunks"`
+	Bytes        int64  `json:"bytes"`
+	BatchDepth   int    `json:"batch_depth"`
+	Cost         string `json:"cost,omitempty"`
+	BatchCost    string `json:"batch_cost,omitempty"`
+}
+
+var estimateRepair = &cobra.Command{
+	Use:   "estimate <reference>",
+	Short: "Count the chunks a repair would store and estimate their postage",
+	Long: `Runs the repair of a file or directory reference without storing anything and prints the number of new chunks it would write, the smallest postage batch depth which holds them and, with --chunk-price, what stamping them costs.
+
+Only the new manifest is written by a repair, the chunks of the files exist already, so this is the number of chunks the postage batch of the repair needs room for.
+
+Example:
+
+	$ bee-repair himalaya estimate 2387e8e7d8a48c2a9339c97c1dc3461a9a7aa07e994c5cb8b38fd7c1b3e6ea48 --chunk-price 10000
+	> New chunks: 12 (49152 bytes)
+	> Smallest batch depth: 17
+	> Estimated cost: 120000 for the chunks, 1310720000 for a batch of depth 17
+
+The price is given in the smallest unit of the token, e.g. PLUR, and the costs are in the same unit. The repair options which change the new manifest, such as --encrypt or --prefix, are taken into account.`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		addr, err := parseReference(args[0])
+		if err != nil {
+			return err
+		}
+		return estimate(cmd, addr)
+	},
+}
+
+// estimate dry runs the repair of the reference and prints the chunks it stores
+func estimate(cmd *cobra.Command, addr swarm.Address) error {
+	if err := ensureExists(cmd, addr); err != nil {
+		return err
+	}
+	roots, err := repair.FindRoots(cmd.Context(), []swarm.Address{addr}, storeOption())
+	if err != nil {
+		return err
+	}
+	if len(roots) == 0 {
+		return fmt.Errorf("reference %s: %w", addr, repair.ErrNotOldFormat)
+	}
+
+	opts := []repair.Option{
+		storeOption(),
+		repair.WithLogger(logger),
+		repair.WithEncryption(encrypted),
+		repair.WithSkipErrors(skipErrors),
+		repair.WithPathPrefix(pathPrefix),
+		repair.WithIndexDocument(indexDoc),
+		repair.WithErrorDocument(errorDoc),
+		repair.WithMetadataLimit(mtdtLimit),
+		repair.WithDirectoryMetadata(dirMtdt),
+		repair.WithMimeOverrides(mimeTypes),
+		repair.WithManifestFlushInterval(flushEvery),
+		repair.WithExcludeGlobs(excludes),
+		symlinkOpt,
+	}
+	res, err := repair.DryRun(cmd.Context(), addr, roots[0].Directory, opts...)
+	var skipped *repair.SkippedError
+	if errors.As(err, &skipped) {
+		for _, f := range skipped.Files {
+			cmd.PrintErrln("Skipped " + f.Error())
+		}
+	} else if err != nil {
+		return err
+	}
+
+	out := estimateOutput{
+		Reference:    addr.String(),
+		NewReference: res.Reference.String(),
+		Chunks:       res.Chunks,
+		Bytes:        res.Bytes,
+		BatchDepth:   batchDepth(res.Chunks),
+	}
+	var msg strings.Builder
+	fmt.Fprintf(&msg, "New chunks: %d (%d bytes)\n", out.Chunks, out.Bytes)
+	fmt.Fprintf(&msg, "Smallest batch depth: %d", out.BatchDepth)
+	if chunkPrice > 0 {
+		price := new(big.Int).SetUint64(chunkPrice)
+		out.Cost = new(big.Int).Mul(price, big.NewInt(int64(out.Chunks))).String()
+		out.BatchCost = new(big.Int).Lsh(price, uint(out.BatchDepth)).String()
+		fmt.Fprintf(&msg, "\nEstimated cost: %s for the chunks, %s for a batch of depth %d", out.Cost, out.BatchCost, out.BatchDepth)
+	}
+	return printResult(cmd, msg.String(), fmt.Sprint(out.Chunks), out)
+}
+
+// batchDepth returns the smallest depth of a postage batch with room for the
+// chunks, assuming they fill its buckets evenly
+func batchDepth(chunks int) int {
+	depth := minBatchDepth
+	for int64(1)<<depth < int64(chunks) {
+		depth++
+	}
+	return depth
+}
+
+func addEstimateCommand(root *cobra.Command) {
+	addAPIFlags(estimateRepair)
+	estimateRepair.Flags().Uint64Var(&chunkPrice, "chunk-price", 0, "price of stamping a single chunk in the smallest unit of the token, e.g. PLUR, 0 prints no cost")
+	estimateRepair.Flags().BoolVar(&encrypted, "encrypt", false, "use encryption")
+	estimateRepair.Flags().StringVar(&indexDoc, "index-document", "", "index document of the new manifest, overrides the one of the old entry")
+	estimateRepair.Flags().StringVar(&errorDoc, "error-document", "", "error document of the new manifest, overrides the one of the old entry")
+	estimateRepair.Flags().Int64Var(&mtdtLimit, "metadata-limit", 0, "maximum size in bytes of the old metadata, 0 means the default of 16 chunks")
+	estimateRepair.Flags().StringVar(&mimeMap, "mime-map", "", "json file mapping file extensions to the content types they are served with")
+	estimateRepair.Flags().BoolVar(&skipErrors, "skip-errors", false, "leave out the files which cannot be repaired")
+	estimateRepair.Flags().StringVar(&pathPrefix, "prefix", "", "repair only the files whose path starts with the prefix")
+	estimateRepair.Flags().BoolVar(&dirMtdt, "directory-metadata", false, "carry over the metadata of the subdirectories, not only of the root")
+	estimateRepair.Flags().IntVar(&flushEvery, "flush-interval", 0, "store the new manifest every this many files, the chunks of the flushes are counted too")
+	estimateRepair.Flags().StringSliceVar(&excludes, "exclude", nil, "glob pattern of the files left out of the new manifest, can be repeated")
+	estimateRepair.Flags().StringVar(&symlinks, "symlinks", "error", "what to do with the symlink entries of the old manifest: error, skip or resolve")
+	estimateRepair.Flags().StringVar(&localDB, "local-db", "", "path of the localstore of a stopped bee node to read the old content from instead of the api, nothing is written to it")
+	root.AddCommand(estimateRepair)
+}
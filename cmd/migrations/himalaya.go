@@ -6,14 +6,22 @@ package migrations
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
 	"sync"
 	"time"
 
 	"github.com/ethersphere/bee-repair/internal/exporter"
 	"github.com/ethersphere/bee-repair/internal/repair"
 	cmdfile "github.com/ethersphere/bee-repair/pkg/file"
+	"github.com/ethersphere/bee/pkg/file"
+	"github.com/ethersphere/bee/pkg/file/joiner"
 	"github.com/ethersphere/bee/pkg/logging"
+	"github.com/ethersphere/bee/pkg/storage"
 	"github.com/ethersphere/bee/pkg/swarm"
 	"github.com/spf13/cobra"
 )
@@ -24,24 +32,73 @@ const (
 )
 
 var (
-	host        string // flag variable, http api host
-	port        int    // flag variable, http api port
-	ssl         bool   // flag variable, uses https for api if set
-	verbosity   string // flag variable, debug level
-	encrypted   bool   // flag variable, uses encryption
-	pin         bool   // flag variable, pins the repaired content
-	dstFilename string // flag variable, destination file
+	host        string        // flag variable, http api host
+	port        int           // flag variable, http api port
+	ssl         bool          // flag variable, uses https for api if set
+	verbosity   string        // flag variable, debug level
+	encrypted   bool          // flag variable, uses encryption
+	pin         bool          // flag variable, pins the repaired content
+	pinMfstOnly bool          // flag variable, pins only the chunks of the new manifests
+	dstFilename string        // flag variable, destination file
+	addrsFile   string        // flag variable, file listing the chunk addresses to export
+	excludeFile string        // flag variable, file listing the chunk addresses to leave out
+	verify      bool          // flag variable, verifies exported chunk data
+	skipCorrupt bool          // flag variable, skips corrupt chunks while exporting
+	skipUnread  bool          // flag variable, skips unreadable chunks while exporting
+	outFilename string        // flag variable, output file
+	filePath    string        // flag variable, path of the file inside a directory reference
+	website     bool          // flag variable, serves the repaired file at the manifest root
+	fileTimeout time.Duration // flag variable, timeout for reading each file of a directory
+	skipErrors  bool          // flag variable, skips the files which cannot be repaired
+	checkSize   bool          // flag variable, validates the length of every file
+	maxDepth    int           // flag variable, levels of directories repaired
+	pathPrefix  string        // flag variable, repairs only the files under the prefix
+	baseRef     string        // flag variable, manifest the repaired files are added to
+	byteProg    bool          // flag variable, reports export progress in bytes
+	checkpoint  string        // flag variable, checkpoint file of a resumable export
+	sha256File  bool          // flag variable, writes the digest of the archive to a file
+	volumeSize  int64         // flag variable, maximum size of the archive volumes
+	concurrency int           // flag variable, number of workers checking the exported chunks
+	workers     int           // flag variable, number of references repaired at once
+	refDstFile  string        // flag variable, destination file of a reference export
+	tlsCA       string        // flag variable, CA certificate file trusted for the api
+	tlsInsecure bool          // flag variable, skips the api certificate verification
+	authToken   string        // flag variable, bearer token for the api
+	authUser    string        // flag variable, basic auth user for the api
+	authPass    string        // flag variable, basic auth password for the api
+	proxyURL    string        // flag variable, SOCKS5 proxy for the api connections
+	rateLimit   float64       // flag variable, maximum api requests per second
+	reqTimeout  time.Duration // flag variable, timeout of every single api request
+	nfRetries   int           // flag variable, times a chunk which is not found is requested again
+	nfBackoff   time.Duration // flag variable, wait before the first not found retry
+	postBatch   string        // flag variable, postage batch the uploaded chunks are stamped with
+	ensEndpoint string        // flag variable, ethereum json-rpc endpoint the ENS names are resolved with
+	indexDoc    string        // flag variable, index document of the new manifest
+	errorDoc    string        // flag variable, error document of the new manifest
+	mtdtLimit   int64         // flag variable, maximum size of the old metadata
+	strictMtdt  bool          // flag variable, fails on old metadata without filename
+	dirMtdt     bool          // flag variable, carries over the metadata of the directories
+	mimeMap     string        // flag variable, json file mapping extensions to content types
+	localDB     string        // flag variable, database the repair reads from and writes to
+	timeout     time.Duration // flag variable, bounds the time the whole command runs
+	maxBuffered int64         // flag variable, maximum bytes of old metadata held at once
+	progLog     string        // flag variable, file the repair progress is logged to
+	progLogMode string        // flag variable, append, truncate or rotate the progress log
+	putMode     string        // flag variable, mode the repaired chunks are stored with
+	symlinks    string        // flag variable, how the symlink entries of directories are repaired
+	exportTar   string        // flag variable, tar file the content of the new manifest is written to
+	flushEvery  int           // flag variable, files after which the new manifest is stored
+	excludes    []string      // flag variable, glob patterns of the files left out of directories
+	verifyRef   bool          // flag variable, reads back the root chunk of the new manifest
 	logger      logging.Logger
+	storeOpts   []cmdfile.APIStoreOption
+	progress    *repair.SyncUpdater
+	mimeTypes   map[string]string
+	budgetOpt   repair.Option
+	putModeOpts []repair.Option
+	symlinkOpt  repair.Option
 )
 
-type stdOutProgressUpdater struct {
-	cmd *cobra.Command
-}
-
-func (s *stdOutProgressUpdater) Update(msg string) {
-	s.cmd.Println(msg)
-}
-
 var fileRepair = &cobra.Command{
 	Use:   "file <reference>",
 	Short: "Repair a file entry",
@@ -52,89 +109,557 @@ Example:
 	$ bee-repair file 2387e8e7d8a48c2a9339c97c1dc3461a9a7aa07e994c5cb8b38fd7c1b3e6ea48
 	> 94434d3312320fab70428c39b79dffb4abc3dbedf3e1562384a61ceaf8a7e36b
 
-The input is the hex representation of the swarm hash passed as argument, the result is a new hash which should be used to query the file from the swarm network.`,
+	$ cat refs.txt | bee-repair file -
+
+The input is the hex representation of the swarm hash passed as argument, the result is a new hash which should be used to query the file from the swarm network. With "-" as argument the references are read from stdin, one per line.
+
+With --path the reference is treated as a directory and only the file at that path inside of it is repaired.`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return withMappingDB(func() error {
+			return forEachReference(cmd, args[0], false, repairFileReference)
+		})
+	},
+}
+
+func repairFileReference(cmd *cobra.Command, addr swarm.Address) (newReference swarm.Address, err error) {
+	if err := ensureExists(cmd, addr); err != nil {
+		return swarm.ZeroAddress, err
+	}
+	if err := checkPostage(cmd, addr, false); err != nil {
+		return swarm.ZeroAddress, err
+	}
+
+	opts := []repair.Option{
+		storeOption(),
+		repair.WithLogger(logger),
+		repair.WithEncryption(encrypted),
+		repair.WithPin(pin || pinMfstOnly),
+		repair.WithPinManifestOnly(pinMfstOnly),
+		repair.WithIndexDocument(indexDoc),
+		repair.WithErrorDocument(errorDoc),
+		repair.WithMetadataLimit(mtdtLimit),
+		repair.WithStrictMetadata(strictMtdt),
+		repair.WithMimeOverrides(mimeTypes),
+		repair.WithWebsiteMode(website),
+		repair.WithVerifyReference(verifyRef),
+		budgetOpt,
+	}
+	opts = append(opts, putModeOpts...)
+	opts = append(opts, repairProgressOptions(cmd, addr, false)...)
+	if filePath == "" {
+		// the file of a directory does not replace the directory reference
+		opts = append(opts, mappingOptions()...)
+	}
+
+	if filePath != "" {
+		newReference, err = repair.FileRepairInDirectory(cmd.Context(), addr, filePath, opts...)
+	} else {
+		newReference, err = repair.FileRepair(cmd.Context(), addr, opts...)
+	}
+	if err != nil {
+		return swarm.ZeroAddress, err
+	}
+	if err := writeReferenceFile(newReference); err != nil {
+		return swarm.ZeroAddress, err
+	}
+	if err := writeExportTar(cmd, newReference); err != nil {
+		return swarm.ZeroAddress, err
+	}
+	return newReference, printReference(cmd, "Repaired file reference. New reference ", newReference)
+}
+
+var directoryRepair = &cobra.Command{
+	Use:   "directory <reference>",
+	Short: "Repair a directory entry",
+	Long: `Repairs a directory entry by adding all the required metadata in the new format.
+
+Example:
+
+	$ bee-repair directory 2387e8e7d8a48c2a9339c97c1dc3461a9a7aa07e994c5cb8b38fd7c1b3e6ea48
+	> 94434d3312320fab70428c39b79dffb4abc3dbedf3e1562384a61ceaf8a7e36b
+
+	$ cat refs.txt | bee-repair directory -
+
+The input is the hex representation of the swarm hash passed as argument, the result is a new hash which should be used to query the directory from the swarm network. With "-" as argument the references are read from stdin, one per line.`,
 	Args: cobra.ExactArgs(1),
 	RunE: func(cmd *cobra.Command, args []string) error {
-		addr, err := swarm.ParseHexAddress(args[0])
+		return withMappingDB(func() error {
+			return forEachReference(cmd, args[0], true, repairDirectoryReference)
+		})
+	},
+}
+
+func repairDirectoryReference(cmd *cobra.Command, addr swarm.Address) (swarm.Address, error) {
+	if err := ensureExists(cmd, addr); err != nil {
+		return swarm.ZeroAddress, err
+	}
+	if err := checkPostage(cmd, addr, true); err != nil {
+		return swarm.ZeroAddress, err
+	}
+	base := swarm.ZeroAddress
+	if baseRef != "" {
+		var err error
+		if base, err = parseReference(baseRef); err != nil {
+			return swarm.ZeroAddress, fmt.Errorf("base manifest: %w", err)
+		}
+	}
+
+	opts := []repair.Option{
+		storeOption(),
+		repair.WithLogger(logger),
+		repair.WithEncryption(encrypted),
+		repair.WithPin(pin || pinMfstOnly),
+		repair.WithPinManifestOnly(pinMfstOnly),
+		repair.WithPerFileTimeout(fileTimeout),
+		repair.WithSkipErrors(skipErrors),
+		repair.WithValidateSize(checkSize),
+		repair.WithMaxDepth(maxDepth),
+		repair.WithPathPrefix(pathPrefix),
+		repair.WithBaseManifest(base),
+		repair.WithIndexDocument(indexDoc),
+		repair.WithErrorDocument(errorDoc),
+		repair.WithMetadataLimit(mtdtLimit),
+		repair.WithStrictMetadata(strictMtdt),
+		repair.WithDirectoryMetadata(dirMtdt),
+		repair.WithMimeOverrides(mimeTypes),
+		repair.WithManifestFlushInterval(flushEvery),
+		repair.WithExcludeGlobs(excludes),
+		repair.WithVerifyReference(verifyRef),
+		budgetOpt,
+		symlinkOpt,
+	}
+	opts = append(opts, putModeOpts...)
+	opts = append(opts, repairProgressOptions(cmd, addr, true)...)
+	opts = append(opts, mappingOptions()...)
+
+	newReference, err := repair.DirectoryRepair(cmd.Context(), addr, opts...)
+	var skipped *repair.SkippedError
+	if errors.As(err, &skipped) {
+		for _, f := range skipped.Files {
+			cmd.PrintErrln("Skipped " + f.Error())
+		}
+	} else if err != nil {
+		return swarm.ZeroAddress, err
+	}
+	if werr := writeReferenceFile(newReference); werr != nil {
+		return swarm.ZeroAddress, werr
+	}
+	if werr := writeExportTar(cmd, newReference); werr != nil {
+		return swarm.ZeroAddress, werr
+	}
+	if perr := printReference(cmd, "Repaired directory reference. New reference ", newReference); perr != nil {
+		return swarm.ZeroAddress, perr
+	}
+	return newReference, err
+}
+
+// writeExportTar writes the content of the new manifest to the --export-tar file,
+// reading it back from the store it was repaired to
+func writeExportTar(cmd *cobra.Command, ref swarm.Address) (err error) {
+	if exportTar == "" {
+		return nil
+	}
+	f, err := os.Create(exportTar)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			os.Remove(exportTar)
+		}
+	}()
+	if err := repair.ExportTar(cmd.Context(), ref, f, storeOption(), repair.WithLogger(logger)); err != nil {
+		return err
+	}
+	if !scriptOutput() {
+		cmd.PrintErrf("Exported the content of %s to %s\n", ref, exportTar)
+	}
+	return nil
+}
+
+func addAPIFlags(cmd *cobra.Command) {
+	cmd.Flags().StringVar(&host, "host", "127.0.0.1", "api host, defaults to $BEE_API_HOST when set")
+	cmd.Flags().IntVar(&port, "port", 1633, "api port, defaults to $BEE_API_PORT when set")
+	cmd.Flags().BoolVar(&ssl, "ssl", false, "use ssl, defaults to $BEE_API_SSL when set")
+	cmd.Flags().StringVar(&tlsCA, "tls-ca", "", "PEM file with the CA certificates trusted for the api, used with --ssl")
+	cmd.Flags().BoolVar(&tlsInsecure, "tls-insecure", false, "skip the verification of the api certificate, use only with trusted nodes")
+	cmd.Flags().StringVar(&authToken, "auth-token", "", "bearer token sent to the api")
+	cmd.Flags().StringVar(&authUser, "auth-user", "", "basic auth user sent to the api")
+	cmd.Flags().StringVar(&authPass, "auth-pass", "", "basic auth password sent to the api")
+	cmd.Flags().StringVar(&proxyURL, "proxy", "", "SOCKS5 proxy url used to reach the api, e.g. socks5://127.0.0.1:1080")
+	cmd.Flags().Float64Var(&rateLimit, "rate-limit", 0, "maximum number of api requests per second, 0 means no limit")
+	cmd.Flags().DurationVar(&reqTimeout, "request-timeout", 0, "timeout of every single api request, 0 means no timeout")
+	cmd.Flags().IntVar(&nfRetries, "not-found-retries", 0, "times a chunk which is not found is requested again, for gateways which are still retrieving it from the network")
+	cmd.Flags().DurationVar(&nfBackoff, "not-found-backoff", time.Second, "wait before the first retry of a chunk which is not found, doubled for every next retry")
+}
+
+// apiStoreOptions returns the api store options selected by the flags
+func apiStoreOptions() ([]cmdfile.APIStoreOption, error) {
+	var opts []cmdfile.APIStoreOption
+	if tlsCA != "" {
+		pool, err := cmdfile.LoadCertPool(tlsCA)
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, cmdfile.WithRootCAs(pool))
+	}
+	if tlsInsecure {
+		opts = append(opts, cmdfile.WithInsecureSkipVerify(true))
+	}
+	if authToken != "" {
+		opts = append(opts, cmdfile.WithBearerToken(authToken))
+	}
+	if authUser != "" {
+		opts = append(opts, cmdfile.WithBasicAuth(authUser, authPass))
+	}
+	if proxyURL != "" {
+		d, err := cmdfile.ProxyDialer(proxyURL)
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, cmdfile.WithProxy(d))
+	}
+	if rateLimit > 0 {
+		opts = append(opts, cmdfile.WithRateLimit(rateLimit))
+	}
+	if reqTimeout > 0 {
+		opts = append(opts, cmdfile.WithRequestTimeout(reqTimeout))
+	}
+	if nfRetries > 0 {
+		opts = append(opts, cmdfile.WithNotFoundRetries(nfRetries, nfBackoff))
+	}
+	if postBatch != "" {
+		opts = append(opts, cmdfile.WithPostageBatch(postBatch))
+	}
+	return opts, nil
+}
+
+// readMimeMap reads the json object mapping file extensions to content types from
+// the file, if any
+func readMimeMap(fname string) (map[string]string, error) {
+	if fname == "" {
+		return nil, nil
+	}
+	b, err := ioutil.ReadFile(fname)
+	if err != nil {
+		return nil, err
+	}
+	var m map[string]string
+	if err := json.Unmarshal(b, &m); err != nil {
+		return nil, fmt.Errorf("invalid mime map %s: %w", fname, err)
+	}
+	return m, nil
+}
+
+// storeOption returns the option of the store the repair reads the old entries from
+// and writes the new manifests to
+func storeOption() repair.Option {
+	if localDB != "" {
+		return repair.WithLocalStore(localDB)
+	}
+	return repair.WithAPIStore(host, port, ssl, storeOpts...)
+}
+
+// putModes are the values of --put-mode
+var putModes = map[string]storage.ModePut{
+	"upload":      storage.ModePutUpload,
+	"upload-pin":  storage.ModePutUploadPin,
+	"request":     storage.ModePutRequest,
+	"request-pin": storage.ModePutRequestPin,
+	"sync":        storage.ModePutSync,
+}
+
+// putModeOptions returns the option selecting the --put-mode, or none when the
+// mode follows --pin
+func putModeOptions() ([]repair.Option, error) {
+	if putMode == "" {
+		return nil, nil
+	}
+	mode, ok := putModes[putMode]
+	if !ok {
+		return nil, fmt.Errorf("unknown put mode %q, expected upload, upload-pin, request, request-pin or sync", putMode)
+	}
+	return []repair.Option{repair.WithPutMode(mode)}, nil
+}
+
+// symlinkPolicies are the values of --symlinks
+var symlinkPolicies = map[string]repair.SymlinkPolicy{
+	"error":   repair.SymlinkError,
+	"skip":    repair.SymlinkSkip,
+	"resolve": repair.SymlinkResolve,
+}
+
+// symlinkOption returns the option selecting the --symlinks policy
+func symlinkOption() (repair.Option, error) {
+	if symlinks == "" {
+		return repair.WithSymlinkPolicy(repair.SymlinkError), nil
+	}
+	p, ok := symlinkPolicies[symlinks]
+	if !ok {
+		return nil, fmt.Errorf("unknown symlink policy %q, expected error, skip or resolve", symlinks)
+	}
+	return repair.WithSymlinkPolicy(p), nil
+}
+
+// pingAPI checks that the api is reachable for the commands which use it
+func pingAPI(cmd *cobra.Command) error {
+	if cmd.Flags().Lookup("host") == nil || localDB != "" {
+		return nil
+	}
+	api := cmdfile.NewAPIStore(host, port, ssl, storeOpts...).(*cmdfile.APIStore)
+	return api.Ping(cmd.Context())
+}
+
+// checkNodeVersion warns about nodes which cannot serve the repaired content and
+// requires a --postage-batch for the nodes which only accept stamped chunks
+func checkNodeVersion(cmd *cobra.Command) error {
+	if cmd.Flags().Lookup("host") == nil || localDB != "" {
+		return nil
+	}
+	api := cmdfile.NewAPIStore(host, port, ssl, storeOpts...).(*cmdfile.APIStore)
+	v, err := api.NodeVersion(cmd.Context())
+	if errors.Is(err, cmdfile.ErrVersionUnknown) {
+		logger.Debugf("Skipping the node version check: %v", err)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	logger.Debugf("Node runs bee %s", v.Version)
+	if !v.ReadsNewFormat() {
+		cmd.PrintErrf("Warning: the node runs bee %s, the repaired references are served from bee 0.5.4 on\n", v.Version)
+	}
+	if v.RequiresPostage() && postBatch == "" && cmd.Flags().Lookup("postage-batch") != nil {
+		return fmt.Errorf("the node runs bee %s, which only stores chunks stamped with a postage batch, set --postage-batch", v.Version)
+	}
+	return nil
+}
+
+// checkPostage fails fast when the --postage-batch cannot stamp the chunks the
+// repair of the reference is expected to store
+func checkPostage(cmd *cobra.Command, addr swarm.Address, directory bool) error {
+	if postBatch == "" || localDB != "" {
+		return nil
+	}
+	chunks, err := repair.EstimateManifestChunks(cmd.Context(), addr, directory, storeOption(), repair.WithLogger(logger))
+	if err != nil {
+		return err
+	}
+	api := cmdfile.NewAPIStore(host, port, ssl, storeOpts...).(*cmdfile.APIStore)
+	return api.CheckPostageBatch(cmd.Context(), postBatch, int64(chunks))
+}
+
+func addRepairCommands(root *cobra.Command) {
+	for _, cmd := range []*cobra.Command{fileRepair, directoryRepair, retryFailed, repairCSV, indexRepair} {
+		addAPIFlags(cmd)
+		cmd.Flags().BoolVar(&encrypted, "encrypt", false, "use encryption")
+		cmd.Flags().BoolVar(&pin, "pin", false, "pin the repaired content")
+		cmd.Flags().BoolVar(&pinMfstOnly, "pin-manifest-only", false, "pin only the chunks of the new manifests, leaving the pins of the file content as they are, implies --pin")
+		cmd.Flags().StringVar(&indexDoc, "index-document", "", "index document of the new manifest, overrides the one of the old entry")
+		cmd.Flags().StringVar(&errorDoc, "error-document", "", "error document of the new manifest, overrides the one of the old entry")
+		cmd.Flags().Int64Var(&mtdtLimit, "metadata-limit", 0, "maximum size in bytes of the old metadata, 0 means the default of 16 chunks")
+		cmd.Flags().BoolVar(&strictMtdt, "strict-metadata", false, "fail on old entries whose metadata has no filename instead of naming them after their content reference")
+		cmd.Flags().StringVar(&mimeMap, "mime-map", "", "json file mapping file extensions to the content types they are served with, e.g. {\".md\": \"text/markdown\"}")
+		cmd.Flags().Int64Var(&maxBuffered, "max-buffered-bytes", 0, "maximum bytes of old metadata held in memory at once, 0 means no limit")
+		cmd.Flags().StringVar(&postBatch, "postage-batch", "", "id of the postage batch the new chunks are stamped with, checked to be usable and to have room for the repair before anything is uploaded")
+		cmd.Flags().StringVar(&putMode, "put-mode", "", "mode the repaired chunks are stored with: upload, upload-pin, request, request-pin or sync, overrides --pin, only the pinning is honored by the api")
+		cmd.Flags().BoolVar(&verifyRef, "verify-reference", false, "read back the root chunk of every new manifest and check that it hashes to the new reference before it is reported")
+		cmd.Flags().StringVar(&localDB, "local-db", "", "path of the localstore of a stopped bee node to repair from instead of the api, the new manifests are written to it")
+		addMappingDBFlag(cmd)
+		addLinkFlags(cmd)
+
+		root.AddCommand(cmd)
+	}
+	addIndexFlags()
+	fileRepair.Flags().StringVar(&filePath, "path", "", "repair only the file at this path of a directory reference")
+	fileRepair.Flags().BoolVar(&website, "website", true, "make the file the index document of the new manifest, so it is served at the root as well as at its name, --website=false serves it only at its name")
+	for _, cmd := range []*cobra.Command{directoryRepair, retryFailed, repairCSV} {
+		cmd.Flags().DurationVar(&fileTimeout, "file-timeout", 0, "timeout for reading each file, 0 means no timeout")
+		cmd.Flags().BoolVar(&skipErrors, "skip-errors", false, "leave out the files which cannot be repaired")
+		cmd.Flags().BoolVar(&checkSize, "validate-size", false, "read every file and check its length against the recorded size, mismatches are handled like --skip-errors")
+		cmd.Flags().IntVar(&maxDepth, "max-depth", 0, "levels of directories walked, 1 repairs only the top level files, deeper files fail the repair or are left out with --skip-errors, 0 means no limit")
+		cmd.Flags().StringVar(&pathPrefix, "prefix", "", "repair only the files whose path starts with the prefix, e.g. assets/, the new manifest holds only them")
+		cmd.Flags().StringVar(&baseRef, "base-manifest", "", "reference of a manifest of the new format the repaired files are added to, e.g. one repaired before with --prefix")
+		cmd.Flags().BoolVar(&dirMtdt, "directory-metadata", false, "carry over the metadata of the subdirectories, not only of the root")
+		cmd.Flags().IntVar(&flushEvery, "flush-interval", 0, "store the new manifest every this many files and release it from memory, for directories too large to hold, each flush costs extra chunks, 0 stores it once")
+		cmd.Flags().StringVar(&symlinks, "symlinks", "error", "what to do with the symlink entries of the old manifest: error, skip, or resolve to add the file they point to at their path")
+		cmd.Flags().StringSliceVar(&excludes, "exclude", nil, "glob pattern of the files left out of the new manifest, e.g. .DS_Store or '*.tmp', matched against every path element or, with a slash, the path from the root, can be repeated")
+	}
+	for _, cmd := range []*cobra.Command{fileRepair, directoryRepair} {
+		cmd.Flags().StringVar(&resultLog, "output", "", "json lines file logging the result of every reference, failures are logged and the batch goes on")
+		cmd.Flags().IntVar(&workers, "workers", 4, "number of the references read from stdin which are repaired at once")
+		cmd.Flags().StringVar(&exportTar, "export-tar", "", "tar file the content of the new manifest is written to, each file at its path, to archive or host the migrated content elsewhere")
+		cmd.Flags().StringVar(&ensEndpoint, "ens-endpoint", "", "ethereum json-rpc endpoint, e.g. https://cloudflare-eth.com, used to resolve the ENS names given instead of references")
+		cmd.Flags().StringVar(&outputRef, "output-ref", "", "file the bare new references are written to, one per line, as soon as each of them is stored")
+	}
+}
+
+var catReference = &cobra.Command{
+	Use:   "cat <reference>",
+	Short: "Print the content of a reference",
+	Long: `Joins the chunks of a reference and writes the raw bytes to stdout or to the output file.
+
+Example:
+
+	$ bee-repair himalaya cat 2387e8e7d8a48c2a9339c97c1dc3461a9a7aa07e994c5cb8b38fd7c1b3e6ea48 --output entry.bin`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) (err error) {
+		addr, err := parseReference(args[0])
+		if err != nil {
+			return err
+		}
+
+		j, _, err := joiner.New(cmd.Context(), cmdfile.NewAPIStore(host, port, ssl, storeOpts...), addr)
+		if err != nil {
+			return err
+		}
+
+		out := cmd.OutOrStdout()
+		if outFilename != "" {
+			f, err := os.Create(outFilename)
+			if err != nil {
+				return err
+			}
+			defer func() {
+				if cerr := f.Close(); err == nil {
+					err = cerr
+				}
+			}()
+			out = f
+		}
+
+		_, err = file.JoinReadAll(cmd.Context(), j, out)
+		return err
+	},
+}
+
+func addCatCommand(root *cobra.Command) {
+	addAPIFlags(catReference)
+	catReference.Flags().StringVar(&outFilename, "output", "", "file to write the content to instead of stdout")
+	root.AddCommand(catReference)
+}
+
+var verifyManifest = &cobra.Command{
+	Use:   "verify <old reference> <new reference>",
+	Short: "Compare a repaired directory with the old one",
+	Long: `Walks the old directory reference and its repaired manifest and reports every path which is present in only one of them or whose file reference differs.
+
+Example:
+
+	$ bee-repair himalaya verify 2387e8e7d8a48c2a9339c97c1dc3461a9a7aa07e994c5cb8b38fd7c1b3e6ea48 94434d3312320fab70428c39b79dffb4abc3dbedf3e1562384a61ceaf8a7e36b
+	> Manifests match
+
+The command fails when any difference is found.`,
+	Args: cobra.ExactArgs(2),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		oldAddr, err := parseReference(args[0])
+		if err != nil {
+			return err
+		}
+		newAddr, err := parseReference(args[1])
 		if err != nil {
 			return err
 		}
-		newReference, err := repair.FileRepair(
+
+		diffs, err := repair.Verify(
 			cmd.Context(),
-			addr,
-			repair.WithAPIStore(host, port, ssl),
+			oldAddr,
+			newAddr,
+			repair.WithAPIStore(host, port, ssl, storeOpts...),
 			repair.WithLogger(logger),
-			repair.WithEncryption(encrypted),
-			repair.WithProgressUpdater(&stdOutProgressUpdater{cmd}),
+			repair.WithPerFileTimeout(fileTimeout),
 		)
 		if err != nil {
 			return err
 		}
-		cmd.Println("Repaired file reference. New reference " + newReference.String())
+		if err := printDifferences(cmd, diffs); err != nil {
+			return err
+		}
+		if len(diffs) > 0 {
+			return fmt.Errorf("found %d differences", len(diffs))
+		}
 		return nil
 	},
 }
 
-var directoryRepair = &cobra.Command{
-	Use:   "directory <reference>",
-	Short: "Repair a directory entry",
-	Long: `Repairs a directory entry by adding all the required metadata in the new format.
+func addVerifyCommand(root *cobra.Command) {
+	addAPIFlags(verifyManifest)
+	verifyManifest.Flags().DurationVar(&fileTimeout, "file-timeout", 0, "timeout for reading each file, 0 means no timeout")
+	root.AddCommand(verifyManifest)
+}
+
+var listDirectory = &cobra.Command{
+	Use:   "ls <reference>",
+	Short: "List the files of a directory entry",
+	Long: `Walks a directory reference of the old format and prints the path, content type and size of every file in it. Nothing is stored, so no postage stamp is needed.
 
 Example:
 
-	$ bee-repair directory 2387e8e7d8a48c2a9339c97c1dc3461a9a7aa07e994c5cb8b38fd7c1b3e6ea48
-	> 94434d3312320fab70428c39b79dffb4abc3dbedf3e1562384a61ceaf8a7e36b
+	$ bee-repair himalaya ls 2387e8e7d8a48c2a9339c97c1dc3461a9a7aa07e994c5cb8b38fd7c1b3e6ea48
+	> PATH        CONTENT TYPE                SIZE
+	> index.html  text/html; charset=utf-8    4096
+
+With --json the files are printed as a json array of objects with their path, the reference of their content, which the repaired entries point to as well, content type and size, e.g. to plan a migration:
 
-The input is the hex representation of the swarm hash passed as argument, the result is a new hash which should be used to query the directory from the swarm network.`,
+	$ bee-repair himalaya ls 2387e8e7d8a48c2a9339c97c1dc3461a9a7aa07e994c5cb8b38fd7c1b3e6ea48 --json
+	> [{"path":"index.html","reference":"0e8f2a4c6e8a0c2e4a6c8e0a2c4e6a8c0e2a4c6e8a0c2e4a6c8e0a2c4e6a8c0e","content_type":"text/html; charset=utf-8","size":4096}]`,
 	Args: cobra.ExactArgs(1),
 	RunE: func(cmd *cobra.Command, args []string) error {
-		addr, err := swarm.ParseHexAddress(args[0])
+		addr, err := parseReference(args[0])
 		if err != nil {
 			return err
 		}
-		newReference, err := repair.DirectoryRepair(
+
+		entries, err := repair.List(
 			cmd.Context(),
 			addr,
-			repair.WithAPIStore(host, port, ssl),
+			repair.WithAPIStore(host, port, ssl, storeOpts...),
 			repair.WithLogger(logger),
-			repair.WithEncryption(encrypted),
-			repair.WithProgressUpdater(&stdOutProgressUpdater{cmd}),
+			repair.WithPerFileTimeout(fileTimeout),
+			repair.WithMetadataLimit(mtdtLimit),
 		)
 		if err != nil {
 			return err
 		}
-		cmd.Println("Repaired directory reference. New reference " + newReference.String())
-		return nil
+		return printList(cmd, entries)
 	},
 }
 
-func addRepairCommands(root *cobra.Command) {
-	for _, cmd := range []*cobra.Command{fileRepair, directoryRepair} {
-		cmd.Flags().StringVar(&host, "host", "127.0.0.1", "api host")
-		cmd.Flags().IntVar(&port, "port", 1633, "api port")
-		cmd.Flags().BoolVar(&ssl, "ssl", false, "use ssl")
-		cmd.Flags().BoolVar(&encrypted, "encrypt", false, "use encryption")
-		cmd.Flags().BoolVar(&pin, "pin", false, "pin the repaired content")
-
-		root.AddCommand(cmd)
-	}
+func addListCommand(root *cobra.Command) {
+	addAPIFlags(listDirectory)
+	listDirectory.Flags().DurationVar(&fileTimeout, "file-timeout", 0, "timeout for reading each file, 0 means no timeout")
+	listDirectory.Flags().Int64Var(&mtdtLimit, "metadata-limit", 0, "maximum size in bytes of the old metadata, 0 means the default of 16 chunks")
+	root.AddCommand(listDirectory)
 }
 
+// percentUpdater prints the progress in percent every few seconds, along with an
+// estimate of the time left at the throughput so far. It writes to stderr of the
+// command, so that the result printed to stdout stays clean
 type percentUpdater struct {
+	w           io.Writer
 	curr, total int
+	started     time.Time
 	mtx         sync.Mutex
 }
 
+func newPercentUpdater(cmd *cobra.Command) *percentUpdater {
+	return &percentUpdater{w: cmd.ErrOrStderr(), started: time.Now()}
+}
+
 func (p *percentUpdater) start(ctx context.Context) {
 	go func() {
 		complete := false
 		for {
 			p.mtx.Lock()
-			curr, total := p.curr, p.total
+			curr, total, elapsed := p.curr, p.total, time.Since(p.started)
 			p.mtx.Unlock()
 
 			if total != 0 {
-				fmt.Printf("Progress %d %%\n", curr*100/total)
+				fmt.Fprintln(p.w, progressLine(curr, total, elapsed))
 			}
 			if complete {
 				return
@@ -152,6 +677,17 @@ func (p *percentUpdater) start(ctx context.Context) {
 	}()
 }
 
+// progressLine formats the progress in percent and, once some of the work is
+// done, the time left assuming the rest goes at the same rate
+func progressLine(curr, total int, elapsed time.Duration) string {
+	line := fmt.Sprintf("Progress %d %%", curr*100/total)
+	if curr <= 0 || curr >= total || elapsed <= 0 {
+		return line
+	}
+	left := time.Duration(float64(elapsed) * float64(total-curr) / float64(curr))
+	return fmt.Sprintf("%s, about %s left", line, left.Round(time.Second))
+}
+
 func (p *percentUpdater) Update(current, total int) {
 	p.mtx.Lock()
 	defer p.mtx.Unlock()
@@ -159,30 +695,189 @@ func (p *percentUpdater) Update(current, total int) {
 	p.curr, p.total = current, total
 }
 
+func (p *percentUpdater) Corrupt(count int) {
+	if count > 0 {
+		fmt.Fprintf(p.w, "Skipped %d corrupt chunks\n", count)
+	}
+}
+
+func (p *percentUpdater) Unreadable(addrs []swarm.Address) {
+	if len(addrs) > 0 {
+		fmt.Fprintf(p.w, "Skipped %d unreadable chunks\n", len(addrs))
+	}
+}
+
+// checksumUpdater records the digests of the archive volumes and forwards the
+// progress to the percentUpdater, if any
+type checksumUpdater struct {
+	progress *percentUpdater
+	digests  []string
+}
+
+func (c *checksumUpdater) Update(current, total int) {
+	if c.progress != nil {
+		c.progress.Update(current, total)
+	}
+}
+
+func (c *checksumUpdater) Corrupt(count int) {
+	if c.progress != nil {
+		c.progress.Corrupt(count)
+	}
+}
+
+func (c *checksumUpdater) Unreadable(addrs []swarm.Address) {
+	for _, addr := range addrs {
+		logger.Warningf("Skipped unreadable chunk %s", addr)
+	}
+	if c.progress != nil {
+		c.progress.Unreadable(addrs)
+	}
+}
+
+func (c *checksumUpdater) Checksum(digest string) {
+	c.digests = append(c.digests, digest)
+}
+
 var exportDB = &cobra.Command{
 	Use:   "export-db <database path>",
 	Short: "Export the local database as a tar archive",
-	Long:  `Command is used to export the locally present database as a tar archive.`,
-	Args:  cobra.ExactArgs(1),
-	RunE: func(cmd *cobra.Command, args []string) error {
-		updater := &percentUpdater{}
-		updater.start(cmd.Context())
+	Long: `Command is used to export the locally present database as a tar archive.
 
-		err := exporter.Export(
-			args[0],
+With --info 5 the address of every chunk is logged as it is written, which shows the chunk a stalled export is stuck on.`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		opts := []exporter.Option{
 			exporter.WithDestinationFilename(dstFilename),
+			exporter.WithVerifyChunks(verify, skipCorrupt),
+			exporter.WithSkipUnreadable(skipUnread),
+			exporter.WithByteProgress(byteProg),
+			exporter.WithCheckpoint(checkpoint),
+			exporter.WithChecksumFile(sha256File),
+			exporter.WithMaxVolumeSize(volumeSize),
+			exporter.WithConcurrency(concurrency),
+			exporter.WithPins(exportPins),
+			exporter.WithLogger(logger),
+		}
+		if addrsFile != "" {
+			filter, err := exporter.LoadAddressFilter(addrsFile)
+			if err != nil {
+				return err
+			}
+			opts = append(opts, exporter.WithAddressFilter(filter))
+		}
+		if excludeFile != "" {
+			filter, err := exporter.LoadExcludeFilter(excludeFile)
+			if err != nil {
+				return err
+			}
+			opts = append(opts, exporter.WithAddressFilter(filter))
+		}
+
+		updater := &checksumUpdater{}
+		if !scriptOutput() {
+			progress := newPercentUpdater(cmd)
+			progress.start(cmd.Context())
+			updater.progress = progress
+		}
+		opts = append(opts, exporter.WithProgressUpdater(updater))
+
+		err := exporter.ExportContext(cmd.Context(), args[0], opts...)
+		if err != nil {
+			return err
+		}
+		if volumeSize > 0 {
+			msg := fmt.Sprintf("Exported database to %d volumes", len(updater.digests))
+			out := exportOutput{DestinationFile: dstFilename}
+			for i, digest := range updater.digests {
+				fname := exporter.VolumeFilename(dstFilename, i+1)
+				msg += fmt.Sprintf("\n%s SHA-256 %s", fname, digest)
+				out.Volumes = append(out.Volumes, volumeOutput{File: fname, SHA256: digest})
+			}
+			return printResult(cmd, msg, exporter.VolumeFilename(dstFilename, 1), out)
+		}
+		digest := updater.digests[0]
+		return printResult(
+			cmd,
+			fmt.Sprintf("Exported database to %s SHA-256 %s", dstFilename, digest),
+			dstFilename,
+			exportOutput{DestinationFile: dstFilename, SHA256: digest},
+		)
+	},
+}
+
+var exportReference = &cobra.Command{
+	Use:   "export-reference <reference>",
+	Short: "Export the chunks of a reference as a tar archive",
+	Long: `Traverses a file or directory reference, in the old or the new format, and exports only the chunks reachable from it as a tar archive in the export-db format. The chunks are read through the api.
+
+Example:
+
+	$ bee-repair himalaya export-reference 2387e8e7d8a48c2a9339c97c1dc3461a9a7aa07e994c5cb8b38fd7c1b3e6ea48 --destination-file site.tar`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		addr, err := parseReference(args[0])
+		if err != nil {
+			return err
+		}
+		dst := refDstFile
+		if dst == "" {
+			dst = addr.String() + ".tar"
+		}
+
+		updater := &checksumUpdater{}
+		if !scriptOutput() {
+			progress := newPercentUpdater(cmd)
+			progress.start(cmd.Context())
+			updater.progress = progress
+		}
+
+		err = exporter.ExportReference(
+			cmd.Context(),
+			cmdfile.NewAPIStore(host, port, ssl, storeOpts...),
+			addr,
+			dst,
+			exporter.WithVerifyChunks(verify, false),
+			exporter.WithSkipUnreadable(skipUnread),
+			exporter.WithChecksumFile(sha256File),
+			exporter.WithLogger(logger),
 			exporter.WithProgressUpdater(updater),
 		)
 		if err != nil {
 			return err
 		}
-		cmd.Println("Exported database to " + dstFilename)
-		return nil
+		digest := updater.digests[0]
+		return printResult(
+			cmd,
+			fmt.Sprintf("Exported reference to %s SHA-256 %s", dst, digest),
+			dst,
+			exportOutput{DestinationFile: dst, SHA256: digest},
+		)
 	},
 }
 
+func addExportReferenceCommand(root *cobra.Command) {
+	addAPIFlags(exportReference)
+	exportReference.Flags().StringVar(&refDstFile, "destination-file", "", "archive file to create, named after the reference by default")
+	exportReference.Flags().BoolVar(&verify, "verify", false, "verify that chunk data matches the chunk address")
+	exportReference.Flags().BoolVar(&skipUnread, "skip-unreadable", false, "skip the chunks which cannot be retrieved instead of failing")
+	exportReference.Flags().BoolVar(&sha256File, "checksum-file", false, "write the SHA-256 digest of the archive to a .sha256 file next to it")
+	root.AddCommand(exportReference)
+}
+
 func addExportDBCommand(root *cobra.Command) {
 	exportDB.Flags().StringVar(&dstFilename, "destination-file", "swarm-exportdb.tar", "The filename along with complete path to be used for creating archive")
+	exportDB.Flags().StringVar(&addrsFile, "addresses", "", "file with hex chunk addresses, one per line, to limit the export to")
+	exportDB.Flags().StringVar(&excludeFile, "exclude", "", "file with hex chunk addresses, one per line, or export archive, e.g. of a peer node, whose chunks are left out of the export")
+	exportDB.Flags().BoolVar(&verify, "verify", false, "verify that chunk data matches the chunk address")
+	exportDB.Flags().BoolVar(&skipCorrupt, "skip-corrupt", false, "skip corrupt chunks instead of failing, used with --verify")
+	exportDB.Flags().BoolVar(&skipUnread, "skip-unreadable", false, "skip the chunks whose data cannot be read instead of failing")
+	exportDB.Flags().BoolVar(&byteProg, "byte-progress", false, "report progress by bytes written instead of chunk count")
+	exportDB.Flags().StringVar(&checkpoint, "checkpoint", "", "checkpoint file used to resume an interrupted export")
+	exportDB.Flags().BoolVar(&sha256File, "checksum-file", false, "write the SHA-256 digest of the archive to a .sha256 file next to it")
+	exportDB.Flags().Int64Var(&volumeSize, "max-volume-size", 0, "split the archive in volumes of at most this many bytes, 0 means a single archive")
+	exportDB.Flags().IntVar(&concurrency, "concurrency", 1, "number of workers reading and verifying the chunks, the archive is still written in order")
+	exportDB.Flags().BoolVar(&exportPins, "pins", false, "add the pin counters of the chunks to the archive, which makes it export version 2, see restore-pins")
 	root.AddCommand(exportDB)
 }
 
@@ -201,15 +896,75 @@ Example:
 	> 94434d3312320fab70428c39b79dffb4abc3dbedf3e1562384a61ceaf8a7e36b`,
 		SilenceUsage: true,
 		PersistentPreRunE: func(cmd *cobra.Command, args []string) (err error) {
+			if err := applyConfig(cmd); err != nil {
+				return err
+			}
+			if err := applyEnv(cmd); err != nil {
+				return err
+			}
+			if err := checkLinkFlags(); err != nil {
+				return err
+			}
+			startTimeout(timeout)
 			logger, err = cmdfile.SetLogger(cmd, verbosity)
-			return err
+			if err != nil {
+				return err
+			}
+			progress, err = progressUpdater(cmd)
+			if err != nil {
+				return err
+			}
+			mimeTypes, err = readMimeMap(mimeMap)
+			if err != nil {
+				return err
+			}
+			storeOpts, err = apiStoreOptions()
+			if err != nil {
+				return err
+			}
+			// shared by the references repaired by the command
+			budgetOpt = repair.WithMaxBufferedBytes(maxBuffered)
+			putModeOpts, err = putModeOptions()
+			if err != nil {
+				return err
+			}
+			symlinkOpt, err = symlinkOption()
+			if err != nil {
+				return err
+			}
+			if err := pingAPI(cmd); err != nil {
+				return err
+			}
+			return checkNodeVersion(cmd)
+		},
+		PersistentPostRunE: func(cmd *cobra.Command, args []string) error {
+			return closeProgressLog()
 		},
 	}
 
 	addRepairCommands(c)
 	addExportDBCommand(c)
+	addExportReferenceCommand(c)
+	addBundleCommands(c)
+	addCatCommand(c)
+	addVerifyCommand(c)
+	addListCommand(c)
+	addInspectCommand(c)
+	addLookupCommand(c)
+	addMigrateDBCommand(c)
+	addRestorePinsCommand(c)
+	addPredictCommand(c)
+	addUpgradeArchiveCommand(c)
+	addEstimateCommand(c)
+	addSelfTestCommand(c)
 
 	c.PersistentFlags().StringVar(&verbosity, "info", "0", "log verbosity level 0=silent, 1=error, 2=warn, 3=info, 4=debug, 5=trace")
+	c.PersistentFlags().BoolVar(&quiet, "quiet", false, "print only the resulting reference")
+	c.PersistentFlags().BoolVar(&jsonOutput, "json", false, "print only the result as json")
+	c.PersistentFlags().StringVar(&configFile, "config", "", "yaml file with the default values of the flags, keyed by flag name, ~/"+defaultConfigName+" by default")
+	c.PersistentFlags().StringVar(&progLog, "progress-log", "", "file the repair progress is logged to with timestamps instead of the console")
+	c.PersistentFlags().StringVar(&progLogMode, "progress-log-mode", "append", "what happens to an existing progress log: append, truncate, or rotate to <file>.1")
+	c.PersistentFlags().DurationVar(&timeout, "timeout", 0, "cancel the command when it runs longer than this, e.g. 30m, 0 means no timeout")
 
 	rootCmd.AddCommand(c)
 }
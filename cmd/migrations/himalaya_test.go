@@ -0,0 +1,28 @@
+// Copyright 2021 The Swarm Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package migrations
+
+import (
+	"testing"
+	"time"
+)
+
+func TestProgressLine(t *testing.T) {
+	for _, tc := range []struct {
+		curr, total int
+		elapsed     time.Duration
+		want        string
+	}{
+		{0, 100, time.Minute, "Progress 0 %"},
+		{25, 100, time.Minute, "Progress 25 %, about 3m0s left"},
+		{2, 3, 1500 * time.Millisecond, "Progress 66 %, about 1s left"},
+		{100, 100, time.Hour, "Progress 100 %"},
+		{10, 100, 0, "Progress 10 %"},
+	} {
+		if got := progressLine(tc.curr, tc.total, tc.elapsed); got != tc.want {
+			t.Errorf("progressLine(%d, %d, %s) = %q, want %q", tc.curr, tc.total, tc.elapsed, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,118 @@
+// Copyright 2021 The Swarm Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package migrations
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/ethersphere/bee-repair/internal/repair"
+	"github.com/ethersphere/bee/pkg/swarm"
+	"github.com/spf13/cobra"
+)
+
+var indexRepair = &cobra.Command{
+	Use:   "index <reference>",
+	Short: "Repair every file and directory an index manifest points to",
+	Long: `Reads an index manifest whose entries point to whole files or directories of the old format, e.g. the sites of a deployment, repairs each of them and stores a new index manifest with the new references at the same paths.
+
+Example:
+
+	$ bee-repair himalaya index 2387e8e7d8a48c2a9339c97c1dc3461a9a7aa07e994c5cb8b38fd7c1b3e6ea48
+	> Repaired directory reference. New reference 7a2b5c0d9e3f4a1b8c6d2e0f1a3b5c7d9e1f2a4b6c8d0e2f4a6b8c0d2e4f6a8b
+	> Repaired file reference. New reference 0e8f2a4c6e8a0c2e4a6c8e0a2c4e6a8c0e2a4c6e8a0c2e4a6c8e0a2c4e6a8c0e
+	> Repaired index reference. New reference 94434d3312320fab70428c39b79dffb4abc3dbedf3e1562384a61ceaf8a7e36b
+
+The index can be a manifest of the new format or a directory of the old one. Its entries which are not of the old format, such as feeds, are carried over as they are. The repair options apply to every file and directory, and --index-document and --error-document to the new index as well.`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		addr, err := parseReference(args[0])
+		if err != nil {
+			return err
+		}
+		return withMappingDB(func() error {
+			return repairIndex(cmd, addr)
+		})
+	},
+}
+
+// repairIndex repairs the old entries of the index and stores the new index
+func repairIndex(cmd *cobra.Command, addr swarm.Address) error {
+	if err := ensureExists(cmd, addr); err != nil {
+		return err
+	}
+	idx, err := repair.ReadIndex(cmd.Context(), addr, storeOption(), repair.WithLogger(logger), repair.WithSkipErrors(skipErrors))
+	if err != nil {
+		return err
+	}
+
+	batchRepair = true
+	defer func() { batchRepair = false }()
+
+	var skipped []*repair.FileError
+	for _, e := range idx.Entries {
+		if e.Err != nil {
+			skipped = append(skipped, &repair.FileError{Path: e.Path, Err: e.Err})
+			continue
+		}
+		if !e.OldFormat {
+			continue
+		}
+		fn := repairFileReference
+		if e.Directory {
+			fn = repairDirectoryReference
+		}
+		filePath = ""
+		newReference, err := fn(cmd, e.Reference)
+		var skippedFiles *repair.SkippedError
+		if err != nil && !errors.As(err, &skippedFiles) {
+			if !skipErrors {
+				return fmt.Errorf("index entry %s: %w", e.Path, err)
+			}
+			e.Err = err
+			skipped = append(skipped, &repair.FileError{Path: e.Path, Err: err})
+			continue
+		}
+		e.NewReference = newReference
+	}
+
+	opts := []repair.Option{
+		storeOption(),
+		repair.WithLogger(logger),
+		repair.WithEncryption(encrypted),
+		repair.WithPin(pin),
+		repair.WithIndexDocument(indexDoc),
+		repair.WithErrorDocument(errorDoc),
+		repair.WithVerifyReference(verifyRef),
+	}
+	opts = append(opts, putModeOpts...)
+	opts = append(opts, mappingOptions()...)
+	newReference, err := repair.WriteIndex(cmd.Context(), idx, opts...)
+	if err != nil {
+		return err
+	}
+	for _, f := range skipped {
+		cmd.PrintErrln("Skipped " + f.Error())
+	}
+	if err := printReference(cmd, "Repaired index reference. New reference ", newReference); err != nil {
+		return err
+	}
+	if len(skipped) > 0 {
+		return &repair.SkippedError{Files: skipped}
+	}
+	return nil
+}
+
+// addIndexFlags adds the directory flags of the index command, which is added to
+// the root along with the other repair commands
+func addIndexFlags() {
+	indexRepair.Flags().DurationVar(&fileTimeout, "file-timeout", 0, "timeout for reading each file, 0 means no timeout")
+	indexRepair.Flags().BoolVar(&skipErrors, "skip-errors", false, "leave out the files, directories and index entries which cannot be repaired")
+	indexRepair.Flags().BoolVar(&checkSize, "validate-size", false, "read every file and check its length against the recorded size, mismatches are handled like --skip-errors")
+	indexRepair.Flags().BoolVar(&dirMtdt, "directory-metadata", false, "carry over the metadata of the subdirectories, not only of the root")
+	indexRepair.Flags().StringVar(&symlinks, "symlinks", "error", "what to do with the symlink entries of the old manifests: error, skip or resolve")
+	indexRepair.Flags().StringSliceVar(&excludes, "exclude", nil, "glob pattern of the files left out of the repaired directories, can be repeated")
+	indexRepair.Flags().IntVar(&flushEvery, "flush-interval", 0, "store the new manifest of a directory every this many files, 0 stores it once")
+}
@@ -5,9 +5,16 @@
 package migrations
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"os"
+	"os/signal"
+	"sync/atomic"
+	"syscall"
+	"time"
 
+	"github.com/ethersphere/bee-repair/internal/repair"
 	"github.com/spf13/cobra"
 )
 
@@ -19,10 +26,78 @@ func Run() {
 
 	InitHimalayaCommands(c)
 
-	c.SetOutput(c.OutOrStdout())
-	err := c.Execute()
+	c.SetOut(c.OutOrStdout())
+	ctx, cancel := interruptContext()
+	defer cancel()
+	runCancel = cancel
+	err := c.ExecuteContext(ctx)
 	if err != nil {
+		if atomic.LoadInt32(&timedOut) == 1 {
+			err = fmt.Errorf("timed out after %s: %w", timeout, err)
+		}
 		fmt.Fprintln(os.Stderr, err.Error())
-		os.Exit(1)
+		code, hint := describeError(err)
+		if hint != "" {
+			fmt.Fprintln(os.Stderr, hint)
+		}
+		cancel()
+		os.Exit(code)
 	}
 }
+
+// exit codes of the common failures, any other failure exits with 1
+const (
+	exitNotFound       = 2
+	exitNotOldFormat   = 3
+	exitUploadRejected = 4
+)
+
+// describeError returns the exit code of the error and a hint on how to resolve it
+func describeError(err error) (code int, hint string) {
+	switch {
+	case errors.Is(err, repair.ErrNotFound):
+		return exitNotFound, "The content is not available on the node, check the reference and that the node is connected to the network"
+	case errors.Is(err, repair.ErrNotOldFormat):
+		return exitNotOldFormat, "The reference does not point to content uploaded with bee v0.5.3 or older, it might not need a repair"
+	case errors.Is(err, repair.ErrUploadRejected):
+		return exitUploadRejected, "The node did not accept the new chunks, check its logs and that it allows uploads"
+	}
+	return 1, ""
+}
+
+var (
+	runCancel context.CancelFunc // cancels the context the commands run with
+	timedOut  int32              // set once the timeout of the command expired
+)
+
+// startTimeout cancels the context the commands run with once the timeout expires.
+// The context of a command can not be replaced once it runs, so the one set up by
+// Run is cancelled instead
+func startTimeout(d time.Duration) {
+	if d <= 0 || runCancel == nil {
+		return
+	}
+	time.AfterFunc(d, func() {
+		atomic.StoreInt32(&timedOut, 1)
+		runCancel()
+	})
+}
+
+// interruptContext returns a context which is cancelled on the first SIGINT or
+// SIGTERM, so that the commands can stop cleanly and keep the completed work. A
+// second signal kills the process
+func interruptContext() (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(context.Background())
+	sigC := make(chan os.Signal, 1)
+	signal.Notify(sigC, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		defer signal.Stop(sigC)
+		select {
+		case <-sigC:
+			fmt.Fprintln(os.Stderr, "Interrupted, stopping...")
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	return ctx, cancel
+}
@@ -0,0 +1,54 @@
+// Copyright 2021 The Swarm Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package migrations
+
+import (
+	"github.com/ethersphere/bee-repair/internal/repair"
+	"github.com/spf13/cobra"
+)
+
+var inspectReference = &cobra.Command{
+	Use:   "inspect <reference>",
+	Short: "Print the decoded old entry of a file reference",
+	Long: `Reads the entry and the metadata of a file reference of the old format, as the repair does, and prints them as json, along with the metadata as it is stored. Nothing is stored, so no postage stamp is needed.
+
+Example:
+
+	$ bee-repair himalaya inspect 2387e8e7d8a48c2a9339c97c1dc3461a9a7aa07e994c5cb8b38fd7c1b3e6ea48
+	> {
+	>   "reference": "0e8f2a4c6e8a0c2e4a6c8e0a2c4e6a8c0e2a4c6e8a0c2e4a6c8e0a2c4e6a8c0e",
+	>   "metadata_reference": "5a2c6e8a0c2e4a6c8e0a2c4e6a8c0e2a4c6e8a0c2e4a6c8e0a2c4e6a8c0e2a4c",
+	>   "filename": "index.html",
+	>   "mimetype": "text/html; charset=utf-8",
+	>   "metadata": {"mimetype":"text/html; charset=utf-8","filename":"index.html"}
+	> }
+
+It helps to tell why a repair produces unexpected filenames or content types.`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		addr, err := parseReference(args[0])
+		if err != nil {
+			return err
+		}
+
+		i, err := repair.Inspect(
+			cmd.Context(),
+			addr,
+			repair.WithAPIStore(host, port, ssl, storeOpts...),
+			repair.WithLogger(logger),
+			repair.WithMetadataLimit(mtdtLimit),
+		)
+		if err != nil {
+			return err
+		}
+		return printInspection(cmd, i)
+	},
+}
+
+func addInspectCommand(root *cobra.Command) {
+	addAPIFlags(inspectReference)
+	inspectReference.Flags().Int64Var(&mtdtLimit, "metadata-limit", 0, "maximum size in bytes of the old metadata, 0 means the default of 16 chunks")
+	root.AddCommand(inspectReference)
+}
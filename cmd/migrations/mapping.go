@@ -0,0 +1,88 @@
+// Copyright 2021 The Swarm Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package migrations
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/ethersphere/bee-repair/internal/mapping"
+	"github.com/ethersphere/bee-repair/internal/repair"
+	"github.com/ethersphere/bee/pkg/storage"
+	"github.com/spf13/cobra"
+)
+
+var mappingDB string // flag variable, database recording the repaired references
+
+// mappings is the open mapping database while a command runs with --mapping-db
+var mappings *mapping.Store
+
+// withMappingDB runs fn with the mapping database of the flag open, if any
+func withMappingDB(fn func() error) (err error) {
+	if mappingDB == "" {
+		return fn()
+	}
+	mappings, err = mapping.Open(mappingDB)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if cerr := mappings.Close(); err == nil {
+			err = cerr
+		}
+		mappings = nil
+	}()
+	return fn()
+}
+
+// mappingOptions returns the options recording the repaired references in the
+// open mapping database
+func mappingOptions() []repair.Option {
+	if mappings == nil {
+		return nil
+	}
+	return []repair.Option{repair.WithMappingRecorder(mappings)}
+}
+
+func addMappingDBFlag(cmd *cobra.Command) {
+	cmd.Flags().StringVar(&mappingDB, "mapping-db", "", "database directory recording the new reference of every repaired reference")
+}
+
+var lookupReference = &cobra.Command{
+	Use:   "lookup <old reference>",
+	Short: "Look up the new reference of a repaired reference",
+	Long: `Prints the reference an old reference was repaired to, as recorded in the mapping database by the repair commands run with --mapping-db.
+
+Example:
+
+	$ bee-repair himalaya lookup 2387e8e7d8a48c2a9339c97c1dc3461a9a7aa07e994c5cb8b38fd7c1b3e6ea48 --mapping-db migrated.db
+	> 94434d3312320fab70428c39b79dffb4abc3dbedf3e1562384a61ceaf8a7e36b`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if mappingDB == "" {
+			return errors.New("--mapping-db is required")
+		}
+		addr, err := parseReference(args[0])
+		if err != nil {
+			return err
+		}
+		return withMappingDB(func() error {
+			newReference, err := mappings.Get(addr)
+			if errors.Is(err, storage.ErrNotFound) {
+				return fmt.Errorf("%s: reference not found in mapping database", addr)
+			}
+			if err != nil {
+				return err
+			}
+			return printReference(cmd, "New reference ", newReference)
+		})
+	},
+}
+
+func addLookupCommand(root *cobra.Command) {
+	addMappingDBFlag(lookupReference)
+	addLinkFlags(lookupReference)
+	root.AddCommand(lookupReference)
+}
@@ -0,0 +1,118 @@
+// Copyright 2021 The Swarm Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package migrations
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/ethersphere/bee-repair/internal/exporter"
+	"github.com/ethersphere/bee-repair/internal/repair"
+	"github.com/ethersphere/bee/pkg/swarm"
+	"github.com/spf13/cobra"
+)
+
+var mappingFile string // flag variable, file recording the migrated references
+
+var migrateDB = &cobra.Command{
+	Use:   "migrate-db <database path>",
+	Short: "Repair all the content of the local database",
+	Long: `Finds the file and directory references of the old format in the local database, repairs each of them and records the old and the new reference in the mapping file, one pair per line.
+
+The old entries are read from the database and the new manifests are uploaded through the api. The chunks of the content itself are not uploaded, the node is expected to already have them, e.g. by importing an archive created with export-db.
+
+Example:
+
+	$ bee-repair himalaya migrate-db /home/user/.bee/localstore --mapping-file migrated.txt`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return withMappingDB(func() error {
+			return migrateDatabase(cmd, args[0])
+		})
+	},
+}
+
+// migrateDatabase repairs the roots found in the database at path
+func migrateDatabase(cmd *cobra.Command, path string) (err error) {
+	store, err := exporter.OpenStore(path)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	var candidates []swarm.Address
+	err = store.Iterate(func(ch swarm.Chunk) (bool, error) {
+		if repair.IsEntryChunk(ch) {
+			candidates = append(candidates, ch.Address())
+		}
+		return false, nil
+	})
+	if err != nil {
+		return err
+	}
+
+	opts := []repair.Option{
+		repair.WithAPIStore(host, port, ssl, storeOpts...),
+		repair.WithSourceStore(store),
+		repair.WithLogger(logger),
+		repair.WithEncryption(encrypted),
+	}
+	opts = append(opts, mappingOptions()...)
+	roots, err := repair.FindRoots(cmd.Context(), candidates, opts...)
+	if err != nil {
+		return err
+	}
+	if !scriptOutput() {
+		cmd.Printf("Found %d references to migrate\n", len(roots))
+	}
+
+	f, err := os.Create(mappingFile)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+	}()
+
+	failed := 0
+	for i, root := range roots {
+		rootOpts := append(repairProgressOptions(cmd, root.Reference, false), opts...)
+
+		var newReference swarm.Address
+		if root.Directory {
+			newReference, err = repair.DirectoryRepair(cmd.Context(), root.Reference, rootOpts...)
+		} else {
+			newReference, err = repair.FileRepair(cmd.Context(), root.Reference, rootOpts...)
+		}
+		if errors.Is(err, context.Canceled) {
+			cmd.PrintErrf("Interrupted after migrating %d of %d references\n", i-failed, len(roots))
+			return err
+		}
+		if err != nil {
+			cmd.PrintErrf("Failed migrating %s: %v\n", root.Reference, err)
+			failed++
+			continue
+		}
+		if _, err := fmt.Fprintf(f, "%s %s\n", root.Reference, newReference); err != nil {
+			return err
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("failed migrating %d of %d references", failed, len(roots))
+	}
+	return printResult(cmd, "Migrated references written to "+mappingFile, mappingFile, exportOutput{DestinationFile: mappingFile})
+}
+
+func addMigrateDBCommand(root *cobra.Command) {
+	addAPIFlags(migrateDB)
+	migrateDB.Flags().BoolVar(&encrypted, "encrypt", false, "use encryption")
+	migrateDB.Flags().StringVar(&mappingFile, "mapping-file", "swarm-migrated.txt", "file recording the old and the new references")
+	addMappingDBFlag(migrateDB)
+	root.AddCommand(migrateDB)
+}
@@ -0,0 +1,297 @@
+// Copyright 2021 The Swarm Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package migrations
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"text/tabwriter"
+
+	"github.com/ethersphere/bee-repair/internal/repair"
+	"github.com/ethersphere/bee/pkg/swarm"
+	"github.com/spf13/cobra"
+)
+
+var (
+	quiet      bool   // flag variable, prints only the result
+	jsonOutput bool   // flag variable, prints the result as json
+	outputRef  string // flag variable, file the bare new references are written to
+	linkBase   string // flag variable, gateway url the links to the new references start with
+	linkOnly   bool   // flag variable, prints only the link to the new reference
+)
+
+type referenceOutput struct {
+	NewReference string `json:"new_reference"`
+	Link         string `json:"link,omitempty"`
+}
+
+type differenceOutput struct {
+	Path         string `json:"path"`
+	OldReference string `json:"old_reference,omitempty"`
+	NewReference string `json:"new_reference,omitempty"`
+}
+
+type listOutput struct {
+	Path        string `json:"path"`
+	Reference   string `json:"reference"`
+	ContentType string `json:"content_type"`
+	Size        int64  `json:"size"`
+}
+
+type inspectOutput struct {
+	Reference         string          `json:"reference"`
+	MetadataReference string          `json:"metadata_reference"`
+	Filename          string          `json:"filename"`
+	MimeType          string          `json:"mimetype"`
+	Size              int64           `json:"size,omitempty"`
+	Metadata          json.RawMessage `json:"metadata"`
+}
+
+type exportOutput struct {
+	DestinationFile string         `json:"destination_file"`
+	SHA256          string         `json:"sha256,omitempty"`
+	Volumes         []volumeOutput `json:"volumes,omitempty"`
+}
+
+type restoreOutput struct {
+	Chunks int `json:"chunks"`
+}
+
+type volumeOutput struct {
+	File   string `json:"file"`
+	SHA256 string `json:"sha256"`
+}
+
+// scriptOutput reports whether the output is meant to be consumed by scripts,
+// in which case nothing but the result is printed
+func scriptOutput() bool {
+	return quiet || jsonOutput
+}
+
+var progressLog *repair.FileLogUpdater // set with --progress-log
+
+var progressLogModes = map[string]repair.LogFileMode{
+	"append":   repair.LogAppend,
+	"truncate": repair.LogTruncate,
+	"rotate":   repair.LogRotate,
+}
+
+// progressUpdater returns the updater the repair progress is reported to, the
+// console or the --progress-log file
+func progressUpdater(cmd *cobra.Command) (*repair.SyncUpdater, error) {
+	if progLog == "" {
+		return repair.NewSyncUpdater(cmd.OutOrStdout()), nil
+	}
+	mode, ok := progressLogModes[progLogMode]
+	if !ok {
+		return nil, fmt.Errorf("unknown progress log mode %q, expected append, truncate or rotate", progLogMode)
+	}
+	var err error
+	progressLog, err = repair.NewFileLogUpdater(progLog, mode)
+	if err != nil {
+		return nil, err
+	}
+	return progressLog.SyncUpdater, nil
+}
+
+func closeProgressLog() error {
+	if progressLog == nil {
+		return nil
+	}
+	err := progressLog.Close()
+	progressLog = nil
+	return err
+}
+
+// repairProgressOptions returns the options used to report the progress of the
+// repair of addr, unless the output is meant for scripts and not logged to a file.
+// When several references are repaired the messages are prefixed with the reference
+func repairProgressOptions(cmd *cobra.Command, addr swarm.Address, counting bool) []repair.Option {
+	if scriptOutput() && progressLog == nil {
+		return nil
+	}
+	var updater repair.ProgressUpdater = progress
+	if batchRepair || progressLog != nil {
+		updater = progress.WithPrefix(addr.String() + ": ")
+	}
+	opts := []repair.Option{
+		repair.WithProgressUpdater(updater),
+	}
+	// the percentage is meant for the console only, for one reference at a time
+	if counting && progressLog == nil && (!batchRepair || workers <= 1) {
+		counter := newPercentUpdater(cmd)
+		counter.start(cmd.Context())
+		opts = append(opts, repair.WithCountingProgressUpdater(counter))
+	}
+	return opts
+}
+
+// addLinkFlags adds the flags which print links to the new references
+func addLinkFlags(cmd *cobra.Command) {
+	cmd.Flags().StringVar(&linkBase, "link-base", "", "gateway url, e.g. https://gateway.ethswarm.org, to print the link <url>/bzz/<new reference>/ after the new reference")
+	cmd.Flags().BoolVar(&linkOnly, "link-only", false, "print only the link to the new reference, used with --link-base")
+}
+
+// checkLinkFlags validates the link flags before anything is repaired
+func checkLinkFlags() error {
+	if linkOnly && linkBase == "" {
+		return errors.New("--link-only requires --link-base")
+	}
+	if linkBase == "" {
+		return nil
+	}
+	u, err := url.Parse(linkBase)
+	if err != nil || u.Scheme == "" || u.Host == "" {
+		return fmt.Errorf("invalid link base %q, expected a url like https://gateway.ethswarm.org", linkBase)
+	}
+	return nil
+}
+
+// referenceLink returns the gateway link to the reference, or an empty string
+// without --link-base
+func referenceLink(ref swarm.Address) string {
+	if linkBase == "" {
+		return ""
+	}
+	return strings.TrimSuffix(linkBase, "/") + "/bzz/" + ref.String() + "/"
+}
+
+// printReference prints the new reference in the format selected by the flags,
+// followed by its link with --link-base
+func printReference(cmd *cobra.Command, msg string, ref swarm.Address) error {
+	link := referenceLink(ref)
+	out := referenceOutput{NewReference: ref.String(), Link: link}
+	switch {
+	case linkOnly && !jsonOutput:
+		_, err := fmt.Fprintln(cmd.OutOrStdout(), link)
+		return err
+	case link != "" && !scriptOutput():
+		msg = msg + ref.String() + "\n" + link
+	default:
+		msg = msg + ref.String()
+	}
+	return printResult(cmd, msg, ref.String(), out)
+}
+
+// resetReferenceFile empties the --output-ref file before the references of a
+// command are written to it
+func resetReferenceFile() error {
+	if outputRef == "" {
+		return nil
+	}
+	return ioutil.WriteFile(outputRef, nil, 0644)
+}
+
+// referenceFileMtx serializes the writes of the references repaired at once
+var referenceFileMtx sync.Mutex
+
+// writeReferenceFile appends the bare new reference to the --output-ref file as
+// soon as it is stored, before the message meant for humans is printed
+func writeReferenceFile(ref swarm.Address) (err error) {
+	if outputRef == "" {
+		return nil
+	}
+	referenceFileMtx.Lock()
+	defer referenceFileMtx.Unlock()
+
+	f, err := os.OpenFile(outputRef, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+	}()
+	_, err = fmt.Fprintln(f, ref.String())
+	return err
+}
+
+// printDifferences prints one line for every difference of the manifests, or the
+// json encoded list with --json
+func printDifferences(cmd *cobra.Command, diffs []*repair.Difference) error {
+	if jsonOutput {
+		out := make([]differenceOutput, 0, len(diffs))
+		for _, d := range diffs {
+			o := differenceOutput{Path: d.Path}
+			if !d.OldReference.Equal(swarm.ZeroAddress) {
+				o.OldReference = d.OldReference.String()
+			}
+			if !d.NewReference.Equal(swarm.ZeroAddress) {
+				o.NewReference = d.NewReference.String()
+			}
+			out = append(out, o)
+		}
+		return json.NewEncoder(cmd.OutOrStdout()).Encode(out)
+	}
+	for _, d := range diffs {
+		if _, err := fmt.Fprintln(cmd.OutOrStdout(), d.String()); err != nil {
+			return err
+		}
+	}
+	if len(diffs) == 0 && !quiet {
+		cmd.Println("Manifests match")
+	}
+	return nil
+}
+
+// printList prints the files as a table, or the json encoded list with --json.
+// The table header is left out with --quiet
+func printList(cmd *cobra.Command, entries []*repair.ListEntry) error {
+	if jsonOutput {
+		out := make([]listOutput, 0, len(entries))
+		for _, e := range entries {
+			out = append(out, listOutput{Path: e.Path, Reference: e.Reference.String(), ContentType: e.ContentType, Size: e.Size})
+		}
+		return json.NewEncoder(cmd.OutOrStdout()).Encode(out)
+	}
+	w := tabwriter.NewWriter(cmd.OutOrStdout(), 0, 0, 2, ' ', 0)
+	if !quiet {
+		if _, err := fmt.Fprintln(w, "PATH\tCONTENT TYPE\tSIZE"); err != nil {
+			return err
+		}
+	}
+	for _, e := range entries {
+		if _, err := fmt.Fprintf(w, "%s\t%s\t%d\n", e.Path, e.ContentType, e.Size); err != nil {
+			return err
+		}
+	}
+	return w.Flush()
+}
+
+// printInspection prints the decoded old entry as indented json
+func printInspection(cmd *cobra.Command, i *repair.Inspection) error {
+	enc := json.NewEncoder(cmd.OutOrStdout())
+	enc.SetIndent("", "  ")
+	return enc.Encode(inspectOutput{
+		Reference:         i.Reference.String(),
+		MetadataReference: i.MetadataReference.String(),
+		Filename:          i.Filename,
+		MimeType:          i.MimeType,
+		Size:              i.Size,
+		Metadata:          json.RawMessage(i.RawMetadata),
+	})
+}
+
+// printResult prints the message, or the bare result with --quiet, or the json
+// encoded value with --json
+func printResult(cmd *cobra.Command, msg, result string, v interface{}) error {
+	switch {
+	case jsonOutput:
+		return json.NewEncoder(cmd.OutOrStdout()).Encode(v)
+	case quiet:
+		_, err := fmt.Fprintln(cmd.OutOrStdout(), result)
+		return err
+	default:
+		cmd.Println(msg)
+		return nil
+	}
+}
@@ -0,0 +1,62 @@
+// Copyright 2021 The Swarm Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package migrations
+
+import (
+	"fmt"
+
+	"github.com/ethersphere/bee-repair/internal/exporter"
+	cmdfile "github.com/ethersphere/bee-repair/pkg/file"
+	"github.com/spf13/cobra"
+)
+
+var exportPins bool // flag variable, adds the pin counters to the exported archive
+
+var restorePins = &cobra.Command{
+	Use:   "restore-pins <archive>",
+	Short: "Pin the chunks of an imported archive again",
+	Long: `Reads the pin counters recorded in an archive exported with export-db --pins and pins every chunk on the node as many times. The chunks must have been imported to the node first.
+
+Example:
+
+	$ bee-repair himalaya export-db /home/user/.bee/localstore --pins
+	$ bee-repair himalaya restore-pins swarm-exportdb.tar
+
+With volumes the pins are recorded in the last volume.`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		pins, err := exporter.ReadPins(args[0])
+		if err != nil {
+			return err
+		}
+		if pins == nil {
+			return fmt.Errorf("no pins recorded in %s, export the database with --pins", args[0])
+		}
+
+		api := cmdfile.NewAPIStore(host, port, ssl, storeOpts...).(*cmdfile.APIStore)
+		var updater *percentUpdater
+		if !scriptOutput() {
+			updater = newPercentUpdater(cmd)
+			updater.start(cmd.Context())
+		}
+		for i, p := range pins {
+			for c := uint64(0); c < p.Counter; c++ {
+				if err := api.Pin(cmd.Context(), p.Address); err != nil {
+					return fmt.Errorf("restored the pins of %d of %d chunks: %w", i, len(pins), err)
+				}
+			}
+			if updater != nil {
+				updater.Update(i+1, len(pins))
+			}
+		}
+		msg := fmt.Sprintf("Restored the pins of %d chunks", len(pins))
+		return printResult(cmd, msg, fmt.Sprint(len(pins)), restoreOutput{Chunks: len(pins)})
+	},
+}
+
+func addRestorePinsCommand(root *cobra.Command) {
+	addAPIFlags(restorePins)
+	root.AddCommand(restorePins)
+}
@@ -0,0 +1,113 @@
+// Copyright 2021 The Swarm Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package migrations
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/ethersphere/bee-repair/internal/exporter"
+	"github.com/ethersphere/bee-repair/internal/repair"
+	"github.com/ethersphere/bee/pkg/storage"
+	"github.com/ethersphere/bee/pkg/swarm"
+	"github.com/spf13/cobra"
+)
+
+var (
+	archives  []string // flag variable, export archives the old content is read from
+	predictDB string   // flag variable, database the old content is read from
+)
+
+var predictReference = &cobra.Command{
+	Use:   "predict <reference>",
+	Short: "Compute the new reference of old content without a node",
+	Long: `Repairs a file or directory reference in memory, reading the old content from export archives or from the database of a stopped node, and prints the new reference it migrates to. Nothing is uploaded and no network access is needed.
+
+Example:
+
+	$ bee-repair himalaya predict 2387e8e7d8a48c2a9339c97c1dc3461a9a7aa07e994c5cb8b38fd7c1b3e6ea48 --archive swarm-exportdb.tar
+	> Predicted new reference 94434d3312320fab70428c39b79dffb4abc3dbedf3e1562384a61ceaf8a7e36b
+
+The archive flag can be repeated, e.g. for the volumes of an archive. Whether the reference is a file or a directory is detected from its entry.`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		addr, err := parseReference(args[0])
+		if err != nil {
+			return err
+		}
+		return predict(cmd, addr)
+	},
+}
+
+// predict repairs the reference in memory and prints the new reference
+func predict(cmd *cobra.Command, addr swarm.Address) error {
+	if (len(archives) == 0) == (predictDB == "") {
+		return errors.New("either --archive or --db is required")
+	}
+
+	store := repair.NewMemoryStore()
+	opts := []repair.Option{
+		repair.WithMemoryStore(store),
+		repair.WithLogger(logger),
+		repair.WithIndexDocument(indexDoc),
+		repair.WithErrorDocument(errorDoc),
+		repair.WithDirectoryMetadata(dirMtdt),
+		repair.WithMimeOverrides(mimeTypes),
+	}
+	for _, fname := range archives {
+		err := exporter.ReadArchive(fname, func(ch swarm.Chunk) error {
+			_, err := store.Put(cmd.Context(), storage.ModePutUpload, ch)
+			return err
+		})
+		if err != nil {
+			return err
+		}
+	}
+	if predictDB != "" {
+		db, err := exporter.OpenStore(predictDB)
+		if err != nil {
+			return err
+		}
+		defer db.Close()
+		opts = append(opts, repair.WithSourceStore(db))
+	}
+
+	found, err := repair.Exists(cmd.Context(), addr, opts...)
+	if err != nil {
+		return err
+	}
+	if !found {
+		return fmt.Errorf("reference %s not found in the old content: %w", addr, repair.ErrNotFound)
+	}
+	roots, err := repair.FindRoots(cmd.Context(), []swarm.Address{addr}, opts...)
+	if err != nil {
+		return err
+	}
+	if len(roots) == 0 {
+		return fmt.Errorf("reference %s: %w", addr, repair.ErrNotOldFormat)
+	}
+
+	var newReference swarm.Address
+	if roots[0].Directory {
+		newReference, err = repair.DirectoryRepair(cmd.Context(), addr, opts...)
+	} else {
+		newReference, err = repair.FileRepair(cmd.Context(), addr, opts...)
+	}
+	if err != nil {
+		return err
+	}
+	return printReference(cmd, "Predicted new reference ", newReference)
+}
+
+func addPredictCommand(root *cobra.Command) {
+	predictReference.Flags().StringSliceVar(&archives, "archive", nil, "export archive the old content is read from, can be repeated")
+	predictReference.Flags().StringVar(&predictDB, "db", "", "path of the localstore of a stopped bee node the old content is read from, nothing is written to it")
+	predictReference.Flags().StringVar(&indexDoc, "index-document", "", "index document of the new manifest, overrides the one of the old entry")
+	predictReference.Flags().StringVar(&errorDoc, "error-document", "", "error document of the new manifest, overrides the one of the old entry")
+	predictReference.Flags().StringVar(&mimeMap, "mime-map", "", "json file mapping file extensions to the content types they are served with")
+	predictReference.Flags().BoolVar(&dirMtdt, "directory-metadata", false, "carry over the metadata of the subdirectories, not only of the root")
+	addLinkFlags(predictReference)
+	root.AddCommand(predictReference)
+}
@@ -0,0 +1,239 @@
+// Copyright 2021 The Swarm Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package migrations
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/ethersphere/bee-repair/internal/ens"
+	"github.com/ethersphere/bee-repair/internal/repair"
+	"github.com/ethersphere/bee/pkg/encryption"
+	"github.com/ethersphere/bee/pkg/swarm"
+	"github.com/spf13/cobra"
+)
+
+// stdinReference is the reference argument which makes the commands read the
+// references from stdin instead
+const stdinReference = "-"
+
+// referencePrefixes are stripped from the references before parsing them
+var referencePrefixes = []string{"bzz://", "0x", "0X"}
+
+// parseReference parses a reference in the forms users copy it in, i.e. bare hex,
+// with the 0x or bzz:// prefix, surrounding whitespace or trailing slashes
+func parseReference(s string) (swarm.Address, error) {
+	ref := strings.TrimSpace(s)
+	for _, prefix := range referencePrefixes {
+		ref = strings.TrimPrefix(ref, prefix)
+	}
+	ref = strings.TrimRight(ref, "/")
+
+	addr, err := swarm.ParseHexAddress(ref)
+	if err != nil {
+		return swarm.ZeroAddress, fmt.Errorf("invalid reference %q: not a hex string", s)
+	}
+	if n := len(addr.Bytes()); n != swarm.HashSize && n != encryption.ReferenceSize {
+		return swarm.ZeroAddress, fmt.Errorf("invalid reference %q: length %d, expected %d or %d bytes",
+			s, n, swarm.HashSize, encryption.ReferenceSize)
+	}
+	return addr, nil
+}
+
+// batchRepair is set while the references read from stdin are repaired
+var batchRepair bool
+
+// parseReferences parses the reference argument. When it is "-" the references
+// are read from stdin, one per line. With --ens-endpoint ENS names are accepted as
+// well and resolved, their names are returned at the index of their reference
+func parseReferences(cmd *cobra.Command, arg string) (addrs []swarm.Address, names []string, err error) {
+	args := []string{arg}
+	if arg == stdinReference {
+		args = nil
+		scanner := bufio.NewScanner(cmd.InOrStdin())
+		for scanner.Scan() {
+			if line := strings.TrimSpace(scanner.Text()); line != "" {
+				args = append(args, line)
+			}
+		}
+		if err := scanner.Err(); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	for _, a := range args {
+		name := ""
+		addr, err := parseReference(a)
+		if err != nil && ensEndpoint != "" && ens.IsName(a) {
+			name = strings.TrimSpace(a)
+			addr, err = resolveName(cmd, name)
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+		addrs = append(addrs, addr)
+		names = append(names, name)
+	}
+	return addrs, names, nil
+}
+
+// resolveName returns the reference the ENS name points to
+func resolveName(cmd *cobra.Command, name string) (swarm.Address, error) {
+	addr, err := ens.NewResolver(ensEndpoint).Resolve(cmd.Context(), name)
+	if err != nil {
+		return swarm.ZeroAddress, fmt.Errorf("resolving %s: %w", name, err)
+	}
+	if !scriptOutput() {
+		cmd.PrintErrf("Resolved %s to %s\n", name, addr)
+	}
+	return addr, nil
+}
+
+// repairFunc repairs the reference and returns the new one
+type repairFunc func(*cobra.Command, swarm.Address) (swarm.Address, error)
+
+// forEachReference calls fn for every reference of the argument, with up to --workers
+// references repaired at once. It stops on the first error, except for skipped
+// files which are reported once all the references are done. With --output every
+// result is logged in the order of the references and the failed references do
+// not stop the others
+func forEachReference(cmd *cobra.Command, arg string, directory bool, fn repairFunc) (err error) {
+	addrs, names, err := parseReferences(cmd, arg)
+	if err != nil {
+		return err
+	}
+	if exportTar != "" && len(addrs) > 1 {
+		return errors.New("--export-tar is used with a single reference")
+	}
+
+	if err := resetReferenceFile(); err != nil {
+		return err
+	}
+
+	log, err := createResultLog(resultLog)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if cerr := log.close(); err == nil {
+			err = cerr
+		}
+	}()
+
+	batchRepair = arg == stdinReference
+	defer func() { batchRepair = false }()
+
+	results := repairBatch(cmd, addrs, fn, log == nil)
+
+	var skippedErr error
+	failed, repaired := 0, 0
+	for _, r := range results {
+		if !r.done {
+			break
+		}
+		var skipped *repair.SkippedError
+		if r.err == nil || errors.As(r.err, &skipped) {
+			repaired++
+		}
+	}
+	for i, r := range results {
+		if !r.done {
+			break
+		}
+		if errors.Is(r.err, context.Canceled) {
+			if batchRepair {
+				cmd.PrintErrf("Interrupted after repairing %d of %d references\n", repaired, len(addrs))
+			}
+			return r.err
+		}
+		if lerr := log.record(newLogEntry(addrs[i], directory, filePath, r.newReference, r.err)); lerr != nil {
+			return lerr
+		}
+		var skipped *repair.SkippedError
+		if names[i] != "" && (r.err == nil || errors.As(r.err, &skipped)) && !scriptOutput() {
+			cmd.Printf("Set the content hash of %s to %s to serve the repaired content\n", names[i], r.newReference)
+		}
+		if errors.As(r.err, &skipped) {
+			skippedErr = r.err
+			continue
+		}
+		if r.err != nil {
+			if log == nil {
+				return r.err
+			}
+			cmd.PrintErrf("Failed repairing %s: %v\n", addrs[i], r.err)
+			failed++
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("failed repairing %d of %d references, see %s", failed, len(addrs), resultLog)
+	}
+	return skippedErr
+}
+
+// batchResult is the result of the repair of a reference of a batch
+type batchResult struct {
+	done         bool
+	newReference swarm.Address
+	err          error
+}
+
+// repairBatch calls fn for the references with at most --workers calls at once and
+// returns the results in the order of the references. No more references are
+// started once the context is cancelled, or once a repair fails when stopOnError is
+// set, and the results of the references which were not started are not done
+func repairBatch(cmd *cobra.Command, addrs []swarm.Address, fn repairFunc, stopOnError bool) []batchResult {
+	n := workers
+	// the local store can only be opened by one repair at a time
+	if n < 1 || localDB != "" {
+		n = 1
+	}
+	results := make([]batchResult, len(addrs))
+	sem := make(chan struct{}, n)
+	var (
+		wg      sync.WaitGroup
+		stopped int32
+	)
+	for i, addr := range addrs {
+		sem <- struct{}{}
+		if atomic.LoadInt32(&stopped) == 1 {
+			break
+		}
+		wg.Add(1)
+		go func(i int, addr swarm.Address) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			newReference, err := fn(cmd, addr)
+			results[i] = batchResult{done: true, newReference: newReference, err: err}
+
+			var skipped *repair.SkippedError
+			if errors.Is(err, context.Canceled) || (stopOnError && err != nil && !errors.As(err, &skipped)) {
+				atomic.StoreInt32(&stopped, 1)
+			}
+		}(i, addr)
+	}
+	wg.Wait()
+	return results
+}
+
+// ensureExists fails with a clear error when the root chunk of the reference is
+// not present on the node, before any repair work starts
+func ensureExists(cmd *cobra.Command, addr swarm.Address) error {
+	found, err := repair.Exists(cmd.Context(), addr, storeOption())
+	if err != nil {
+		return err
+	}
+	if !found {
+		return fmt.Errorf("reference %s not found on node: %w", addr, repair.ErrNotFound)
+	}
+	return nil
+}
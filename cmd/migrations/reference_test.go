@@ -0,0 +1,191 @@
+// Copyright 2021 The Swarm Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package migrations
+
+import (
+	"errors"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/ethersphere/bee/pkg/swarm"
+	"github.com/spf13/cobra"
+)
+
+func TestParseReference(t *testing.T) {
+	const ref = "2387e8e7d8a48c2a9339c97c1dc3461a9a7aa07e994c5cb8b38fd7c1b3e6ea48"
+
+	for _, tc := range []struct {
+		in      string
+		wantErr bool
+	}{
+		{in: ref},
+		{in: "0x" + ref},
+		{in: "bzz://" + ref},
+		{in: "bzz://" + ref + "/"},
+		{in: "  " + ref + "\n"},
+		{in: ref + strings.Repeat("0", 64)},
+		{in: "bzz://" + ref + "/index.html", wantErr: true},
+		{in: ref[:10], wantErr: true},
+		{in: "not a reference", wantErr: true},
+	} {
+		addr, err := parseReference(tc.in)
+		if tc.wantErr {
+			if err == nil {
+				t.Fatalf("%q: expected error", tc.in)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("%q: %v", tc.in, err)
+		}
+		if !strings.HasPrefix(addr.String(), ref) {
+			t.Fatalf("%q: unexpected reference %s", tc.in, addr)
+		}
+	}
+}
+
+func TestReferenceFile(t *testing.T) {
+	outputRef = filepath.Join(t.TempDir(), "refs.txt")
+	defer func() { outputRef = "" }()
+
+	if err := ioutil.WriteFile(outputRef, []byte("stale\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := resetReferenceFile(); err != nil {
+		t.Fatal(err)
+	}
+	refs := []string{
+		"2387e8e7d8a48c2a9339c97c1dc3461a9a7aa07e994c5cb8b38fd7c1b3e6ea48",
+		"94434d3312320fab70428c39b79dffb4abc3dbedf3e1562384a61ceaf8a7e36b",
+	}
+	for _, ref := range refs {
+		if err := writeReferenceFile(swarm.MustParseHexAddress(ref)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	b, err := ioutil.ReadFile(outputRef)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := strings.Join(refs, "\n") + "\n"; string(b) != want {
+		t.Fatalf("got %q, want %q", b, want)
+	}
+}
+
+func TestPrintReferenceLink(t *testing.T) {
+	const ref = "94434d3312320fab70428c39b79dffb4abc3dbedf3e1562384a61ceaf8a7e36b"
+	defer func() { linkBase, linkOnly, quiet, jsonOutput = "", false, false, false }()
+
+	for _, tc := range []struct {
+		name    string
+		base    string
+		only    bool
+		quiet   bool
+		json    bool
+		want    string
+		wantErr bool
+	}{
+		{name: "none", want: "New reference " + ref + "\n"},
+		{name: "link", base: "https://gateway.ethswarm.org/", want: "New reference " + ref + "\nhttps://gateway.ethswarm.org/bzz/" + ref + "/\n"},
+		{name: "link only", base: "https://gateway.ethswarm.org", only: true, want: "https://gateway.ethswarm.org/bzz/" + ref + "/\n"},
+		{name: "quiet", base: "https://gateway.ethswarm.org", quiet: true, want: ref + "\n"},
+		{name: "json", base: "https://gateway.ethswarm.org", json: true, want: `{"new_reference":"` + ref + `","link":"https://gateway.ethswarm.org/bzz/` + ref + `/"}` + "\n"},
+		{name: "only without base", only: true, wantErr: true},
+		{name: "invalid base", base: "gateway.ethswarm.org", wantErr: true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			linkBase, linkOnly, quiet, jsonOutput = tc.base, tc.only, tc.quiet, tc.json
+			err := checkLinkFlags()
+			if tc.wantErr {
+				if err == nil {
+					t.Fatal("expected error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			cmd := &cobra.Command{}
+			buf := new(strings.Builder)
+			cmd.SetOut(buf)
+			if err := printReference(cmd, "New reference ", swarm.MustParseHexAddress(ref)); err != nil {
+				t.Fatal(err)
+			}
+			if buf.String() != tc.want {
+				t.Fatalf("got %q, want %q", buf.String(), tc.want)
+			}
+		})
+	}
+}
+
+func TestPercentUpdaterOutput(t *testing.T) {
+	cmd := &cobra.Command{}
+	stdout, stderr := new(strings.Builder), new(strings.Builder)
+	cmd.SetOut(stdout)
+	cmd.SetErr(stderr)
+
+	p := newPercentUpdater(cmd)
+	p.Corrupt(2)
+	p.Unreadable([]swarm.Address{swarm.MustParseHexAddress("aa")})
+
+	if stdout.Len() != 0 {
+		t.Fatalf("unexpected output %q", stdout.String())
+	}
+	if want := "Skipped 2 corrupt chunks\nSkipped 1 unreadable chunks\n"; stderr.String() != want {
+		t.Fatalf("got %q, want %q", stderr.String(), want)
+	}
+}
+
+func TestRepairBatch(t *testing.T) {
+	workers = 3
+	defer func() { workers = 4 }()
+
+	var addrs []swarm.Address
+	for i := 0; i < 10; i++ {
+		b := make([]byte, swarm.HashSize)
+		b[0] = byte(i)
+		addrs = append(addrs, swarm.NewAddress(b))
+	}
+
+	var running, maxRunning int32
+	repairFn := func(fail int) repairFunc {
+		return func(_ *cobra.Command, addr swarm.Address) (swarm.Address, error) {
+			n := atomic.AddInt32(&running, 1)
+			defer atomic.AddInt32(&running, -1)
+			for {
+				max := atomic.LoadInt32(&maxRunning)
+				if n <= max || atomic.CompareAndSwapInt32(&maxRunning, max, n) {
+					break
+				}
+			}
+			time.Sleep(10 * time.Millisecond)
+			if int(addr.Bytes()[0]) == fail {
+				return swarm.ZeroAddress, errors.New("failed")
+			}
+			// the new reference tells which old one it belongs to
+			return addr, nil
+		}
+	}
+
+	results := repairBatch(&cobra.Command{}, addrs, repairFn(-1), true)
+	for i, r := range results {
+		if !r.done || r.err != nil || !r.newReference.Equal(addrs[i]) {
+			t.Fatalf("unexpected result %d: %+v", i, r)
+		}
+	}
+	if maxRunning != int32(workers) {
+		t.Fatalf("expected %d repairs at once, got %d", workers, maxRunning)
+	}
+
+	// no more references are started after a failure
+	results = repairBatch(&cobra.Command{}, addrs, repairFn(0), true)
+	if results[0].err == nil || results[len(results)-1].done {
+		t.Fatalf("expected the batch to stop after the failure, got %+v", results)
+	}
+}
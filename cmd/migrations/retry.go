@@ -0,0 +1,204 @@
+// Copyright 2021 The Swarm Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package migrations
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/ethersphere/bee/pkg/swarm"
+	"github.com/spf13/cobra"
+)
+
+var resultLog string // flag variable, json lines file logging the result of every reference
+
+// logEntry is a line of the result log of a batch repair
+type logEntry struct {
+	Reference    string `json:"reference"`
+	Directory    bool   `json:"directory,omitempty"`
+	Path         string `json:"path,omitempty"`
+	NewReference string `json:"new_reference,omitempty"`
+	Error        string `json:"error,omitempty"`
+}
+
+func newLogEntry(addr swarm.Address, directory bool, path string, newReference swarm.Address, err error) *logEntry {
+	e := &logEntry{
+		Reference: addr.String(),
+		Directory: directory,
+		Path:      path,
+	}
+	e.update(newReference, err)
+	return e
+}
+
+// update sets the result of the last repair of the reference. A directory with
+// skipped files has both a new reference and an error
+func (e *logEntry) update(newReference swarm.Address, err error) {
+	e.NewReference = ""
+	if !newReference.Equal(swarm.ZeroAddress) {
+		e.NewReference = newReference.String()
+	}
+	e.Error = ""
+	if err != nil {
+		e.Error = err.Error()
+	}
+}
+
+// resultLogFile is the result log being written, a nil log records nothing
+type resultLogFile struct {
+	f   *os.File
+	enc *json.Encoder
+}
+
+// createResultLog creates the log at fname, or returns nil when fname is empty
+func createResultLog(fname string) (*resultLogFile, error) {
+	if fname == "" {
+		return nil, nil
+	}
+	f, err := os.Create(fname)
+	if err != nil {
+		return nil, err
+	}
+	return &resultLogFile{f: f, enc: json.NewEncoder(f)}, nil
+}
+
+// record writes the entry as a line of the log
+func (l *resultLogFile) record(e *logEntry) error {
+	if l == nil {
+		return nil
+	}
+	return l.enc.Encode(e)
+}
+
+func (l *resultLogFile) close() error {
+	if l == nil {
+		return nil
+	}
+	return l.f.Close()
+}
+
+// readResultLog reads the entries of the log at fname
+func readResultLog(fname string) ([]*logEntry, error) {
+	f, err := os.Open(fname)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var entries []*logEntry
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(nil, 1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		e := &logEntry{}
+		if err := json.Unmarshal(scanner.Bytes(), e); err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", fname, line, err)
+		}
+		entries = append(entries, e)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+// writeResultLog replaces the log at fname with the entries
+func writeResultLog(fname string, entries []*logEntry) (err error) {
+	tmp := fname + ".tmp"
+	log, err := createResultLog(tmp)
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		if err := log.record(e); err != nil {
+			log.close()
+			os.Remove(tmp)
+			return err
+		}
+	}
+	if err := log.close(); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, fname)
+}
+
+var retryFailed = &cobra.Command{
+	Use:   "retry-failed <log file>",
+	Short: "Repair again the references which failed in a batch",
+	Long: `Reads the result log written by the file and directory commands with --output, repairs again the references which recorded an error and rewrites the log with the new results.
+
+Example:
+
+	$ cat refs.txt | bee-repair himalaya directory - --output results.json
+	$ bee-repair himalaya retry-failed results.json
+
+Directories with skipped files are retried as well. The command fails while any of the references still fails, so it can be run until it succeeds.`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return withMappingDB(func() error {
+			return retryLog(cmd, args[0])
+		})
+	},
+}
+
+// retryLog repairs the failed references of the log and records the new results
+func retryLog(cmd *cobra.Command, fname string) (err error) {
+	entries, err := readResultLog(fname)
+	if err != nil {
+		return err
+	}
+
+	batchRepair = true
+	defer func() { batchRepair = false }()
+
+	// the results are kept even when the retry is interrupted
+	defer func() {
+		if werr := writeResultLog(fname, entries); err == nil {
+			err = werr
+		}
+	}()
+
+	retried, failed := 0, 0
+	for _, e := range entries {
+		if e.Error == "" {
+			continue
+		}
+		addr, err := parseReference(e.Reference)
+		if err != nil {
+			return err
+		}
+
+		fn := repairFileReference
+		if e.Directory {
+			fn = repairDirectoryReference
+		}
+		filePath = e.Path
+		newReference, err := fn(cmd, addr)
+		if errors.Is(err, context.Canceled) {
+			cmd.PrintErrf("Interrupted after retrying %d references\n", retried)
+			return err
+		}
+		retried++
+		e.update(newReference, err)
+		if err != nil {
+			cmd.PrintErrf("Failed repairing %s: %v\n", addr, err)
+			failed++
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("failed repairing %d of %d references again, see %s", failed, retried, fname)
+	}
+	if !scriptOutput() {
+		cmd.Printf("Repaired %d failed references\n", retried)
+	}
+	return nil
+}
@@ -0,0 +1,60 @@
+// Copyright 2021 The Swarm Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package migrations
+
+import (
+	"errors"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/ethersphere/bee/pkg/swarm"
+)
+
+func TestResultLog(t *testing.T) {
+	fname := filepath.Join(t.TempDir(), "results.json")
+	oldRef := swarm.MustParseHexAddress("2387e8e7d8a48c2a9339c97c1dc3461a9a7aa07e994c5cb8b38fd7c1b3e6ea48")
+	newRef := swarm.MustParseHexAddress("94434d3312320fab70428c39b79dffb4abc3dbedf3e1562384a61ceaf8a7e36b")
+
+	log, err := createResultLog(fname)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []*logEntry{
+		newLogEntry(oldRef, false, "", newRef, nil),
+		newLogEntry(oldRef, true, "", swarm.ZeroAddress, errors.New("not found")),
+	}
+	for _, e := range want {
+		if err := log.record(e); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := log.close(); err != nil {
+		t.Fatal(err)
+	}
+
+	entries, err := readResultLog(fname)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(entries, want) {
+		t.Fatalf("unexpected entries %+v", entries)
+	}
+	if entries[0].Error != "" || entries[1].Error != "not found" || entries[1].NewReference != "" {
+		t.Fatalf("unexpected results %+v", entries)
+	}
+
+	entries[1].update(newRef, nil)
+	if err := writeResultLog(fname, entries); err != nil {
+		t.Fatal(err)
+	}
+	entries, err = readResultLog(fname)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if entries[1].Error != "" || entries[1].NewReference != newRef.String() || !entries[1].Directory {
+		t.Fatalf("unexpected updated entry %+v", entries[1])
+	}
+}
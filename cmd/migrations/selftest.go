@@ -0,0 +1,47 @@
+// Copyright 2021 The Swarm Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package migrations
+
+import (
+	"fmt"
+
+	"github.com/ethersphere/bee-repair/internal/repair"
+	"github.com/spf13/cobra"
+)
+
+var selfTest = &cobra.Command{
+	Use:   "selftest",
+	Short: "Repair a known file and directory in memory to check the build",
+	Long: `Creates a file and a directory of the old format in memory, repairs them and checks the new manifests, to confirm that the repair works before it is run against real content. No node is needed.
+
+Example:
+
+	$ bee-repair himalaya selftest
+	> PASS file
+	> PASS directory
+
+The command fails when any of the steps fails.`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		results := repair.SelfTest(cmd.Context(), repair.WithLogger(logger))
+		failed := 0
+		for _, r := range results {
+			if r.Err != nil {
+				failed++
+				cmd.Printf("FAIL %s: %v\n", r.Name, r.Err)
+				continue
+			}
+			cmd.Printf("PASS %s\n", r.Name)
+		}
+		if failed > 0 {
+			return fmt.Errorf("self-test failed %d of %d steps", failed, len(results))
+		}
+		return nil
+	},
+}
+
+func addSelfTestCommand(root *cobra.Command) {
+	root.AddCommand(selfTest)
+}
@@ -0,0 +1,64 @@
+// Copyright 2021 The Swarm Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package migrations
+
+import (
+	"fmt"
+
+	"github.com/ethersphere/bee-repair/internal/exporter"
+	"github.com/spf13/cobra"
+)
+
+var upgradeDst string // flag variable, archive written by upgrade-archive
+
+var upgradeArchive = &cobra.Command{
+	Use:   "upgrade-archive <archive or volumes...>",
+	Short: "Rewrite an archive of an earlier export version in the latest one",
+	Long: `Reads an archive written by an earlier export-db and writes its chunks to a new archive of the latest export version, without a node or database. The volumes of an archive are given in order and are joined into a single archive.
+
+Example:
+
+	$ bee-repair himalaya upgrade-archive swarm-exportdb.tar --destination-file swarm-exportdb-v2.tar
+	$ bee-repair himalaya upgrade-archive swarm-exportdb.tar.001 swarm-exportdb.tar.002 --destination-file swarm-exportdb-v2.tar
+
+Version 1 archives have no pin counters, so the upgraded archive records none.`,
+	Args: cobra.MinimumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		updater := &checksumUpdater{}
+		if !scriptOutput() {
+			progress := newPercentUpdater(cmd)
+			progress.start(cmd.Context())
+			updater.progress = progress
+		}
+
+		err := exporter.Upgrade(
+			cmd.Context(),
+			args,
+			exporter.WithDestinationFilename(upgradeDst),
+			exporter.WithVerifyChunks(verify, skipCorrupt),
+			exporter.WithChecksumFile(sha256File),
+			exporter.WithLogger(logger),
+			exporter.WithProgressUpdater(updater),
+		)
+		if err != nil {
+			return err
+		}
+		digest := updater.digests[0]
+		return printResult(
+			cmd,
+			fmt.Sprintf("Upgraded archive to %s SHA-256 %s", upgradeDst, digest),
+			upgradeDst,
+			exportOutput{DestinationFile: upgradeDst, SHA256: digest},
+		)
+	},
+}
+
+func addUpgradeArchiveCommand(root *cobra.Command) {
+	upgradeArchive.Flags().StringVar(&upgradeDst, "destination-file", "swarm-exportdb-upgraded.tar", "archive file to create")
+	upgradeArchive.Flags().BoolVar(&verify, "verify", false, "verify that chunk data matches the chunk address")
+	upgradeArchive.Flags().BoolVar(&skipCorrupt, "skip-corrupt", false, "skip corrupt chunks instead of failing, used with --verify")
+	upgradeArchive.Flags().BoolVar(&sha256File, "checksum-file", false, "write the SHA-256 digest of the archive to a .sha256 file next to it")
+	root.AddCommand(upgradeArchive)
+}
@@ -6,4 +6,7 @@ require (
 	github.com/ethersphere/bee v0.5.4-0.20210419211605-a63f64b18fd5
 	github.com/sirupsen/logrus v1.6.0
 	github.com/spf13/cobra v1.0.0
+	golang.org/x/net v0.0.0-20201224014010-6772e930b67b
+	golang.org/x/time v0.0.0-20191024005414-555d28b269f0
+	gopkg.in/yaml.v2 v2.3.0
 )
@@ -8,10 +8,12 @@ import (
 	"encoding/json"
 )
 
-// Metadata provides mime type and filename to file entry.
+// Metadata provides mime type and filename to file entry. The size is only
+// recorded by some uploaders.
 type Metadata struct {
 	MimeType string `json:"mimetype"`
 	Filename string `json:"filename"`
+	Size     int64  `json:"size,omitempty"`
 }
 
 // NewMetadata creates a new Metadata.
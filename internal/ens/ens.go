@@ -0,0 +1,279 @@
+// Copyright 2021 The Swarm Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package ens resolves ENS names to the swarm references they point to, through
+// the json-rpc api of an ethereum node.
+package ens
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/ethersphere/bee/pkg/crypto"
+	"github.com/ethersphere/bee/pkg/swarm"
+)
+
+// DefaultRegistry is the address of the ENS registry on the ethereum mainnet
+const DefaultRegistry = "0x00000000000C2E074eC69A0dFb2997BA6C7d2e1e"
+
+var (
+	// ErrNotFound is returned when the name has no resolver
+	ErrNotFound = errors.New("name not found")
+	// ErrNoContent is returned when the resolver of the name has no content hash
+	// set for it
+	ErrNoContent = errors.New("no content hash")
+	// ErrNotSwarm is returned when the content hash of the name is not a swarm
+	// reference, e.g. an ipfs one
+	ErrNotSwarm = errors.New("content hash is not a swarm reference")
+)
+
+// multicodecs of the content hash of swarm references, see EIP-1577
+const (
+	codecSwarmNamespace = 0xe4
+	codecSwarmManifest  = 0xfa
+	// the codec of the content hashes set before the swarm manifest codec existed
+	codecDagPB    = 0x70
+	hashKeccak256 = 0x1b
+)
+
+// Resolver resolves ENS names with eth_call requests to an ethereum node
+type Resolver struct {
+	endpoint string
+	registry string
+	client   *http.Client
+}
+
+// Option is used to supply functional options for the Resolver
+type Option func(*Resolver)
+
+// WithRegistry sets the address of the ENS registry, e.g. the one of a testnet
+func WithRegistry(addr string) Option {
+	return func(r *Resolver) {
+		r.registry = addr
+	}
+}
+
+// WithHTTPClient sets the client the json-rpc requests are sent with
+func WithHTTPClient(c *http.Client) Option {
+	return func(r *Resolver) {
+		r.client = c
+	}
+}
+
+// NewResolver creates a Resolver which sends its requests to the json-rpc endpoint
+// of an ethereum node, e.g. https://cloudflare-eth.com
+func NewResolver(endpoint string, opts ...Option) *Resolver {
+	r := &Resolver{
+		endpoint: endpoint,
+		registry: DefaultRegistry,
+		client:   http.DefaultClient,
+	}
+	for _, opt := range opts {
+		opt(r)
+	}
+	return r
+}
+
+// IsName reports whether the string looks like an ENS name rather than a reference
+func IsName(s string) bool {
+	s = strings.TrimSpace(s)
+	return strings.Contains(s, ".") && !strings.ContainsAny(s, "/: ")
+}
+
+// Resolve returns the swarm reference the content hash of the name points to. The
+// legacy content record, which names set in the days of the old swarm format use,
+// is read when the name has no content hash
+func (r *Resolver) Resolve(ctx context.Context, name string) (swarm.Address, error) {
+	node, err := NameHash(name)
+	if err != nil {
+		return swarm.ZeroAddress, err
+	}
+
+	res, err := r.call(ctx, r.registry, "resolver(bytes32)", node)
+	if err != nil {
+		return swarm.ZeroAddress, fmt.Errorf("resolver of %s: %w", name, err)
+	}
+	if len(res) != 32 || isZero(res) {
+		return swarm.ZeroAddress, fmt.Errorf("%s: %w", name, ErrNotFound)
+	}
+	resolver := "0x" + hex.EncodeToString(res[12:])
+
+	res, err = r.call(ctx, resolver, "contenthash(bytes32)", node)
+	if err == nil {
+		content, err := decodeBytes(res)
+		if err != nil {
+			return swarm.ZeroAddress, fmt.Errorf("content hash of %s: %w", name, err)
+		}
+		if len(content) > 0 {
+			addr, err := decodeContentHash(content)
+			if err != nil {
+				return swarm.ZeroAddress, fmt.Errorf("%s: %w", name, err)
+			}
+			return addr, nil
+		}
+	}
+
+	// resolvers which predate content hashes revert the call instead
+	res, err = r.call(ctx, resolver, "content(bytes32)", node)
+	if err != nil {
+		return swarm.ZeroAddress, fmt.Errorf("%s: %w: %v", name, ErrNoContent, err)
+	}
+	if len(res) != 32 || isZero(res) {
+		return swarm.ZeroAddress, fmt.Errorf("%s: %w", name, ErrNoContent)
+	}
+	return swarm.NewAddress(res), nil
+}
+
+// NameHash returns the node of the name in the ENS registry, see EIP-137
+func NameHash(name string) ([]byte, error) {
+	node := make([]byte, 32)
+	name = strings.ToLower(strings.TrimSuffix(strings.TrimSpace(name), "."))
+	if name == "" {
+		return node, nil
+	}
+	labels := strings.Split(name, ".")
+	for i := len(labels) - 1; i >= 0; i-- {
+		if labels[i] == "" {
+			return nil, fmt.Errorf("invalid name %q: empty label", name)
+		}
+		label, err := crypto.LegacyKeccak256([]byte(labels[i]))
+		if err != nil {
+			return nil, err
+		}
+		node, err = crypto.LegacyKeccak256(append(node, label...))
+		if err != nil {
+			return nil, err
+		}
+	}
+	return node, nil
+}
+
+// decodeContentHash returns the swarm reference of the EIP-1577 content hash
+func decodeContentHash(b []byte) (swarm.Address, error) {
+	var codecs []uint64
+	for i := 0; i < 4; i++ {
+		v, n := binary.Uvarint(b)
+		if n <= 0 {
+			return swarm.ZeroAddress, fmt.Errorf("%w: invalid content hash %x", ErrNotSwarm, b)
+		}
+		codecs = append(codecs, v)
+		b = b[n:]
+	}
+	// namespace, cid version, content codec and hash function
+	switch {
+	case codecs[0] != codecSwarmNamespace,
+		codecs[1] != 1,
+		codecs[2] != codecSwarmManifest && codecs[2] != codecDagPB,
+		codecs[3] != hashKeccak256:
+		return swarm.ZeroAddress, fmt.Errorf("%w: codecs %x", ErrNotSwarm, codecs)
+	}
+	size, n := binary.Uvarint(b)
+	if n <= 0 || size != swarm.HashSize || len(b[n:]) != swarm.HashSize {
+		return swarm.ZeroAddress, fmt.Errorf("%w: invalid hash length", ErrNotSwarm)
+	}
+	return swarm.NewAddress(b[n:]), nil
+}
+
+// decodeBytes decodes the abi encoded bytes returned by a contract call
+func decodeBytes(b []byte) ([]byte, error) {
+	if len(b) == 0 {
+		return nil, nil
+	}
+	if len(b) < 64 {
+		return nil, fmt.Errorf("invalid abi encoded bytes of length %d", len(b))
+	}
+	offset := abiUint(b[:32])
+	if offset+32 > uint64(len(b)) {
+		return nil, fmt.Errorf("invalid abi offset %d", offset)
+	}
+	size := abiUint(b[offset : offset+32])
+	if offset+32+size > uint64(len(b)) {
+		return nil, fmt.Errorf("invalid abi length %d", size)
+	}
+	return b[offset+32 : offset+32+size], nil
+}
+
+// abiUint decodes an abi encoded unsigned integer, the ones which do not fit in
+// 64 bits are returned as the largest value so that they fail the bounds checks
+func abiUint(b []byte) uint64 {
+	if !isZero(b[:24]) {
+		return ^uint64(0) >> 1
+	}
+	return binary.BigEndian.Uint64(b[24:32])
+}
+
+type rpcRequest struct {
+	JSONRPC string        `json:"jsonrpc"`
+	ID      int           `json:"id"`
+	Method  string        `json:"method"`
+	Params  []interface{} `json:"params"`
+}
+
+type callParams struct {
+	To   string `json:"to"`
+	Data string `json:"data"`
+}
+
+type rpcResponse struct {
+	Result string `json:"result"`
+	Error  *struct {
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+// call calls the function of the contract with the node as argument and returns
+// the raw result
+func (r *Resolver) call(ctx context.Context, contract, signature string, node []byte) ([]byte, error) {
+	selector, err := crypto.LegacyKeccak256([]byte(signature))
+	if err != nil {
+		return nil, err
+	}
+	data := append(selector[:4], node...)
+	body, err := json.Marshal(rpcRequest{
+		JSONRPC: "2.0",
+		ID:      1,
+		Method:  "eth_call",
+		Params:  []interface{}{callParams{To: contract, Data: "0x" + hex.EncodeToString(data)}, "latest"},
+	})
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", r.endpoint, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	res, err := r.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("ethereum node %s: unexpected status %s", r.endpoint, res.Status)
+	}
+	var rr rpcResponse
+	if err := json.NewDecoder(res.Body).Decode(&rr); err != nil {
+		return nil, fmt.Errorf("ethereum node %s: invalid response: %w", r.endpoint, err)
+	}
+	if rr.Error != nil {
+		return nil, fmt.Errorf("ethereum node %s: %s", r.endpoint, rr.Error.Message)
+	}
+	return hex.DecodeString(strings.TrimPrefix(rr.Result, "0x"))
+}
+
+func isZero(b []byte) bool {
+	for _, v := range b {
+		if v != 0 {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,169 @@
+// Copyright 2021 The Swarm Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ens_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/ethersphere/bee-repair/internal/ens"
+	"github.com/ethersphere/bee/pkg/swarm"
+)
+
+const resolverAddress = "0x1111111111111111111111111111111111111111"
+
+var (
+	selectorResolver    = "0178b8bf" // resolver(bytes32)
+	selectorContentHash = "bc1c58d1" // contenthash(bytes32)
+	selectorContent     = "2dff6941" // content(bytes32)
+)
+
+func TestNameHash(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		want string
+	}{
+		{"", "0000000000000000000000000000000000000000000000000000000000000000"},
+		{"eth", "93cdeb708b7545dc668eb9280176169d1c33cfd8ed6f04690a0bcc88a93fc4ae"},
+		{"foo.eth", "de9b09fd7c5f901e23a3f19fecc54828e9c848539801e86591bd9801b019f84f"},
+		{"Foo.eth.", "de9b09fd7c5f901e23a3f19fecc54828e9c848539801e86591bd9801b019f84f"},
+	} {
+		got, err := ens.NameHash(tc.name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if hex.EncodeToString(got) != tc.want {
+			t.Errorf("name hash of %q: got %x, want %s", tc.name, got, tc.want)
+		}
+	}
+
+	if _, err := ens.NameHash("foo..eth"); err == nil {
+		t.Error("expected error for empty label")
+	}
+}
+
+func TestIsName(t *testing.T) {
+	for s, want := range map[string]bool{
+		"swarm.eth":           true,
+		"sub.swarm.eth":       true,
+		"bzz://swarm.eth":     false,
+		"swarm.eth/index.htm": false,
+		"a4e3fcb8ceb59f16a7ee3b1ba9a25d8ad2e71e2658e93cb5fbc8f6ee5d8b03f4": false,
+	} {
+		if got := ens.IsName(s); got != want {
+			t.Errorf("IsName(%q): got %v, want %v", s, got, want)
+		}
+	}
+}
+
+func TestResolve(t *testing.T) {
+	ref := swarm.MustParseHexAddress("a4e3fcb8ceb59f16a7ee3b1ba9a25d8ad2e71e2658e93cb5fbc8f6ee5d8b03f4")
+	contentHash, _ := hex.DecodeString("e40101fa011b20" + ref.String())
+	ipfsHash, _ := hex.DecodeString("e301017012201111111111111111111111111111111111111111111111111111111111111111")
+
+	for _, tc := range []struct {
+		name        string
+		resolver    bool
+		contentHash []byte
+		content     []byte
+		want        swarm.Address
+		wantErr     error
+	}{
+		{name: "content hash", resolver: true, contentHash: contentHash, want: ref},
+		{name: "legacy content", resolver: true, content: ref.Bytes(), want: ref},
+		{name: "not found", wantErr: ens.ErrNotFound},
+		{name: "no content", resolver: true, wantErr: ens.ErrNoContent},
+		{name: "ipfs", resolver: true, contentHash: ipfsHash, wantErr: ens.ErrNotSwarm},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				var req struct {
+					Method string            `json:"method"`
+					Params []json.RawMessage `json:"params"`
+				}
+				if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Method != "eth_call" || len(req.Params) == 0 {
+					http.Error(w, "bad request", http.StatusBadRequest)
+					return
+				}
+				var call struct {
+					To   string `json:"to"`
+					Data string `json:"data"`
+				}
+				if err := json.Unmarshal(req.Params[0], &call); err != nil {
+					http.Error(w, "bad request", http.StatusBadRequest)
+					return
+				}
+
+				var result []byte
+				switch selector := strings.TrimPrefix(call.Data, "0x")[:8]; {
+				case call.To == ens.DefaultRegistry && selector == selectorResolver:
+					result = make([]byte, 32)
+					if tc.resolver {
+						b, _ := hex.DecodeString(strings.TrimPrefix(resolverAddress, "0x"))
+						copy(result[12:], b)
+					}
+				case call.To == resolverAddress && selector == selectorContentHash:
+					if tc.contentHash == nil {
+						writeRPC(w, "", "execution reverted")
+						return
+					}
+					result = abiBytes(tc.contentHash)
+				case call.To == resolverAddress && selector == selectorContent:
+					result = make([]byte, 32)
+					copy(result, tc.content)
+				default:
+					writeRPC(w, "", "unexpected call")
+					return
+				}
+				writeRPC(w, "0x"+hex.EncodeToString(result), "")
+			}))
+			defer srv.Close()
+
+			got, err := ens.NewResolver(srv.URL).Resolve(context.Background(), "swarm.eth")
+			if tc.wantErr != nil {
+				if !errors.Is(err, tc.wantErr) {
+					t.Fatalf("got error %v, want %v", err, tc.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !got.Equal(tc.want) {
+				t.Fatalf("got reference %s, want %s", got, tc.want)
+			}
+		})
+	}
+}
+
+// abiBytes abi encodes the bytes as the single return value of a call
+func abiBytes(b []byte) []byte {
+	buf := make([]byte, 64)
+	buf[31] = 32
+	buf[63] = byte(len(b))
+	padded := make([]byte, (len(b)+31)/32*32)
+	copy(padded, b)
+	return append(buf, padded...)
+}
+
+func writeRPC(w http.ResponseWriter, result, errMsg string) {
+	res := map[string]interface{}{"jsonrpc": "2.0", "id": 1}
+	if errMsg != "" {
+		res["error"] = map[string]interface{}{"code": -32000, "message": errMsg}
+	} else {
+		res["result"] = result
+	}
+	var buf bytes.Buffer
+	_ = json.NewEncoder(&buf).Encode(res)
+	w.Header().Set("Content-Type", "application/json")
+	_, _ = w.Write(buf.Bytes())
+}
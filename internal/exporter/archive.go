@@ -0,0 +1,138 @@
+package exporter
+
+import (
+	"archive/tar"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/ethersphere/bee/pkg/swarm"
+)
+
+var (
+	// ErrNoVersion is returned when the archive does not start with the export
+	// version entry, e.g. because it is a later volume of an archive
+	ErrNoVersion = errors.New("no export version")
+	// ErrUnsupportedVersion is returned when the export version of the archive is
+	// not one of SupportedExportVersions
+	ErrUnsupportedVersion = errors.New("unsupported export version")
+)
+
+// SupportedExportVersions are the export versions of the archives written and read
+// by this package: CurrentExportVersion for plain archives and PinsExportVersion
+// for archives with the pin counters
+var SupportedExportVersions = []string{CurrentExportVersion, PinsExportVersion}
+
+// maxVersionSize is the largest version entry which is read
+const maxVersionSize = 64
+
+// ReadVersion reads the export version from the first entry of the archive and
+// returns it, so that importers can tell the formats apart. The version is not
+// checked, see CheckVersion
+func ReadVersion(tr *tar.Reader) (string, error) {
+	hdr, err := tr.Next()
+	if err == io.EOF {
+		return "", ErrNoVersion
+	}
+	if err != nil {
+		return "", err
+	}
+	switch {
+	case hdr.Name == ExportVolumeFilename:
+		return "", fmt.Errorf("%w: the archive is a later volume, the version is in the first one", ErrNoVersion)
+	case hdr.Name != ExportVersionFilename:
+		return "", ErrNoVersion
+	}
+	b, err := ioutil.ReadAll(io.LimitReader(tr, maxVersionSize))
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(b)), nil
+}
+
+// CheckVersion returns ErrUnsupportedVersion when the version is not one of the
+// SupportedExportVersions
+func CheckVersion(version string) error {
+	for _, v := range SupportedExportVersions {
+		if v == version {
+			return nil
+		}
+	}
+	return fmt.Errorf("%w %q", ErrUnsupportedVersion, version)
+}
+
+// ReadArchive calls fn with every chunk of the archive, or of a volume of it, in the
+// order they were exported. The entries holding the version, the volume number and
+// the pins are skipped
+func ReadArchive(fname string, fn func(swarm.Chunk) error) error {
+	f, err := os.Open(fname)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	tr := tar.NewReader(f)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("reading archive %s Err: %w", fname, err)
+		}
+		if strings.HasPrefix(hdr.Name, ".") {
+			continue
+		}
+		addr, err := swarm.ParseHexAddress(hdr.Name)
+		if err != nil {
+			return fmt.Errorf("invalid chunk entry %q in archive %s Err: %w", hdr.Name, fname, err)
+		}
+		data, err := ioutil.ReadAll(tr)
+		if err != nil {
+			return fmt.Errorf("reading chunk %s Err: %w", addr, err)
+		}
+		if err := fn(swarm.NewChunk(addr, data)); err != nil {
+			return err
+		}
+	}
+}
+
+// isArchive reports whether the file starts with the version or volume entry of an
+// export archive
+func isArchive(r io.Reader) bool {
+	hdr, err := tar.NewReader(r).Next()
+	if err != nil {
+		return false
+	}
+	return hdr.Name == ExportVersionFilename || hdr.Name == ExportVolumeFilename
+}
+
+// archiveAddresses returns the addresses of the chunks of the archive, without
+// reading their data
+func archiveAddresses(f io.ReadSeeker) (map[string]struct{}, error) {
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	addrs := make(map[string]struct{})
+	tr := tar.NewReader(f)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return addrs, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if strings.HasPrefix(hdr.Name, ".") {
+			continue
+		}
+		addr, err := swarm.ParseHexAddress(hdr.Name)
+		if err != nil {
+			return nil, fmt.Errorf("invalid chunk entry %q Err: %w", hdr.Name, err)
+		}
+		addrs[addr.String()] = struct{}{}
+	}
+}
@@ -0,0 +1,207 @@
+// Copyright 2021 The Swarm Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package exporter
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/ethersphere/bee/pkg/cac"
+	"github.com/ethersphere/bee/pkg/file/loadsave"
+	"github.com/ethersphere/bee/pkg/soc"
+	"github.com/ethersphere/bee/pkg/storage"
+	"github.com/ethersphere/bee/pkg/swarm"
+)
+
+// BundleVersion is the version of the bundles written by ExportBundle
+const BundleVersion = 1
+
+// maxBundleChunkSize is the largest chunk data accepted in a bundle, the one of a
+// single owner chunk
+const maxBundleChunkSize = soc.IdSize + soc.SignatureSize + swarm.ChunkWithSpanSize
+
+// bundleMagic starts every bundle
+var bundleMagic = []byte("swrmbndl")
+
+// ErrInvalidBundle is returned when a file is not a bundle or is truncated
+var ErrInvalidBundle = errors.New("invalid bundle")
+
+// A bundle holds the chunks of a single reference. It starts with a header of the
+// magic bytes, the version byte, the length of the root reference in a byte, the
+// root reference and the number of chunks as a big endian uint64. Every chunk
+// follows as its address, the big endian uint32 length of its data and the data.
+
+// ExportBundle writes the chunks reachable from the root reference to a bundle at
+// dst, reading them from the store. The chunks are found as with ExportReference,
+// and only the logger, progress and verification options apply. The bundle holds
+// every chunk, so corrupt or unreadable chunks fail the export
+func ExportBundle(ctx context.Context, store storage.Getter, root swarm.Address, dst string, opts ...Option) (err error) {
+	e := &exporter{}
+	for _, opt := range opts {
+		opt(e)
+	}
+	defaultOpts(e)
+
+	c := &collector{
+		getter: store,
+		ls:     loadsave.New(&readOnlyStore{store}, storage.ModePutUpload, false),
+		refs:   make(map[string]struct{}),
+		chunks: make(map[string]struct{}),
+	}
+	if err := c.collect(ctx, root); err != nil {
+		return fmt.Errorf("failed traversing reference %s Err: %w", root, err)
+	}
+
+	f, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			os.Remove(dst)
+		}
+	}()
+
+	w := bufio.NewWriter(f)
+	if err := writeBundleHeader(w, root, len(c.addrs)); err != nil {
+		return err
+	}
+
+	total := len(c.addrs)
+	e.updater.Update(0, total)
+	for i, addr := range c.addrs {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		ch, err := store.Get(ctx, storage.ModeGetRequest, addr)
+		if err != nil {
+			return fmt.Errorf("chunk %s: %w", addr, err)
+		}
+		if e.verify && !cac.Valid(ch) && !soc.Valid(ch) {
+			return fmt.Errorf("chunk %s: %w", addr, ErrInvalidChunk)
+		}
+		e.logger.Tracef("Writing chunk %s of %d bytes", addr, len(ch.Data()))
+		if err := writeBundleChunk(w, ch); err != nil {
+			return err
+		}
+		e.updater.Update(i+1, total)
+	}
+	return w.Flush()
+}
+
+// ImportBundle stores the chunks of the bundle at fname and returns the root
+// reference recorded in it. Only the logger, progress and verification options
+// apply, a chunk which fails the verification fails the import
+func ImportBundle(ctx context.Context, fname string, store storage.Putter, opts ...Option) (swarm.Address, error) {
+	e := &exporter{}
+	for _, opt := range opts {
+		opt(e)
+	}
+	defaultOpts(e)
+
+	f, err := os.Open(fname)
+	if err != nil {
+		return swarm.ZeroAddress, err
+	}
+	defer f.Close()
+
+	r := bufio.NewReader(f)
+	root, total, err := readBundleHeader(r)
+	if err != nil {
+		return swarm.ZeroAddress, fmt.Errorf("bundle %s: %w", fname, err)
+	}
+
+	e.updater.Update(0, total)
+	for i := 0; i < total; i++ {
+		if err := ctx.Err(); err != nil {
+			return swarm.ZeroAddress, err
+		}
+		ch, err := readBundleChunk(r)
+		if err != nil {
+			return swarm.ZeroAddress, fmt.Errorf("bundle %s: chunk %d of %d: %w", fname, i+1, total, err)
+		}
+		if e.verify && !cac.Valid(ch) && !soc.Valid(ch) {
+			return swarm.ZeroAddress, fmt.Errorf("chunk %s: %w", ch.Address(), ErrInvalidChunk)
+		}
+		e.logger.Tracef("Storing chunk %s of %d bytes", ch.Address(), len(ch.Data()))
+		if _, err := store.Put(ctx, storage.ModePutUpload, ch); err != nil {
+			return swarm.ZeroAddress, fmt.Errorf("chunk %s: %w", ch.Address(), err)
+		}
+		e.updater.Update(i+1, total)
+	}
+	return root, nil
+}
+
+func writeBundleHeader(w io.Writer, root swarm.Address, chunks int) error {
+	hdr := append([]byte{}, bundleMagic...)
+	hdr = append(hdr, BundleVersion, byte(len(root.Bytes())))
+	hdr = append(hdr, root.Bytes()...)
+	hdr = append(hdr, make([]byte, 8)...)
+	binary.BigEndian.PutUint64(hdr[len(hdr)-8:], uint64(chunks))
+	_, err := w.Write(hdr)
+	return err
+}
+
+// readBundleHeader returns the root reference and the number of chunks of the
+// bundle
+func readBundleHeader(r io.Reader) (swarm.Address, int, error) {
+	buf := make([]byte, len(bundleMagic)+2)
+	if _, err := io.ReadFull(r, buf); err != nil {
+		return swarm.ZeroAddress, 0, fmt.Errorf("%w: %v", ErrInvalidBundle, err)
+	}
+	if !bytes.Equal(buf[:len(bundleMagic)], bundleMagic) {
+		return swarm.ZeroAddress, 0, ErrInvalidBundle
+	}
+	if v := buf[len(bundleMagic)]; v != BundleVersion {
+		return swarm.ZeroAddress, 0, fmt.Errorf("%w: unsupported version %d", ErrInvalidBundle, v)
+	}
+	ref := make([]byte, buf[len(bundleMagic)+1])
+	if _, err := io.ReadFull(r, ref); err != nil {
+		return swarm.ZeroAddress, 0, fmt.Errorf("%w: %v", ErrInvalidBundle, err)
+	}
+	var count [8]byte
+	if _, err := io.ReadFull(r, count[:]); err != nil {
+		return swarm.ZeroAddress, 0, fmt.Errorf("%w: %v", ErrInvalidBundle, err)
+	}
+	return swarm.NewAddress(ref), int(binary.BigEndian.Uint64(count[:])), nil
+}
+
+func writeBundleChunk(w io.Writer, ch swarm.Chunk) error {
+	var size [4]byte
+	binary.BigEndian.PutUint32(size[:], uint32(len(ch.Data())))
+	if _, err := w.Write(ch.Address().Bytes()); err != nil {
+		return err
+	}
+	if _, err := w.Write(size[:]); err != nil {
+		return err
+	}
+	_, err := w.Write(ch.Data())
+	return err
+}
+
+func readBundleChunk(r io.Reader) (swarm.Chunk, error) {
+	buf := make([]byte, swarm.HashSize+4)
+	if _, err := io.ReadFull(r, buf); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidBundle, err)
+	}
+	size := binary.BigEndian.Uint32(buf[swarm.HashSize:])
+	if size > maxBundleChunkSize {
+		return nil, fmt.Errorf("%w: chunk of %d bytes", ErrInvalidBundle, size)
+	}
+	data := make([]byte, size)
+	if _, err := io.ReadFull(r, data); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidBundle, err)
+	}
+	return swarm.NewChunk(swarm.NewAddress(buf[:swarm.HashSize]), data), nil
+}
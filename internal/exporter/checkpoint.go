@@ -0,0 +1,100 @@
+package exporter
+
+import (
+	"archive/tar"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+)
+
+// number of chunks exported between two checkpoints
+const checkpointInterval = 1000
+
+// checkpoint records how far an export got so that it can be resumed
+type checkpoint struct {
+	Address string `json:"address"` // last exported chunk address
+	Offset  int64  `json:"offset"`  // archive size after the last exported chunk
+	Done    int    `json:"done"`    // progress reported so far
+}
+
+// readCheckpoint returns the recorded checkpoint or nil if there is none
+func readCheckpoint(fname string) (*checkpoint, error) {
+	b, err := ioutil.ReadFile(fname)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	cp := &checkpoint{}
+	err = json.Unmarshal(b, cp)
+	if err != nil {
+		return nil, fmt.Errorf("invalid checkpoint file Err: %w", err)
+	}
+	return cp, nil
+}
+
+// writeCheckpoint replaces the checkpoint file so that it is never left half
+// written
+func writeCheckpoint(fname string, cp *checkpoint) error {
+	b, err := json.Marshal(cp)
+	if err != nil {
+		return err
+	}
+	tmp := fname + ".tmp"
+	err = ioutil.WriteFile(tmp, b, 0644)
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp, fname)
+}
+
+func removeCheckpoint(fname string) error {
+	err := os.Remove(fname)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// openArchive creates the archive, or reopens it at the position recorded in the
+// checkpoint dropping anything written after it
+func (e *exporter) openArchive(cp *checkpoint) (*os.File, error) {
+	if cp == nil {
+		return os.Create(e.dstFile)
+	}
+	f, err := os.OpenFile(e.dstFile, os.O_RDWR, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("cannot resume export Err: %w", err)
+	}
+	err = f.Truncate(cp.Offset)
+	if err == nil {
+		_, err = f.Seek(cp.Offset, io.SeekStart)
+	}
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return f, nil
+}
+
+// saveCheckpoint flushes the archive and records the position after the last
+// exported chunk
+func (e *exporter) saveCheckpoint(tw *tar.Writer, f *os.File, addr []byte, done int) error {
+	err := tw.Flush()
+	if err != nil {
+		return err
+	}
+	offset, err := f.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return err
+	}
+	return writeCheckpoint(e.checkpointFile, &checkpoint{
+		Address: hex.EncodeToString(addr),
+		Offset:  offset,
+		Done:    done,
+	})
+}
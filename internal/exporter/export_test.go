@@ -1,3 +1,6 @@
 package exporter
 
-var GetRetrievalIndex = getRetrievalIndex
+var (
+	GetRetrievalIndex = getRetrievalIndex
+	NewPinIndex       = newPinIndex
+)
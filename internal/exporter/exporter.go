@@ -2,28 +2,68 @@ package exporter
 
 import (
 	"archive/tar"
+	"bufio"
+	"context"
+	"crypto/sha256"
 	"encoding/binary"
 	"encoding/hex"
+	"errors"
 	"fmt"
+	"github.com/ethersphere/bee/pkg/cac"
+	"github.com/ethersphere/bee/pkg/logging"
 	"github.com/ethersphere/bee/pkg/shed"
+	"github.com/ethersphere/bee/pkg/soc"
+	"github.com/ethersphere/bee/pkg/swarm"
+	"hash"
 	"io"
+	"io/ioutil"
 	"os"
+	"path/filepath"
+	"strings"
 )
 
 const (
 	// filename in tar archive that holds the information
 	// about exported data format version
 	ExportVersionFilename = ".swarm-export-version"
-	// current export format version
+	// export format version of the archives without pins, see
+	// SupportedExportVersions
 	CurrentExportVersion = "1"
 	// default export filename
 	DefaultExportFilename = "swarm-exportdb.tar"
 )
 
+// ErrUnreadableChunk is returned when the stored data of a chunk cannot be read
+var ErrUnreadableChunk = errors.New("unreadable chunk data")
+
+// ErrInvalidChunk is returned when verification is enabled and the stored chunk
+// data does not hash to its address
+var ErrInvalidChunk = errors.New("invalid chunk data")
+
 type ProgressUpdater interface {
 	Update(int, int)
 }
 
+// CorruptUpdater can be optionally implemented by the ProgressUpdater to be told
+// how many corrupt chunks were left out of the archive once the export is done
+type CorruptUpdater interface {
+	Corrupt(int)
+}
+
+// UnreadableUpdater can be optionally implemented by the ProgressUpdater to be
+// told the addresses of the chunks which could not be read and were left out of
+// the archive once the export is done, see WithSkipUnreadable
+type UnreadableUpdater interface {
+	Unreadable([]swarm.Address)
+}
+
+// ChecksumUpdater can be optionally implemented by the ProgressUpdater to be told
+// the hex encoded SHA-256 digest of the archive once the export is done. With
+// volumes it is called for each of them in order
+type ChecksumUpdater interface {
+	Checksum(string)
+}
+
 type Option func(*exporter)
 
 func WithDestinationFilename(fname string) Option {
@@ -32,19 +72,163 @@ func WithDestinationFilename(fname string) Option {
 	}
 }
 
+// WithLogger is used to supply optional logger, which logs the address of every
+// chunk written to the archive at trace level
+func WithLogger(l logging.Logger) Option {
+	return func(e *exporter) {
+		e.logger = l
+	}
+}
+
 func WithProgressUpdater(upd ProgressUpdater) Option {
 	return func(e *exporter) {
 		e.updater = upd
 	}
 }
 
+// WithVerifyChunks is used to check that the data of every exported chunk hashes
+// to its address. A corrupt chunk fails the export unless skip is set, in which
+// case it is left out of the archive
+func WithVerifyChunks(verify, skip bool) Option {
+	return func(e *exporter) {
+		e.verify = verify
+		e.skipCorrupt = skip
+	}
+}
+
+// WithSkipUnreadable is used to leave out the chunks whose data cannot be read
+// instead of failing the export, to salvage as much of a damaged database as
+// possible
+func WithSkipUnreadable(val bool) Option {
+	return func(e *exporter) {
+		e.skipUnreadable = val
+	}
+}
+
+// WithChecksumFile is used to write the SHA-256 digest of the archive next to it,
+// in a file named after the archive with the .sha256 extension. The format is the
+// one of the sha256sum utility
+func WithChecksumFile(val bool) Option {
+	return func(e *exporter) {
+		e.checksumFile = val
+	}
+}
+
+// WithByteProgress is used to report the progress in bytes of chunk data instead
+// of number of chunks. The size of the data is summed up front, which takes an
+// additional pass over the index
+func WithByteProgress(val bool) Option {
+	return func(e *exporter) {
+		e.byteProgress = val
+	}
+}
+
+// WithCheckpoint is used to make the export resumable. The progress is recorded
+// periodically in the checkpoint file and, when the file exists, the export
+// continues the archive from the recorded position instead of starting over.
+// The archive is not removed when the export fails in this mode
+func WithCheckpoint(fname string) Option {
+	return func(e *exporter) {
+		e.checkpointFile = fname
+	}
+}
+
+// AddressFilter reports whether the chunk with the given address should be
+// exported
+type AddressFilter func(swarm.Address) bool
+
+// WithAddressFilter is used to export only the chunks accepted by the filter. When
+// it is used more than once, only the chunks accepted by all the filters are
+// exported
+func WithAddressFilter(f AddressFilter) Option {
+	return func(e *exporter) {
+		if prev := e.filter; prev != nil {
+			e.filter = func(addr swarm.Address) bool {
+				return prev(addr) && f(addr)
+			}
+			return
+		}
+		e.filter = f
+	}
+}
+
+// LoadAddressFilter reads hex encoded chunk addresses, one per line, from the
+// file and returns a filter accepting only those addresses. The file can also be
+// an export archive, or a volume of it, whose chunks are accepted
+func LoadAddressFilter(fname string) (AddressFilter, error) {
+	addrs, err := loadAddresses(fname)
+	if err != nil {
+		return nil, err
+	}
+	return func(addr swarm.Address) bool {
+		_, found := addrs[addr.String()]
+		return found
+	}, nil
+}
+
+// LoadExcludeFilter reads the chunk addresses from the file like LoadAddressFilter
+// and returns a filter accepting only the other addresses, e.g. to export only the
+// chunks missing from the archive of a peer node
+func LoadExcludeFilter(fname string) (AddressFilter, error) {
+	addrs, err := loadAddresses(fname)
+	if err != nil {
+		return nil, err
+	}
+	return func(addr swarm.Address) bool {
+		_, found := addrs[addr.String()]
+		return !found
+	}, nil
+}
+
+func loadAddresses(fname string) (map[string]struct{}, error) {
+	f, err := os.Open(fname)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	if isArchive(f) {
+		return archiveAddresses(f)
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+
+	addrs := make(map[string]struct{})
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" {
+			continue
+		}
+		addr, err := swarm.ParseHexAddress(text)
+		if err != nil {
+			return nil, fmt.Errorf("invalid address on line %d Err: %w", line, err)
+		}
+		addrs[addr.String()] = struct{}{}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return addrs, nil
+}
+
+// Export writes the chunks of the localstore at src to the archive, see
+// ExportContext to stop it early
 func Export(src string, opts ...Option) error {
+	return ExportContext(context.Background(), src, opts...)
+}
+
+// ExportContext is like Export but stops once the context is cancelled. The
+// incomplete archive is removed in that case.
+func ExportContext(ctx context.Context, src string, opts ...Option) error {
 	e, err := newExporter(src, opts...)
 	if err != nil {
 		return fmt.Errorf("invalid source directory Err: %w", err)
 	}
-	err = e.export()
+	err = e.export(ctx)
 	if err != nil {
+		e.close()
 		return fmt.Errorf("failed exporting DB Err: %w", err)
 	}
 	return e.close()
@@ -59,6 +243,18 @@ type exporter struct {
 	closer         io.Closer
 	dstFile        string
 	updater        ProgressUpdater
+	logger         logging.Logger
+	filter         AddressFilter
+	verify         bool
+	skipCorrupt    bool
+	skipUnreadable bool
+	byteProgress   bool
+	checkpointFile string
+	checksumFile   bool
+	maxVolumeSize  int64
+	concurrency    int
+	pins           bool
+	pinIndex       shed.Index
 }
 
 func defaultOpts(e *exporter) {
@@ -68,15 +264,35 @@ func defaultOpts(e *exporter) {
 	if e.updater == nil {
 		e.updater = noopUpdater{}
 	}
+	if e.logger == nil {
+		e.logger = logging.New(ioutil.Discard, 0)
+	}
+	if e.filter == nil {
+		e.filter = func(swarm.Address) bool { return true }
+	}
 }
 
 func getRetrievalIndex(src string) (index shed.Index, closer io.Closer, err error) {
+	if err := checkLayout(src); err != nil {
+		return index, nil, err
+	}
 	s, e := shed.NewDB(src, nil)
 	if e != nil {
 		return index, nil, e
 	}
 
-	index, err = s.NewIndex("Address->StoreTimestamp|BinID|Data", shed.IndexFuncs{
+	index, err = newRetrievalIndex(s)
+	if err != nil {
+		s.Close()
+		return index, nil, err
+	}
+
+	closer = s
+	return
+}
+
+func newRetrievalIndex(s *shed.DB) (shed.Index, error) {
+	return s.NewIndex("Address->StoreTimestamp|BinID|Data", shed.IndexFuncs{
 		EncodeKey: func(fields shed.Item) (key []byte, err error) {
 			return fields.Address, nil
 		},
@@ -92,15 +308,16 @@ func getRetrievalIndex(src string) (index shed.Index, closer io.Closer, err erro
 			return value, nil
 		},
 		DecodeValue: func(keyItem shed.Item, value []byte) (e shed.Item, err error) {
+			if len(value) < 16 {
+				// left without data, which is reported as unreadable
+				return e, nil
+			}
 			e.StoreTimestamp = int64(binary.BigEndian.Uint64(value[8:16]))
 			e.BinID = binary.BigEndian.Uint64(value[:8])
 			e.Data = value[16:]
 			return e, nil
 		},
 	})
-
-	closer = s
-	return
 }
 
 func newExporter(src string, opts ...Option) (*exporter, error) {
@@ -110,62 +327,291 @@ func newExporter(src string, opts ...Option) (*exporter, error) {
 	}
 	defaultOpts(e)
 
+	if err := checkLayout(src); err != nil {
+		return nil, err
+	}
+	db, err := shed.NewDB(src, nil)
+	if err != nil {
+		return nil, err
+	}
+
 	// Index storing actual chunk address, data and bin id.
-	idx, closer, err := getRetrievalIndex(src)
+	e.retrievalIndex, err = newRetrievalIndex(db)
 	if err != nil {
+		db.Close()
 		return nil, err
 	}
-	e.retrievalIndex = idx
-	e.closer = closer
+	empty, err := isEmpty(e.retrievalIndex)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	if empty {
+		e.logger.Warningf("No chunks in the retrieval index of %s, the database may have an index schema of another bee version", src)
+	}
+	if e.pins {
+		e.pinIndex, err = newPinIndex(db)
+		if err != nil {
+			db.Close()
+			return nil, err
+		}
+	}
+	e.closer = db
 	return e, nil
 }
 
-func (e *exporter) export() error {
-	total, err := e.retrievalIndex.Count()
+// progress returns the amount of work exporting the item represents
+func (e *exporter) progress(item shed.Item) int {
+	if e.byteProgress {
+		return len(item.Data)
+	}
+	return 1
+}
+
+func (e *exporter) total() (total int, err error) {
+	if !e.byteProgress {
+		return e.retrievalIndex.Count()
+	}
+	err = e.retrievalIndex.Iterate(func(item shed.Item) (stop bool, err error) {
+		total += len(item.Data)
+		return false, nil
+	}, nil)
+	return total, err
+}
+
+func (e *exporter) export(ctx context.Context) (err error) {
+	total, err := e.total()
 	if err != nil {
 		return err
 	}
 
-	dstF, err := os.Create(e.dstFile)
+	if e.maxVolumeSize > 0 {
+		return e.exportVolumes(ctx, total)
+	}
+
+	var cp *checkpoint
+	if e.checkpointFile != "" {
+		cp, err = readCheckpoint(e.checkpointFile)
+		if err != nil {
+			return err
+		}
+	}
+
+	dstF, err := e.openArchive(cp)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if cerr := dstF.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil && e.checkpointFile == "" {
+			// do not leave a truncated archive behind
+			os.Remove(e.dstFile)
+		}
+	}()
+
+	// the digest is computed while writing, a resumed archive is read back up to
+	// the checkpoint first
+	h := sha256.New()
+	if cp != nil {
+		if _, err := io.Copy(h, io.NewSectionReader(dstF, 0, cp.Offset)); err != nil {
+			return err
+		}
+	}
+	tw := tar.NewWriter(io.MultiWriter(dstF, h))
+
+	doneCount, corruptCount := 0, 0
+	var unreadable []swarm.Address
+	var iterOpts *shed.IterateOptions
+	if cp != nil {
+		addr, err := hex.DecodeString(cp.Address)
+		if err != nil {
+			return fmt.Errorf("invalid checkpoint address Err: %w", err)
+		}
+		iterOpts = &shed.IterateOptions{
+			StartFrom:         &shed.Item{Address: addr},
+			SkipStartFromItem: true,
+		}
+		doneCount = cp.Done
+	} else if err := writeVersion(tw, e.version()); err != nil {
+		return err
+	}
+
+	e.updater.Update(doneCount, total)
+
+	var lastAddr []byte
+	sinceCheckpoint := 0
+	err = e.iterate(ctx, iterOpts, func(c checkedItem) error {
+		select {
+		case <-ctx.Done():
+			if e.checkpointFile != "" && lastAddr != nil {
+				if err := e.saveCheckpoint(tw, dstF, lastAddr, doneCount); err != nil {
+					return err
+				}
+			}
+			return ctx.Err()
+		default:
+		}
+
+		if err := e.writeItem(tw, c); err != nil {
+			return err
+		}
+		if c.corrupt {
+			corruptCount++
+		}
+		if c.unreadable {
+			unreadable = append(unreadable, swarm.NewAddress(c.item.Address))
+		}
+
+		doneCount += e.progress(c.item)
+		e.updater.Update(doneCount, total)
+
+		if e.checkpointFile != "" {
+			lastAddr = append(lastAddr[:0], c.item.Address...)
+			sinceCheckpoint++
+			if sinceCheckpoint == checkpointInterval {
+				if err := e.saveCheckpoint(tw, dstF, lastAddr, doneCount); err != nil {
+					return err
+				}
+				sinceCheckpoint = 0
+			}
+		}
+		return nil
+	})
 	if err != nil {
 		return err
 	}
-	tw := tar.NewWriter(dstF)
-	defer tw.Close()
 
+	if e.pins {
+		if err := e.writePins(tw); err != nil {
+			return err
+		}
+	}
+
+	if err := e.finish(tw, h, corruptCount, unreadable); err != nil {
+		return err
+	}
+
+	if e.checkpointFile != "" {
+		return removeCheckpoint(e.checkpointFile)
+	}
+	return nil
+}
+
+// version returns the export format version of the archive
+func (e *exporter) version() string {
+	if e.pins {
+		return PinsExportVersion
+	}
+	return CurrentExportVersion
+}
+
+// writeVersion adds the entry with the export format version to the archive
+func writeVersion(tw *tar.Writer, version string) error {
 	if err := tw.WriteHeader(&tar.Header{
 		Name: ExportVersionFilename,
 		Mode: 0644,
-		Size: int64(len(CurrentExportVersion)),
+		Size: int64(len(version)),
 	}); err != nil {
 		return err
 	}
-	if _, err := tw.Write([]byte(CurrentExportVersion)); err != nil {
-		return err
+	_, err := tw.Write([]byte(version))
+	return err
+}
+
+// reportSkipped reports the chunks left out of the archive
+func (e *exporter) reportSkipped(corruptCount int, unreadable []swarm.Address) {
+	if c, ok := e.updater.(CorruptUpdater); ok && e.verify {
+		c.Corrupt(corruptCount)
+	}
+	if u, ok := e.updater.(UnreadableUpdater); ok && e.skipUnreadable {
+		u.Unreadable(unreadable)
 	}
+}
 
-	doneCount := 0
-	e.updater.Update(doneCount, total)
+// finish closes the archive written through the hash and reports the skipped
+// chunks and the digest of the archive
+func (e *exporter) finish(tw *tar.Writer, h hash.Hash, corruptCount int, unreadable []swarm.Address) error {
+	e.reportSkipped(corruptCount, unreadable)
 
-	return e.retrievalIndex.Iterate(func(item shed.Item) (stop bool, err error) {
+	if err := tw.Close(); err != nil {
+		return err
+	}
 
-		hdr := &tar.Header{
-			Name: hex.EncodeToString(item.Address),
-			Mode: 0644,
-			Size: int64(len(item.Data)),
+	digest := hex.EncodeToString(h.Sum(nil))
+	if e.checksumFile {
+		if err := writeChecksumFile(e.dstFile, digest); err != nil {
+			return err
 		}
+	}
+	if c, ok := e.updater.(ChecksumUpdater); ok {
+		c.Checksum(digest)
+	}
+	return nil
+}
+
+// checkItem applies the filter and the verification to the chunk
+func (e *exporter) checkItem(item shed.Item) checkedItem {
+	c := checkedItem{item: item}
+	addr := swarm.NewAddress(item.Address)
+	if !e.filter(addr) {
+		return c
+	}
 
-		if err := tw.WriteHeader(hdr); err != nil {
-			return false, err
+	// every chunk starts with its span
+	if len(item.Data) < swarm.SpanSize {
+		if !e.skipUnreadable {
+			c.err = fmt.Errorf("chunk %s: %w", addr, ErrUnreadableChunk)
+			return c
 		}
-		if _, err := tw.Write(item.Data); err != nil {
-			return false, err
+		c.unreadable = true
+		return c
+	}
+
+	if e.verify {
+		ch := swarm.NewChunk(addr, item.Data)
+		if !cac.Valid(ch) && !soc.Valid(ch) {
+			if !e.skipCorrupt {
+				c.err = fmt.Errorf("chunk %s: %w", addr, ErrInvalidChunk)
+				return c
+			}
+			c.corrupt = true
+			return c
 		}
+	}
+	c.include = true
+	return c
+}
 
-		doneCount++
-		e.updater.Update(doneCount, total)
-		return false, nil
-	}, nil)
+// writeItem adds the checked chunk to the archive unless it was left out
+func (e *exporter) writeItem(tw *tar.Writer, c checkedItem) error {
+	if c.err != nil {
+		return c.err
+	}
+	if !c.include {
+		e.logger.Tracef("Leaving out chunk %x", c.item.Address)
+		return nil
+	}
+	e.logger.Tracef("Writing chunk %x of %d bytes", c.item.Address, len(c.item.Data))
+
+	hdr := &tar.Header{
+		Name: hex.EncodeToString(c.item.Address),
+		Mode: 0644,
+		Size: int64(len(c.item.Data)),
+	}
+
+	if err := tw.WriteHeader(hdr); err != nil {
+		return err
+	}
+	_, err := tw.Write(c.item.Data)
+	return err
+}
+
+// writeChecksumFile writes the digest of the archive to its .sha256 file
+func writeChecksumFile(fname, digest string) error {
+	line := fmt.Sprintf("%s  %s\n", digest, filepath.Base(fname))
+	return ioutil.WriteFile(fname+".sha256", []byte(line), 0644)
 }
 
 func (e *exporter) close() error {
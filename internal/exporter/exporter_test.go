@@ -3,21 +3,40 @@ package exporter_test
 import (
 	"archive/tar"
 	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"io"
+	"io/ioutil"
 	"os"
 	"path/filepath"
+	"strconv"
+	"strings"
 	"testing"
 	"time"
 
+	"github.com/ethersphere/bee-repair/internal/collection/entry"
 	"github.com/ethersphere/bee-repair/internal/exporter"
+	"github.com/ethersphere/bee/pkg/file"
+	"github.com/ethersphere/bee/pkg/file/joiner"
+	"github.com/ethersphere/bee/pkg/file/splitter"
+	"github.com/ethersphere/bee/pkg/logging"
 	"github.com/ethersphere/bee/pkg/shed"
+	"github.com/ethersphere/bee/pkg/storage"
+	"github.com/ethersphere/bee/pkg/storage/mock"
 	chunktesting "github.com/ethersphere/bee/pkg/storage/testing"
 	"github.com/ethersphere/bee/pkg/swarm"
+	"github.com/sirupsen/logrus"
 )
 
 type checkUpdater struct {
-	prev int
-	t    *testing.T
+	prev  int
+	total int
+	t     *testing.T
 }
 
 func (c *checkUpdater) Update(done, total int) {
@@ -27,11 +46,11 @@ func (c *checkUpdater) Update(done, total int) {
 	if done > total {
 		c.t.Fatal("incorrect update")
 	}
-	c.prev = done
+	c.prev, c.total = done, total
 }
 
 func TestExporter(t *testing.T) {
-	verifyTar := func(t *testing.T, tr *tar.Reader, chunkMap map[string]swarm.Chunk) {
+	verifyTar := func(t *testing.T, tr *tar.Reader, chunkMap map[string]swarm.Chunk) (count int) {
 		for {
 			hdr, err := tr.Next()
 			if err != nil {
@@ -68,7 +87,9 @@ func TestExporter(t *testing.T) {
 			if !bytes.Equal(chunk.Data(), chunkBuf) {
 				t.Fatal("invalid data bytes")
 			}
+			count++
 		}
+		return count
 	}
 
 	t.Run("default", func(t *testing.T) {
@@ -137,6 +158,1258 @@ func TestExporter(t *testing.T) {
 		verifyTar(t, tr, chMap)
 
 	})
+	t.Run("bytes", func(t *testing.T) {
+		testFileName := "testexportfile.tar"
+		defer os.RemoveAll("src")
+		defer os.RemoveAll(filepath.Join(".", testFileName))
+
+		err := os.Mkdir("src", 0775)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		chMap, err := createTestStore("src")
+		if err != nil {
+			t.Fatal(err)
+		}
+		size := 0
+		for _, c := range chMap {
+			size += len(c.Data())
+		}
+
+		updater := &checkUpdater{t: t}
+		err = exporter.Export(
+			"src",
+			exporter.WithDestinationFilename(testFileName),
+			exporter.WithProgressUpdater(updater),
+			exporter.WithByteProgress(true),
+		)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if updater.total != size || updater.prev != size {
+			t.Fatalf("Final update incorrect, expected: %d got: %d/%d", size, updater.prev, updater.total)
+		}
+	})
+	t.Run("filter", func(t *testing.T) {
+		testFileName := "testexportfile.tar"
+		addrsFileName := "addresses.txt"
+		defer os.RemoveAll("src")
+		defer os.RemoveAll(filepath.Join(".", testFileName))
+		defer os.RemoveAll(filepath.Join(".", addrsFileName))
+
+		err := os.Mkdir("src", 0775)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		chMap, err := createTestStore("src")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		filtered := make(map[string]swarm.Chunk)
+		addrsBuf := bytes.NewBuffer(nil)
+		for k, v := range chMap {
+			if len(filtered) == 10 {
+				break
+			}
+			filtered[k] = v
+			addrsBuf.WriteString(k + "\n")
+		}
+		err = ioutil.WriteFile(addrsFileName, addrsBuf.Bytes(), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		filter, err := exporter.LoadAddressFilter(addrsFileName)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		updater := &checkUpdater{t: t}
+		err = exporter.Export(
+			"src",
+			exporter.WithDestinationFilename(testFileName),
+			exporter.WithAddressFilter(filter),
+			exporter.WithProgressUpdater(updater),
+		)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if updater.prev != 100 {
+			t.Fatal("Final update incorrect")
+		}
+
+		tarFile, err := os.Open(filepath.Join(".", testFileName))
+		if err != nil {
+			t.Fatal(err)
+		}
+		tr := tar.NewReader(tarFile)
+
+		if count := verifyTar(t, tr, filtered); count != len(filtered) {
+			t.Fatalf("unexpected chunk count, expected: %d got: %d", len(filtered), count)
+		}
+	})
+	t.Run("exclude", func(t *testing.T) {
+		testFileName := "testexportfile.tar"
+		peerFileName := "peer.tar"
+		addrsFileName := "addresses.txt"
+		defer os.RemoveAll("src")
+		defer os.RemoveAll(filepath.Join(".", testFileName))
+		defer os.RemoveAll(filepath.Join(".", peerFileName))
+		defer os.RemoveAll(filepath.Join(".", addrsFileName))
+
+		err := os.Mkdir("src", 0775)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		chMap, err := createTestStore("src")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// the peer has some of the chunks in its archive
+		missing := make(map[string]swarm.Chunk)
+		addrsBuf := bytes.NewBuffer(nil)
+		for k, v := range chMap {
+			if len(missing) == 10 {
+				addrsBuf.WriteString(k + "\n")
+				continue
+			}
+			missing[k] = v
+		}
+		err = ioutil.WriteFile(addrsFileName, addrsBuf.Bytes(), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+		filter, err := exporter.LoadAddressFilter(addrsFileName)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = exporter.Export("src", exporter.WithDestinationFilename(peerFileName), exporter.WithAddressFilter(filter))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		filter, err = exporter.LoadExcludeFilter(peerFileName)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = exporter.Export("src", exporter.WithDestinationFilename(testFileName), exporter.WithAddressFilter(filter))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		tarFile, err := os.Open(filepath.Join(".", testFileName))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer tarFile.Close()
+		tr := tar.NewReader(tarFile)
+
+		if count := verifyTar(t, tr, missing); count != len(missing) {
+			t.Fatalf("unexpected chunk count, expected: %d got: %d", len(missing), count)
+		}
+	})
+	t.Run("cancelled", func(t *testing.T) {
+		testFileName := "testexportfile.tar"
+		defer os.RemoveAll("src")
+		defer os.RemoveAll(filepath.Join(".", testFileName))
+
+		err := os.Mkdir("src", 0775)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		_, err = createTestStore("src")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+
+		err = exporter.ExportContext(
+			ctx,
+			"src",
+			exporter.WithDestinationFilename(testFileName),
+		)
+		if !errors.Is(err, context.Canceled) {
+			t.Fatalf("expected context cancelled error, got %v", err)
+		}
+
+		_, err = os.Stat(filepath.Join(".", testFileName))
+		if !os.IsNotExist(err) {
+			t.Fatal("incomplete archive not removed")
+		}
+	})
+}
+
+// cancelUpdater cancels the export once it reaches the given progress
+type cancelUpdater struct {
+	at     int
+	cancel context.CancelFunc
+}
+
+func (c *cancelUpdater) Update(done, _ int) {
+	if done == c.at {
+		c.cancel()
+	}
+}
+
+func TestExporterCheckpoint(t *testing.T) {
+	testFileName := "testexportfile.tar"
+	checkpointFileName := "testexport.checkpoint"
+	defer os.RemoveAll("src")
+	defer os.RemoveAll(filepath.Join(".", testFileName))
+	defer os.RemoveAll(filepath.Join(".", checkpointFileName))
+
+	err := os.Mkdir("src", 0775)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	chMap, err := createTestStore("src")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	err = exporter.ExportContext(
+		ctx,
+		"src",
+		exporter.WithDestinationFilename(testFileName),
+		exporter.WithCheckpoint(checkpointFileName),
+		exporter.WithProgressUpdater(&cancelUpdater{at: 50, cancel: cancel}),
+	)
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected context cancelled error, got %v", err)
+	}
+	if _, err := os.Stat(checkpointFileName); err != nil {
+		t.Fatalf("checkpoint not written: %v", err)
+	}
+
+	updater := &checkUpdater{t: t}
+	err = exporter.Export(
+		"src",
+		exporter.WithDestinationFilename(testFileName),
+		exporter.WithCheckpoint(checkpointFileName),
+		exporter.WithProgressUpdater(updater),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if updater.prev != 100 {
+		t.Fatal("Final update incorrect")
+	}
+	if _, err := os.Stat(checkpointFileName); !os.IsNotExist(err) {
+		t.Fatal("checkpoint not removed after export")
+	}
+
+	tarFile, err := os.Open(filepath.Join(".", testFileName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tarFile.Close()
+
+	seen := make(map[string]struct{})
+	tr := tar.NewReader(tarFile)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if hdr.Name == exporter.ExportVersionFilename {
+			continue
+		}
+		if _, found := chMap[hdr.Name]; !found {
+			t.Fatalf("chunk %s not found", hdr.Name)
+		}
+		if _, found := seen[hdr.Name]; found {
+			t.Fatalf("chunk %s exported twice", hdr.Name)
+		}
+		seen[hdr.Name] = struct{}{}
+	}
+	if len(seen) != len(chMap) {
+		t.Fatalf("unexpected chunk count, expected: %d got: %d", len(chMap), len(seen))
+	}
+}
+
+type corruptUpdater struct {
+	checkUpdater
+	corrupt int
+}
+
+func (c *corruptUpdater) Corrupt(count int) {
+	c.corrupt = count
+}
+
+func TestExporterVerify(t *testing.T) {
+	testFileName := "testexportfile.tar"
+
+	setup := func(t *testing.T) map[string]swarm.Chunk {
+		err := os.Mkdir("src", 0775)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		chMap, err := createTestStore("src")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		idx, closer, err := exporter.GetRetrievalIndex("src")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer closer.Close()
+
+		// flip a byte of one chunk so that it no longer hashes to its address
+		for k, c := range chMap {
+			data := append([]byte{}, c.Data()...)
+			data[len(data)-1] ^= 0xff
+			err = idx.Put(shed.Item{
+				Address:        c.Address().Bytes(),
+				Data:           data,
+				StoreTimestamp: time.Now().Unix(),
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			delete(chMap, k)
+			break
+		}
+		return chMap
+	}
+
+	t.Run("fail", func(t *testing.T) {
+		defer os.RemoveAll("src")
+		defer os.RemoveAll(filepath.Join(".", testFileName))
+
+		setup(t)
+
+		err := exporter.Export(
+			"src",
+			exporter.WithDestinationFilename(testFileName),
+			exporter.WithVerifyChunks(true, false),
+		)
+		if !errors.Is(err, exporter.ErrInvalidChunk) {
+			t.Fatalf("expected invalid chunk error, got %v", err)
+		}
+	})
+	t.Run("skip", func(t *testing.T) {
+		defer os.RemoveAll("src")
+		defer os.RemoveAll(filepath.Join(".", testFileName))
+
+		chMap := setup(t)
+
+		updater := &corruptUpdater{checkUpdater: checkUpdater{t: t}}
+		err := exporter.Export(
+			"src",
+			exporter.WithDestinationFilename(testFileName),
+			exporter.WithVerifyChunks(true, true),
+			exporter.WithProgressUpdater(updater),
+		)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if updater.corrupt != 1 {
+			t.Fatalf("unexpected corrupt count, expected: %d got: %d", 1, updater.corrupt)
+		}
+
+		tarFile, err := os.Open(filepath.Join(".", testFileName))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer tarFile.Close()
+
+		count := 0
+		tr := tar.NewReader(tarFile)
+		for {
+			hdr, err := tr.Next()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if hdr.Name == exporter.ExportVersionFilename {
+				continue
+			}
+			if _, found := chMap[hdr.Name]; !found {
+				t.Fatalf("unexpected chunk %s in archive", hdr.Name)
+			}
+			count++
+		}
+		if count != len(chMap) {
+			t.Fatalf("unexpected chunk count, expected: %d got: %d", len(chMap), count)
+		}
+	})
+}
+
+type unreadableUpdater struct {
+	checkUpdater
+	unreadable []swarm.Address
+}
+
+func (u *unreadableUpdater) Unreadable(addrs []swarm.Address) {
+	u.unreadable = addrs
+}
+
+func TestExporterSkipUnreadable(t *testing.T) {
+	testFileName := "testexportfile.tar"
+	defer os.RemoveAll("src")
+	defer os.RemoveAll(filepath.Join(".", testFileName))
+
+	err := os.Mkdir("src", 0775)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	chMap, err := createTestStore("src")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a chunk whose stored value is left without data
+	idx, closer, err := exporter.GetRetrievalIndex("src")
+	if err != nil {
+		t.Fatal(err)
+	}
+	badAddr := chunktesting.GenerateTestRandomChunk().Address()
+	err = idx.Put(shed.Item{
+		Address:        badAddr.Bytes(),
+		StoreTimestamp: time.Now().Unix(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	closer.Close()
+
+	err = exporter.Export("src", exporter.WithDestinationFilename(testFileName))
+	if !errors.Is(err, exporter.ErrUnreadableChunk) {
+		t.Fatalf("expected unreadable chunk error, got %v", err)
+	}
+
+	updater := &unreadableUpdater{checkUpdater: checkUpdater{t: t}}
+	err = exporter.Export(
+		"src",
+		exporter.WithDestinationFilename(testFileName),
+		exporter.WithSkipUnreadable(true),
+		exporter.WithProgressUpdater(updater),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(updater.unreadable) != 1 || !updater.unreadable[0].Equal(badAddr) {
+		t.Fatalf("unexpected unreadable chunks %v", updater.unreadable)
+	}
+
+	tarFile, err := os.Open(testFileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tarFile.Close()
+
+	count := 0
+	tr := tar.NewReader(tarFile)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if hdr.Name != exporter.ExportVersionFilename {
+			count++
+		}
+	}
+	if count != len(chMap) {
+		t.Fatalf("unexpected chunk count, expected: %d got: %d", len(chMap), count)
+	}
+}
+
+func TestExporterPins(t *testing.T) {
+	testFileName := "testexportfile.tar"
+	defer os.RemoveAll("src")
+	defer os.RemoveAll(filepath.Join(".", testFileName))
+
+	err := os.Mkdir("src", 0775)
+	if err != nil {
+		t.Fatal(err)
+	}
+	chMap, err := createTestStore("src")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// pin two of the chunks
+	db, err := shed.NewDB("src", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pinIndex, err := exporter.NewPinIndex(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pinned := make(map[string]uint64)
+	for addr := range chMap {
+		pinned[addr] = uint64(len(pinned) + 1)
+		err = pinIndex.Put(shed.Item{
+			Address:    swarm.MustParseHexAddress(addr).Bytes(),
+			PinCounter: pinned[addr],
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(pinned) == 2 {
+			break
+		}
+	}
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	err = exporter.Export("src", exporter.WithDestinationFilename(testFileName), exporter.WithPins(true))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tarFile, err := os.Open(testFileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tarFile.Close()
+	version, err := exporter.ReadVersion(tar.NewReader(tarFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if version != exporter.PinsExportVersion {
+		t.Fatalf("unexpected version %s", version)
+	}
+	if err := exporter.CheckVersion(version); err != nil {
+		t.Fatal(err)
+	}
+
+	pins, err := exporter.ReadPins(testFileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pins) != len(pinned) {
+		t.Fatalf("expected %d pins, got %d", len(pinned), len(pins))
+	}
+	for _, p := range pins {
+		if pinned[p.Address.String()] != p.Counter {
+			t.Fatalf("unexpected pin counter %d of %s", p.Counter, p.Address)
+		}
+	}
+
+	// archives exported without pins have none
+	err = exporter.Export("src", exporter.WithDestinationFilename(testFileName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	pins, err = exporter.ReadPins(testFileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pins != nil {
+		t.Fatalf("unexpected pins %v", pins)
+	}
+}
+
+func TestExporterLogger(t *testing.T) {
+	testFileName := "testexportfile.tar"
+	defer os.RemoveAll("src")
+	defer os.RemoveAll(filepath.Join(".", testFileName))
+
+	err := os.Mkdir("src", 0775)
+	if err != nil {
+		t.Fatal(err)
+	}
+	chMap, err := createTestStore("src")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, level := range []logrus.Level{logrus.DebugLevel, logrus.TraceLevel} {
+		buf := bytes.NewBuffer(nil)
+		err = exporter.Export(
+			"src",
+			exporter.WithDestinationFilename(testFileName),
+			exporter.WithLogger(logging.New(buf, level)),
+		)
+		if err != nil {
+			t.Fatal(err)
+		}
+		logs := buf.String()
+		for addr := range chMap {
+			logged := strings.Contains(logs, "Writing chunk "+addr)
+			if logged != (level == logrus.TraceLevel) {
+				t.Fatalf("chunk %s logged %t at level %s", addr, logged, level)
+			}
+		}
+	}
+}
+
+func TestReadArchive(t *testing.T) {
+	testFileName := "testexportfile.tar"
+	defer os.RemoveAll("src")
+	defer os.RemoveAll(filepath.Join(".", testFileName))
+
+	err := os.Mkdir("src", 0775)
+	if err != nil {
+		t.Fatal(err)
+	}
+	chMap, err := createTestStore("src")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the pins entry is not read as a chunk
+	err = exporter.Export("src", exporter.WithDestinationFilename(testFileName), exporter.WithPins(true))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	count := 0
+	err = exporter.ReadArchive(testFileName, func(ch swarm.Chunk) error {
+		want, ok := chMap[ch.Address().String()]
+		if !ok || !bytes.Equal(want.Data(), ch.Data()) {
+			return fmt.Errorf("unexpected chunk %s", ch.Address())
+		}
+		count++
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != len(chMap) {
+		t.Fatalf("expected %d chunks, got %d", len(chMap), count)
+	}
+
+	if err := exporter.CheckVersion("3"); !errors.Is(err, exporter.ErrUnsupportedVersion) {
+		t.Fatalf("expected unsupported version error, got %v", err)
+	}
+	buf := bytes.NewBuffer(nil)
+	tw := tar.NewWriter(buf)
+	if err := tw.WriteHeader(&tar.Header{Name: exporter.ExportVolumeFilename, Mode: 0644, Size: 1}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tw.Write([]byte("2")); err != nil {
+		t.Fatal(err)
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := exporter.ReadVersion(tar.NewReader(buf)); !errors.Is(err, exporter.ErrNoVersion) {
+		t.Fatalf("expected no version error, got %v", err)
+	}
+}
+
+type checksumUpdater struct {
+	checkUpdater
+	digest string
+}
+
+func (c *checksumUpdater) Checksum(digest string) {
+	c.digest = digest
+}
+
+func TestExporterChecksum(t *testing.T) {
+	testFileName := "testexportfile.tar"
+	checkpointFileName := "testexport.checkpoint"
+	defer os.RemoveAll("src")
+	defer os.RemoveAll(filepath.Join(".", testFileName))
+	defer os.RemoveAll(filepath.Join(".", testFileName+".sha256"))
+	defer os.RemoveAll(filepath.Join(".", checkpointFileName))
+
+	err := os.Mkdir("src", 0775)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = createTestStore("src")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	verifyChecksum := func(t *testing.T, digest string) {
+		t.Helper()
+
+		b, err := ioutil.ReadFile(testFileName)
+		if err != nil {
+			t.Fatal(err)
+		}
+		sum := sha256.Sum256(b)
+		if digest != hex.EncodeToString(sum[:]) {
+			t.Fatalf("invalid checksum, expected %x got %s", sum, digest)
+		}
+
+		b, err = ioutil.ReadFile(testFileName + ".sha256")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(b) != digest+"  "+testFileName+"\n" {
+			t.Fatalf("invalid checksum file %q", b)
+		}
+	}
+
+	t.Run("default", func(t *testing.T) {
+		updater := &checksumUpdater{checkUpdater: checkUpdater{t: t}}
+		err := exporter.Export(
+			"src",
+			exporter.WithDestinationFilename(testFileName),
+			exporter.WithChecksumFile(true),
+			exporter.WithProgressUpdater(updater),
+		)
+		if err != nil {
+			t.Fatal(err)
+		}
+		verifyChecksum(t, updater.digest)
+	})
+
+	t.Run("resumed", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		err := exporter.ExportContext(
+			ctx,
+			"src",
+			exporter.WithDestinationFilename(testFileName),
+			exporter.WithCheckpoint(checkpointFileName),
+			exporter.WithProgressUpdater(&cancelUpdater{at: 50, cancel: cancel}),
+		)
+		if !errors.Is(err, context.Canceled) {
+			t.Fatalf("expected context cancelled error, got %v", err)
+		}
+
+		updater := &checksumUpdater{checkUpdater: checkUpdater{t: t}}
+		err = exporter.Export(
+			"src",
+			exporter.WithDestinationFilename(testFileName),
+			exporter.WithCheckpoint(checkpointFileName),
+			exporter.WithChecksumFile(true),
+			exporter.WithProgressUpdater(updater),
+		)
+		if err != nil {
+			t.Fatal(err)
+		}
+		verifyChecksum(t, updater.digest)
+	})
+}
+
+func TestExporterConcurrency(t *testing.T) {
+	testFileName := "testexportfile.tar"
+	defer os.RemoveAll("src")
+	defer os.RemoveAll(filepath.Join(".", testFileName))
+
+	err := os.Mkdir("src", 0775)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = createTestStore("src")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the archive is the same whatever the number of workers
+	var digests []string
+	for _, n := range []int{1, 4} {
+		updater := &checksumUpdater{checkUpdater: checkUpdater{t: t}}
+		err := exporter.Export(
+			"src",
+			exporter.WithDestinationFilename(testFileName),
+			exporter.WithVerifyChunks(true, false),
+			exporter.WithConcurrency(n),
+			exporter.WithProgressUpdater(updater),
+		)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if updater.prev != updater.total {
+			t.Fatalf("incomplete progress with %d workers, expected: %d got: %d", n, updater.total, updater.prev)
+		}
+		digests = append(digests, updater.digest)
+	}
+	if digests[0] != digests[1] {
+		t.Fatalf("archives differ, expected digest %s got %s", digests[0], digests[1])
+	}
+
+	// the workers stop with the context
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	err = exporter.ExportContext(
+		ctx,
+		"src",
+		exporter.WithDestinationFilename(testFileName),
+		exporter.WithConcurrency(4),
+	)
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected context cancelled error, got %v", err)
+	}
+}
+
+func TestExporterVolumes(t *testing.T) {
+	testFileName := "testexportfile.tar"
+	maxSize := int64(20 * 1024)
+	defer os.RemoveAll("src")
+
+	err := os.Mkdir("src", 0775)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	chMap, err := createTestStore("src")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	updater := &checksumUpdater{checkUpdater: checkUpdater{t: t}}
+	err = exporter.Export(
+		"src",
+		exporter.WithDestinationFilename(testFileName),
+		exporter.WithMaxVolumeSize(maxSize),
+		exporter.WithProgressUpdater(updater),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if updater.prev != 100 {
+		t.Fatal("Final update incorrect")
+	}
+
+	count := 0
+	for i := 1; ; i++ {
+		fname := exporter.VolumeFilename(testFileName, i)
+		b, err := ioutil.ReadFile(fname)
+		if os.IsNotExist(err) {
+			if i < 3 {
+				t.Fatalf("expected multiple volumes, found %d", i-1)
+			}
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.Remove(fname)
+
+		if int64(len(b)) > maxSize {
+			t.Fatalf("volume %s size %d exceeds %d", fname, len(b), maxSize)
+		}
+
+		tr := tar.NewReader(bytes.NewReader(b))
+		hdr, err := tr.Next()
+		if err != nil {
+			t.Fatal(err)
+		}
+		marker, err := ioutil.ReadAll(tr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if i == 1 {
+			if hdr.Name != exporter.ExportVersionFilename || string(marker) != exporter.CurrentExportVersion {
+				t.Fatalf("invalid version entry %s in first volume", hdr.Name)
+			}
+		} else if hdr.Name != exporter.ExportVolumeFilename || string(marker) != strconv.Itoa(i) {
+			t.Fatalf("invalid volume entry %s %q in volume %d", hdr.Name, marker, i)
+		}
+
+		for {
+			hdr, err := tr.Next()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, found := chMap[hdr.Name]; !found {
+				t.Fatalf("unexpected chunk %s in volume %d", hdr.Name, i)
+			}
+			count++
+		}
+	}
+	if count != len(chMap) {
+		t.Fatalf("unexpected chunk count, expected: %d got: %d", len(chMap), count)
+	}
+
+	err = exporter.Export(
+		"src",
+		exporter.WithDestinationFilename(testFileName),
+		exporter.WithMaxVolumeSize(1024),
+	)
+	if err == nil {
+		t.Fatal("expected error for too small volume size")
+	}
+}
+
+func TestUpgrade(t *testing.T) {
+	testFileName := "testexportfile.tar"
+	upgradedFileName := "testupgraded.tar"
+	defer os.RemoveAll("src")
+	defer os.Remove(upgradedFileName)
+
+	err := os.Mkdir("src", 0775)
+	if err != nil {
+		t.Fatal(err)
+	}
+	chMap, err := createTestStore("src")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the volumes of a version 1 archive are upgraded to a single archive
+	err = exporter.Export(
+		"src",
+		exporter.WithDestinationFilename(testFileName),
+		exporter.WithMaxVolumeSize(20*1024),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var volumes []string
+	for i := 1; ; i++ {
+		fname := exporter.VolumeFilename(testFileName, i)
+		if _, err := os.Stat(fname); os.IsNotExist(err) {
+			break
+		}
+		defer os.Remove(fname)
+		volumes = append(volumes, fname)
+	}
+
+	updater := &checksumUpdater{checkUpdater: checkUpdater{t: t}}
+	err = exporter.Upgrade(
+		context.Background(),
+		volumes,
+		exporter.WithDestinationFilename(upgradedFileName),
+		exporter.WithProgressUpdater(updater),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if updater.prev != 100 {
+		t.Fatal("Final update incorrect")
+	}
+
+	tarFile, err := os.Open(upgradedFileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tarFile.Close()
+	version, err := exporter.ReadVersion(tar.NewReader(tarFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if version != exporter.PinsExportVersion {
+		t.Fatalf("unexpected version %s", version)
+	}
+
+	count := 0
+	err = exporter.ReadArchive(upgradedFileName, func(ch swarm.Chunk) error {
+		want, ok := chMap[ch.Address().String()]
+		if !ok || !bytes.Equal(want.Data(), ch.Data()) {
+			return fmt.Errorf("unexpected chunk %s", ch.Address())
+		}
+		count++
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != len(chMap) {
+		t.Fatalf("expected %d chunks, got %d", len(chMap), count)
+	}
+	b, err := ioutil.ReadFile(upgradedFileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sum := sha256.Sum256(b); updater.digest != hex.EncodeToString(sum[:]) {
+		t.Fatalf("invalid checksum, expected %x got %s", sum, updater.digest)
+	}
+
+	err = exporter.Upgrade(
+		context.Background(),
+		[]string{upgradedFileName},
+		exporter.WithDestinationFilename(testFileName),
+	)
+	if !errors.Is(err, exporter.ErrUpToDate) {
+		t.Fatalf("expected up to date error, got %v", err)
+	}
+}
+
+func TestExportReference(t *testing.T) {
+	testFileName := "testexportref.tar"
+	defer os.RemoveAll(filepath.Join(".", testFileName))
+
+	ctx := context.Background()
+	store := mock.NewStorer()
+	s := splitter.NewSimpleSplitter(store, storage.ModePutUpload)
+
+	split := func(data []byte) swarm.Address {
+		t.Helper()
+		addr, err := s.Split(ctx, ioutil.NopCloser(bytes.NewReader(data)), int64(len(data)), false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return addr
+	}
+
+	// an old file entry with 3 data chunks and their intermediate chunk
+	fileData := make([]byte, 2*swarm.ChunkSize+10)
+	if _, err := rand.Read(fileData); err != nil {
+		t.Fatal(err)
+	}
+	mtdt, err := json.Marshal(&entry.Metadata{Filename: "a.bin", MimeType: "application/octet-stream"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	entryData, err := entry.New(split(fileData), split(mtdt)).MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	root := split(entryData)
+
+	// unrelated content of the store
+	split([]byte("other content"))
+
+	updater := &checkUpdater{t: t}
+	err = exporter.ExportReference(ctx, store, root, testFileName, exporter.WithProgressUpdater(updater))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tarFile, err := os.Open(testFileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tarFile.Close()
+
+	count := 0
+	tr := tar.NewReader(tarFile)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if hdr.Name == exporter.ExportVersionFilename {
+			continue
+		}
+		addr, err := swarm.ParseHexAddress(hdr.Name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := store.Get(ctx, storage.ModeGetRequest, addr); err != nil {
+			t.Fatalf("unexpected chunk %s in archive", hdr.Name)
+		}
+		count++
+	}
+	// 4 file chunks, the metadata chunk and the entry chunk
+	if count != 6 {
+		t.Fatalf("unexpected chunk count, expected: %d got: %d", 6, count)
+	}
+	if updater.prev != count || updater.total != count {
+		t.Fatalf("unexpected progress %d/%d", updater.prev, updater.total)
+	}
+}
+
+func TestBundle(t *testing.T) {
+	testFileName := "testbundle.bin"
+	defer os.RemoveAll(filepath.Join(".", testFileName))
+
+	ctx := context.Background()
+	store := mock.NewStorer()
+	s := splitter.NewSimpleSplitter(store, storage.ModePutUpload)
+
+	split := func(data []byte) swarm.Address {
+		t.Helper()
+		addr, err := s.Split(ctx, ioutil.NopCloser(bytes.NewReader(data)), int64(len(data)), false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return addr
+	}
+
+	fileData := make([]byte, 2*swarm.ChunkSize+10)
+	if _, err := rand.Read(fileData); err != nil {
+		t.Fatal(err)
+	}
+	mtdt, err := json.Marshal(&entry.Metadata{Filename: "a.bin", MimeType: "application/octet-stream"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	entryData, err := entry.New(split(fileData), split(mtdt)).MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	root := split(entryData)
+	split([]byte("other content"))
+
+	err = exporter.ExportBundle(ctx, store, root, testFileName, exporter.WithVerifyChunks(true, false))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dst := mock.NewStorer()
+	updater := &checkUpdater{t: t}
+	bundleRoot, err := exporter.ImportBundle(ctx, testFileName, dst, exporter.WithVerifyChunks(true, false), exporter.WithProgressUpdater(updater))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bundleRoot.Equal(root) {
+		t.Fatalf("unexpected root, expected: %s got: %s", root, bundleRoot)
+	}
+	// 4 file chunks, the metadata chunk and the entry chunk
+	if updater.prev != 6 || updater.total != 6 {
+		t.Fatalf("unexpected progress %d/%d", updater.prev, updater.total)
+	}
+	j, _, err := joiner.New(ctx, dst, root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := file.JoinReadAll(ctx, j, ioutil.Discard); err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := ioutil.ReadFile(testFileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(testFileName, b[:len(b)-1], 0644); err != nil {
+		t.Fatal(err)
+	}
+	_, err = exporter.ImportBundle(ctx, testFileName, mock.NewStorer())
+	if !errors.Is(err, exporter.ErrInvalidBundle) {
+		t.Fatalf("expected invalid bundle error for truncated bundle, got %v", err)
+	}
+}
+
+func TestStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "exporter-store")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	chMap, err := createTestStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s, err := exporter.OpenStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	for _, ch := range chMap {
+		got, err := s.Get(context.Background(), storage.ModeGetRequest, ch.Address())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !got.Equal(ch) {
+			t.Fatalf("chunk mismatch %s", ch.Address())
+		}
+	}
+
+	_, err = s.Get(context.Background(), storage.ModeGetRequest, swarm.MustParseHexAddress("aa"))
+	if !errors.Is(err, storage.ErrNotFound) {
+		t.Fatalf("expected not found error, got %v", err)
+	}
+
+	count := 0
+	err = s.Iterate(func(ch swarm.Chunk) (bool, error) {
+		if _, found := chMap[ch.Address().String()]; !found {
+			t.Fatalf("unexpected chunk %s", ch.Address())
+		}
+		count++
+		return false, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != len(chMap) {
+		t.Fatalf("unexpected chunk count, expected: %d got: %d", len(chMap), count)
+	}
+}
+
+func TestExporterLayout(t *testing.T) {
+	dst := filepath.Join(t.TempDir(), "testexportfile.tar")
+
+	newer := t.TempDir()
+	if err := os.Mkdir(filepath.Join(newer, "sharky"), 0775); err != nil {
+		t.Fatal(err)
+	}
+	notDB := t.TempDir()
+	if err := ioutil.WriteFile(filepath.Join(notDB, "notes.txt"), []byte("notes"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	dataDir := t.TempDir()
+	if err := os.Mkdir(filepath.Join(dataDir, "localstore"), 0775); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		name    string
+		src     string
+		wantErr error
+	}{
+		{name: "newer layout", src: newer, wantErr: exporter.ErrUnsupportedLayout},
+		{name: "missing", src: filepath.Join(t.TempDir(), "missing"), wantErr: exporter.ErrNoDatabase},
+		{name: "not a database", src: notDB, wantErr: exporter.ErrNoDatabase},
+		{name: "data directory", src: dataDir, wantErr: exporter.ErrNoDatabase},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			err := exporter.Export(tc.src, exporter.WithDestinationFilename(dst))
+			if !errors.Is(err, tc.wantErr) {
+				t.Fatalf("expected %v, got %v", tc.wantErr, err)
+			}
+			_, err = exporter.OpenStore(tc.src)
+			if !errors.Is(err, tc.wantErr) {
+				t.Fatalf("expected %v, got %v", tc.wantErr, err)
+			}
+		})
+	}
+
+	// an empty database exports an empty archive
+	empty := t.TempDir()
+	s, err := exporter.OpenStore(empty)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := exporter.Export(empty, exporter.WithDestinationFilename(dst)); err != nil {
+		t.Fatal(err)
+	}
+	tarFile, err := os.Open(dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tarFile.Close()
+	tr := tar.NewReader(tarFile)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if hdr.Name != exporter.ExportVersionFilename {
+			t.Fatalf("unexpected entry %s in the archive of an empty database", hdr.Name)
+		}
+	}
 }
 
 func createTestStore(src string) (map[string]swarm.Chunk, error) {
@@ -146,8 +1419,9 @@ func createTestStore(src string) (map[string]swarm.Chunk, error) {
 	}
 	defer closer.Close()
 	chunkMap := make(map[string]swarm.Chunk, 100)
-	chunks := chunktesting.GenerateTestRandomChunks(100)
-	for _, c := range chunks {
+	for i := 0; i < 100; i++ {
+		// valid chunks, so that only the ones corrupted by a test fail the checks
+		c := chunktesting.GenerateTestRandomChunk()
 		item := shed.Item{
 			Address:        c.Address().Bytes(),
 			Data:           c.Data(),
@@ -0,0 +1,68 @@
+package exporter
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/ethersphere/bee/pkg/shed"
+)
+
+var (
+	// ErrNoDatabase is returned when the path does not hold the localstore of a
+	// bee node
+	ErrNoDatabase = errors.New("no localstore database")
+	// ErrUnsupportedLayout is returned when the localstore has the layout of later
+	// bee versions, which keep the chunk data outside of the shed indexes
+	ErrUnsupportedLayout = errors.New("unsupported localstore layout")
+)
+
+// newerLayoutEntries are the entries of the data directory of bee versions which
+// store the chunk data in sharky instead of the retrieval index
+var newerLayoutEntries = []string{"sharky", "indexstore", "chunkstore"}
+
+// checkLayout checks that src holds the shed database of the bee versions this
+// package reads, before it is opened. Opening a path which is not a database would
+// create an empty one there instead of failing. An empty directory is accepted
+// and opened as an empty database, which exports an empty archive
+func checkLayout(src string) error {
+	entries, err := ioutil.ReadDir(src)
+	if os.IsNotExist(err) {
+		return fmt.Errorf("%w at %s: the directory does not exist", ErrNoDatabase, src)
+	}
+	if err != nil {
+		return err
+	}
+	if len(entries) == 0 {
+		return nil
+	}
+	names := make(map[string]struct{}, len(entries))
+	for _, e := range entries {
+		names[e.Name()] = struct{}{}
+	}
+	for _, name := range newerLayoutEntries {
+		if _, found := names[name]; found {
+			return fmt.Errorf("%w: %s has the %s of a later bee version, export it with the bee version which wrote it", ErrUnsupportedLayout, src, name)
+		}
+	}
+	if _, found := names["localstore"]; found {
+		return fmt.Errorf("%w at %s, the localstore is at %s", ErrNoDatabase, src, filepath.Join(src, "localstore"))
+	}
+	// every leveldb directory has the CURRENT file
+	if _, found := names["CURRENT"]; !found {
+		return fmt.Errorf("%w at %s: not a leveldb directory", ErrNoDatabase, src)
+	}
+	return nil
+}
+
+// isEmpty reports whether the index has no items
+func isEmpty(idx shed.Index) (bool, error) {
+	empty := true
+	err := idx.Iterate(func(shed.Item) (bool, error) {
+		empty = false
+		return true, nil
+	}, nil)
+	return empty, err
+}
@@ -0,0 +1,153 @@
+package exporter
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/ethersphere/bee/pkg/shed"
+	"github.com/ethersphere/bee/pkg/swarm"
+)
+
+const (
+	// filename in tar archive that holds the pin counters of the pinned chunks,
+	// one hex encoded address and counter per line
+	ExportPinsFilename = ".swarm-export-pins"
+	// export format version of the archives which hold the pin counters
+	PinsExportVersion = "2"
+)
+
+// Pin is the pin counter of a chunk
+type Pin struct {
+	Address swarm.Address
+	Counter uint64
+}
+
+// WithPins is used to add the pin counters of the exported chunks to the archive,
+// so that the pins can be restored along with the chunks. The archive then has the
+// export version 2, as importers of version 1 do not know the pins entry. With
+// volumes the pins are written to the last volume
+func WithPins(val bool) Option {
+	return func(e *exporter) {
+		e.pins = val
+	}
+}
+
+func newPinIndex(s *shed.DB) (shed.Index, error) {
+	return s.NewIndex("Hash->PinCounter", shed.IndexFuncs{
+		EncodeKey: func(fields shed.Item) (key []byte, err error) {
+			return fields.Address, nil
+		},
+		DecodeKey: func(key []byte) (e shed.Item, err error) {
+			e.Address = key
+			return e, nil
+		},
+		EncodeValue: func(fields shed.Item) (value []byte, err error) {
+			b := make([]byte, 8)
+			binary.BigEndian.PutUint64(b[:8], fields.PinCounter)
+			return b, nil
+		},
+		DecodeValue: func(keyItem shed.Item, value []byte) (e shed.Item, err error) {
+			if len(value) < 8 {
+				return e, fmt.Errorf("invalid pin counter of chunk %x", keyItem.Address)
+			}
+			e.PinCounter = binary.BigEndian.Uint64(value[:8])
+			return e, nil
+		},
+	})
+}
+
+// pinsData returns the content of the pins entry, with the pins of the chunks
+// accepted by the filter
+func (e *exporter) pinsData() ([]byte, error) {
+	var buf bytes.Buffer
+	err := e.pinIndex.Iterate(func(item shed.Item) (stop bool, err error) {
+		if !e.filter(swarm.NewAddress(item.Address)) {
+			return false, nil
+		}
+		_, err = fmt.Fprintf(&buf, "%x %d\n", item.Address, item.PinCounter)
+		return false, err
+	}, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed reading pins Err: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// writePins adds the pins entry to the archive
+func (e *exporter) writePins(tw *tar.Writer) error {
+	data, err := e.pinsData()
+	if err != nil {
+		return err
+	}
+	return writePinsEntry(tw, data)
+}
+
+func writePinsEntry(tw *tar.Writer, data []byte) error {
+	if err := tw.WriteHeader(&tar.Header{
+		Name: ExportPinsFilename,
+		Mode: 0644,
+		Size: int64(len(data)),
+	}); err != nil {
+		return err
+	}
+	_, err := tw.Write(data)
+	return err
+}
+
+// ReadPins returns the pin counters recorded in the archive, or none when it was
+// exported without them. With volumes the pins are in the last one
+func ReadPins(fname string) ([]Pin, error) {
+	f, err := os.Open(fname)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	tr := tar.NewReader(f)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if hdr.Name == ExportPinsFilename {
+			return parsePins(tr)
+		}
+	}
+}
+
+func parsePins(r io.Reader) ([]Pin, error) {
+	var pins []Pin
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 {
+			continue
+		}
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("invalid pin on line %d", line)
+		}
+		addr, err := swarm.ParseHexAddress(fields[0])
+		if err != nil {
+			return nil, fmt.Errorf("invalid pin address on line %d Err: %w", line, err)
+		}
+		counter, err := strconv.ParseUint(fields[1], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid pin counter on line %d Err: %w", line, err)
+		}
+		pins = append(pins, Pin{Address: addr, Counter: counter})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return pins, nil
+}
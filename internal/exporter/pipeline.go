@@ -0,0 +1,99 @@
+package exporter
+
+import (
+	"context"
+	"sync"
+
+	"github.com/ethersphere/bee/pkg/shed"
+)
+
+// WithConcurrency is used to check the chunks, i.e. filter and verify them, with
+// n workers while the archive is still written by a single one in index order
+func WithConcurrency(n int) Option {
+	return func(e *exporter) {
+		e.concurrency = n
+	}
+}
+
+// checkedItem is a chunk of the index with the result of its checks
+type checkedItem struct {
+	item       shed.Item
+	include    bool // passed the filter and the verification
+	corrupt    bool // left out for being corrupt
+	unreadable bool // left out for its data not being readable
+	err        error
+}
+
+// checkJob is a chunk waiting to be checked by a worker
+type checkJob struct {
+	item shed.Item
+	out  chan<- checkedItem
+}
+
+// iterate calls fn with every checked chunk of the index in order, starting from
+// the options, until fn returns an error. With concurrency the chunks are checked
+// ahead by the workers, which stop once the context is cancelled
+func (e *exporter) iterate(parent context.Context, iterOpts *shed.IterateOptions, fn func(checkedItem) error) error {
+	if e.concurrency <= 1 {
+		return e.retrievalIndex.Iterate(func(item shed.Item) (bool, error) {
+			if err := fn(e.checkItem(item)); err != nil {
+				return true, err
+			}
+			return false, nil
+		}, iterOpts)
+	}
+
+	// the results are queued in index order, which bounds the chunks in flight
+	ctx, cancel := context.WithCancel(parent)
+	jobs := make(chan checkJob)
+	results := make(chan (<-chan checkedItem), e.concurrency)
+
+	var wg sync.WaitGroup
+	for i := 0; i < e.concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := range jobs {
+				j.out <- e.checkItem(j.item)
+			}
+		}()
+	}
+
+	var iterErr error
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		defer close(results)
+		defer close(jobs)
+		iterErr = e.retrievalIndex.Iterate(func(item shed.Item) (bool, error) {
+			out := make(chan checkedItem, 1)
+			select {
+			case jobs <- checkJob{item: item, out: out}:
+			case <-ctx.Done():
+				return true, nil
+			}
+			select {
+			case results <- out:
+			case <-ctx.Done():
+				return true, nil
+			}
+			return false, nil
+		}, iterOpts)
+	}()
+
+	// the index must not be iterated anymore when this returns
+	defer wg.Wait()
+	defer cancel()
+
+	for out := range results {
+		if err := fn(<-out); err != nil {
+			return err
+		}
+	}
+	wg.Wait()
+	if iterErr == nil {
+		// the iteration stops early when the context is cancelled
+		iterErr = parent.Err()
+	}
+	return iterErr
+}
@@ -0,0 +1,274 @@
+package exporter
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/ethersphere/bee-repair/internal/collection/entry"
+	"github.com/ethersphere/bee/pkg/file"
+	"github.com/ethersphere/bee/pkg/file/joiner"
+	"github.com/ethersphere/bee/pkg/file/loadsave"
+	"github.com/ethersphere/bee/pkg/manifest"
+	"github.com/ethersphere/bee/pkg/manifest/mantaray"
+	"github.com/ethersphere/bee/pkg/shed"
+	"github.com/ethersphere/bee/pkg/storage"
+	"github.com/ethersphere/bee/pkg/swarm"
+)
+
+// maxStructureSize is the largest entry, metadata or manifest node which is read
+// to find out what a reference points to
+const maxStructureSize = 16 * swarm.ChunkSize
+
+// errReadOnly is returned when the traversal tries to store a chunk
+var errReadOnly = errors.New("read only store")
+
+// ExportReference writes the chunks reachable from the root reference to the
+// archive at dst, reading them from the store. The root can be a file or directory
+// entry of the old format, a manifest or plain bytes, and the files of directories
+// and manifests are followed. The progress, verification and checksum options
+// apply as they do for Export, while checkpoints, volumes and pins are not supported
+func ExportReference(ctx context.Context, store storage.Getter, root swarm.Address, dst string, opts ...Option) error {
+	e := &exporter{}
+	for _, opt := range opts {
+		opt(e)
+	}
+	e.dstFile = dst
+	defaultOpts(e)
+
+	c := &collector{
+		getter: store,
+		ls:     loadsave.New(&readOnlyStore{store}, storage.ModePutUpload, false),
+		refs:   make(map[string]struct{}),
+		chunks: make(map[string]struct{}),
+	}
+	if err := c.collect(ctx, root); err != nil {
+		return fmt.Errorf("failed traversing reference %s Err: %w", root, err)
+	}
+	if err := e.exportChunks(ctx, store, c.addrs); err != nil {
+		return fmt.Errorf("failed exporting reference Err: %w", err)
+	}
+	return nil
+}
+
+// exportChunks writes the chunks of the addresses to the archive in order
+func (e *exporter) exportChunks(ctx context.Context, store storage.Getter, addrs []swarm.Address) (err error) {
+	f, err := os.Create(e.dstFile)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			os.Remove(e.dstFile)
+		}
+	}()
+
+	h := sha256.New()
+	tw := tar.NewWriter(io.MultiWriter(f, h))
+	if err := writeVersion(tw, CurrentExportVersion); err != nil {
+		return err
+	}
+
+	doneCount, corruptCount := 0, 0
+	var unreadable []swarm.Address
+	total := len(addrs)
+	e.updater.Update(doneCount, total)
+
+	for _, addr := range addrs {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		var data []byte
+		ch, err := store.Get(ctx, storage.ModeGetRequest, addr)
+		switch {
+		case err == nil:
+			data = ch.Data()
+		case !e.skipUnreadable:
+			return fmt.Errorf("chunk %s: %w", addr, err)
+		}
+		c := e.checkItem(shed.Item{Address: addr.Bytes(), Data: data})
+		if err := e.writeItem(tw, c); err != nil {
+			return err
+		}
+		if c.corrupt {
+			corruptCount++
+		}
+		if c.unreadable {
+			unreadable = append(unreadable, addr)
+		}
+		doneCount++
+		e.updater.Update(doneCount, total)
+	}
+
+	return e.finish(tw, h, corruptCount, unreadable)
+}
+
+// readOnlyStore lets the manifests be loaded from a getter
+type readOnlyStore struct {
+	storage.Getter
+}
+
+func (s *readOnlyStore) Put(_ context.Context, _ storage.ModePut, _ ...swarm.Chunk) ([]bool, error) {
+	return nil, errReadOnly
+}
+
+// collector gathers the addresses of the chunks reachable from a reference, each
+// of them once and in the order they are found
+type collector struct {
+	getter storage.Getter
+	ls     file.LoadSaver
+	refs   map[string]struct{}
+	chunks map[string]struct{}
+	addrs  []swarm.Address
+}
+
+// collect adds the chunks of the reference and of everything it points to
+func (c *collector) collect(ctx context.Context, ref swarm.Address) error {
+	if _, found := c.refs[ref.String()]; found {
+		return nil
+	}
+	c.refs[ref.String()] = struct{}{}
+
+	e, mtdt, err := c.oldEntry(ctx, ref)
+	if err != nil {
+		return err
+	}
+	if e != nil {
+		if err := c.addBytes(ctx, ref); err != nil {
+			return err
+		}
+		if err := c.addBytes(ctx, e.Metadata()); err != nil {
+			return err
+		}
+		switch {
+		case isZero(e.Reference().Bytes()):
+			// empty files of the old format have no chunks
+			return nil
+		case mtdt.MimeType == manifest.ManifestMantarayContentType:
+			return c.collectManifest(ctx, e.Reference())
+		default:
+			return c.addBytes(ctx, e.Reference())
+		}
+	}
+
+	isManifest, err := c.isManifest(ctx, ref)
+	if err != nil {
+		return err
+	}
+	if isManifest {
+		return c.collectManifest(ctx, ref)
+	}
+	return c.addBytes(ctx, ref)
+}
+
+// collectManifest adds the chunks of every node of the manifest and collects the
+// references of its entries. Feeds are left out, as their content is not part of
+// the manifest
+func (c *collector) collectManifest(ctx context.Context, ref swarm.Address) error {
+	node := mantaray.NewNodeRef(ref.Bytes())
+	return node.WalkNode(ctx, []byte{}, c.ls, func(path []byte, n *mantaray.Node, err error) error {
+		if err != nil {
+			return err
+		}
+		if r := n.Reference(); len(r) > 0 {
+			if err := c.addBytes(ctx, swarm.NewAddress(r)); err != nil {
+				return err
+			}
+		}
+		// a loaded node with forks is typed only as an edge, the entry tells
+		// whether it has one
+		if isZero(n.Entry()) {
+			return nil
+		}
+		if _, feed := n.Metadata()["swarm-feed-owner"]; feed {
+			return nil
+		}
+		return c.collect(ctx, swarm.NewAddress(n.Entry()))
+	})
+}
+
+// addBytes adds the chunks of the tree of the reference
+func (c *collector) addBytes(ctx context.Context, ref swarm.Address) error {
+	j, _, err := joiner.New(ctx, c.getter, ref)
+	if err != nil {
+		return err
+	}
+	return j.IterateChunkAddresses(func(addr swarm.Address) error {
+		if _, found := c.chunks[addr.String()]; !found {
+			c.chunks[addr.String()] = struct{}{}
+			c.addrs = append(c.addrs, addr)
+		}
+		return nil
+	})
+}
+
+// oldEntry returns the entry of the old format the reference points to and its
+// metadata, or nil when it does not point to one
+func (c *collector) oldEntry(ctx context.Context, ref swarm.Address) (*entry.Entry, *entry.Metadata, error) {
+	data, ok, err := c.read(ctx, ref, func(size int64) bool { return entry.CanUnmarshal(size) })
+	if err != nil || !ok {
+		return nil, nil, err
+	}
+	e := &entry.Entry{}
+	if err := e.UnmarshalBinary(data); err != nil {
+		return nil, nil, nil
+	}
+
+	data, ok, err = c.read(ctx, e.Metadata(), func(size int64) bool { return size <= maxStructureSize })
+	if err != nil {
+		return nil, nil, fmt.Errorf("metadata of entry %s: %w", ref, err)
+	}
+	if !ok {
+		return nil, nil, nil
+	}
+	mtdt := &entry.Metadata{}
+	if err := json.Unmarshal(data, mtdt); err != nil {
+		// an entry sized file which happens to point to existing data
+		return nil, nil, nil
+	}
+	return e, mtdt, nil
+}
+
+// isManifest reports whether the reference points to a manifest node
+func (c *collector) isManifest(ctx context.Context, ref swarm.Address) (bool, error) {
+	data, ok, err := c.read(ctx, ref, func(size int64) bool { return size <= maxStructureSize })
+	if err != nil || !ok {
+		return false, err
+	}
+	return mantaray.New().UnmarshalBinary(data) == nil, nil
+}
+
+// read returns the bytes of the reference when their size is accepted
+func (c *collector) read(ctx context.Context, ref swarm.Address, accept func(int64) bool) ([]byte, bool, error) {
+	j, size, err := joiner.New(ctx, c.getter, ref)
+	if err != nil {
+		return nil, false, err
+	}
+	if !accept(size) {
+		return nil, false, nil
+	}
+	buf := bytes.NewBuffer(nil)
+	if _, err := file.JoinReadAll(ctx, j, buf); err != nil {
+		return nil, false, err
+	}
+	return buf.Bytes(), true, nil
+}
+
+// isZero reports whether the reference has only zero bytes
+func isZero(ref []byte) bool {
+	for _, b := range ref {
+		if b != 0 {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,82 @@
+package exporter
+
+import (
+	"context"
+	"io"
+	"time"
+
+	"github.com/ethersphere/bee/pkg/shed"
+	"github.com/ethersphere/bee/pkg/storage"
+	"github.com/ethersphere/bee/pkg/swarm"
+)
+
+// Store gives access to the chunks of a local database
+type Store struct {
+	retrievalIndex shed.Index
+	closer         io.Closer
+}
+
+// OpenStore opens the database at src for reading the chunks
+func OpenStore(src string) (*Store, error) {
+	idx, closer, err := getRetrievalIndex(src)
+	if err != nil {
+		return nil, err
+	}
+	return &Store{
+		retrievalIndex: idx,
+		closer:         closer,
+	}, nil
+}
+
+// Get implements storage.Getter. storage.ErrNotFound is returned when the chunk
+// is not present in the database
+func (s *Store) Get(_ context.Context, _ storage.ModeGet, addr swarm.Address) (swarm.Chunk, error) {
+	key := shed.Item{Address: addr.Bytes()}
+	has, err := s.retrievalIndex.Has(key)
+	if err != nil {
+		return nil, err
+	}
+	if !has {
+		return nil, storage.ErrNotFound
+	}
+	item, err := s.retrievalIndex.Get(key)
+	if err != nil {
+		return nil, err
+	}
+	return swarm.NewChunk(addr, item.Data), nil
+}
+
+// Put implements storage.Putter. The chunks are added to the retrieval index
+// only, which is enough for them to be read and exported, and the mode is ignored
+func (s *Store) Put(_ context.Context, _ storage.ModePut, chs ...swarm.Chunk) (exist []bool, err error) {
+	exist = make([]bool, len(chs))
+	for i, ch := range chs {
+		item := shed.Item{Address: ch.Address().Bytes()}
+		exist[i], err = s.retrievalIndex.Has(item)
+		if err != nil {
+			return nil, err
+		}
+		if exist[i] {
+			continue
+		}
+		item.Data = ch.Data()
+		item.StoreTimestamp = time.Now().UTC().UnixNano()
+		if err := s.retrievalIndex.Put(item); err != nil {
+			return nil, err
+		}
+	}
+	return exist, nil
+}
+
+// Iterate calls fn with every chunk of the database until it returns stop or an
+// error
+func (s *Store) Iterate(fn func(swarm.Chunk) (stop bool, err error)) error {
+	return s.retrievalIndex.Iterate(func(item shed.Item) (bool, error) {
+		return fn(swarm.NewChunk(swarm.NewAddress(item.Address), item.Data))
+	}, nil)
+}
+
+// Close closes the database
+func (s *Store) Close() error {
+	return s.closer.Close()
+}
@@ -0,0 +1,156 @@
+package exporter
+
+import (
+	"archive/tar"
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/ethersphere/bee/pkg/shed"
+	"github.com/ethersphere/bee/pkg/swarm"
+)
+
+// ErrUpToDate is returned when the archive to upgrade already has the latest
+// export version
+var ErrUpToDate = errors.New("archive already has the latest export version")
+
+// Upgrade reads an archive of an earlier export version and writes its chunks to
+// a new archive of the latest version, without a node or database. The sources
+// are the volumes of the archive in order, or the archive itself. The pins entry
+// of version 2 is added empty, as earlier archives have no pin counters. The
+// progress, verification, filter and checksum options apply as they do for
+// Export, while checkpoints, volumes and pins are not supported
+func Upgrade(ctx context.Context, srcs []string, opts ...Option) error {
+	if len(srcs) == 0 {
+		return errors.New("no archive to upgrade")
+	}
+	e := &exporter{}
+	for _, opt := range opts {
+		opt(e)
+	}
+	defaultOpts(e)
+	for _, src := range srcs {
+		if src == e.dstFile {
+			return fmt.Errorf("archive %s cannot be upgraded in place", src)
+		}
+	}
+
+	version, err := readVersionFile(srcs[0])
+	if err != nil {
+		return fmt.Errorf("reading archive %s Err: %w", srcs[0], err)
+	}
+	if err := CheckVersion(version); err != nil {
+		return err
+	}
+	if version == PinsExportVersion {
+		return fmt.Errorf("%s: %w", srcs[0], ErrUpToDate)
+	}
+
+	total := 0
+	for _, src := range srcs {
+		n, err := countArchiveChunks(src)
+		if err != nil {
+			return fmt.Errorf("reading archive %s Err: %w", src, err)
+		}
+		total += n
+	}
+
+	if err := e.upgrade(ctx, srcs, total); err != nil {
+		return fmt.Errorf("failed upgrading archive Err: %w", err)
+	}
+	return nil
+}
+
+// upgrade writes the chunks of the sources to the archive of the latest version
+func (e *exporter) upgrade(ctx context.Context, srcs []string, total int) (err error) {
+	f, err := os.Create(e.dstFile)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			os.Remove(e.dstFile)
+		}
+	}()
+
+	h := sha256.New()
+	tw := tar.NewWriter(io.MultiWriter(f, h))
+	if err := writeVersion(tw, PinsExportVersion); err != nil {
+		return err
+	}
+
+	doneCount, corruptCount := 0, 0
+	var unreadable []swarm.Address
+	e.updater.Update(doneCount, total)
+
+	for _, src := range srcs {
+		err := ReadArchive(src, func(ch swarm.Chunk) error {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			c := e.checkItem(shed.Item{Address: ch.Address().Bytes(), Data: ch.Data()})
+			if err := e.writeItem(tw, c); err != nil {
+				return err
+			}
+			if c.corrupt {
+				corruptCount++
+			}
+			if c.unreadable {
+				unreadable = append(unreadable, ch.Address())
+			}
+			doneCount++
+			e.updater.Update(doneCount, total)
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	if err := writePinsEntry(tw, nil); err != nil {
+		return err
+	}
+	return e.finish(tw, h, corruptCount, unreadable)
+}
+
+// readVersionFile returns the export version of the archive at fname
+func readVersionFile(fname string) (string, error) {
+	f, err := os.Open(fname)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	return ReadVersion(tar.NewReader(f))
+}
+
+// countArchiveChunks returns the number of chunks in the archive at fname, reading
+// only the headers
+func countArchiveChunks(fname string) (int, error) {
+	f, err := os.Open(fname)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	count := 0
+	tr := tar.NewReader(f)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return count, nil
+		}
+		if err != nil {
+			return 0, err
+		}
+		if !strings.HasPrefix(hdr.Name, ".") {
+			count++
+		}
+	}
+}
@@ -0,0 +1,255 @@
+package exporter
+
+import (
+	"archive/tar"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"strconv"
+
+	"github.com/ethersphere/bee/pkg/swarm"
+)
+
+const (
+	// filename in the tar archive of every volume after the first one that holds
+	// the number of the volume, starting from 1 for the first volume
+	ExportVolumeFilename = ".swarm-export-volume"
+
+	// size of the tar headers and of the padding of the entries
+	tarBlockSize = 512
+	// size of the two zero blocks which end the tar archive
+	tarTrailerSize = 2 * tarBlockSize
+)
+
+// minVolumeSize is the smallest volume which can hold the marker and one chunk
+var minVolumeSize = tarEntrySize(tarBlockSize) + tarEntrySize(swarm.ChunkSize+swarm.SpanSize) + tarTrailerSize
+
+// WithMaxVolumeSize is used to split the archive in volumes of at most size bytes.
+// The volumes are named after the destination file with the .001, .002, ... suffix
+// and each of them is a tar archive on its own. The first volume holds the export
+// version like a single archive does, the others hold their volume number instead.
+// A checkpoint cannot be used together with volumes
+func WithMaxVolumeSize(size int64) Option {
+	return func(e *exporter) {
+		e.maxVolumeSize = size
+	}
+}
+
+// VolumeFilename returns the name of the volume of the destination file, the
+// first volume having number 1
+func VolumeFilename(fname string, volume int) string {
+	return fmt.Sprintf("%s.%03d", fname, volume)
+}
+
+// tarEntrySize returns the size of the header and the padded data of an entry
+func tarEntrySize(size int) int64 {
+	blocks := (size + tarBlockSize - 1) / tarBlockSize
+	return int64(tarBlockSize + blocks*tarBlockSize)
+}
+
+// countingWriter counts the bytes written to the underlying writer
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(b []byte) (int, error) {
+	n, err := c.w.Write(b)
+	c.n += int64(n)
+	return n, err
+}
+
+// volumeWriter writes the tar archive into volumes of capped size
+type volumeWriter struct {
+	fname   string
+	maxSize int64
+	version string
+	files   []string
+	digests []string
+
+	f      *os.File
+	h      hash.Hash
+	cw     *countingWriter
+	tw     *tar.Writer
+	chunks int
+}
+
+// open starts the next volume
+func (v *volumeWriter) open() error {
+	volume := len(v.files) + 1
+	fname := VolumeFilename(v.fname, volume)
+	f, err := os.Create(fname)
+	if err != nil {
+		return err
+	}
+	v.files = append(v.files, fname)
+	v.f = f
+	v.h = sha256.New()
+	v.cw = &countingWriter{w: io.MultiWriter(f, v.h)}
+	v.tw = tar.NewWriter(v.cw)
+	v.chunks = 0
+
+	name, data := ExportVersionFilename, v.version
+	if volume > 1 {
+		name, data = ExportVolumeFilename, strconv.Itoa(volume)
+	}
+	if err := v.tw.WriteHeader(&tar.Header{
+		Name: name,
+		Mode: 0644,
+		Size: int64(len(data)),
+	}); err != nil {
+		return err
+	}
+	if _, err := v.tw.Write([]byte(data)); err != nil {
+		return err
+	}
+	return v.tw.Flush()
+}
+
+// finish ends the archive of the current volume and closes it
+func (v *volumeWriter) finish() error {
+	if v.f == nil {
+		return nil
+	}
+	err := v.tw.Close()
+	if cerr := v.f.Close(); err == nil {
+		err = cerr
+	}
+	v.f = nil
+	if err != nil {
+		return err
+	}
+	v.digests = append(v.digests, hex.EncodeToString(v.h.Sum(nil)))
+	return nil
+}
+
+// reserve makes room for a chunk with data of the size, starting a new volume when
+// it would not fit in the current one
+func (v *volumeWriter) reserve(size int) error {
+	if v.chunks == 0 || v.cw.n+tarEntrySize(size)+tarTrailerSize <= v.maxSize {
+		return nil
+	}
+	if err := v.finish(); err != nil {
+		return err
+	}
+	return v.open()
+}
+
+// written records whether the last item was added to the current volume
+func (v *volumeWriter) written(before int64) error {
+	if err := v.tw.Flush(); err != nil {
+		return err
+	}
+	if v.cw.n > before {
+		v.chunks++
+	}
+	return nil
+}
+
+// remove deletes all the volumes
+func (v *volumeWriter) remove() {
+	if v.f != nil {
+		v.f.Close()
+		v.f = nil
+	}
+	for _, fname := range v.files {
+		os.Remove(fname)
+	}
+}
+
+// exportVolumes writes the archive as volumes of capped size
+func (e *exporter) exportVolumes(ctx context.Context, total int) (err error) {
+	if e.checkpointFile != "" {
+		return errors.New("volumes cannot be used with a checkpoint")
+	}
+	if e.maxVolumeSize < minVolumeSize {
+		return fmt.Errorf("volume size must be at least %d bytes", minVolumeSize)
+	}
+
+	v := &volumeWriter{fname: e.dstFile, maxSize: e.maxVolumeSize, version: e.version()}
+	defer func() {
+		if ferr := v.finish(); err == nil {
+			err = ferr
+		}
+		if err != nil {
+			v.remove()
+		}
+	}()
+	if err := v.open(); err != nil {
+		return err
+	}
+
+	doneCount, corruptCount := 0, 0
+	var unreadable []swarm.Address
+	e.updater.Update(doneCount, total)
+
+	err = e.iterate(ctx, nil, func(c checkedItem) error {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		default:
+		}
+
+		if c.include {
+			if err := v.reserve(len(c.item.Data)); err != nil {
+				return err
+			}
+		}
+		before := v.cw.n
+		if err := e.writeItem(v.tw, c); err != nil {
+			return err
+		}
+		if err := v.written(before); err != nil {
+			return err
+		}
+		if c.corrupt {
+			corruptCount++
+		}
+		if c.unreadable {
+			unreadable = append(unreadable, swarm.NewAddress(c.item.Address))
+		}
+
+		doneCount += e.progress(c.item)
+		e.updater.Update(doneCount, total)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if e.pins {
+		// the pins go to the last volume
+		pins, err := e.pinsData()
+		if err != nil {
+			return err
+		}
+		if err := v.reserve(len(pins)); err != nil {
+			return err
+		}
+		if err := writePinsEntry(v.tw, pins); err != nil {
+			return err
+		}
+	}
+
+	e.reportSkipped(corruptCount, unreadable)
+
+	if err := v.finish(); err != nil {
+		return err
+	}
+	for i, fname := range v.files {
+		if e.checksumFile {
+			if err := writeChecksumFile(fname, v.digests[i]); err != nil {
+				return err
+			}
+		}
+		if c, ok := e.updater.(ChecksumUpdater); ok {
+			c.Checksum(v.digests[i])
+		}
+	}
+	return nil
+}
@@ -0,0 +1,85 @@
+// Copyright 2021 The Swarm Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package mapping records which new reference every old reference was repaired
+// to, in a key value database which can be queried later on.
+package mapping
+
+import (
+	"github.com/ethersphere/bee/pkg/shed"
+	"github.com/ethersphere/bee/pkg/storage"
+	"github.com/ethersphere/bee/pkg/swarm"
+)
+
+// Store is a database of old to new reference mappings
+type Store struct {
+	db    *shed.DB
+	index shed.Index
+}
+
+// Open opens the database at path, creating it when it does not exist
+func Open(path string) (*Store, error) {
+	db, err := shed.NewDB(path, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	index, err := db.NewIndex("OldReference->NewReference", shed.IndexFuncs{
+		EncodeKey: func(fields shed.Item) (key []byte, err error) {
+			return fields.Address, nil
+		},
+		DecodeKey: func(key []byte) (e shed.Item, err error) {
+			e.Address = key
+			return e, nil
+		},
+		EncodeValue: func(fields shed.Item) (value []byte, err error) {
+			return fields.Data, nil
+		},
+		DecodeValue: func(keyItem shed.Item, value []byte) (e shed.Item, err error) {
+			e.Data = value
+			return e, nil
+		},
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return &Store{
+		db:    db,
+		index: index,
+	}, nil
+}
+
+// Put records that the old reference was repaired to the new one, replacing any
+// earlier mapping of the old reference
+func (s *Store) Put(oldRef, newRef swarm.Address) error {
+	return s.index.Put(shed.Item{
+		Address: oldRef.Bytes(),
+		Data:    newRef.Bytes(),
+	})
+}
+
+// Get returns the new reference of the old one. storage.ErrNotFound is returned
+// when the old reference was not recorded
+func (s *Store) Get(oldRef swarm.Address) (swarm.Address, error) {
+	key := shed.Item{Address: oldRef.Bytes()}
+	has, err := s.index.Has(key)
+	if err != nil {
+		return swarm.ZeroAddress, err
+	}
+	if !has {
+		return swarm.ZeroAddress, storage.ErrNotFound
+	}
+	item, err := s.index.Get(key)
+	if err != nil {
+		return swarm.ZeroAddress, err
+	}
+	return swarm.NewAddress(item.Data), nil
+}
+
+// Close closes the database
+func (s *Store) Close() error {
+	return s.db.Close()
+}
@@ -0,0 +1,59 @@
+// Copyright 2021 The Swarm Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package mapping_test
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/ethersphere/bee-repair/internal/mapping"
+	"github.com/ethersphere/bee/pkg/storage"
+	"github.com/ethersphere/bee/pkg/swarm"
+)
+
+func TestStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mapping")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	oldRef := swarm.MustParseHexAddress("2387e8e7d8a48c2a9339c97c1dc3461a9a7aa07e994c5cb8b38fd7c1b3e6ea48")
+	newRef := swarm.MustParseHexAddress("94434d3312320fab70428c39b79dffb4abc3dbedf3e1562384a61ceaf8a7e36b")
+
+	s, err := mapping.Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = s.Get(oldRef)
+	if !errors.Is(err, storage.ErrNotFound) {
+		t.Fatalf("expected not found error, got %v", err)
+	}
+	err = s.Put(oldRef, newRef)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = s.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the mapping is kept across reopening
+	s, err = mapping.Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	found, err := s.Get(oldRef)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !found.Equal(newRef) {
+		t.Fatalf("Invalid new reference, Exp: %s Found: %s", newRef, found)
+	}
+}
@@ -0,0 +1,143 @@
+// Copyright 2021 The Swarm Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package repair
+
+import (
+	"context"
+	"strings"
+	"sync"
+
+	"github.com/ethersphere/bee/pkg/logging"
+	"github.com/ethersphere/bee/pkg/manifest"
+	"github.com/ethersphere/bee/pkg/swarm"
+)
+
+// manifestAdder serializes the changes to the new manifest, which is not safe for
+// concurrent use, so that its entries can be added by several goroutines
+type manifestAdder struct {
+	mtx sync.Mutex
+	m   manifest.Interface
+	// pending are the entries, in path order, which are added among the ones
+	// passed to Add so that all of them are added in path order
+	pending []*pathEntry
+	// flushInterval is the number of entries after which the manifest is stored,
+	// added is the number of entries added since it was stored last
+	flushInterval int
+	added         int
+	// spine are the paths added which are prefixes of the path added last,
+	// including it
+	spine []string
+	// sized is set once an entry with a reference is added, which sets the size of
+	// the references of the nodes
+	sized  bool
+	logger logging.Logger
+}
+
+func newManifestAdder(m manifest.Interface) *manifestAdder {
+	return &manifestAdder{m: m}
+}
+
+// newDirectoryAdder returns the adder of a directory manifest whose entries are
+// added in path order, together with the pending ones. The manifest is stored
+// every flushInterval entries, which releases the nodes added so far from memory,
+// while zero means it is stored once. The path order keeps the flushes, and so the
+// reference, independent of the order of the walk, though it needs the paths of
+// all of the files sorted first, which stay in memory with the old manifest
+func newDirectoryAdder(m manifest.Interface, pending []*pathEntry, flushInterval int, logger logging.Logger) *manifestAdder {
+	return &manifestAdder{
+		m:             m,
+		pending:       pending,
+		flushInterval: flushInterval,
+		logger:        logger,
+	}
+}
+
+// Add adds the entry at the path of the manifest, after the pending entries which
+// come before it
+func (a *manifestAdder) Add(ctx context.Context, path string, entry manifest.Entry) error {
+	a.mtx.Lock()
+	defer a.mtx.Unlock()
+	if err := a.addPending(ctx, path); err != nil {
+		return err
+	}
+	return a.add(ctx, path, entry)
+}
+
+// Store stores the manifest and returns its reference, the entries added
+// concurrently must be done by then
+func (a *manifestAdder) Store(ctx context.Context) (swarm.Address, error) {
+	a.mtx.Lock()
+	defer a.mtx.Unlock()
+	if len(a.pending) > 0 {
+		if err := a.addPending(ctx, a.pending[len(a.pending)-1].path); err != nil {
+			return swarm.ZeroAddress, err
+		}
+	}
+	return a.m.Store(ctx)
+}
+
+// addPending adds the pending entries up to the path, the ones at the path itself
+// are replaced by the entry added there
+func (a *manifestAdder) addPending(ctx context.Context, path string) error {
+	for len(a.pending) > 0 && a.pending[0].path <= path {
+		p := a.pending[0]
+		a.pending[0] = nil
+		a.pending = a.pending[1:]
+		if err := a.add(ctx, p.path, p.entry); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// add adds the entry, storing the manifest before it when the flush interval is
+// reached and the entry allows it
+func (a *manifestAdder) add(ctx context.Context, path string, entry manifest.Entry) error {
+	if a.flushInterval <= 0 {
+		return a.m.Add(ctx, path, entry)
+	}
+	// with the paths in order, the ones which are not prefixes of this path are
+	// not prefixes of the later ones either
+	for len(a.spine) > 0 && !strings.HasPrefix(path, a.spine[len(a.spine)-1]) {
+		a.spine = a.spine[:len(a.spine)-1]
+	}
+	flush := a.added >= a.flushInterval && a.canFlush(path, entry)
+	a.spine = append(a.spine, path)
+	a.sized = a.sized || len(entry.Reference().Bytes()) > 0
+	if !flush {
+		a.added++
+		return a.m.Add(ctx, path, entry)
+	}
+
+	ref, err := a.m.Store(ctx)
+	if err != nil {
+		return err
+	}
+	a.logger.Debugf("Stored new manifest %s after %d entries, before adding %s", ref, a.added, path)
+	a.added = 1
+	return a.m.Add(ctx, path, entry)
+}
+
+// canFlush reports whether the manifest can be stored before the entry is added,
+// otherwise the flush is put off to the first entry which allows it. Every flush
+// stores the nodes changed since the last one, chunks to pay postage for on top
+// of the ones of the final manifest, so the interval should be in the thousands.
+// The next add loads the stored nodes of its path again, which the mantaray
+// version of go.mod does lossily, as TestMantarayReload records. A loaded node
+// with forks is typed as an edge only, so no path added before may be a prefix of
+// the path, whose node would lose its entry. A loaded node does not keep the size
+// of its references either, which only an entry with a reference sets, so one
+// must have been added before and the entry must have one too. The loaded nodes
+// lose their path separator flags as well, which changes the reference of the
+// manifest but not the entries it serves
+func (a *manifestAdder) canFlush(path string, entry manifest.Entry) bool {
+	return a.sized && len(entry.Reference().Bytes()) > 0 && len(a.spine) == 0
+}
+
+// pathEntry is an entry of the new manifest and the path it is added at
+type pathEntry struct {
+	path  string
+	entry manifest.Entry
+}
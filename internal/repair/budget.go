@@ -0,0 +1,103 @@
+// Copyright 2021 The Swarm Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package repair
+
+import (
+	"context"
+	"sync"
+)
+
+// WithMaxBufferedBytes is used to bound the number of bytes of old metadata held in
+// memory at once, by the entries being read and by the ones a directory walk holds
+// to reuse them for the files at several paths. An entry whose metadata does not
+// fit drops the entries held by its walk and waits for the ones being read to be
+// released, while metadata larger than the bound is read on its own. The same
+// option can be passed to concurrent repairs, which then share the bound. File
+// contents are never buffered, so n only needs to cover the metadata
+func WithMaxBufferedBytes(n int64) Option {
+	if n <= 0 {
+		return func(*Repairer) {}
+	}
+	b := newByteBudget(n)
+	return func(c *Repairer) {
+		c.budget = b
+	}
+}
+
+// WithManifestFlushInterval is used to store the new manifest of a directory every
+// n entries and release its nodes from memory, for directories too large to hold
+// at the cost of extra chunks. Zero, the default, stores it once
+func WithManifestFlushInterval(n int) Option {
+	return func(c *Repairer) {
+		c.flushInterval = n
+	}
+}
+
+// byteBudget bounds the number of bytes held by concurrent readers
+type byteBudget struct {
+	mtx      sync.Mutex
+	size     int64
+	used     int64
+	released chan struct{}
+}
+
+func newByteBudget(size int64) *byteBudget {
+	return &byteBudget{
+		size:     size,
+		released: make(chan struct{}),
+	}
+}
+
+// acquire waits until n bytes are available or the context is done. Requests for
+// more than the whole budget wait for all of it
+func (b *byteBudget) acquire(ctx context.Context, n int64) error {
+	if n > b.size {
+		n = b.size
+	}
+	for {
+		b.mtx.Lock()
+		if b.used+n <= b.size {
+			b.used += n
+			b.mtx.Unlock()
+			return nil
+		}
+		released := b.released
+		b.mtx.Unlock()
+
+		select {
+		case <-released:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// tryAcquire takes n bytes when they are available, without waiting
+func (b *byteBudget) tryAcquire(n int64) bool {
+	if n > b.size {
+		n = b.size
+	}
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+
+	if b.used+n > b.size {
+		return false
+	}
+	b.used += n
+	return true
+}
+
+// release returns n bytes acquired before and wakes up the waiting readers
+func (b *byteBudget) release(n int64) {
+	if n > b.size {
+		n = b.size
+	}
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+
+	b.used -= n
+	close(b.released)
+	b.released = make(chan struct{})
+}
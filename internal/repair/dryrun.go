@@ -0,0 +1,101 @@
+// Copyright 2021 The Swarm Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package repair
+
+import (
+	"context"
+	"errors"
+	"sync"
+
+	cmdfile "github.com/ethersphere/bee-repair/pkg/file"
+	"github.com/ethersphere/bee/pkg/storage"
+	"github.com/ethersphere/bee/pkg/swarm"
+)
+
+// DryRunResult is the outcome of a repair which stored nothing
+type DryRunResult struct {
+	// Reference is the new reference the repair would store
+	Reference swarm.Address
+	// Chunks is the number of chunks the repair would write, each of which needs a
+	// postage stamp. The chunks of the files exist already and are not counted
+	Chunks int
+	// Bytes is the size of the data of the chunks, spans included
+	Bytes int64
+}
+
+// DryRun takes in an older file or directory reference and repairs it without
+// storing anything: the new chunks are held in memory, so that the new manifest
+// can still be read back while it is built, and counted. The old content is read
+// from the configured store as by FileRepair and DirectoryRepair. Files skipped
+// with WithSkipErrors are reported with a SkippedError along with the result
+func DryRun(ctx context.Context, addr swarm.Address, directory bool, opts ...Option) (*DryRunResult, error) {
+	s := &dryRunStore{chunks: make(map[string]swarm.Chunk)}
+	opts = append(opts, func(c *Repairer) {
+		c.dryRun = s
+		// nothing was stored for the mapping to point to
+		c.mapping = nil
+	})
+
+	var (
+		ref swarm.Address
+		err error
+	)
+	if directory {
+		ref, err = DirectoryRepair(ctx, addr, opts...)
+	} else {
+		ref, err = FileRepair(ctx, addr, opts...)
+	}
+	var skipped *SkippedError
+	if err != nil && !errors.As(err, &skipped) {
+		return nil, err
+	}
+	chunks, size := s.count()
+	return &DryRunResult{Reference: ref, Chunks: chunks, Bytes: size}, err
+}
+
+// dryRunStore keeps the chunks put to it in memory and reads the others from the
+// store it wraps
+type dryRunStore struct {
+	cmdfile.PutGetter
+	mtx    sync.Mutex
+	chunks map[string]swarm.Chunk
+}
+
+func (s *dryRunStore) Put(_ context.Context, _ storage.ModePut, chs ...swarm.Chunk) ([]bool, error) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	exist := make([]bool, len(chs))
+	for i, ch := range chs {
+		if _, found := s.chunks[ch.Address().String()]; found {
+			exist[i] = true
+			continue
+		}
+		s.chunks[ch.Address().String()] = ch
+	}
+	return exist, nil
+}
+
+func (s *dryRunStore) Get(ctx context.Context, mode storage.ModeGet, addr swarm.Address) (swarm.Chunk, error) {
+	s.mtx.Lock()
+	ch, found := s.chunks[addr.String()]
+	s.mtx.Unlock()
+	if found {
+		return ch, nil
+	}
+	return s.PutGetter.Get(ctx, mode, addr)
+}
+
+// count returns the number of chunks put and the size of their data
+func (s *dryRunStore) count() (int, int64) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	var size int64
+	for _, ch := range s.chunks {
+		size += int64(len(ch.Data()))
+	}
+	return len(s.chunks), size
+}
@@ -0,0 +1,60 @@
+// Copyright 2021 The Swarm Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package repair
+
+import (
+	"github.com/ethersphere/bee/pkg/swarm"
+)
+
+// maxCachedEntries bounds the number of old entries a walk holds to reuse them for
+// the files at several paths
+const maxCachedEntries = 1024
+
+// entryCache holds the old entries read by a walk, so that a file at several paths
+// is read once while its entry is held. It is emptied when it is full, and the
+// metadata of the entries held is taken from the byte budget, if any, so that an
+// entry which does not fit is not held
+type entryCache struct {
+	entries map[string]*fileEntry
+	budget  *byteBudget
+	held    int64
+}
+
+func newEntryCache(budget *byteBudget) *entryCache {
+	return &entryCache{
+		entries: make(map[string]*fileEntry),
+		budget:  budget,
+	}
+}
+
+// get returns the entry held for the reference
+func (c *entryCache) get(ref swarm.Address) (*fileEntry, bool) {
+	f, ok := c.entries[ref.String()]
+	return f, ok
+}
+
+// add holds the entry of the reference, dropping the ones held before when the
+// cache is full
+func (c *entryCache) add(ref swarm.Address, f *fileEntry) {
+	if len(c.entries) >= maxCachedEntries {
+		c.reset()
+	}
+	if c.budget != nil {
+		if f.mtdtSize > c.budget.size || !c.budget.tryAcquire(f.mtdtSize) {
+			return
+		}
+		c.held += f.mtdtSize
+	}
+	c.entries[ref.String()] = f
+}
+
+// reset drops the entries held and returns their bytes to the budget
+func (c *entryCache) reset() {
+	if c.budget != nil && c.held > 0 {
+		c.budget.release(c.held)
+	}
+	c.held = 0
+	c.entries = make(map[string]*fileEntry)
+}
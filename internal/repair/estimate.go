@@ -0,0 +1,49 @@
+// Copyright 2021 The Swarm Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package repair
+
+import (
+	"context"
+
+	"github.com/ethersphere/bee/pkg/manifest/mantaray"
+	"github.com/ethersphere/bee/pkg/swarm"
+)
+
+// fileManifestChunks is the number of nodes of the manifest of a repaired file:
+// the root and the nodes of the root path and of the filename
+const fileManifestChunks = 3
+
+// EstimateManifestChunks takes in an older file or directory reference and returns
+// the number of chunks the repair is expected to store, without storing anything.
+// Only the manifest is new, the file chunks exist already. The manifest of a
+// directory has about as many nodes as the old one, which are counted
+func EstimateManifestChunks(ctx context.Context, addr swarm.Address, directory bool, opts ...Option) (int, error) {
+	if !directory {
+		return fileManifestChunks, nil
+	}
+	r, err := newWithOptions(opts...)
+	if err != nil {
+		return 0, err
+	}
+	defer r.close()
+
+	node, err := r.getOldManifest(ctx, addr)
+	if err != nil {
+		return 0, err
+	}
+	// the root entry of the new manifest is one more node
+	count := 1
+	err = node.WalkNode(ctx, []byte{}, r.ls, func(_ []byte, _ *mantaray.Node, err error) error {
+		if err != nil {
+			return err
+		}
+		count++
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return count, nil
+}
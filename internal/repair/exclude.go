@@ -0,0 +1,66 @@
+// Copyright 2021 The Swarm Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package repair
+
+import (
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/ethersphere/bee/pkg/manifest"
+)
+
+// WithExcludeGlobs is used to leave the files of old directories which match any of
+// the glob patterns out of the new manifest, e.g. .DS_Store or *.tmp. The patterns
+// have the syntax of path.Match. A pattern without a slash is matched against every
+// element of the path, so that it excludes the matching files, and the directories
+// with all their files, at any depth. A pattern with a slash is matched against the
+// path from the root and its parent directories. The excluded files are not read
+func WithExcludeGlobs(globs []string) Option {
+	return func(c *Repairer) {
+		c.excludeGlobs = globs
+	}
+}
+
+// checkExcludeGlobs returns an error for the first malformed pattern
+func checkExcludeGlobs(globs []string) error {
+	for _, g := range globs {
+		if _, err := path.Match(g, g); err != nil {
+			return fmt.Errorf("invalid exclude pattern %q: %w", g, err)
+		}
+	}
+	return nil
+}
+
+// excluded reports whether the file or directory at p matches an exclude pattern
+func (r *Repairer) excluded(p string) bool {
+	if len(r.excludeGlobs) == 0 {
+		return false
+	}
+	elems := strings.Split(strings.Trim(p, manifest.RootPath), manifest.RootPath)
+	for _, g := range r.excludeGlobs {
+		if !strings.Contains(g, manifest.RootPath) {
+			for _, e := range elems {
+				if ok, _ := path.Match(g, e); ok {
+					return true
+				}
+			}
+			continue
+		}
+		g = strings.Trim(g, manifest.RootPath)
+		for i := range elems {
+			if ok, _ := path.Match(g, strings.Join(elems[:i+1], manifest.RootPath)); ok {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// included reports whether the file at path is repaired, i.e. it is within the
+// path prefix and not excluded
+func (r *Repairer) included(path []byte) bool {
+	return r.selected(path) && !r.excluded(string(path))
+}
@@ -5,11 +5,23 @@
 package repair
 
 import (
-	"github.com/ethersphere/bee/pkg/storage"
+	"io/ioutil"
+
+	"github.com/ethersphere/bee/pkg/logging"
+	"github.com/ethersphere/bee/pkg/manifest"
 )
 
-func WithMockStore(st storage.Storer) Option {
-	return func(r *Repairer) {
-		r.store = st
-	}
+var NewManifestAdder = newManifestAdder
+
+func NewDirectoryAdder(m manifest.Interface, flushInterval int) *manifestAdder {
+	return newDirectoryAdder(m, nil, flushInterval, logging.New(ioutil.Discard, 0))
+}
+
+// BudgetUsed returns the bytes taken of the budget set by the option
+func BudgetUsed(o Option) int64 {
+	r := &Repairer{}
+	o(r)
+	r.budget.mtx.Lock()
+	defer r.budget.mtx.Unlock()
+	return r.budget.used
 }
@@ -0,0 +1,98 @@
+// Copyright 2021 The Swarm Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package repair
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/ethersphere/bee/pkg/manifest"
+	"github.com/ethersphere/bee/pkg/swarm"
+)
+
+// ErrDuplicateFilename is returned by FilesRepair when two of the old file
+// references have the same filename
+var ErrDuplicateFilename = errors.New("duplicate filename")
+
+// FilesRepair takes in older file references and creates a single new manifest
+// which contains all of the files, each at its filename, e.g. for the files of a
+// website which were uploaded one by one. The index document is the filename of the
+// first reference unless it is set with WithIndexDocument. The manifest is encrypted
+// when any of the file references is encrypted
+func FilesRepair(ctx context.Context, addrs []swarm.Address, opts ...Option) (swarm.Address, error) {
+	if len(addrs) == 0 {
+		return swarm.ZeroAddress, errors.New("no file references")
+	}
+	r, err := newWithOptions(opts...)
+	if err != nil {
+		return swarm.ZeroAddress, err
+	}
+	defer r.close()
+	r.logger.Infof("Repairing %d file references into one manifest", len(addrs))
+
+	files := make([]*fileEntry, 0, len(addrs))
+	byName := make(map[string]swarm.Address, len(addrs))
+	encryptedRef := swarm.ZeroAddress
+	for _, addr := range addrs {
+		f, err := r.getOldFileEntryWithTimeout(ctx, addr)
+		if err != nil {
+			return swarm.ZeroAddress, fmt.Errorf("file reference %s: %w", addr, err)
+		}
+		if other, found := byName[f.mtdt.Filename]; found {
+			return swarm.ZeroAddress, fmt.Errorf("%w %s of file references %s and %s", ErrDuplicateFilename, f.mtdt.Filename, other, addr)
+		}
+		byName[f.mtdt.Filename] = addr
+		if isEncrypted(f.e.Reference()) {
+			encryptedRef = f.e.Reference()
+		}
+		files = append(files, f)
+	}
+
+	ls, encrypt := r.manifestLoadSaver(encryptedRef)
+	m, err := manifest.NewDefaultManifest(ls, encrypt)
+	if err != nil {
+		return swarm.ZeroAddress, err
+	}
+
+	var rootMtdt map[string]string
+	if !r.noWebsite {
+		rootMtdt = map[string]string{
+			manifest.WebsiteIndexDocumentSuffixKey: files[0].mtdt.Filename,
+		}
+	}
+	err = m.Add(ctx, manifest.RootPath, manifest.NewEntry(swarm.ZeroAddress, r.rootMetadata(rootMtdt)))
+	if err != nil {
+		return swarm.ZeroAddress, err
+	}
+
+	// added in filename order, so that the same files result in the same reference
+	sorted := append([]*fileEntry(nil), files...)
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].mtdt.Filename < sorted[j].mtdt.Filename
+	})
+	for _, f := range sorted {
+		r.events.FileStarted(f.mtdt.Filename, f.mtdt.Filename)
+		err := m.Add(ctx, f.mtdt.Filename, manifest.NewEntry(f.e.Reference(), r.entryMetadata(f)))
+		if err != nil {
+			return swarm.ZeroAddress, fmt.Errorf("file %s: %w", f.mtdt.Filename, err)
+		}
+		r.events.FileCompleted(f.mtdt.Filename, f.e.Reference())
+	}
+
+	newReference, err := m.Store(ctx)
+	if err != nil {
+		return swarm.ZeroAddress, err
+	}
+	if err := r.verifyReference(ctx, newReference); err != nil {
+		return swarm.ZeroAddress, err
+	}
+
+	r.logger.Infof("Created new manifest of %d files with reference %s", len(files), newReference)
+	r.events.RepairCompleted(newReference)
+
+	return newReference, nil
+}
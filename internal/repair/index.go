@@ -0,0 +1,152 @@
+// Copyright 2021 The Swarm Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package repair
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/ethersphere/bee/pkg/manifest"
+	"github.com/ethersphere/bee/pkg/manifest/mantaray"
+	"github.com/ethersphere/bee/pkg/swarm"
+)
+
+// Index is a manifest whose entries point to whole files or directories, e.g. the
+// sites of a deployment, rather than to the files of a single directory
+type Index struct {
+	Reference    swarm.Address
+	RootMetadata map[string]string
+	Entries      []*IndexEntry
+}
+
+// IndexEntry is an entry of an index manifest
+type IndexEntry struct {
+	Path      string
+	Reference swarm.Address
+	Metadata  map[string]string
+	// OldFormat is set when the reference is a file or directory entry of the old
+	// format, which is repaired. The other entries, e.g. feeds or content of the
+	// new format, are carried over as they are
+	OldFormat bool
+	Directory bool
+	// NewReference is the repaired reference, set by the caller before WriteIndex
+	NewReference swarm.Address
+	// Err is set when the entry cannot be read and WithSkipErrors is used, such
+	// entries are left out of the new index
+	Err error
+}
+
+// ReadIndex takes in an index manifest, of the new format or a directory of the old
+// one, and returns its entries in path order, telling apart the files and the
+// directories of the old format which need a repair. Nothing is stored
+func ReadIndex(ctx context.Context, addr swarm.Address, opts ...Option) (*Index, error) {
+	r, err := newWithOptions(opts...)
+	if err != nil {
+		return nil, err
+	}
+	defer r.close()
+	r.readOnly = true
+
+	node, err := r.getOldManifest(ctx, addr)
+	if errors.Is(err, ErrNotOldFormat) {
+		r.logger.Debugf("Reading index %s as a manifest of the new format", addr)
+		node = mantaray.NewNodeRef(addr.Bytes())
+	} else if err != nil {
+		return nil, err
+	}
+
+	idx := &Index{Reference: addr}
+	err = node.WalkNode(ctx, []byte{}, r.ls, func(path []byte, n *mantaray.Node, err error) error {
+		if err != nil {
+			return err
+		}
+		if !isValueNode(n) {
+			return nil
+		}
+		if string(path) == manifest.RootPath {
+			idx.RootMetadata = n.Metadata()
+			return nil
+		}
+		ref := swarm.NewAddress(n.Entry())
+		if isZeroReference(ref) {
+			return nil
+		}
+		e := &IndexEntry{Path: string(path), Reference: ref, Metadata: n.Metadata()}
+		idx.Entries = append(idx.Entries, e)
+		if isFeedMetadata(e.Metadata) {
+			return nil
+		}
+
+		f, err := r.getOldFileEntry(ctx, ref)
+		switch {
+		case errors.Is(err, ErrNotOldFormat):
+			r.logger.Debugf("Carrying over index entry %s, which is not of the old format", path)
+		case err != nil && r.skipErrors:
+			e.Err = err
+		case err != nil:
+			return &FileError{Path: e.Path, Err: err}
+		default:
+			e.OldFormat = true
+			e.Directory = f.mtdt.MimeType == manifest.ManifestMantarayContentType
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(idx.Entries, func(i, j int) bool { return idx.Entries[i].Path < idx.Entries[j].Path })
+	return idx, nil
+}
+
+// WriteIndex stores the new index manifest, with the new references of the old
+// entries and the other entries as they are, each at its path and with its
+// metadata. The entries which failed to be read are left out and every old entry
+// must have a new reference
+func WriteIndex(ctx context.Context, idx *Index, opts ...Option) (swarm.Address, error) {
+	r, err := newWithOptions(opts...)
+	if err != nil {
+		return swarm.ZeroAddress, err
+	}
+	defer r.close()
+
+	m, err := r.newManifest(ctx, idx.Reference)
+	if err != nil {
+		return swarm.ZeroAddress, err
+	}
+	err = m.Add(ctx, manifest.RootPath, manifest.NewEntry(swarm.ZeroAddress, r.rootMetadata(idx.RootMetadata)))
+	if err != nil {
+		return swarm.ZeroAddress, err
+	}
+	for _, e := range idx.Entries {
+		if e.Err != nil {
+			continue
+		}
+		ref := e.Reference
+		if e.OldFormat {
+			if e.NewReference.Equal(swarm.ZeroAddress) {
+				return swarm.ZeroAddress, fmt.Errorf("index entry %s was not repaired", e.Path)
+			}
+			ref = e.NewReference
+		}
+		if err := m.Add(ctx, e.Path, manifest.NewEntry(ref, e.Metadata)); err != nil {
+			return swarm.ZeroAddress, err
+		}
+	}
+
+	newReference, err := m.Store(ctx)
+	if err != nil {
+		return swarm.ZeroAddress, err
+	}
+	if err := r.verifyReference(ctx, newReference); err != nil {
+		return swarm.ZeroAddress, err
+	}
+	r.logger.Infof("Created new index manifest with reference %s", newReference)
+	if err := r.recordMapping(idx.Reference, newReference); err != nil {
+		return swarm.ZeroAddress, err
+	}
+	return newReference, nil
+}
@@ -0,0 +1,63 @@
+// Copyright 2021 The Swarm Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package repair
+
+import (
+	"bytes"
+	"context"
+
+	"github.com/ethersphere/bee/pkg/file"
+	"github.com/ethersphere/bee/pkg/file/joiner"
+	"github.com/ethersphere/bee/pkg/swarm"
+)
+
+// Inspection is the old entry of a file reference with its decoded metadata
+type Inspection struct {
+	// Reference is the reference of the file content
+	Reference swarm.Address
+	// MetadataReference is the reference of the json encoded metadata
+	MetadataReference swarm.Address
+	Filename          string
+	MimeType          string
+	Size              int64
+	// RawMetadata is the metadata as it is stored, with the fields which are not
+	// decoded by the repair
+	RawMetadata []byte
+}
+
+// Inspect takes in an older file reference and returns its entry and metadata as
+// read by the repair, without storing anything. A missing filename is reported as
+// the one the repair names the file with
+func Inspect(ctx context.Context, addr swarm.Address, opts ...Option) (*Inspection, error) {
+	r, err := newWithOptions(opts...)
+	if err != nil {
+		return nil, err
+	}
+	defer r.close()
+	r.readOnly = true
+
+	f, err := r.getOldFileEntry(ctx, addr)
+	if err != nil {
+		return nil, err
+	}
+
+	j, _, err := joiner.New(ctx, r.store, f.e.Metadata())
+	if err != nil {
+		return nil, err
+	}
+	buf := bytes.NewBuffer(nil)
+	if _, err := file.JoinReadAll(ctx, j, buf); err != nil {
+		return nil, err
+	}
+
+	return &Inspection{
+		Reference:         f.e.Reference(),
+		MetadataReference: f.e.Metadata(),
+		Filename:          f.mtdt.Filename,
+		MimeType:          f.mtdt.MimeType,
+		Size:              f.mtdt.Size,
+		RawMetadata:       buf.Bytes(),
+	}, nil
+}
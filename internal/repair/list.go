@@ -0,0 +1,53 @@
+// Copyright 2021 The Swarm Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package repair
+
+import (
+	"context"
+
+	"github.com/ethersphere/bee/pkg/swarm"
+)
+
+// ListEntry describes a file of a directory in the old format
+type ListEntry struct {
+	Path string
+	// Reference is the reference of the file content, which the repaired entry
+	// points to as well
+	Reference   swarm.Address
+	ContentType string
+	Size        int64
+}
+
+// List takes in an older directory reference and returns its files with their
+// reference, content type and size, without storing anything. Feed entries are listed with
+// no content type and zero size
+func List(ctx context.Context, addr swarm.Address, opts ...Option) ([]*ListEntry, error) {
+	r, err := newWithOptions(opts...)
+	if err != nil {
+		return nil, err
+	}
+	defer r.close()
+
+	var entries []*ListEntry
+	err = r.walkOldDirectory(ctx, addr, func(f *OldFile) error {
+		if f.Err != nil {
+			return &FileError{Path: f.Path, Err: f.Err}
+		}
+		entries = append(entries, &ListEntry{
+			Path:        f.Path,
+			Reference:   f.Reference,
+			ContentType: f.MimeType,
+			Size:        f.Size,
+		})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	r.logger.Debugf("Listed %d files of directory reference %s", len(entries), addr)
+
+	return entries, nil
+}
@@ -0,0 +1,119 @@
+// Copyright 2021 The Swarm Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package repair
+
+import (
+	"context"
+	"encoding/binary"
+
+	"github.com/ethersphere/bee-repair/internal/collection/entry"
+	"github.com/ethersphere/bee/pkg/manifest"
+	"github.com/ethersphere/bee/pkg/storage"
+	"github.com/ethersphere/bee/pkg/swarm"
+)
+
+// WithSourceStore is used to read the old entries from the getter, e.g. a local
+// database, while the new manifests are still stored in the configured store
+func WithSourceStore(g storage.Getter) Option {
+	return func(c *Repairer) {
+		c.source = g
+	}
+}
+
+// sourceStore reads the chunks from the source and writes them to the store
+type sourceStore struct {
+	storage.Getter
+	storage.Putter
+}
+
+// Root is a top level reference of the old format, which is not part of any other
+// directory
+type Root struct {
+	Reference swarm.Address
+	Directory bool
+}
+
+// IsEntryChunk reports whether the chunk could hold a collection entry of the old
+// format, which is where every old file and directory reference points to
+func IsEntryChunk(ch swarm.Chunk) bool {
+	data := ch.Data()
+	if len(data) < swarm.SpanSize {
+		return false
+	}
+	span := binary.LittleEndian.Uint64(data[:swarm.SpanSize])
+	return span == uint64(len(data)-swarm.SpanSize) && entry.CanUnmarshal(int64(span))
+}
+
+// FindRoots reads the candidate entries and returns the file and directory references
+// among them which are not part of a directory. Candidates which cannot be read as
+// entries of the old format are ignored
+func FindRoots(ctx context.Context, candidates []swarm.Address, opts ...Option) ([]*Root, error) {
+	r, err := newWithOptions(opts...)
+	if err != nil {
+		return nil, err
+	}
+	defer r.close()
+
+	var dirs, files []swarm.Address
+	inDirectory := make(map[string]struct{})
+
+	for _, addr := range candidates {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		e, err := r.getOldFileEntry(ctx, addr)
+		if err != nil {
+			r.logger.Debugf("Ignoring candidate %s: %v", addr, err)
+			continue
+		}
+		if e.mtdt.MimeType != manifest.ManifestMantarayContentType {
+			files = append(files, addr)
+			continue
+		}
+
+		node, err := r.getOldManifest(ctx, addr)
+		if err != nil {
+			r.logger.Debugf("Ignoring candidate %s: %v", addr, err)
+			continue
+		}
+		var dirFiles []string
+		err = walkFiles(ctx, node, r.ls, func(path []byte, isDir bool, err error) error {
+			if err != nil {
+				return err
+			}
+			if !isDir {
+				fnode, err := node.LookupNode(ctx, path, r.ls)
+				if err != nil {
+					return err
+				}
+				dirFiles = append(dirFiles, swarm.NewAddress(fnode.Entry()).String())
+			}
+			return nil
+		})
+		if err != nil {
+			r.logger.Debugf("Ignoring candidate %s: %v", addr, err)
+			continue
+		}
+		for _, f := range dirFiles {
+			inDirectory[f] = struct{}{}
+		}
+		dirs = append(dirs, addr)
+	}
+
+	roots := make([]*Root, 0, len(dirs)+len(files))
+	for _, addr := range dirs {
+		roots = append(roots, &Root{Reference: addr, Directory: true})
+	}
+	for _, addr := range files {
+		if _, found := inDirectory[addr.String()]; !found {
+			roots = append(roots, &Root{Reference: addr})
+		}
+	}
+
+	r.logger.Debugf("Found %d root references among %d candidates", len(roots), len(candidates))
+
+	return roots, nil
+}
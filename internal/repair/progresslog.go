@@ -0,0 +1,77 @@
+// Copyright 2021 The Swarm Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package repair
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"time"
+)
+
+// LogFileMode selects what happens to an existing progress log file
+type LogFileMode int
+
+const (
+	// LogAppend adds the messages to the end of the existing file
+	LogAppend LogFileMode = iota
+	// LogTruncate replaces the content of the existing file
+	LogTruncate
+	// LogRotate renames the existing file by appending ".1" to its name, replacing
+	// the one rotated before, and starts a new file
+	LogRotate
+)
+
+// FileLogUpdater is a SyncUpdater which writes every message to a file, prefixed by
+// the RFC3339 time it was written at, e.g. to keep a record of long unattended
+// repairs
+type FileLogUpdater struct {
+	*SyncUpdater
+	f *os.File
+}
+
+// NewFileLogUpdater opens the log file at path as the mode says. The file must be
+// closed once the repairs are done
+func NewFileLogUpdater(path string, mode LogFileMode) (*FileLogUpdater, error) {
+	flag := os.O_CREATE | os.O_WRONLY | os.O_APPEND
+	switch mode {
+	case LogAppend:
+	case LogTruncate:
+		flag |= os.O_TRUNC
+	case LogRotate:
+		if err := os.Rename(path, path+".1"); err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("unknown log file mode %d", mode)
+	}
+	f, err := os.OpenFile(path, flag, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return &FileLogUpdater{
+		SyncUpdater: NewSyncUpdater(&timestampWriter{w: f, now: time.Now}),
+		f:           f,
+	}, nil
+}
+
+// Close closes the log file
+func (u *FileLogUpdater) Close() error {
+	return u.f.Close()
+}
+
+// timestampWriter prefixes every write with the current time. The SyncUpdater
+// writes every message at once, so every line gets its timestamp
+type timestampWriter struct {
+	w   io.Writer
+	now func() time.Time
+}
+
+func (t *timestampWriter) Write(p []byte) (int, error) {
+	if _, err := fmt.Fprintf(t.w, "%s %s", t.now().Format(time.RFC3339), p); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
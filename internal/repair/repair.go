@@ -8,9 +8,13 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"github.com/ethersphere/bee-repair/internal/collection/entry"
+	"github.com/ethersphere/bee-repair/internal/exporter"
 	cmdfile "github.com/ethersphere/bee-repair/pkg/file"
+	"github.com/ethersphere/bee/pkg/cac"
+	"github.com/ethersphere/bee/pkg/encryption"
 	"github.com/ethersphere/bee/pkg/file"
 	"github.com/ethersphere/bee/pkg/file/joiner"
 	"github.com/ethersphere/bee/pkg/file/loadsave"
@@ -18,12 +22,25 @@ import (
 	"github.com/ethersphere/bee/pkg/manifest"
 	"github.com/ethersphere/bee/pkg/manifest/mantaray"
 	"github.com/ethersphere/bee/pkg/storage"
+	"github.com/ethersphere/bee/pkg/storage/mock"
 	"github.com/ethersphere/bee/pkg/swarm"
+	"io"
 	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
 )
 
 const (
-	limitMetadataLength = swarm.ChunkSize
+	// metadata keys of the feed entries, as set by the bee api
+	feedMetadataEntryOwner = "swarm-feed-owner"
+	feedMetadataEntryTopic = "swarm-feed-topic"
+
+	// default limit of the size of the old entries and their metadata, which
+	// allows for metadata spanning multiple chunks
+	defaultMetadataLimit = 16 * swarm.ChunkSize
 )
 
 // ProgressUpdater is and interface which can be implemented by client to recieve
@@ -32,14 +49,84 @@ type ProgressUpdater interface {
 	Update(string)
 }
 
+// EventUpdater is an interface which can be implemented by client to recieve
+// structured updates from the utility. When supplied it is used instead of the
+// ProgressUpdater messages
+type EventUpdater interface {
+	// FileStarted is called before the file at path is added to the new manifest
+	FileStarted(path, filename string)
+	// FileCompleted is called once the file at path was added to the new manifest
+	FileCompleted(path string, reference swarm.Address)
+	// FileSkipped is called when the file at path could not be repaired and was
+	// left out of the new manifest
+	FileSkipped(path string, err error)
+	// RepairCompleted is called with the reference of the stored new manifest
+	RepairCompleted(reference swarm.Address)
+}
+
+// CountingProgressUpdater is an interface which can be implemented by client to
+// recieve numeric progress updates from the utility, in the same way the exporter
+// reports them
+type CountingProgressUpdater interface {
+	Update(current, total int)
+}
+
+// TotalUpdater can be implemented by the ProgressUpdater or the EventUpdater to
+// learn the number of files of a directory before the first of them is repaired
+type TotalUpdater interface {
+	Total(files int)
+}
+
+// MappingRecorder can be implemented by client to record which new reference
+// every repaired old reference maps to, e.g. in a database
+type MappingRecorder interface {
+	Put(oldRef, newRef swarm.Address) error
+}
+
 // Option is used to supply functional options for the repairer utility
 type Option func(*Repairer)
 
 // WithAPIStore is used to configure the API endpoint for running the utility. This
 // could be locally running bee node or some gateway
-func WithAPIStore(host string, port int, useSSL bool) Option {
+func WithAPIStore(host string, port int, useSSL bool, opts ...cmdfile.APIStoreOption) Option {
+	return func(c *Repairer) {
+		c.store = cmdfile.NewAPIStore(host, port, useSSL, opts...)
+	}
+}
+
+// WithStore is used to supply the store the old entries are read from and the new
+// manifests are written to, instead of the API of a bee node. Any storage.Storer
+// can be used, which allows to repair content without a running node
+func WithStore(st cmdfile.PutGetter) Option {
+	return func(c *Repairer) {
+		c.store = st
+	}
+}
+
+// NewMemoryStore returns an empty store held in memory. The old entries can be put
+// into it, e.g. in tests or for offline validation, and it can be read from after
+// the repair to check the new manifests
+func NewMemoryStore() storage.Storer {
+	return mock.NewStorer()
+}
+
+// WithMemoryStore is used to repair the content of a store held in memory instead of
+// the one of a bee node, see NewMemoryStore. A nil store is replaced by an empty one
+func WithMemoryStore(st storage.Storer) Option {
+	if st == nil {
+		st = NewMemoryStore()
+	}
+	return WithStore(st)
+}
+
+// WithLocalStore is used to read the old entries from the database of a bee node
+// at dbPath, e.g. of a node which does not start on the new version, and to write
+// the new manifests to it instead of going through the API. The database is opened
+// by each repair and closed when it returns, so the node must not be running. The
+// new chunks can then be moved to the new node by exporting the database
+func WithLocalStore(dbPath string) Option {
 	return func(c *Repairer) {
-		c.store = cmdfile.NewAPIStore(host, port, useSSL)
+		c.localPath = dbPath
 	}
 }
 
@@ -64,6 +151,27 @@ func WithPin(val bool) Option {
 	}
 }
 
+// WithPinManifestOnly is used to pin only the chunks of the new manifests. The
+// content of the files is not pinned by the repair either way, as it is already
+// stored, apart from the chunk of empty content the empty files of the old format
+// are pointed to, which is left unpinned with this option
+func WithPinManifestOnly(val bool) Option {
+	return func(c *Repairer) {
+		c.pinMfstOnly = val
+	}
+}
+
+// WithPutMode is used to select the mode the repaired chunks are stored with, e.g.
+// storage.ModePutRequest to add them to the cache of a gateway instead of
+// uploading them. It overrides storage.ModePutUpload and storage.ModePutUploadPin,
+// which are chosen with WithPin otherwise. The api store only pins the chunks
+// stored with storage.ModePutUploadPin and does not tell the other modes apart
+func WithPutMode(mode storage.ModePut) Option {
+	return func(c *Repairer) {
+		c.putMode = &mode
+	}
+}
+
 // WithProgressUpdater is used to provide updater implementation to see updates
 // from utility
 func WithProgressUpdater(upd ProgressUpdater) Option {
@@ -72,6 +180,250 @@ func WithProgressUpdater(upd ProgressUpdater) Option {
 	}
 }
 
+// WithEventUpdater is used to provide updater implementation to see structured
+// updates from utility
+func WithEventUpdater(upd EventUpdater) Option {
+	return func(c *Repairer) {
+		c.events = upd
+	}
+}
+
+// WithCountingProgressUpdater is used to provide updater implementation to see
+// numeric progress of the utility. The files are counted up front, so this makes
+// the directory repair walk the old manifest twice
+func WithCountingProgressUpdater(upd CountingProgressUpdater) Option {
+	return func(c *Repairer) {
+		c.counter = upd
+	}
+}
+
+// WithPerFileTimeout is used to bound the time spent reading each file of a
+// directory. A file which is not read in time fails the directory repair
+func WithPerFileTimeout(d time.Duration) Option {
+	return func(c *Repairer) {
+		c.fileTimeout = d
+	}
+}
+
+// WithSkipErrors is used to leave out the files of a directory which cannot be read
+// instead of failing the whole directory repair
+func WithSkipErrors(val bool) Option {
+	return func(c *Repairer) {
+		c.skipErrors = val
+	}
+}
+
+// WithFileSizes is used to read the length of every old file from the root chunk
+// of its content along with its entry, at the cost of one more retrieval per file,
+// so that it can be reported, e.g. by WalkOldDirectory
+func WithFileSizes(val bool) Option {
+	return func(c *Repairer) {
+		c.fileSizes = val
+	}
+}
+
+// WithVerifyReference is used to read back the root chunk of every new manifest once
+// it is stored and to check that its content address is the returned reference,
+// which catches stores which lose or corrupt the chunks before the reference is
+// relied upon. A mismatch fails the repair with ErrReferenceMismatch
+func WithVerifyReference(val bool) Option {
+	return func(c *Repairer) {
+		c.verifyRef = val
+	}
+}
+
+// WithValidateSize is used to read the whole content of every file of a directory
+// and compare its length to the size recorded in the old metadata, if any, and to
+// the span of the file. A mismatch, e.g. because of lost chunks, is treated as a
+// file which cannot be read, so it fails the repair unless WithSkipErrors is set
+func WithValidateSize(val bool) Option {
+	return func(c *Repairer) {
+		c.validateSize = val
+	}
+}
+
+// WithMaxDepth is used to bound how many levels of directories the walk of an old
+// manifest descends, e.g. 1 for only the files at the top level. A deeper file
+// fails the repair with ErrMaxDepth, or with WithSkipErrors it is left out without
+// being read, which migrates just the shallower content. Zero means no limit
+func WithMaxDepth(n int) Option {
+	return func(c *Repairer) {
+		c.maxDepth = n
+	}
+}
+
+// WithPathPrefix is used to repair only the files of a directory whose path starts
+// with the prefix, e.g. "assets/", so that the new manifest holds only them. Huge
+// directories can then be migrated a part at a time
+func WithPathPrefix(prefix string) Option {
+	return func(c *Repairer) {
+		c.pathPrefix = strings.TrimPrefix(prefix, manifest.RootPath)
+	}
+}
+
+// WithBaseManifest is used to add the files of the old directory to an existing
+// manifest of the new format, e.g. one repaired before with WithPathPrefix, instead
+// of to an empty one. The returned reference is the one of the merged manifest,
+// where the repaired files and the root metadata replace the ones of the base. The
+// entries of the base are copied to the new manifest, so they are held in memory
+// while it is built
+func WithBaseManifest(ref swarm.Address) Option {
+	return func(c *Repairer) {
+		c.baseManifest = ref
+	}
+}
+
+// WithStrictMetadata is used to fail the repair of old entries whose metadata has no
+// filename with ErrInvalidMetadata, as corrupt entries do. By default such entries
+// are named after the reference of their content
+func WithStrictMetadata(val bool) Option {
+	return func(c *Repairer) {
+		c.strictMetadata = val
+	}
+}
+
+// WithWebsiteMode is used to choose whether the manifest of a repaired file is a
+// website, which it is by default. A website manifest has the file as its index
+// document, so that it is served at the root of the manifest as well as at its
+// path. Without website mode the file is only served at its path, i.e. its name.
+// An index document set with WithIndexDocument is still added. Directories keep
+// the root metadata of the old manifest either way
+func WithWebsiteMode(val bool) Option {
+	return func(c *Repairer) {
+		c.noWebsite = !val
+	}
+}
+
+// WithIndexDocument is used to set the index document of the new manifest instead
+// of the one of the old entry
+func WithIndexDocument(name string) Option {
+	return func(c *Repairer) {
+		c.indexDocument = name
+	}
+}
+
+// WithErrorDocument is used to set the error document of the new manifest instead
+// of the one of the old entry
+func WithErrorDocument(name string) Option {
+	return func(c *Repairer) {
+		c.errorDocument = name
+	}
+}
+
+// WithMetadataLimit is used to change the maximum size in bytes of the old entries
+// and their metadata which are read. Larger metadata fails the repair
+func WithMetadataLimit(limit int64) Option {
+	return func(c *Repairer) {
+		c.metadataLimit = limit
+	}
+}
+
+// WithDirectoryMetadata is used to carry over the metadata of the directories of
+// the old manifest, e.g. per directory error documents, and not only the one of
+// the root
+func WithDirectoryMetadata(val bool) Option {
+	return func(c *Repairer) {
+		c.dirMetadata = val
+	}
+}
+
+// WithMappingRecorder is used to record the new reference of every repaired file
+// and directory reference. A failure to record it fails the repair
+func WithMappingRecorder(m MappingRecorder) Option {
+	return func(c *Repairer) {
+		c.mapping = m
+	}
+}
+
+// WithMimeOverrides is used to replace the content type of the files by their
+// extension, e.g. ".md" -> "text/markdown; charset=utf-8". The extensions are
+// matched case insensitively and the leading dot is optional
+func WithMimeOverrides(overrides map[string]string) Option {
+	return func(c *Repairer) {
+		c.mimeOverrides = make(map[string]string, len(overrides))
+		for ext, mime := range overrides {
+			ext = strings.ToLower(ext)
+			if !strings.HasPrefix(ext, ".") {
+				ext = "." + ext
+			}
+			c.mimeOverrides[ext] = mime
+		}
+	}
+}
+
+// FileError records the failure to repair the file at a path of a directory
+type FileError struct {
+	Path string
+	Err  error
+}
+
+func (e *FileError) Error() string {
+	return fmt.Sprintf("file %s: %v", e.Path, e.Err)
+}
+
+func (e *FileError) Unwrap() error {
+	return e.Err
+}
+
+var (
+	// ErrNotFound is returned when a chunk of the old entry is not present on the
+	// node
+	ErrNotFound = storage.ErrNotFound
+	// ErrNotOldFormat is returned when the reference does not point to an entry of
+	// the old format
+	ErrNotOldFormat = errors.New("not an entry of the old format")
+	// ErrUploadRejected is returned when the node does not accept the chunks of the
+	// new manifest
+	ErrUploadRejected = cmdfile.ErrUploadRejected
+	// ErrSizeMismatch is returned when the content of a file does not have the
+	// expected length
+	ErrSizeMismatch = errors.New("size mismatch")
+	// ErrInvalidMetadata is returned with WithStrictMetadata when the metadata of
+	// an old entry has no filename
+	ErrInvalidMetadata = errors.New("invalid metadata")
+	// ErrMaxDepth is returned when a file of a directory is nested deeper than the
+	// limit set with WithMaxDepth
+	ErrMaxDepth = errors.New("maximum depth exceeded")
+	// ErrReferenceMismatch is returned with WithVerifyReference when the root chunk
+	// of the stored manifest cannot be read back or does not match its reference
+	ErrReferenceMismatch = errors.New("reference mismatch")
+)
+
+// SkippedError is returned along with the new reference when some files of a
+// directory were skipped
+type SkippedError struct {
+	Files []*FileError
+}
+
+func (e *SkippedError) Error() string {
+	return fmt.Sprintf("skipped %d files", len(e.Files))
+}
+
+// Exists reports whether the root chunk of the reference can be retrieved from the
+// store. It is a cheap check to run before the repair of a reference which might
+// not be present on the node
+func Exists(ctx context.Context, addr swarm.Address, opts ...Option) (bool, error) {
+	r, err := newWithOptions(opts...)
+	if err != nil {
+		return false, err
+	}
+	defer r.close()
+
+	// the root chunk of an encrypted reference is addressed by the hash only
+	rootAddr := addr
+	if isEncrypted(addr) {
+		rootAddr = swarm.NewAddress(addr.Bytes()[:swarm.HashSize])
+	}
+	_, err = r.store.Get(ctx, storage.ModeGetRequest, rootAddr)
+	if errors.Is(err, storage.ErrNotFound) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
 // FileRepair takes in an older file reference and creates a new manifest which contains
 // the file and the metadata. This reference can be then used to query the /bzz endpoint to
 // serve the file
@@ -89,25 +441,81 @@ func WithProgressUpdater(upd ProgressUpdater) Option {
 //                                |-> File reference
 //
 func FileRepair(ctx context.Context, addr swarm.Address, opts ...Option) (swarm.Address, error) {
-	r := newWithOptions(opts...)
+	r, err := newWithOptions(opts...)
+	if err != nil {
+		return swarm.ZeroAddress, err
+	}
+	defer r.close()
+	r.logger.Infof("Repairing file reference %s", addr)
 
 	oldEntry, err := r.getOldFileEntry(ctx, addr)
 	if err != nil {
 		return swarm.ZeroAddress, err
 	}
 
-	r.updater.Update(fmt.Sprintf("Updating reference for file %s", oldEntry.mtdt.Filename))
+	newReference, err := r.repairFile(ctx, oldEntry)
+	if err != nil {
+		return swarm.ZeroAddress, err
+	}
+	if err := r.recordMapping(addr, newReference); err != nil {
+		return swarm.ZeroAddress, err
+	}
+	return newReference, nil
+}
 
-	newManifest, err := manifest.NewDefaultManifest(r.ls, false)
+// FileRepairInDirectory takes in an older directory reference and the path of a file
+// inside of it and creates a new manifest which contains only that file and its
+// metadata, in the same way FileRepair does for a standalone file reference
+func FileRepairInDirectory(ctx context.Context, addr swarm.Address, path string, opts ...Option) (swarm.Address, error) {
+	r, err := newWithOptions(opts...)
 	if err != nil {
 		return swarm.ZeroAddress, err
 	}
+	defer r.close()
+	r.logger.Infof("Repairing file %s of directory reference %s", path, addr)
 
+	node, err := r.getOldManifest(ctx, addr)
+	if err != nil {
+		return swarm.ZeroAddress, err
+	}
+
+	fnode, err := node.LookupNode(ctx, []byte(strings.TrimPrefix(path, "/")), r.ls)
+	if err != nil {
+		return swarm.ZeroAddress, fmt.Errorf("path %s: %w", path, err)
+	}
+	if !fnode.IsValueType() {
+		return swarm.ZeroAddress, fmt.Errorf("path %s is not a file", path)
+	}
+
+	oldEntry, err := r.getOldFileEntry(ctx, swarm.NewAddress(fnode.Entry()))
+	if err != nil {
+		return swarm.ZeroAddress, err
+	}
+
+	return r.repairFile(ctx, oldEntry)
+}
+
+// create the new manifest for a single file entry. If the old file reference is
+// encrypted the new manifest is encrypted as well
+func (r *Repairer) repairFile(ctx context.Context, oldEntry *fileEntry) (swarm.Address, error) {
+	r.events.FileStarted(oldEntry.mtdt.Filename, oldEntry.mtdt.Filename)
+
+	ls, encrypt := r.manifestLoadSaver(oldEntry.e.Reference())
+	m, err := manifest.NewDefaultManifest(ls, encrypt)
+	if err != nil {
+		return swarm.ZeroAddress, err
+	}
+	newManifest := newManifestAdder(m)
+
+	var rootMtdt map[string]string
+	if !r.noWebsite {
+		rootMtdt = map[string]string{
+			manifest.WebsiteIndexDocumentSuffixKey: oldEntry.mtdt.Filename,
+		}
+	}
 	err = newManifest.Add(ctx, manifest.RootPath, manifest.NewEntry(
 		swarm.ZeroAddress,
-		map[string]string{
-			manifest.WebsiteIndexDocumentSuffixKey: oldEntry.mtdt.Filename,
-		},
+		r.rootMetadata(rootMtdt),
 	))
 	if err != nil {
 		return swarm.ZeroAddress, err
@@ -116,21 +524,23 @@ func FileRepair(ctx context.Context, addr swarm.Address, opts ...Option) (swarm.
 	err = newManifest.Add(
 		ctx,
 		oldEntry.mtdt.Filename,
-		manifest.NewEntry(oldEntry.e.Reference(), map[string]string{
-			manifest.EntryMetadataFilenameKey:    oldEntry.mtdt.Filename,
-			manifest.EntryMetadataContentTypeKey: oldEntry.mtdt.MimeType,
-		}),
+		manifest.NewEntry(oldEntry.e.Reference(), r.entryMetadata(oldEntry)),
 	)
 	if err != nil {
 		return swarm.ZeroAddress, err
 	}
+	r.events.FileCompleted(oldEntry.mtdt.Filename, oldEntry.e.Reference())
 
 	newReference, err := newManifest.Store(ctx)
 	if err != nil {
 		return swarm.ZeroAddress, err
 	}
+	if err := r.verifyReference(ctx, newReference); err != nil {
+		return swarm.ZeroAddress, err
+	}
 
-	r.logger.Debugf("Created new file manifest with reference %s", newReference.String())
+	r.logger.Infof("Created new file manifest with reference %s", newReference.String())
+	r.events.RepairCompleted(newReference)
 
 	return newReference, nil
 }
@@ -139,6 +549,17 @@ func FileRepair(ctx context.Context, addr swarm.Address, opts ...Option) (swarm.
 // all the files and the metadata. This reference can be then used to query the /bzz endpoint to
 // serve the index document or /bzz/{reference}/{path} to query individual files
 //
+// With WithSkipErrors the files which fail to be read are left out of the new manifest. The new
+// reference is then returned together with a *SkippedError listing them. The files matching
+// WithExcludeGlobs are left out without being read, and their number is reported to the
+// ProgressUpdater once the new manifest is stored.
+//
+// The files are added to the new manifest in path order once all of them are read, so that
+// repairing the same directory again without encryption results in the same reference. With
+// encryption the manifest nodes are encrypted with random keys and the reference differs.
+// The paths are carried over byte for byte, including the ones of dotfiles and of
+// directories such as .well-known, which are neither cleaned nor left out.
+//
 // Old Entry:
 // mantaray manifest -> Root Node (/) -> Metadata (index file/error file)
 //                   |
@@ -153,13 +574,30 @@ func FileRepair(ctx context.Context, addr swarm.Address, opts ...Option) (swarm.
 //                                |-> File reference
 //
 func DirectoryRepair(ctx context.Context, addr swarm.Address, opts ...Option) (swarm.Address, error) {
-	r := newWithOptions(opts...)
+	r, err := newWithOptions(opts...)
+	if err != nil {
+		return swarm.ZeroAddress, err
+	}
+	defer r.close()
+	r.logger.Infof("Repairing directory reference %s", addr)
+
+	// stops the walk of the old files on an early return
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
 
 	dir, err := r.getOldDirectoryEntry(ctx, addr)
 	if err != nil {
 		return swarm.ZeroAddress, err
 	}
 
+	doneCount := 0
+	r.counter.Update(doneCount, dir.total)
+	if t, ok := r.events.(TotalUpdater); ok {
+		t.Total(dir.total)
+	}
+
+	var skipped []*FileError
+
 loop:
 	for {
 		select {
@@ -167,18 +605,27 @@ loop:
 			if !ok {
 				break loop
 			}
-			r.updater.Update(fmt.Sprintf("Updating reference for file %s", f.mtdt.Filename))
-			err := dir.m.Add(
-				ctx,
-				f.filepath,
-				manifest.NewEntry(f.e.Reference(), map[string]string{
-					manifest.EntryMetadataFilenameKey:    f.mtdt.Filename,
-					manifest.EntryMetadataContentTypeKey: f.mtdt.MimeType,
-				}),
-			)
+			if f.err != nil {
+				r.logger.Warningf("Skipping file %s: %v", f.filepath, f.err)
+				r.events.FileSkipped(f.filepath, f.err)
+				skipped = append(skipped, &FileError{Path: f.filepath, Err: f.err})
+				doneCount++
+				r.counter.Update(doneCount, dir.total)
+				continue
+			}
+			r.logger.Infof("Repairing file %s", f.filepath)
+			r.events.FileStarted(f.filepath, f.mtdt.Filename)
+			// the files are walked in path order, so that repairing the same
+			// directory again results in the same manifest
+			err := dir.m.Add(ctx, f.filepath, manifest.NewEntry(f.e.Reference(), r.entryMetadata(f)))
 			if err != nil {
 				return swarm.ZeroAddress, err
 			}
+			r.logger.Infof("Repaired file %s with reference %s", f.filepath, f.e.Reference())
+			r.events.FileCompleted(f.filepath, f.e.Reference())
+			doneCount++
+			r.counter.Update(doneCount, dir.total)
+
 		case e, ok := <-dir.errC:
 			if !ok {
 				break loop
@@ -196,26 +643,152 @@ loop:
 	if err != nil {
 		return swarm.ZeroAddress, err
 	}
+	if err := r.verifyReference(ctx, newReference); err != nil {
+		return swarm.ZeroAddress, err
+	}
 
-	r.logger.Debugf("Created new directory manifest with reference %s", newReference.String())
+	r.logger.Infof("Created new directory manifest with reference %s", newReference.String())
+	if len(skipped) > 0 {
+		r.logger.Warningf("Skipped %d files of directory reference %s", len(skipped), addr)
+	}
+	if len(r.excludedFiles) > 0 {
+		r.logger.Infof("Excluded %d files of directory reference %s", len(r.excludedFiles), addr)
+		r.updater.Update(fmt.Sprintf("Excluded %d files matching the exclude patterns", len(r.excludedFiles)))
+	}
+	r.events.RepairCompleted(newReference)
 
+	if err := r.recordMapping(addr, newReference); err != nil {
+		return swarm.ZeroAddress, err
+	}
+	if len(skipped) > 0 {
+		return newReference, &SkippedError{Files: skipped}
+	}
 	return newReference, nil
 }
 
 // Repairer is the implementation of the repairer utility
 type Repairer struct {
-	store   cmdfile.PutGetter
-	ls      file.LoadSaver
-	logger  logging.Logger
-	encrypt bool
-	pin     bool
-	updater ProgressUpdater
+	store       cmdfile.PutGetter
+	source      storage.Getter
+	ls          file.LoadSaver
+	logger      logging.Logger
+	encrypt     bool
+	pin         bool
+	pinMfstOnly bool
+	mode        storage.ModePut
+	// dataMode is the mode of the file content stored by the repair
+	dataMode    storage.ModePut
+	putMode     *storage.ModePut
+	updater     ProgressUpdater
+	events      EventUpdater
+	counter     CountingProgressUpdater
+	fileTimeout time.Duration
+	skipErrors  bool
+
+	indexDocument string
+	errorDocument string
+	metadataLimit int64
+	dirMetadata   bool
+	mapping       MappingRecorder
+	mimeOverrides map[string]string
+	validateSize  bool
+	localPath     string
+	closer        io.Closer
+	budget        *byteBudget
+	readOnly      bool
+	maxDepth      int
+	pathPrefix    string
+	baseManifest  swarm.Address
+
+	strictMetadata bool
+	noWebsite      bool
+	dryRun         *dryRunStore
+	symlinks       SymlinkPolicy
+	fileSizes      bool
+	flushInterval  int
+	verifyRef      bool
+	excludeGlobs   []string
+	// dest is the store the new chunks are written to, which they are read back
+	// from also when the old chunks are read from a source store
+	dest cmdfile.PutGetter
+	// excludedFiles are the files left out by the exclude patterns during the walk
+	excludedFiles []string
+	// entries are the old entries held by the walk, which are dropped when the
+	// budget is needed to read another one
+	entries *entryCache
 }
 
 type noopUpdater struct{}
 
 func (n *noopUpdater) Update(_ string) {}
 
+// SyncUpdater is a ProgressUpdater which can be shared by concurrent repairs. Every
+// message is written to the writer as a whole line
+type SyncUpdater struct {
+	mtx sync.Mutex
+	w   io.Writer
+}
+
+// NewSyncUpdater returns a SyncUpdater writing the messages to w
+func NewSyncUpdater(w io.Writer) *SyncUpdater {
+	return &SyncUpdater{w: w}
+}
+
+// Update writes the message followed by a newline. A nil SyncUpdater writes nothing
+func (s *SyncUpdater) Update(msg string) {
+	if s == nil {
+		return
+	}
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	_, _ = fmt.Fprintln(s.w, msg)
+}
+
+// WithPrefix returns an updater which writes to the same writer and prefixes every
+// message, e.g. with the reference being repaired
+func (s *SyncUpdater) WithPrefix(prefix string) ProgressUpdater {
+	return &prefixUpdater{updater: s, prefix: prefix}
+}
+
+type prefixUpdater struct {
+	updater *SyncUpdater
+	prefix  string
+}
+
+func (p *prefixUpdater) Update(msg string) {
+	p.updater.Update(p.prefix + msg)
+}
+
+// progressEvents reports the events as messages to the ProgressUpdater
+type progressEvents struct {
+	updater ProgressUpdater
+}
+
+func (p *progressEvents) FileStarted(_, filename string) {
+	p.updater.Update(fmt.Sprintf("Updating reference for file %s", filename))
+}
+
+func (p *progressEvents) FileCompleted(_ string, _ swarm.Address) {}
+
+func (p *progressEvents) FileSkipped(path string, err error) {
+	p.updater.Update(fmt.Sprintf("Skipping file %s: %v", path, err))
+}
+
+func (p *progressEvents) RepairCompleted(_ swarm.Address) {}
+
+func (p *progressEvents) Total(files int) {
+	if t, ok := p.updater.(TotalUpdater); ok {
+		t.Total(files)
+	}
+}
+
+type noopCounter struct{}
+
+func (n *noopCounter) Update(_, _ int) {}
+
+// defaultOpts fills in the options which were not set or were set to nil. Every
+// repair builds its Repairer with newWithOptions, so none of them is ever nil
 func defaultOpts(c *Repairer) {
 	if c.store == nil {
 		c.store = cmdfile.NewAPIStore("127.0.0.1", 1633, false)
@@ -223,33 +796,229 @@ func defaultOpts(c *Repairer) {
 	if c.updater == nil {
 		c.updater = &noopUpdater{}
 	}
+	if c.events == nil {
+		c.events = &progressEvents{c.updater}
+	}
+	if c.counter == nil {
+		c.counter = &noopCounter{}
+	}
 	if c.logger == nil {
 		c.logger = logging.New(ioutil.Discard, 0)
 	}
+	if c.metadataLimit <= 0 {
+		c.metadataLimit = defaultMetadataLimit
+	}
 }
 
-func newWithOptions(opts ...Option) *Repairer {
+func newWithOptions(opts ...Option) (*Repairer, error) {
 	r := &Repairer{}
 	for _, opt := range opts {
 		opt(r)
 	}
 	defaultOpts(r)
-	mode := storage.ModePutUpload
+	if err := checkExcludeGlobs(r.excludeGlobs); err != nil {
+		return nil, err
+	}
+	if r.localPath != "" {
+		st, err := exporter.OpenStore(r.localPath)
+		if err != nil {
+			return nil, fmt.Errorf("opening local store %s: %w", r.localPath, err)
+		}
+		r.store = st
+		r.closer = st
+	}
+	r.dest = r.store
+	if r.source != nil {
+		r.store = &sourceStore{Getter: r.source, Putter: r.store}
+	}
+	if r.dryRun != nil {
+		r.dryRun.PutGetter = r.store
+		r.store = r.dryRun
+		r.dest = r.dryRun
+	}
+	r.mode = storage.ModePutUpload
 	if r.pin {
-		mode = storage.ModePutUploadPin
+		r.mode = storage.ModePutUploadPin
 	}
-	r.ls = loadsave.New(r.store, mode, r.encrypt)
-	return r
+	if r.putMode != nil {
+		r.mode = *r.putMode
+	}
+	r.dataMode = r.mode
+	if r.pinMfstOnly {
+		r.dataMode = unpinnedMode(r.mode)
+	}
+	r.ls = loadsave.New(r.store, r.mode, r.encrypt)
+	return r, nil
+}
+
+// close releases the local store, if one was opened
+func (r *Repairer) close() {
+	if r.closer == nil {
+		return
+	}
+	if err := r.closer.Close(); err != nil {
+		r.logger.Errorf("Closing local store %s: %v", r.localPath, err)
+	}
+}
+
+// recordMapping records the new reference of the old one when a recorder is set
+func (r *Repairer) recordMapping(oldRef, newRef swarm.Address) error {
+	if r.mapping == nil {
+		return nil
+	}
+	if err := r.mapping.Put(oldRef, newRef); err != nil {
+		return fmt.Errorf("recording mapping of %s: %w", oldRef, err)
+	}
+	return nil
+}
+
+// isEncrypted reports whether the reference carries the key of encrypted data
+func isEncrypted(addr swarm.Address) bool {
+	return len(addr.Bytes()) == encryption.ReferenceSize
+}
+
+// manifestLoadSaver returns the load saver used to store the new manifest for the
+// old reference and whether the manifest should be encrypted. The encryption of the
+// old data is carried over even when it was not requested
+func (r *Repairer) manifestLoadSaver(oldRef swarm.Address) (file.LoadSaver, bool) {
+	encrypt := r.encrypt || isEncrypted(oldRef)
+	return loadsave.New(r.dest, r.mode, encrypt), encrypt
+}
+
+// newManifest returns the manifest the entries of the old directory are added to,
+// which is encrypted also when the base manifest is
+func (r *Repairer) newManifest(ctx context.Context, oldRef swarm.Address) (manifest.Interface, error) {
+	ls, encrypt := r.manifestLoadSaver(oldRef)
+	if isEncrypted(r.baseManifest) && !encrypt {
+		ls = loadsave.New(r.dest, r.mode, true)
+		encrypt = true
+	}
+	return manifest.NewDefaultManifest(ls, encrypt)
+}
+
+// baseEntries returns the entries of the base manifest, including the directories
+// with metadata, in path order. They are added to the new manifest instead of the
+// new entries being added to the stored one, as the stored nodes which other paths
+// continue are typed only as edges once they are loaded and would lose their
+// entries
+func (r *Repairer) baseEntries(ctx context.Context) ([]*pathEntry, error) {
+	ls := loadsave.New(r.dest, r.mode, isEncrypted(r.baseManifest))
+	var entries []*pathEntry
+	err := mantaray.NewNodeRef(r.baseManifest.Bytes()).WalkNode(ctx, []byte{}, ls, func(path []byte, n *mantaray.Node, err error) error {
+		if err != nil {
+			return err
+		}
+		if len(path) == 0 || !isValueNode(n) && len(n.Metadata()) == 0 {
+			return nil
+		}
+		ref := swarm.NewAddress(n.Entry())
+		if isZeroReference(ref) {
+			ref = swarm.ZeroAddress
+		}
+		entries = append(entries, &pathEntry{
+			path:  string(path),
+			entry: manifest.NewEntry(ref, n.Metadata()),
+		})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].path < entries[j].path })
+	return entries, nil
+}
+
+// verifyReference reads back the root chunk of the stored manifest and checks that
+// its content address is the reference, when WithVerifyReference is set
+func (r *Repairer) verifyReference(ctx context.Context, ref swarm.Address) error {
+	if !r.verifyRef {
+		return nil
+	}
+	// encrypted references carry the decryption key after the address
+	addr := swarm.NewAddress(ref.Bytes()[:swarm.HashSize])
+	ch, err := r.dest.Get(ctx, storage.ModeGetRequest, addr)
+	if err != nil {
+		return fmt.Errorf("%w: reading back %s: %v", ErrReferenceMismatch, ref, err)
+	}
+	if !ch.Address().Equal(addr) || !cac.Valid(ch) {
+		return fmt.Errorf("%w: root chunk of %s does not hash to its address", ErrReferenceMismatch, ref)
+	}
+	r.logger.Debugf("Verified the root chunk of reference %s", ref)
+	return nil
+}
+
+func (r *Repairer) hasBaseManifest() bool {
+	return !r.baseManifest.Equal(swarm.ZeroAddress)
+}
+
+// rootMetadata returns the metadata of the root entry of the new manifest, with the
+// index and error documents overridden when configured
+func (r *Repairer) rootMetadata(mtdt map[string]string) map[string]string {
+	if r.indexDocument == "" && r.errorDocument == "" {
+		return mtdt
+	}
+	newMtdt := make(map[string]string, len(mtdt)+2)
+	for k, v := range mtdt {
+		newMtdt[k] = v
+	}
+	if r.indexDocument != "" {
+		newMtdt[manifest.WebsiteIndexDocumentSuffixKey] = r.indexDocument
+	}
+	if r.errorDocument != "" {
+		newMtdt[manifest.WebsiteErrorDocumentPathKey] = r.errorDocument
+	}
+	return newMtdt
 }
 
 type fileEntry struct {
 	filepath string
 	e        *entry.Entry
 	mtdt     *entry.Metadata
+	feed     map[string]string
+	err      error
+	// size is the length of the file content, set with WithFileSizes
+	size int64
+	// mtdtSize is the length of the old metadata the entry was read from
+	mtdtSize int64
+}
+
+// metadata returns the metadata of the entry in the new manifest, which for a feed
+// is the metadata of the old manifest entry
+func (f *fileEntry) metadata() map[string]string {
+	if f.feed != nil {
+		return f.feed
+	}
+	return map[string]string{
+		manifest.EntryMetadataFilenameKey:    f.mtdt.Filename,
+		manifest.EntryMetadataContentTypeKey: f.mtdt.MimeType,
+	}
+}
+
+// entryMetadata returns the metadata of the entry in the new manifest with the
+// content type overridden by the extension of the file, if any
+func (r *Repairer) entryMetadata(f *fileEntry) map[string]string {
+	mtdt := f.metadata()
+	if f.feed != nil || len(r.mimeOverrides) == 0 {
+		return mtdt
+	}
+	ext := strings.ToLower(filepath.Ext(f.mtdt.Filename))
+	if mime, ok := r.mimeOverrides[ext]; ok && ext != "" {
+		r.logger.Debugf("Overriding content type %q of %s with %q", f.mtdt.MimeType, f.mtdt.Filename, mime)
+		mtdt[manifest.EntryMetadataContentTypeKey] = mime
+	}
+	return mtdt
+}
+
+// isFeedMetadata reports whether the metadata is the one of a feed entry
+func isFeedMetadata(mtdt map[string]string) bool {
+	_, owner := mtdt[feedMetadataEntryOwner]
+	_, topic := mtdt[feedMetadataEntryTopic]
+	return owner && topic
 }
 
 type dirEntry struct {
-	m      manifest.Interface
+	m      *manifestAdder
+	total  int
 	filesC <-chan *fileEntry
 	errC   <-chan error
 }
@@ -258,7 +1027,7 @@ type dirEntry struct {
 func (r *Repairer) getOldFileEntry(ctx context.Context, addr swarm.Address) (*fileEntry, error) {
 	buf := bytes.NewBuffer(nil)
 	writeCloser := cmdfile.NopWriteCloser(buf)
-	limitBuf := cmdfile.NewLimitWriteCloser(writeCloser, limitMetadataLength)
+	limitBuf := cmdfile.NewLimitWriteCloser(writeCloser, r.metadataLimit)
 
 	j, _, err := joiner.New(ctx, r.store, addr)
 	if err != nil {
@@ -272,15 +1041,34 @@ func (r *Repairer) getOldFileEntry(ctx context.Context, addr swarm.Address) (*fi
 	e := &entry.Entry{}
 	err = e.UnmarshalBinary(buf.Bytes())
 	if err != nil {
-		return nil, err
+		return nil, fmt.Errorf("%w: %v", ErrNotOldFormat, err)
 	}
 
-	j, _, err = joiner.New(ctx, r.store, e.Metadata())
+	if isZeroReference(e.Reference()) && !r.readOnly {
+		emptyRef, err := r.emptyFileReference(ctx)
+		if err != nil {
+			return nil, err
+		}
+		r.logger.Warningf("Replacing empty file reference %s with %s", e.Reference(), emptyRef)
+		e = entry.New(emptyRef, e.Metadata())
+	}
+
+	j, size, err := joiner.New(ctx, r.store, e.Metadata())
 	if err != nil {
 		return nil, err
 	}
+	if size > r.metadataLimit {
+		return nil, fmt.Errorf("metadata size %d exceeds the limit of %d bytes", size, r.metadataLimit)
+	}
+
+	if r.budget != nil {
+		if err := r.acquireBudget(ctx, size); err != nil {
+			return nil, err
+		}
+		defer r.budget.release(size)
+	}
 
-	buf = bytes.NewBuffer(nil)
+	buf = bytes.NewBuffer(make([]byte, 0, size))
 
 	_, err = file.JoinReadAll(ctx, j, buf)
 	if err != nil {
@@ -291,19 +1079,126 @@ func (r *Repairer) getOldFileEntry(ctx context.Context, addr swarm.Address) (*fi
 	metaData := &entry.Metadata{}
 	err = json.Unmarshal(buf.Bytes(), metaData)
 	if err != nil {
-		return nil, err
+		return nil, fmt.Errorf("%w: invalid metadata: %v", ErrNotOldFormat, err)
+	}
+	if metaData.Filename == "" {
+		if r.strictMetadata {
+			return nil, fmt.Errorf("%w: entry %s has no filename", ErrInvalidMetadata, addr)
+		}
+		metaData.Filename = e.Reference().String()
+		r.logger.Warningf("Old entry %s has no filename, naming it %s", addr, metaData.Filename)
 	}
 	r.logger.Debugf("Read old file entry Filename: %s MIME-type: %s Reference: %s",
-		e.Reference(), metaData.Filename, metaData.MimeType)
+		metaData.Filename, metaData.MimeType, e.Reference())
 
-	return &fileEntry{
-		e:    e,
-		mtdt: metaData,
-	}, nil
+	f := &fileEntry{
+		e:        e,
+		mtdt:     metaData,
+		mtdtSize: size,
+	}
+	if r.fileSizes && !isZeroReference(e.Reference()) {
+		// the span of the root chunk, the content itself is not read
+		_, f.size, err = joiner.New(ctx, r.store, e.Reference())
+		if err != nil {
+			return nil, fmt.Errorf("size of file %s: %w", e.Reference(), err)
+		}
+	}
+	return f, nil
 }
 
-// read the directory present in old format
-func (r *Repairer) getOldDirectoryEntry(ctx context.Context, addr swarm.Address) (*dirEntry, error) {
+// acquireBudget waits until n bytes of the budget are available, dropping the
+// entries held by the walk first when they are not, as the walk itself would
+// otherwise wait for them to be released
+func (r *Repairer) acquireBudget(ctx context.Context, n int64) error {
+	if r.budget.tryAcquire(n) {
+		return nil
+	}
+	if r.entries != nil {
+		r.entries.reset()
+	}
+	return r.budget.acquire(ctx, n)
+}
+
+// isZeroReference reports whether the reference has only zero bytes, which is how
+// empty files could be referenced by the old entries
+func isZeroReference(addr swarm.Address) bool {
+	for _, b := range addr.Bytes() {
+		if b != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// emptyFileReference stores the chunk of empty content and returns its address, so
+// that the empty file is served with no content instead of not being found
+func (r *Repairer) emptyFileReference(ctx context.Context) (swarm.Address, error) {
+	ch, err := cac.NewWithDataSpan(make([]byte, swarm.SpanSize))
+	if err != nil {
+		return swarm.ZeroAddress, err
+	}
+	_, err = r.store.Put(ctx, r.dataMode, ch)
+	if err != nil {
+		return swarm.ZeroAddress, err
+	}
+	return ch.Address(), nil
+}
+
+// unpinnedMode returns the put mode which stores the chunks as the mode does,
+// without pinning them
+func unpinnedMode(mode storage.ModePut) storage.ModePut {
+	switch mode {
+	case storage.ModePutUploadPin:
+		return storage.ModePutUpload
+	case storage.ModePutRequestPin:
+		return storage.ModePutRequest
+	}
+	return mode
+}
+
+// read the file entry present in the old format and validate its size when
+// configured, bounded by the per file timeout
+func (r *Repairer) getOldFileEntryWithTimeout(ctx context.Context, addr swarm.Address) (*fileEntry, error) {
+	if r.fileTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, r.fileTimeout)
+		defer cancel()
+	}
+	f, err := r.getOldFileEntry(ctx, addr)
+	if err != nil || !r.validateSize {
+		return f, err
+	}
+	if err := r.checkSize(ctx, f); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// checkSize reads the whole content of the file and checks that its length is the
+// span of the file and the size recorded in the metadata
+func (r *Repairer) checkSize(ctx context.Context, f *fileEntry) error {
+	j, span, err := joiner.New(ctx, r.store, f.e.Reference())
+	if err != nil {
+		return err
+	}
+	if f.mtdt.Size > 0 && f.mtdt.Size != span {
+		return fmt.Errorf("%w: metadata records %d bytes, content spans %d bytes", ErrSizeMismatch, f.mtdt.Size, span)
+	}
+	n, err := file.JoinReadAll(ctx, j, ioutil.Discard)
+	if err != nil {
+		if ctx.Err() != nil {
+			return err
+		}
+		return fmt.Errorf("%w: joined %d of %d bytes: %v", ErrSizeMismatch, n, span, err)
+	}
+	if n != span {
+		return fmt.Errorf("%w: joined %d of %d bytes", ErrSizeMismatch, n, span)
+	}
+	return nil
+}
+
+// read the mantaray manifest of the directory present in old format
+func (r *Repairer) getOldManifest(ctx context.Context, addr swarm.Address) (*mantaray.Node, error) {
 	j, _, err := joiner.New(ctx, r.store, addr)
 	if err != nil {
 		return nil, err
@@ -319,7 +1214,7 @@ func (r *Repairer) getOldDirectoryEntry(ctx context.Context, addr swarm.Address)
 	entry := new(entry.Entry)
 	err = entry.UnmarshalBinary(buf.Bytes())
 	if err != nil {
-		return nil, err
+		return nil, fmt.Errorf("%w: %v", ErrNotOldFormat, err)
 	}
 
 	j, _, err = joiner.New(ctx, r.store, entry.Reference())
@@ -335,60 +1230,337 @@ func (r *Repairer) getOldDirectoryEntry(ctx context.Context, addr swarm.Address)
 
 	node := new(mantaray.Node)
 	err = node.UnmarshalBinary(buf.Bytes())
+	if err != nil {
+		return nil, fmt.Errorf("%w: invalid manifest: %v", ErrNotOldFormat, err)
+	}
+
+	return node, nil
+}
+
+// read the directory present in old format
+func (r *Repairer) getOldDirectoryEntry(ctx context.Context, addr swarm.Address) (*dirEntry, error) {
+	node, err := r.getOldManifest(ctx, addr)
 	if err != nil {
 		return nil, err
 	}
 
-	entryChan := make(chan *fileEntry)
-	walkFn := func(path []byte, isDir bool, err error) error {
-		if err != nil {
-			return err
-		}
-		if !isDir {
-			fnode, err := node.LookupNode(ctx, path, r.ls)
-			if err != nil {
-				return err
-			}
-			fentry, err := r.getOldFileEntry(ctx, swarm.NewAddress(fnode.Entry()))
-			if err != nil {
-				return err
+	// the nodes loaded by the count stay cached for the walk of the files
+	total := 0
+	if r.needsTotal() {
+		selected := r.included
+		if r.symlinks == SymlinkSkip {
+			// the skipped symlinks are not walked as files
+			selected = func(path []byte) bool {
+				if !r.included(path) {
+					return false
+				}
+				fnode, err := node.LookupNode(ctx, path, r.ls)
+				if err != nil {
+					return true
+				}
+				_, symlink := symlinkTarget(fnode.Metadata())
+				return !symlink
 			}
-			fentry.filepath = string(path)
-			entryChan <- fentry
 		}
-		return nil
+		total, err = countFiles(ctx, node, r.ls, selected)
+		if err != nil {
+			return nil, err
+		}
 	}
 
+	// some old manifests have no root entry, which is the same as no root metadata
+	var rootMtdt map[string]string
 	rootNode, err := node.LookupNode(ctx, []byte(manifest.RootPath), r.ls)
+	switch {
+	case errors.Is(err, mantaray.ErrNotFound):
+		r.logger.Debugf("Directory %s has no root entry", addr)
+	case err != nil:
+		return nil, err
+	default:
+		rootMtdt = rootNode.Metadata()
+	}
+
+	// the directories are walked before the files, as the nodes are loaded lazily
+	var dirs []*dirMetadata
+	if r.dirMetadata {
+		dirs, err = directoryMetadata(ctx, node, r.ls)
+		if err != nil {
+			return nil, err
+		}
+		dirs = r.selectedDirectories(dirs)
+	}
+
+	// the new manifest is set up before the walk starts, so that nothing is left
+	// walking when it fails
+	newManifest, err := r.newManifest(ctx, addr)
 	if err != nil {
 		return nil, err
 	}
+	var pending []*pathEntry
+	if r.hasBaseManifest() {
+		pending, err = r.baseEntries(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("loading base manifest %s: %w", r.baseManifest, err)
+		}
+	}
+	pending = append(pending, &pathEntry{
+		path:  manifest.RootPath,
+		entry: manifest.NewEntry(swarm.ZeroAddress, r.rootMetadata(rootMtdt)),
+	})
+	for _, d := range dirs {
+		pending = append(pending, &pathEntry{
+			path:  d.path,
+			entry: manifest.NewEntry(swarm.ZeroAddress, d.mtdt),
+		})
+		r.logger.Debugf("Copying metadata of directory %s: %v", d.path, d.mtdt)
+	}
+	// the entries of the old directory are added after the ones of the base
+	// manifest at the same paths, which they replace
+	sort.SliceStable(pending, func(i, j int) bool { return pending[i].path < pending[j].path })
+	m := newDirectoryAdder(newManifest, pending, r.flushInterval, r.logger)
 
+	// the walk stops when the context is done, the callers cancel it when they
+	// return before reading all of the files
+	entryChan := make(chan *fileEntry)
 	errChan := make(chan error)
 	go func() {
 		defer close(entryChan)
 		defer close(errChan)
-		err = node.Walk(ctx, []byte{}, r.ls, walkFn)
+		err := r.walkOldFiles(ctx, node, func(f *fileEntry) error {
+			select {
+			case entryChan <- f:
+				return nil
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		})
 		if err != nil {
-			errChan <- err
+			select {
+			case errChan <- err:
+			case <-ctx.Done():
+			}
 		}
 	}()
 
-	m, err := manifest.NewDefaultManifest(r.ls, r.encrypt)
+	r.logger.Debugf("Walking directory %s root metadata: %v", addr.String(), rootMtdt)
+
+	return &dirEntry{
+		m:      m,
+		total:  total,
+		filesC: entryChan,
+		errC:   errChan,
+	}, nil
+}
+
+// walkOldFiles walks the old manifest and calls fn with the entry of every file, in
+// path order. With WithSkipErrors the files which cannot be read are passed with
+// their error. An old file reference at several paths is read once while its entry
+// is held by the bounded cache of the walk
+func (r *Repairer) walkOldFiles(ctx context.Context, node *mantaray.Node, fn func(*fileEntry) error) error {
+	paths, err := r.oldFilePaths(ctx, node)
+	if err != nil {
+		return err
+	}
+	r.entries = newEntryCache(r.budget)
+	defer r.entries.reset()
+	for _, path := range paths {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := r.walkOldFile(ctx, node, path, fn); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// oldFilePaths returns the paths of the selected files of the old manifest which
+// are not excluded, sorted. The forks of the manifest are walked in no particular
+// order, so the paths are collected before any entry is read
+func (r *Repairer) oldFilePaths(ctx context.Context, node *mantaray.Node) ([]string, error) {
+	var paths []string
+	err := walkFiles(ctx, node, r.ls, func(path []byte, isDir bool, err error) error {
+		if err != nil {
+			return err
+		}
+		if isDir {
+			dir := strings.TrimSuffix(string(path), manifest.RootPath) + manifest.RootPath
+			if !strings.HasPrefix(dir, r.pathPrefix) && !strings.HasPrefix(r.pathPrefix, dir) {
+				// a directory outside of the prefix has no selected files
+				return nil
+			}
+			// without skipping, the walk stops before descending too deep
+			if !r.skipErrors && r.tooDeep(path, 1) {
+				return fmt.Errorf("directory %s: %w: limit is %d", path, ErrMaxDepth, r.maxDepth)
+			}
+			return nil
+		}
+		if !r.selected(path) {
+			return nil
+		}
+		if r.excluded(string(path)) {
+			r.logger.Debugf("Excluding file %s", path)
+			r.excludedFiles = append(r.excludedFiles, string(path))
+			return nil
+		}
+		paths = append(paths, string(path))
+		return nil
+	})
 	if err != nil {
 		return nil, err
 	}
+	sort.Strings(paths)
+	return paths, nil
+}
 
-	err = m.Add(ctx, manifest.RootPath, manifest.NewEntry(swarm.ZeroAddress, rootNode.Metadata()))
+// walkOldFile reads the entry of the file at the path of the old manifest, or
+// takes it from the entries held, and calls fn with it
+func (r *Repairer) walkOldFile(ctx context.Context, node *mantaray.Node, path string, fn func(*fileEntry) error) error {
+	if r.tooDeep([]byte(path), 0) {
+		err := fmt.Errorf("%w: limit is %d", ErrMaxDepth, r.maxDepth)
+		if !r.skipErrors {
+			return &FileError{Path: path, Err: err}
+		}
+		return fn(&fileEntry{filepath: path, err: err})
+	}
+	fnode, err := node.LookupNode(ctx, []byte(path), r.ls)
+	if err != nil {
+		return err
+	}
+	if isFeedMetadata(fnode.Metadata()) {
+		// feeds are not collection entries, they are passed through as is
+		r.logger.Debugf("Passing through feed entry %s", path)
+		return fn(&fileEntry{
+			filepath: path,
+			e:        entry.New(swarm.NewAddress(fnode.Entry()), swarm.ZeroAddress),
+			mtdt:     entry.NewMetadata(filepath.Base(path)),
+			feed:     fnode.Metadata(),
+		})
+	}
+	if target, ok := symlinkTarget(fnode.Metadata()); ok {
+		switch r.symlinks {
+		case SymlinkSkip:
+			r.logger.Debugf("Skipping symlink %s to %s", path, target)
+			return nil
+		case SymlinkResolve:
+			fnode, err = r.resolveSymlink(ctx, node, path, target)
+		default:
+			err = fmt.Errorf("%w to %s", ErrSymlink, target)
+		}
+		if err != nil {
+			if !r.skipErrors {
+				return &FileError{Path: path, Err: err}
+			}
+			return fn(&fileEntry{filepath: path, err: err})
+		}
+	}
+	ref := swarm.NewAddress(fnode.Entry())
+	cached, found := r.entries.get(ref)
+	if !found {
+		fentry, err := r.getOldFileEntryWithTimeout(ctx, ref)
+		if err != nil {
+			fentry = &fileEntry{err: err}
+		}
+		cached = fentry
+		r.entries.add(ref, cached)
+	} else {
+		r.logger.Debugf("Reusing entry %s for file %s", ref, path)
+	}
+	if cached.err != nil && !r.skipErrors {
+		return &FileError{Path: path, Err: cached.err}
+	}
+	// the same file can be at several paths
+	fentry := *cached
+	fentry.filepath = path
+	return fn(&fentry)
+}
+
+// tooDeep reports whether the path, with the levels below it, is nested deeper than
+// the limit. The files at the top level are at depth 1
+func (r *Repairer) tooDeep(path []byte, below int) bool {
+	path = bytes.Trim(path, manifest.RootPath)
+	if r.maxDepth <= 0 || len(path) == 0 {
+		return false
+	}
+	depth := bytes.Count(path, []byte(manifest.RootPath)) + 1
+	return depth+below > r.maxDepth
+}
+
+type dirMetadata struct {
+	path string
+	mtdt map[string]string
+}
+
+// directoryMetadata walks the old manifest and returns the directory nodes other
+// than the root which have metadata
+func directoryMetadata(ctx context.Context, node *mantaray.Node, ls file.LoadSaver) ([]*dirMetadata, error) {
+	var dirs []*dirMetadata
+	err := node.WalkNode(ctx, []byte{}, ls, func(path []byte, n *mantaray.Node, err error) error {
+		if err != nil {
+			return err
+		}
+		p := string(path)
+		if p == manifest.RootPath || !strings.HasSuffix(p, manifest.RootPath) {
+			return nil
+		}
+		if len(n.Metadata()) > 0 {
+			dirs = append(dirs, &dirMetadata{path: p, mtdt: n.Metadata()})
+		}
+		return nil
+	})
 	if err != nil {
 		return nil, err
 	}
+	return dirs, nil
+}
 
-	r.logger.Debugf("Walking directory %s root metadata: %v", addr.String(), rootNode.Metadata())
+// needsTotal reports whether any of the updaters uses the number of files
+func (r *Repairer) needsTotal() bool {
+	if _, ok := r.counter.(*noopCounter); !ok {
+		return true
+	}
+	if _, ok := r.updater.(TotalUpdater); ok {
+		return true
+	}
+	_, ok := r.events.(TotalUpdater)
+	return ok
+}
 
-	return &dirEntry{
-		m:      m,
-		filesC: entryChan,
-		errC:   errChan,
-	}, nil
+// selectedDirectories leaves out the directories deeper than the limit, outside of
+// the path prefix or excluded, as none of their files are repaired
+func (r *Repairer) selectedDirectories(dirs []*dirMetadata) []*dirMetadata {
+	var selected []*dirMetadata
+	for _, d := range dirs {
+		if !strings.HasPrefix(d.path, r.pathPrefix) || r.excluded(d.path) {
+			continue
+		}
+		if !r.tooDeep([]byte(strings.TrimSuffix(d.path, manifest.RootPath)), 1) {
+			selected = append(selected, d)
+		}
+	}
+	return selected
+}
+
+// selected reports whether the file at path is repaired with the path prefix
+func (r *Repairer) selected(path []byte) bool {
+	return bytes.HasPrefix(path, []byte(r.pathPrefix))
+}
+
+// countFiles walks the old manifest and returns the number of selected file entries
+// in it
+func countFiles(ctx context.Context, node *mantaray.Node, ls file.LoadSaver, selected func([]byte) bool) (int, error) {
+	count := 0
+	err := walkFiles(ctx, node, ls, func(path []byte, isDir bool, err error) error {
+		if err != nil {
+			return err
+		}
+		if !isDir && selected(path) {
+			count++
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return count, nil
 }
@@ -5,23 +5,42 @@
 package repair_test
 
 import (
+	"archive/tar"
 	"bytes"
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"io"
 	"io/ioutil"
 	"math/rand"
 	"path/filepath"
+	"reflect"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/ethersphere/bee-repair/internal/collection/entry"
+	"github.com/ethersphere/bee-repair/internal/exporter"
 	"github.com/ethersphere/bee-repair/internal/repair"
+	cmdfile "github.com/ethersphere/bee-repair/pkg/file"
+	"github.com/ethersphere/bee/pkg/encryption"
+	"github.com/ethersphere/bee/pkg/file"
+	"github.com/ethersphere/bee/pkg/file/joiner"
 	"github.com/ethersphere/bee/pkg/file/loadsave"
 	"github.com/ethersphere/bee/pkg/file/splitter"
+	"github.com/ethersphere/bee/pkg/logging"
 	"github.com/ethersphere/bee/pkg/manifest"
+	"github.com/ethersphere/bee/pkg/manifest/mantaray"
 	"github.com/ethersphere/bee/pkg/storage"
 	"github.com/ethersphere/bee/pkg/storage/mock"
 	"github.com/ethersphere/bee/pkg/swarm"
+	"github.com/sirupsen/logrus"
 )
 
 type fEntry struct {
@@ -31,7 +50,10 @@ type fEntry struct {
 	contentType  string
 	size         int64
 	reference    swarm.Address
+	oldReference swarm.Address
 	expectedPins int
+	encrypt      bool
+	mtdtSize     int64
 }
 
 func TestFileRepair(t *testing.T) {
@@ -115,7 +137,7 @@ func TestFileRepair(t *testing.T) {
 			newReference, err := repair.FileRepair(
 				ctx,
 				oldReference,
-				repair.WithMockStore(store),
+				repair.WithStore(store),
 			)
 			if err != nil {
 				t.Fatal(err)
@@ -144,7 +166,7 @@ func TestFileRepair(t *testing.T) {
 			newReference, err := repair.FileRepair(
 				ctx,
 				oldReference,
-				repair.WithMockStore(store),
+				repair.WithStore(store),
 				repair.WithPin(true),
 			)
 			if err != nil {
@@ -164,6 +186,203 @@ func TestFileRepair(t *testing.T) {
 	}
 }
 
+func TestFileRepairEncrypted(t *testing.T) {
+	ctx := context.Background()
+	store := mock.NewStorer()
+
+	f := &fEntry{
+		filename:    "secret.txt",
+		contentType: "text/plain; charset=utf-8",
+		size:        swarm.ChunkSize * 2,
+		encrypt:     true,
+	}
+
+	oldReference, err := createFileOldFormat(ctx, store, f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(f.reference.Bytes()) != encryption.ReferenceSize {
+		t.Fatalf("expected encrypted file reference, found %s", f.reference)
+	}
+
+	newReference, err := repair.FileRepair(ctx, oldReference, repair.WithStore(store))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(newReference.Bytes()) != encryption.ReferenceSize {
+		t.Fatalf("expected encrypted manifest reference, found %s", newReference)
+	}
+
+	m, err := manifest.NewDefaultManifestReference(
+		newReference,
+		loadsave.New(store, storage.ModePutUpload, false),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fileEntry, err := m.Lookup(ctx, f.filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !fileEntry.Reference().Equal(f.reference) {
+		t.Fatalf("Invalid manifest file reference, Exp: %s Found: %s",
+			f.reference, fileEntry.Reference())
+	}
+
+	j, size, err := joiner.New(ctx, store, fileEntry.Reference())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if size != f.size {
+		t.Fatalf("unexpected file size, Exp: %d Found: %d", f.size, size)
+	}
+	buf := bytes.NewBuffer(nil)
+	_, err = file.JoinReadAll(ctx, j, buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if int64(buf.Len()) != f.size {
+		t.Fatalf("unexpected file length, Exp: %d Found: %d", f.size, buf.Len())
+	}
+}
+
+func TestFileRepairLongFilename(t *testing.T) {
+	ctx := context.Background()
+	store := mock.NewStorer()
+
+	// the serialized metadata spans more than one chunk
+	f := &fEntry{
+		filename:    strings.Repeat("a", swarm.ChunkSize+100) + ".txt",
+		contentType: "text/plain; charset=utf-8",
+		size:        swarm.ChunkSize,
+	}
+	oldReference, err := createFileOldFormat(ctx, store, f)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	newReference, err := repair.FileRepair(ctx, oldReference, repair.WithStore(store))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m, err := manifest.NewDefaultManifestReference(
+		newReference,
+		loadsave.New(store, storage.ModePutUpload, false),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fileEntry, err := m.Lookup(ctx, f.filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !fileEntry.Reference().Equal(f.reference) {
+		t.Fatalf("Invalid manifest file reference, Exp: %s Found: %s",
+			f.reference, fileEntry.Reference())
+	}
+	if fileEntry.Metadata()[manifest.EntryMetadataFilenameKey] != f.filename {
+		t.Fatal("Invalid manifest file metadata: Filename")
+	}
+
+	_, err = repair.FileRepair(
+		ctx,
+		oldReference,
+		repair.WithStore(store),
+		repair.WithMetadataLimit(swarm.ChunkSize),
+	)
+	if err == nil {
+		t.Fatal("expected metadata limit error")
+	}
+}
+
+func TestFileRepairEmptyFile(t *testing.T) {
+	ctx := context.Background()
+	store := mock.NewStorer()
+
+	f := &fEntry{
+		filename:    "empty.txt",
+		contentType: "text/plain; charset=utf-8",
+	}
+
+	oldReference, err := createFileOldFormat(ctx, store, f)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	newReference, err := repair.FileRepair(ctx, oldReference, repair.WithStore(store))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m, err := manifest.NewDefaultManifestReference(
+		newReference,
+		loadsave.New(store, storage.ModePutUpload, false),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fileEntry, err := m.Lookup(ctx, f.filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fileEntry.Metadata()[manifest.EntryMetadataFilenameKey] != f.filename {
+		t.Fatal("Invalid manifest file metadata: Filename")
+	}
+	if fileEntry.Metadata()[manifest.EntryMetadataContentTypeKey] != f.contentType {
+		t.Fatal("Invalid manifest file metadata: ContentType")
+	}
+
+	// the entry should be served as an empty file
+	j, size, err := joiner.New(ctx, store, fileEntry.Reference())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if size != 0 {
+		t.Fatalf("unexpected file size, Exp: 0 Found: %d", size)
+	}
+	buf := bytes.NewBuffer(nil)
+	_, err = file.JoinReadAll(ctx, j, buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if buf.Len() != 0 {
+		t.Fatalf("unexpected file length, Exp: 0 Found: %d", buf.Len())
+	}
+}
+
+func TestExists(t *testing.T) {
+	ctx := context.Background()
+	store := mock.NewStorer()
+
+	f := &fEntry{
+		filename:    "simple.txt",
+		contentType: "text/plain; charset=utf-8",
+		size:        swarm.ChunkSize,
+	}
+	oldReference, err := createFileOldFormat(ctx, store, f)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	found, err := repair.Exists(ctx, oldReference, repair.WithStore(store))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !found {
+		t.Fatal("expected reference to exist")
+	}
+
+	missing := swarm.MustParseHexAddress("2387e8e7d8a48c2a9339c97c1dc3461a9a7aa07e994c5cb8b38fd7c1b3e6ea48")
+	found, err = repair.Exists(ctx, missing, repair.WithStore(store))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if found {
+		t.Fatal("unexpected missing reference found")
+	}
+}
+
 type countUpdater struct {
 	msgCount int
 }
@@ -172,6 +391,21 @@ func (s *countUpdater) Update(_ string) {
 	s.msgCount++
 }
 
+type percentUpdater struct {
+	t           *testing.T
+	curr, total int
+}
+
+func (p *percentUpdater) Update(current, total int) {
+	if current < p.curr {
+		p.t.Fatal("update arrive with older progress")
+	}
+	if current > total {
+		p.t.Fatal("incorrect update")
+	}
+	p.curr, p.total = current, total
+}
+
 func TestDirectoryRepair(t *testing.T) {
 	testDirs := []struct {
 		name         string
@@ -295,12 +529,14 @@ func TestDirectoryRepair(t *testing.T) {
 			}
 
 			updater := &countUpdater{}
+			counter := &percentUpdater{t: t}
 
 			newReference, err := repair.DirectoryRepair(
 				ctx,
 				oldReference,
-				repair.WithMockStore(store),
+				repair.WithStore(store),
 				repair.WithProgressUpdater(updater),
+				repair.WithCountingProgressUpdater(counter),
 			)
 			if err != nil {
 				t.Fatal(err)
@@ -311,6 +547,12 @@ func TestDirectoryRepair(t *testing.T) {
 				t.Fatal("Progress updater update mismatch")
 			}
 
+			// Total is known up front and the final update completes it
+			if counter.total != len(d.files) || counter.curr != counter.total {
+				t.Fatalf("Counting updater mismatch, expected: %d got: %d/%d",
+					len(d.files), counter.curr, counter.total)
+			}
+
 			validateManifest(t, store, newReference)
 
 			pins, err := store.PinnedChunks(ctx, 0, 10)
@@ -336,7 +578,7 @@ func TestDirectoryRepair(t *testing.T) {
 			newReference, err := repair.DirectoryRepair(
 				ctx,
 				oldReference,
-				repair.WithMockStore(store),
+				repair.WithStore(store),
 				repair.WithProgressUpdater(updater),
 				repair.WithPin(true),
 			)
@@ -362,137 +604,3201 @@ func TestDirectoryRepair(t *testing.T) {
 	}
 }
 
-// putEntry creates a new file entry with the given reference.
-func createFileOldFormat(ctx context.Context, store storage.Storer, f *fEntry) (swarm.Address, error) {
-	// set up splitter to process the metadata
-	s := splitter.NewSimpleSplitter(store, storage.ModePutUpload)
+func TestFileRepairInDirectory(t *testing.T) {
+	ctx := context.Background()
+	store := mock.NewStorer()
 
-	fdata := make([]byte, f.size)
-	_, err := rand.Read(fdata)
-	if err != nil {
-		return swarm.ZeroAddress, err
+	files := []*fEntry{
+		{
+			filename:    "a.txt",
+			contentType: "text/plain; charset=utf-8",
+			size:        swarm.ChunkSize,
+		},
+		{
+			dir:         "c/f",
+			filename:    "g.jpeg",
+			contentType: "image/jpeg; charset=utf-8",
+			size:        swarm.ChunkSize * 2,
+		},
 	}
-	fileBuf := bytes.NewBuffer(fdata)
-	fileBytesReader := io.LimitReader(fileBuf, int64(len(fdata)))
-	fileBytesReadCloser := ioutil.NopCloser(fileBytesReader)
-	fileBytesAddr, err := s.Split(ctx, fileBytesReadCloser, int64(len(fdata)), false)
+
+	oldReference, err := createDirOldFormat(ctx, store, "a.txt", "", files)
 	if err != nil {
-		return swarm.ZeroAddress, err
+		t.Fatal(err)
 	}
 
-	metadata := entry.NewMetadata(f.filename)
-	metadata.MimeType = f.contentType
-
-	// serialize metadata and send it to splitter
-	metadataBytes, err := json.Marshal(metadata)
+	newReference, err := repair.FileRepairInDirectory(
+		ctx,
+		oldReference,
+		"/c/f/g.jpeg",
+		repair.WithStore(store),
+	)
 	if err != nil {
-		return swarm.ZeroAddress, err
+		t.Fatal(err)
 	}
-	// logger.Debugf("metadata contents: %s", metadataBytes)
 
-	// first add metadata
-	metadataBuf := bytes.NewBuffer(metadataBytes)
-	metadataReader := io.LimitReader(metadataBuf, int64(len(metadataBytes)))
-	metadataReadCloser := ioutil.NopCloser(metadataReader)
-	metadataAddr, err := s.Split(ctx, metadataReadCloser, int64(len(metadataBytes)), false)
+	m, err := manifest.NewDefaultManifestReference(
+		newReference,
+		loadsave.New(store, storage.ModePutUpload, false),
+	)
 	if err != nil {
-		return swarm.ZeroAddress, err
+		t.Fatal(err)
 	}
 
-	// create entry from given reference and metadata,
-	// serialize and send to splitter
-	fileEntry := entry.New(fileBytesAddr, metadataAddr)
-	fileEntryBytes, err := fileEntry.MarshalBinary()
+	rootEntry, err := m.Lookup(ctx, manifest.RootPath)
 	if err != nil {
-		return swarm.ZeroAddress, err
+		t.Fatal(err)
 	}
-	fileEntryBuf := bytes.NewBuffer(fileEntryBytes)
-	fileEntryReader := io.LimitReader(fileEntryBuf, int64(len(fileEntryBytes)))
-	fileEntryReadCloser := ioutil.NopCloser(fileEntryReader)
-	fileEntryAddr, err := s.Split(ctx, fileEntryReadCloser, int64(len(fileEntryBytes)), false)
+	if rootEntry.Metadata()[manifest.WebsiteIndexDocumentSuffixKey] != "g.jpeg" {
+		t.Fatal("Invalid manifest root entry")
+	}
+
+	fileEntry, err := m.Lookup(ctx, "g.jpeg")
 	if err != nil {
-		return swarm.ZeroAddress, err
+		t.Fatal(err)
+	}
+	if !fileEntry.Reference().Equal(files[1].reference) {
+		t.Fatalf("Invalid manifest file reference, Exp: %s Found: %s",
+			files[1].reference, fileEntry.Reference())
+	}
+	if fileEntry.Metadata()[manifest.EntryMetadataContentTypeKey] != files[1].contentType {
+		t.Fatal("Invalid manifest file metadata: ContentType")
 	}
 
-	f.reference = fileBytesAddr
-	return fileEntryAddr, nil
+	_, err = m.Lookup(ctx, "a.txt")
+	if !errors.Is(err, manifest.ErrNotFound) {
+		t.Fatalf("expected other files to be left out, got %v", err)
+	}
+
+	_, err = repair.FileRepairInDirectory(ctx, oldReference, "missing.txt", repair.WithStore(store))
+	if err == nil {
+		t.Fatal("expected error for missing path")
+	}
 }
 
-func createDirOldFormat(
-	ctx context.Context,
-	store storage.Storer,
-	indexFile,
-	errorFile string,
-	files []*fEntry,
-) (swarm.Address, error) {
-	m, err := manifest.NewDefaultManifest(
-		loadsave.New(store, storage.ModePutUpload, false),
-		false,
+// slowStore delays every chunk retrieval to simulate a slow gateway
+type slowStore struct {
+	storage.Storer
+	delay time.Duration
+}
+
+func (s *slowStore) Get(ctx context.Context, mode storage.ModeGet, addr swarm.Address) (swarm.Chunk, error) {
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-time.After(s.delay):
+	}
+	return s.Storer.Get(ctx, mode, addr)
+}
+
+func TestDirectoryRepairPerFileTimeout(t *testing.T) {
+	ctx := context.Background()
+	store := mock.NewStorer()
+
+	files := []*fEntry{
+		{
+			filename:    "a.txt",
+			contentType: "text/plain; charset=utf-8",
+			size:        swarm.ChunkSize,
+		},
+	}
+
+	oldReference, err := createDirOldFormat(ctx, store, "", "", files)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = repair.DirectoryRepair(
+		ctx,
+		oldReference,
+		repair.WithStore(&slowStore{Storer: store, delay: 50 * time.Millisecond}),
+		repair.WithPerFileTimeout(10*time.Millisecond),
 	)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected deadline exceeded error, got %v", err)
+	}
+}
+
+// countingStore counts the retrievals of every chunk
+type countingStore struct {
+	storage.Storer
+	mtx  sync.Mutex
+	gets map[string]int
+}
+
+func (s *countingStore) Get(ctx context.Context, mode storage.ModeGet, addr swarm.Address) (swarm.Chunk, error) {
+	s.mtx.Lock()
+	s.gets[addr.String()]++
+	s.mtx.Unlock()
+	return s.Storer.Get(ctx, mode, addr)
+}
+
+func TestDirectoryRepairDuplicateFiles(t *testing.T) {
+	ctx := context.Background()
+	store := mock.NewStorer()
+
+	f := &fEntry{
+		filename:    "logo.png",
+		contentType: "image/png",
+		size:        swarm.ChunkSize,
+	}
+	fileRef, err := createFileOldFormat(ctx, store, f)
 	if err != nil {
-		return swarm.ZeroAddress, err
+		t.Fatal(err)
+	}
+	// the same old file entry at two paths
+	oldReference, err := createDirOldFormatWithEntries(ctx, store, "", "", nil, map[string]manifest.Entry{
+		"logo.png":     manifest.NewEntry(fileRef, nil),
+		"img/logo.png": manifest.NewEntry(fileRef, nil),
+	})
+	if err != nil {
+		t.Fatal(err)
 	}
 
-	var rootMtdt map[string]string
+	counter := &countingStore{Storer: store, gets: make(map[string]int)}
+	newReference, err := repair.DirectoryRepair(ctx, oldReference, repair.WithStore(counter))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := counter.gets[fileRef.String()]; n != 1 {
+		t.Fatalf("expected the old entry to be read once, read %d times", n)
+	}
 
-	if indexFile != "" || errorFile != "" {
-		rootMtdt = make(map[string]string)
-		if indexFile != "" {
-			rootMtdt[manifest.WebsiteIndexDocumentSuffixKey] = indexFile
+	m, err := manifest.NewDefaultManifestReference(
+		newReference,
+		loadsave.New(store, storage.ModePutUpload, false),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, path := range []string{"logo.png", "img/logo.png"} {
+		fileEntry, err := m.Lookup(ctx, path)
+		if err != nil {
+			t.Fatal(err)
 		}
-		if errorFile != "" {
-			rootMtdt[manifest.WebsiteErrorDocumentPathKey] = errorFile
+		if !fileEntry.Reference().Equal(f.reference) {
+			t.Fatalf("Invalid manifest file reference of %s", path)
 		}
 	}
+}
+
+// modeStore records the mode of every chunk stored, the nodes of a manifest are
+// stored concurrently
+type modeStore struct {
+	storage.Storer
+	mtx   sync.Mutex
+	modes []storage.ModePut
+}
+
+func (s *modeStore) Put(ctx context.Context, mode storage.ModePut, chs ...swarm.Chunk) ([]bool, error) {
+	s.mtx.Lock()
+	s.modes = append(s.modes, mode)
+	s.mtx.Unlock()
+	return s.Storer.Put(ctx, mode, chs...)
+}
+
+func TestDirectoryRepairPin(t *testing.T) {
+	ctx := context.Background()
+	store := mock.NewStorer()
+
+	files := []*fEntry{
+		{
+			filename:    "a.txt",
+			contentType: "text/plain; charset=utf-8",
+			size:        swarm.ChunkSize,
+		},
+	}
 
-	err = m.Add(ctx, manifest.RootPath, manifest.NewEntry(swarm.ZeroAddress, rootMtdt))
+	oldReference, err := createDirOldFormat(ctx, store, "a.txt", "", files)
 	if err != nil {
-		return swarm.ZeroAddress, err
+		t.Fatal(err)
 	}
 
-	for _, f := range files {
-		fileRef, err := createFileOldFormat(ctx, store, f)
-		if err != nil {
-			return swarm.ZeroAddress, err
+	for _, pin := range []bool{false, true} {
+		expMode := storage.ModePutUpload
+		if pin {
+			expMode = storage.ModePutUploadPin
 		}
-		err = m.Add(ctx, filepath.Join(f.dir, f.filename), manifest.NewEntry(fileRef, nil))
+		ms := &modeStore{Storer: store}
+		_, err = repair.DirectoryRepair(ctx, oldReference, repair.WithStore(ms), repair.WithPin(pin))
 		if err != nil {
-			return swarm.ZeroAddress, err
+			t.Fatal(err)
+		}
+		if len(ms.modes) == 0 {
+			t.Fatal("no chunks stored")
+		}
+		for _, mode := range ms.modes {
+			if mode != expMode {
+				t.Fatalf("Invalid put mode with pin %t, Exp: %v Found: %v", pin, expMode, mode)
+			}
 		}
 	}
-	newManifest, err := m.Store(ctx)
+}
+
+func TestDirectoryRepairPinManifestOnly(t *testing.T) {
+	ctx := context.Background()
+	store := mock.NewStorer()
+
+	files := []*fEntry{
+		{
+			filename:    "a.txt",
+			contentType: "text/plain; charset=utf-8",
+			size:        swarm.ChunkSize,
+		},
+		{
+			filename:    "empty.txt",
+			contentType: "text/plain; charset=utf-8",
+		},
+	}
+	oldReference, err := createDirOldFormat(ctx, store, "a.txt", "", files)
 	if err != nil {
-		return swarm.ZeroAddress, err
+		t.Fatal(err)
 	}
-	// Create a new collection entry for holding the manifest
-	s := splitter.NewSimpleSplitter(store, storage.ModePutUpload)
 
-	mMtdt := entry.NewMetadata(newManifest.String())
-	mMtdt.MimeType = m.Type()
-	manifestMtdtBytes, err := json.Marshal(mMtdt)
+	newReference, err := repair.DirectoryRepair(ctx, oldReference,
+		repair.WithStore(store),
+		repair.WithPin(true),
+		repair.WithPinManifestOnly(true),
+	)
 	if err != nil {
-		return swarm.ZeroAddress, err
+		t.Fatal(err)
 	}
-	metadataBuf := bytes.NewBuffer(manifestMtdtBytes)
-	metadataReader := io.LimitReader(metadataBuf, int64(len(manifestMtdtBytes)))
-	metadataReadCloser := ioutil.NopCloser(metadataReader)
-	metadataAddr, err := s.Split(ctx, metadataReadCloser, int64(len(manifestMtdtBytes)), false)
+
+	// the chunks of the nodes of the new manifest
+	manifestChunks := make(map[string]struct{})
+	ls := loadsave.New(store, storage.ModePutUpload, false)
+	err = mantaray.NewNodeRef(newReference.Bytes()).WalkNode(ctx, []byte{}, ls, func(_ []byte, n *mantaray.Node, err error) error {
+		if err != nil {
+			return err
+		}
+		j, _, err := joiner.New(ctx, store, swarm.NewAddress(n.Reference()))
+		if err != nil {
+			return err
+		}
+		return j.IterateChunkAddresses(func(addr swarm.Address) error {
+			manifestChunks[addr.String()] = struct{}{}
+			return nil
+		})
+	})
 	if err != nil {
-		return swarm.ZeroAddress, err
+		t.Fatal(err)
 	}
 
-	mEntry := entry.New(newManifest, metadataAddr)
-	manifestEntryBytes, err := mEntry.MarshalBinary()
+	pins, err := store.PinnedChunks(ctx, 0, 100)
 	if err != nil {
-		return swarm.ZeroAddress, err
+		t.Fatal(err)
 	}
-	entryBuf := bytes.NewBuffer(manifestEntryBytes)
-	entryReader := io.LimitReader(entryBuf, int64(len(manifestEntryBytes)))
-	entryReadCloser := ioutil.NopCloser(entryReader)
-	newEntryAddr, err := s.Split(ctx, entryReadCloser, int64(len(manifestEntryBytes)), false)
-	if err != nil {
-		return swarm.ZeroAddress, err
+	if len(pins) != len(manifestChunks) {
+		t.Fatalf("unexpected pin count, expected: %d got: %d", len(manifestChunks), len(pins))
+	}
+	for _, p := range pins {
+		if _, ok := manifestChunks[p.Address.String()]; !ok {
+			t.Fatalf("pinned chunk %s is not a manifest chunk", p.Address)
+		}
 	}
+}
 
-	return newEntryAddr, nil
+func TestRepairPutMode(t *testing.T) {
+	ctx := context.Background()
+	store := mock.NewStorer()
+
+	files := []*fEntry{
+		{
+			filename:    "a.txt",
+			contentType: "text/plain; charset=utf-8",
+			size:        swarm.ChunkSize,
+		},
+	}
+	oldReference, err := createDirOldFormat(ctx, store, "a.txt", "", files)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fileReference, err := createFileOldFormat(ctx, store, files[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the put mode overrides the one chosen with pin
+	for name, repairFn := range map[string]func(...repair.Option) (swarm.Address, error){
+		"file": func(opts ...repair.Option) (swarm.Address, error) {
+			return repair.FileRepair(ctx, fileReference, opts...)
+		},
+		"directory": func(opts ...repair.Option) (swarm.Address, error) {
+			return repair.DirectoryRepair(ctx, oldReference, opts...)
+		},
+	} {
+		ms := &modeStore{Storer: store}
+		_, err := repairFn(repair.WithStore(ms), repair.WithPin(true), repair.WithPutMode(storage.ModePutRequest))
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if len(ms.modes) == 0 {
+			t.Fatalf("%s: no chunks stored", name)
+		}
+		for _, mode := range ms.modes {
+			if mode != storage.ModePutRequest {
+				t.Fatalf("%s: invalid put mode, Exp: %v Found: %v", name, storage.ModePutRequest, mode)
+			}
+		}
+	}
+}
+
+func TestRepairLocalStore(t *testing.T) {
+	ctx := context.Background()
+	dbPath := t.TempDir()
+
+	store, err := exporter.OpenStore(dbPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := &fEntry{
+		filename:    "a.txt",
+		contentType: "text/plain; charset=utf-8",
+		size:        swarm.ChunkSize + 1,
+	}
+	oldReference, err := createFileOldFormat(ctx, store, f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	newReference, err := repair.FileRepair(ctx, oldReference, repair.WithLocalStore(dbPath))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the database is closed after the repair and holds the new manifest
+	store, err = exporter.OpenStore(dbPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	m, err := manifest.NewDefaultManifestReference(
+		newReference,
+		loadsave.New(store, storage.ModePutUpload, false),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fileEntry, err := m.Lookup(ctx, "a.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !fileEntry.Reference().Equal(f.reference) {
+		t.Fatal("Invalid manifest file reference")
+	}
+
+	// the database can not be opened while in use
+	_, err = repair.FileRepair(ctx, oldReference, repair.WithLocalStore(dbPath))
+	if err == nil {
+		t.Fatal("expected error opening the local store")
+	}
+}
+
+func TestFileRepairWebsiteMode(t *testing.T) {
+	ctx := context.Background()
+	store := mock.NewStorer()
+
+	f := &fEntry{
+		filename:    "a.txt",
+		contentType: "text/plain; charset=utf-8",
+		size:        swarm.ChunkSize,
+	}
+	oldReference, err := createFileOldFormat(ctx, store, f)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	newReference, err := repair.FileRepair(ctx, oldReference, repair.WithStore(store), repair.WithWebsiteMode(false))
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, err := manifest.NewDefaultManifestReference(
+		newReference,
+		loadsave.New(store, storage.ModePutUpload, false),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rootEntry, err := m.Lookup(ctx, manifest.RootPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, found := rootEntry.Metadata()[manifest.WebsiteIndexDocumentSuffixKey]; found {
+		t.Fatalf("unexpected index document in root metadata %v", rootEntry.Metadata())
+	}
+	fileEntry, err := m.Lookup(ctx, "a.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !fileEntry.Reference().Equal(f.reference) {
+		t.Fatal("Invalid manifest file reference")
+	}
+}
+
+// TestManifestAdderConcurrent is meant to be run with -race
+func TestManifestAdderConcurrent(t *testing.T) {
+	const (
+		goroutines = 8
+		perRoutine = 50
+	)
+	ctx := context.Background()
+	store := mock.NewStorer()
+	ls := loadsave.New(store, storage.ModePutUpload, false)
+
+	m, err := manifest.NewDefaultManifest(ls, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	adder := repair.NewManifestAdder(m)
+
+	reference := func(g, i int) swarm.Address {
+		b := make([]byte, swarm.HashSize)
+		b[0], b[1] = byte(g+1), byte(i+1)
+		return swarm.NewAddress(b)
+	}
+
+	var wg sync.WaitGroup
+	errC := make(chan error, goroutines)
+	for g := 0; g < goroutines; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < perRoutine; i++ {
+				path := fmt.Sprintf("dir%d/file%d.txt", g, i)
+				if err := adder.Add(ctx, path, manifest.NewEntry(reference(g, i), nil)); err != nil {
+					errC <- err
+					return
+				}
+			}
+		}(g)
+	}
+	wg.Wait()
+	close(errC)
+	for err := range errC {
+		t.Fatal(err)
+	}
+
+	newReference, err := adder.Store(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, err = manifest.NewDefaultManifestReference(newReference, ls)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for g := 0; g < goroutines; g++ {
+		for i := 0; i < perRoutine; i++ {
+			path := fmt.Sprintf("dir%d/file%d.txt", g, i)
+			e, err := m.Lookup(ctx, path)
+			if err != nil {
+				t.Fatalf("%s: %v", path, err)
+			}
+			if !e.Reference().Equal(reference(g, i)) {
+				t.Fatalf("%s: unexpected reference %s", path, e.Reference())
+			}
+		}
+	}
+}
+
+func TestFileRepairMissingFilename(t *testing.T) {
+	ctx := context.Background()
+	store := mock.NewStorer()
+
+	f := &fEntry{
+		contentType: "text/plain; charset=utf-8",
+		size:        swarm.ChunkSize,
+	}
+	oldReference, err := createFileOldFormat(ctx, store, f)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = repair.FileRepair(ctx, oldReference, repair.WithStore(store), repair.WithStrictMetadata(true))
+	if !errors.Is(err, repair.ErrInvalidMetadata) {
+		t.Fatalf("expected invalid metadata error, got %v", err)
+	}
+
+	// the file is named after its content reference
+	newReference, err := repair.FileRepair(ctx, oldReference, repair.WithStore(store))
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, err := manifest.NewDefaultManifestReference(
+		newReference,
+		loadsave.New(store, storage.ModePutUpload, false),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fileEntry, err := m.Lookup(ctx, f.reference.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !fileEntry.Reference().Equal(f.reference) {
+		t.Fatal("Invalid manifest file reference")
+	}
+}
+
+func TestRepairMemoryStore(t *testing.T) {
+	ctx := context.Background()
+	store := repair.NewMemoryStore()
+
+	f := &fEntry{
+		filename:    "a.txt",
+		contentType: "text/plain; charset=utf-8",
+		size:        swarm.ChunkSize + 1,
+	}
+	oldReference, err := createFileOldFormat(ctx, store, f)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	newReference, err := repair.FileRepair(ctx, oldReference, repair.WithMemoryStore(store))
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, err := manifest.NewDefaultManifestReference(
+		newReference,
+		loadsave.New(store, storage.ModePutUpload, false),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fileEntry, err := m.Lookup(ctx, "a.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !fileEntry.Reference().Equal(f.reference) {
+		t.Fatal("Invalid manifest file reference")
+	}
+
+	// an empty store has none of the old entries
+	_, err = repair.FileRepair(ctx, oldReference, repair.WithMemoryStore(nil))
+	if !errors.Is(err, repair.ErrNotFound) {
+		t.Fatalf("expected not found error, got %v", err)
+	}
+}
+
+func TestRepairMaxBufferedBytes(t *testing.T) {
+	ctx := context.Background()
+	store := mock.NewStorer()
+
+	// the bound is smaller than any metadata, so the repairs read them one by one
+	budget := repair.WithMaxBufferedBytes(1)
+
+	var wg sync.WaitGroup
+	errC := make(chan error, 4)
+	for i := 0; i < 4; i++ {
+		f := &fEntry{
+			filename:    fmt.Sprintf("%d.txt", i),
+			contentType: "text/plain; charset=utf-8",
+			size:        swarm.ChunkSize,
+		}
+		oldReference, err := createFileOldFormat(ctx, store, f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := repair.FileRepair(ctx, oldReference, repair.WithStore(store), budget)
+			errC <- err
+		}()
+	}
+	wg.Wait()
+	close(errC)
+	for err := range errC {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestWalkOldDirectoryMaxBufferedBytes(t *testing.T) {
+	ctx := context.Background()
+	store := mock.NewStorer()
+
+	files := []*fEntry{
+		{
+			filename:    "a.txt",
+			contentType: "text/plain; charset=utf-8",
+			size:        10,
+		},
+		{
+			filename:    "b.txt",
+			contentType: "text/plain; charset=utf-8",
+			size:        10,
+		},
+	}
+	// the same entry at several paths is held by the walk to be reused
+	sharedReference, err := createFileOldFormat(ctx, store, &fEntry{
+		filename:    "shared.txt",
+		contentType: "text/plain; charset=utf-8",
+		size:        10,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	extra := make(map[string]manifest.Entry)
+	for _, dir := range []string{"c", "d", "e"} {
+		extra[dir+"/shared.txt"] = manifest.NewEntry(sharedReference, nil)
+	}
+	oldReference, err := createDirOldFormatWithEntries(ctx, store, "", "", files, extra)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the bound fits the metadata of one entry only
+	const limit = 100
+	budget := repair.WithMaxBufferedBytes(limit)
+	var (
+		walked  int
+		maxUsed int64
+	)
+	err = repair.WalkOldDirectory(ctx, store, oldReference, func(f *repair.OldFile) error {
+		walked++
+		used := repair.BudgetUsed(budget)
+		if used > limit {
+			t.Fatalf("%d bytes held walking %s, limit is %d", used, f.Path, limit)
+		}
+		if used > maxUsed {
+			maxUsed = used
+		}
+		return nil
+	}, budget)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if walked != len(files)+len(extra) {
+		t.Fatalf("Invalid number of entries, Exp: %d Found: %d", len(files)+len(extra), walked)
+	}
+	if maxUsed == 0 {
+		t.Fatal("entries held by the walk not taken from the budget")
+	}
+	if used := repair.BudgetUsed(budget); used != 0 {
+		t.Fatalf("%d bytes still held after the walk", used)
+	}
+}
+
+// rejectStore fails the upload of every chunk
+type rejectStore struct {
+	storage.Storer
+}
+
+func (s *rejectStore) Put(_ context.Context, _ storage.ModePut, _ ...swarm.Chunk) ([]bool, error) {
+	return nil, fmt.Errorf("chunk: %w", cmdfile.ErrUploadRejected)
+}
+
+func TestRepairErrors(t *testing.T) {
+	ctx := context.Background()
+	store := mock.NewStorer()
+
+	f := &fEntry{
+		filename:    "a.txt",
+		contentType: "text/plain; charset=utf-8",
+		size:        100,
+	}
+	oldReference, err := createFileOldFormat(ctx, store, f)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = repair.FileRepair(ctx, swarm.MustParseHexAddress("2387e8e7d8a48c2a9339c97c1dc3461a9a7aa07e994c5cb8b38fd7c1b3e6ea48"), repair.WithStore(store))
+	if !errors.Is(err, repair.ErrNotFound) {
+		t.Fatalf("expected not found error, got %v", err)
+	}
+	// the file bytes are not an entry
+	_, err = repair.FileRepair(ctx, f.reference, repair.WithStore(store))
+	if !errors.Is(err, repair.ErrNotOldFormat) {
+		t.Fatalf("expected not old format error, got %v", err)
+	}
+	_, err = repair.DirectoryRepair(ctx, oldReference, repair.WithStore(store))
+	if !errors.Is(err, repair.ErrNotOldFormat) {
+		t.Fatalf("expected not old format error, got %v", err)
+	}
+	_, err = repair.FileRepair(ctx, oldReference, repair.WithStore(&rejectStore{store}))
+	if !errors.Is(err, repair.ErrUploadRejected) {
+		t.Fatalf("expected upload rejected error, got %v", err)
+	}
+}
+
+// mapRecorder records the mappings in memory
+type mapRecorder map[string]swarm.Address
+
+func (m mapRecorder) Put(oldRef, newRef swarm.Address) error {
+	m[oldRef.String()] = newRef
+	return nil
+}
+
+func TestRepairMappingRecorder(t *testing.T) {
+	ctx := context.Background()
+	store := mock.NewStorer()
+
+	f := &fEntry{
+		filename:    "a.txt",
+		contentType: "text/plain; charset=utf-8",
+		size:        swarm.ChunkSize,
+	}
+	oldFileReference, err := createFileOldFormat(ctx, store, f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	oldDirReference, err := createDirOldFormat(ctx, store, "a.txt", "", []*fEntry{f})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	recorder := mapRecorder{}
+	newFileReference, err := repair.FileRepair(ctx, oldFileReference, repair.WithStore(store), repair.WithMappingRecorder(recorder))
+	if err != nil {
+		t.Fatal(err)
+	}
+	newDirReference, err := repair.DirectoryRepair(ctx, oldDirReference, repair.WithStore(store), repair.WithMappingRecorder(recorder))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(recorder) != 2 {
+		t.Fatalf("unexpected mappings %v", recorder)
+	}
+	if ref := recorder[oldFileReference.String()]; !ref.Equal(newFileReference) {
+		t.Fatalf("Invalid file mapping, Exp: %s Found: %s", newFileReference, ref)
+	}
+	if ref := recorder[oldDirReference.String()]; !ref.Equal(newDirReference) {
+		t.Fatalf("Invalid directory mapping, Exp: %s Found: %s", newDirReference, ref)
+	}
+}
+
+// failStore fails the retrieval of the given chunks
+type failStore struct {
+	storage.Storer
+	missing []swarm.Address
+}
+
+func (s *failStore) Get(ctx context.Context, mode storage.ModeGet, addr swarm.Address) (swarm.Chunk, error) {
+	for _, m := range s.missing {
+		if addr.Equal(m) {
+			return nil, storage.ErrNotFound
+		}
+	}
+	return s.Storer.Get(ctx, mode, addr)
+}
+
+func TestDirectoryRepairSkipErrors(t *testing.T) {
+	ctx := context.Background()
+	store := mock.NewStorer()
+
+	files := []*fEntry{
+		{
+			filename:    "a.txt",
+			contentType: "text/plain; charset=utf-8",
+			size:        swarm.ChunkSize,
+		},
+		{
+			dir:         "c",
+			filename:    "b.txt",
+			contentType: "text/plain; charset=utf-8",
+			size:        swarm.ChunkSize,
+		},
+	}
+
+	oldReference, err := createDirOldFormat(ctx, store, "", "", files)
+	if err != nil {
+		t.Fatal(err)
+	}
+	brokenStore := &failStore{Storer: store, missing: []swarm.Address{files[1].oldReference}}
+
+	t.Run("fail", func(t *testing.T) {
+		_, err := repair.DirectoryRepair(ctx, oldReference, repair.WithStore(brokenStore))
+		var fileErr *repair.FileError
+		if !errors.As(err, &fileErr) || fileErr.Path != "c/b.txt" {
+			t.Fatalf("expected file error for c/b.txt, got %v", err)
+		}
+		if !errors.Is(err, storage.ErrNotFound) {
+			t.Fatalf("expected not found error, got %v", err)
+		}
+	})
+	t.Run("skip", func(t *testing.T) {
+		newReference, err := repair.DirectoryRepair(
+			ctx,
+			oldReference,
+			repair.WithStore(brokenStore),
+			repair.WithSkipErrors(true),
+		)
+		var skipped *repair.SkippedError
+		if !errors.As(err, &skipped) {
+			t.Fatalf("expected skipped error, got %v", err)
+		}
+		if len(skipped.Files) != 1 || skipped.Files[0].Path != "c/b.txt" {
+			t.Fatalf("unexpected skipped files %v", skipped.Files)
+		}
+
+		m, err := manifest.NewDefaultManifestReference(
+			newReference,
+			loadsave.New(store, storage.ModePutUpload, false),
+		)
+		if err != nil {
+			t.Fatal(err)
+		}
+		fileEntry, err := m.Lookup(ctx, "a.txt")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !fileEntry.Reference().Equal(files[0].reference) {
+			t.Fatal("Invalid manifest file reference")
+		}
+		_, err = m.Lookup(ctx, "c/b.txt")
+		if !errors.Is(err, manifest.ErrNotFound) {
+			t.Fatalf("expected skipped file to be left out, got %v", err)
+		}
+	})
+}
+
+func TestDirectoryRepairNoRootEntry(t *testing.T) {
+	ctx := context.Background()
+	store := mock.NewStorer()
+
+	files := []*fEntry{
+		{
+			filename:    "a.txt",
+			contentType: "text/plain; charset=utf-8",
+			size:        swarm.ChunkSize,
+		},
+		{
+			dir:         "c",
+			filename:    "b.txt",
+			contentType: "text/plain; charset=utf-8",
+			size:        swarm.ChunkSize,
+		},
+	}
+
+	oldReference, err := createOldDirectory(ctx, store, false, "", "", files, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	newReference, err := repair.DirectoryRepair(ctx, oldReference, repair.WithStore(store))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m, err := manifest.NewDefaultManifestReference(
+		newReference,
+		loadsave.New(store, storage.ModePutUpload, false),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, path := range []string{"a.txt", "c/b.txt"} {
+		fileEntry, err := m.Lookup(ctx, path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !fileEntry.Reference().Equal(files[i].reference) {
+			t.Fatalf("Invalid manifest file reference of %s", path)
+		}
+	}
+	rootEntry, err := m.Lookup(ctx, manifest.RootPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rootEntry.Metadata()) != 0 {
+		t.Fatalf("unexpected root metadata %v", rootEntry.Metadata())
+	}
+}
+
+func TestDirectoryRepairPathPrefix(t *testing.T) {
+	ctx := context.Background()
+	store := mock.NewStorer()
+
+	files := []*fEntry{
+		{
+			filename:    "a.txt",
+			contentType: "text/plain; charset=utf-8",
+			size:        swarm.ChunkSize,
+		},
+		{
+			dir:         "c",
+			filename:    "b.txt",
+			contentType: "text/plain; charset=utf-8",
+			size:        swarm.ChunkSize,
+		},
+		{
+			dir:         "cd",
+			filename:    "e.txt",
+			contentType: "text/plain; charset=utf-8",
+			size:        swarm.ChunkSize,
+		},
+	}
+
+	oldReference, err := createDirOldFormat(ctx, store, "", "", files)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	newReference, err := repair.DirectoryRepair(ctx, oldReference, repair.WithStore(store), repair.WithPathPrefix("/c/"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m, err := manifest.NewDefaultManifestReference(
+		newReference,
+		loadsave.New(store, storage.ModePutUpload, false),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fileEntry, err := m.Lookup(ctx, "c/b.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !fileEntry.Reference().Equal(files[1].reference) {
+		t.Fatal("Invalid manifest file reference")
+	}
+	for _, path := range []string{"a.txt", "cd/e.txt"} {
+		_, err = m.Lookup(ctx, path)
+		if !errors.Is(err, manifest.ErrNotFound) {
+			t.Fatalf("expected %s to be left out, got %v", path, err)
+		}
+	}
+}
+
+func TestDirectoryRepairBaseManifest(t *testing.T) {
+	ctx := context.Background()
+	store := mock.NewStorer()
+
+	files := []*fEntry{
+		{
+			filename:    "a.txt",
+			contentType: "text/plain; charset=utf-8",
+			size:        swarm.ChunkSize,
+		},
+		{
+			dir:         "c",
+			filename:    "b.txt",
+			contentType: "text/plain; charset=utf-8",
+			size:        swarm.ChunkSize,
+		},
+	}
+
+	oldReference, err := createDirOldFormat(ctx, store, "", "", files)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the directory is migrated in two parts
+	baseReference, err := repair.DirectoryRepair(ctx, oldReference, repair.WithStore(store), repair.WithPathPrefix("c/"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	newReference, err := repair.DirectoryRepair(
+		ctx,
+		oldReference,
+		repair.WithStore(store),
+		repair.WithPathPrefix("a"),
+		repair.WithBaseManifest(baseReference),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m, err := manifest.NewDefaultManifestReference(
+		newReference,
+		loadsave.New(store, storage.ModePutUpload, false),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, path := range []string{"a.txt", "c/b.txt"} {
+		fileEntry, err := m.Lookup(ctx, path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !fileEntry.Reference().Equal(files[i].reference) {
+			t.Fatalf("Invalid manifest file reference of %s", path)
+		}
+	}
+
+}
+
+func TestDirectoryRepairMaxDepth(t *testing.T) {
+	ctx := context.Background()
+	store := mock.NewStorer()
+
+	files := []*fEntry{
+		{
+			filename:    "a.txt",
+			contentType: "text/plain; charset=utf-8",
+			size:        swarm.ChunkSize,
+		},
+		{
+			dir:         "c",
+			filename:    "b.txt",
+			contentType: "text/plain; charset=utf-8",
+			size:        swarm.ChunkSize,
+		},
+	}
+
+	oldReference, err := createDirOldFormat(ctx, store, "", "", files)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("fail", func(t *testing.T) {
+		_, err := repair.DirectoryRepair(ctx, oldReference, repair.WithStore(store), repair.WithMaxDepth(1))
+		if !errors.Is(err, repair.ErrMaxDepth) {
+			t.Fatalf("expected max depth error, got %v", err)
+		}
+	})
+	t.Run("skip", func(t *testing.T) {
+		newReference, err := repair.DirectoryRepair(
+			ctx,
+			oldReference,
+			repair.WithStore(store),
+			repair.WithMaxDepth(1),
+			repair.WithSkipErrors(true),
+		)
+		var skipped *repair.SkippedError
+		if !errors.As(err, &skipped) {
+			t.Fatalf("expected skipped error, got %v", err)
+		}
+		if len(skipped.Files) != 1 || skipped.Files[0].Path != "c/b.txt" || !errors.Is(skipped.Files[0].Err, repair.ErrMaxDepth) {
+			t.Fatalf("unexpected skipped files %v", skipped.Files)
+		}
+
+		m, err := manifest.NewDefaultManifestReference(
+			newReference,
+			loadsave.New(store, storage.ModePutUpload, false),
+		)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := m.Lookup(ctx, "a.txt"); err != nil {
+			t.Fatal(err)
+		}
+		_, err = m.Lookup(ctx, "c/b.txt")
+		if !errors.Is(err, manifest.ErrNotFound) {
+			t.Fatalf("expected deep file to be left out, got %v", err)
+		}
+	})
+	t.Run("deep enough", func(t *testing.T) {
+		_, err := repair.DirectoryRepair(ctx, oldReference, repair.WithStore(store), repair.WithMaxDepth(2))
+		if err != nil {
+			t.Fatal(err)
+		}
+	})
+}
+
+func TestDirectoryRepairValidateSize(t *testing.T) {
+	ctx := context.Background()
+	store := mock.NewStorer()
+
+	files := []*fEntry{
+		{
+			filename:    "a.txt",
+			contentType: "text/plain; charset=utf-8",
+			size:        swarm.ChunkSize,
+			mtdtSize:    swarm.ChunkSize,
+		},
+		{
+			filename:    "b.txt",
+			contentType: "text/plain; charset=utf-8",
+			size:        swarm.ChunkSize,
+			mtdtSize:    2 * swarm.ChunkSize,
+		},
+		{
+			filename:    "c.txt",
+			contentType: "text/plain; charset=utf-8",
+			size:        3 * swarm.ChunkSize,
+		},
+	}
+
+	oldReference, err := createDirOldFormat(ctx, store, "", "", files)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// lose the last data chunk of c.txt
+	j, _, err := joiner.New(ctx, store, files[2].reference)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var lost swarm.Address
+	err = j.IterateChunkAddresses(func(addr swarm.Address) error {
+		lost = addr
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	brokenStore := &failStore{Storer: store, missing: []swarm.Address{lost}}
+
+	t.Run("no validation", func(t *testing.T) {
+		_, err := repair.DirectoryRepair(ctx, oldReference, repair.WithStore(brokenStore))
+		if err != nil {
+			t.Fatal(err)
+		}
+	})
+	t.Run("fail", func(t *testing.T) {
+		_, err := repair.DirectoryRepair(
+			ctx,
+			oldReference,
+			repair.WithStore(brokenStore),
+			repair.WithValidateSize(true),
+		)
+		// both files mismatch, the repair stops at the one walked first
+		var fileErr *repair.FileError
+		if !errors.As(err, &fileErr) || (fileErr.Path != "b.txt" && fileErr.Path != "c.txt") {
+			t.Fatalf("expected file error for b.txt or c.txt, got %v", err)
+		}
+		if !errors.Is(err, repair.ErrSizeMismatch) {
+			t.Fatalf("expected size mismatch error, got %v", err)
+		}
+	})
+	t.Run("skip", func(t *testing.T) {
+		newReference, err := repair.DirectoryRepair(
+			ctx,
+			oldReference,
+			repair.WithStore(brokenStore),
+			repair.WithValidateSize(true),
+			repair.WithSkipErrors(true),
+		)
+		var skipped *repair.SkippedError
+		if !errors.As(err, &skipped) {
+			t.Fatalf("expected skipped error, got %v", err)
+		}
+		if len(skipped.Files) != 2 {
+			t.Fatalf("unexpected skipped files %v", skipped.Files)
+		}
+		// the files are skipped in walk order
+		for _, f := range skipped.Files {
+			if f.Path != "b.txt" && f.Path != "c.txt" {
+				t.Fatalf("unexpected skipped file %v", f)
+			}
+			if !errors.Is(f, repair.ErrSizeMismatch) {
+				t.Fatalf("expected size mismatch error, got %v", f)
+			}
+		}
+		if skipped.Files[0].Path == skipped.Files[1].Path {
+			t.Fatalf("unexpected skipped files %v", skipped.Files)
+		}
+
+		m, err := manifest.NewDefaultManifestReference(
+			newReference,
+			loadsave.New(store, storage.ModePutUpload, false),
+		)
+		if err != nil {
+			t.Fatal(err)
+		}
+		fileEntry, err := m.Lookup(ctx, "a.txt")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !fileEntry.Reference().Equal(files[0].reference) {
+			t.Fatal("Invalid manifest file reference")
+		}
+	})
+}
+
+type eventRecorder struct {
+	total     int
+	started   []string
+	completed map[string]swarm.Address
+	skipped   []string
+	reference swarm.Address
+}
+
+// Total records the number of files only when it comes before the first file
+func (e *eventRecorder) Total(files int) {
+	if len(e.started) == 0 {
+		e.total = files
+	}
+}
+
+func (e *eventRecorder) FileStarted(path, _ string) {
+	e.started = append(e.started, path)
+}
+
+func (e *eventRecorder) FileCompleted(path string, reference swarm.Address) {
+	e.completed[path] = reference
+}
+
+func (e *eventRecorder) FileSkipped(path string, _ error) {
+	e.skipped = append(e.skipped, path)
+}
+
+func (e *eventRecorder) RepairCompleted(reference swarm.Address) {
+	e.reference = reference
+}
+
+func TestDirectoryRepairEvents(t *testing.T) {
+	ctx := context.Background()
+	store := mock.NewStorer()
+
+	files := []*fEntry{
+		{
+			filename:    "a.txt",
+			contentType: "text/plain; charset=utf-8",
+			size:        swarm.ChunkSize,
+		},
+		{
+			dir:         "c",
+			filename:    "b.jpeg",
+			contentType: "image/jpeg; charset=utf-8",
+			size:        swarm.ChunkSize * 2,
+		},
+	}
+
+	oldReference, err := createDirOldFormat(ctx, store, "", "", files)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	updater := &countUpdater{}
+	events := &eventRecorder{completed: make(map[string]swarm.Address)}
+
+	newReference, err := repair.DirectoryRepair(
+		ctx,
+		oldReference,
+		repair.WithStore(store),
+		repair.WithProgressUpdater(updater),
+		repair.WithEventUpdater(events),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Events are preferred over the messages
+	if updater.msgCount != 0 {
+		t.Fatal("unexpected progress updater messages")
+	}
+	if len(events.started) != len(files) || len(events.skipped) != 0 {
+		t.Fatalf("unexpected events, started: %v skipped: %v", events.started, events.skipped)
+	}
+	if events.total != len(files) {
+		t.Fatalf("unexpected total, Exp: %d Found: %d", len(files), events.total)
+	}
+	for _, f := range files {
+		ref, found := events.completed[filepath.Join(f.dir, f.filename)]
+		if !found || !ref.Equal(f.reference) {
+			t.Fatalf("missing completed event for %s", f.filename)
+		}
+	}
+	if !events.reference.Equal(newReference) {
+		t.Fatalf("unexpected completed reference, Exp: %s Found: %s", newReference, events.reference)
+	}
+}
+
+func TestRepairDocumentOverrides(t *testing.T) {
+	ctx := context.Background()
+	store := mock.NewStorer()
+
+	files := []*fEntry{
+		{
+			filename:    "index.html",
+			contentType: "text/html; charset=utf-8",
+			size:        swarm.ChunkSize,
+		},
+		{
+			filename:    "404.html",
+			contentType: "text/html; charset=utf-8",
+			size:        swarm.ChunkSize,
+		},
+	}
+
+	oldReference, err := createDirOldFormat(ctx, store, "wrong.html", "", files)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	validateRoot := func(t *testing.T, newReference swarm.Address, indexFile, errorFile string) {
+		t.Helper()
+
+		m, err := manifest.NewDefaultManifestReference(
+			newReference,
+			loadsave.New(store, storage.ModePutUpload, false),
+		)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rootEntry, err := m.Lookup(ctx, manifest.RootPath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if rootEntry.Metadata()[manifest.WebsiteIndexDocumentSuffixKey] != indexFile {
+			t.Fatalf("Invalid index document, Exp: %s Found: %s",
+				indexFile, rootEntry.Metadata()[manifest.WebsiteIndexDocumentSuffixKey])
+		}
+		if rootEntry.Metadata()[manifest.WebsiteErrorDocumentPathKey] != errorFile {
+			t.Fatalf("Invalid error document, Exp: %s Found: %s",
+				errorFile, rootEntry.Metadata()[manifest.WebsiteErrorDocumentPathKey])
+		}
+	}
+
+	t.Run("directory", func(t *testing.T) {
+		newReference, err := repair.DirectoryRepair(
+			ctx,
+			oldReference,
+			repair.WithStore(store),
+			repair.WithIndexDocument("index.html"),
+			repair.WithErrorDocument("404.html"),
+		)
+		if err != nil {
+			t.Fatal(err)
+		}
+		validateRoot(t, newReference, "index.html", "404.html")
+	})
+
+	t.Run("file", func(t *testing.T) {
+		newReference, err := repair.FileRepair(
+			ctx,
+			files[0].oldReference,
+			repair.WithStore(store),
+			repair.WithErrorDocument("index.html"),
+		)
+		if err != nil {
+			t.Fatal(err)
+		}
+		validateRoot(t, newReference, "index.html", "index.html")
+	})
+}
+
+func TestRepairMimeOverrides(t *testing.T) {
+	ctx := context.Background()
+	store := mock.NewStorer()
+
+	files := []*fEntry{
+		{
+			filename:    "README.MD",
+			contentType: "application/octet-stream",
+			size:        swarm.ChunkSize,
+		},
+		{
+			filename:    "index.html",
+			contentType: "text/html; charset=utf-8",
+			size:        swarm.ChunkSize,
+		},
+	}
+
+	oldReference, err := createDirOldFormat(ctx, store, "index.html", "", files)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	newReference, err := repair.DirectoryRepair(
+		ctx,
+		oldReference,
+		repair.WithStore(store),
+		repair.WithMimeOverrides(map[string]string{"md": "text/markdown; charset=utf-8"}),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m, err := manifest.NewDefaultManifestReference(
+		newReference,
+		loadsave.New(store, storage.ModePutUpload, false),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for path, exp := range map[string]string{
+		"README.MD":  "text/markdown; charset=utf-8",
+		"index.html": "text/html; charset=utf-8",
+	} {
+		e, err := m.Lookup(ctx, path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if found := e.Metadata()[manifest.EntryMetadataContentTypeKey]; found != exp {
+			t.Fatalf("Invalid content type of %s, Exp: %s Found: %s", path, exp, found)
+		}
+	}
+}
+
+func TestDirectoryRepairDirectoryMetadata(t *testing.T) {
+	ctx := context.Background()
+	store := mock.NewStorer()
+
+	files := []*fEntry{
+		{
+			filename:    "index.html",
+			contentType: "text/html; charset=utf-8",
+			size:        swarm.ChunkSize,
+		},
+		{
+			dir:         "c",
+			filename:    "d.html",
+			contentType: "text/html; charset=utf-8",
+			size:        swarm.ChunkSize,
+		},
+		{
+			dir:         "c/f",
+			filename:    "404.html",
+			contentType: "text/html; charset=utf-8",
+			size:        swarm.ChunkSize,
+		},
+	}
+	dirMtdt := map[string]map[string]string{
+		"c/":   {manifest.WebsiteIndexDocumentSuffixKey: "d.html"},
+		"c/f/": {manifest.WebsiteErrorDocumentPathKey: "404.html"},
+	}
+
+	extra := make(map[string]manifest.Entry)
+	for path, mtdt := range dirMtdt {
+		extra[path] = manifest.NewEntry(swarm.ZeroAddress, mtdt)
+	}
+	oldReference, err := createDirOldFormatWithEntries(ctx, store, "index.html", "", files, extra)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the metadata is read from the node, as a loaded node with forks is no longer
+	// typed as a value and a lookup of the directory entry would not find it
+	lookupMetadata := func(t *testing.T, newReference swarm.Address, path string) map[string]string {
+		t.Helper()
+
+		n, err := mantaray.NewNodeRef(newReference.Bytes()).LookupNode(
+			ctx,
+			[]byte(path),
+			loadsave.New(store, storage.ModePutUpload, false),
+		)
+		if errors.Is(err, mantaray.ErrNotFound) {
+			return nil
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		return n.Metadata()
+	}
+
+	t.Run("preserve", func(t *testing.T) {
+		newReference, err := repair.DirectoryRepair(
+			ctx,
+			oldReference,
+			repair.WithStore(store),
+			repair.WithDirectoryMetadata(true),
+		)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for path, mtdt := range dirMtdt {
+			found := lookupMetadata(t, newReference, path)
+			for k, v := range mtdt {
+				if found[k] != v {
+					t.Fatalf("Invalid metadata %s of directory %s, Exp: %s Found: %s", k, path, v, found[k])
+				}
+			}
+		}
+		for _, f := range files {
+			if lookupMetadata(t, newReference, filepath.Join(f.dir, f.filename)) == nil {
+				t.Fatalf("missing file %s", f.filename)
+			}
+		}
+	})
+
+	t.Run("default", func(t *testing.T) {
+		newReference, err := repair.DirectoryRepair(ctx, oldReference, repair.WithStore(store))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for path := range dirMtdt {
+			if len(lookupMetadata(t, newReference, path)) != 0 {
+				t.Fatalf("unexpected metadata of directory %s", path)
+			}
+		}
+	})
+}
+
+func TestDirectoryRepairFeed(t *testing.T) {
+	ctx := context.Background()
+	store := mock.NewStorer()
+
+	files := []*fEntry{
+		{
+			filename:    "index.html",
+			contentType: "text/html; charset=utf-8",
+			size:        swarm.ChunkSize,
+		},
+	}
+	feedReference := swarm.MustParseHexAddress("2387e8e7d8a48c2a9339c97c1dc3461a9a7aa07e994c5cb8b38fd7c1b3e6ea48")
+	feedMtdt := map[string]string{
+		"swarm-feed-owner": "8d3766440f0d7b949a5e32995d09619a7f86e632",
+		"swarm-feed-topic": "746f706963",
+		"swarm-feed-type":  "Sequence",
+	}
+
+	oldReference, err := createDirOldFormatWithEntries(ctx, store, "index.html", "", files, map[string]manifest.Entry{
+		"news": manifest.NewEntry(feedReference, feedMtdt),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	newReference, err := repair.DirectoryRepair(ctx, oldReference, repair.WithStore(store))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m, err := manifest.NewDefaultManifestReference(
+		newReference,
+		loadsave.New(store, storage.ModePutUpload, false),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	e, err := m.Lookup(ctx, "news")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !e.Reference().Equal(feedReference) {
+		t.Fatalf("Invalid feed reference, Exp: %s Found: %s", feedReference, e.Reference())
+	}
+	for k, v := range feedMtdt {
+		if e.Metadata()[k] != v {
+			t.Fatalf("Invalid feed metadata %s, Exp: %s Found: %s", k, v, e.Metadata()[k])
+		}
+	}
+	e, err = m.Lookup(ctx, "index.html")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !e.Reference().Equal(files[0].reference) {
+		t.Fatalf("Invalid manifest file reference, Exp: %s Found: %s", files[0].reference, e.Reference())
+	}
+}
+
+func TestVerify(t *testing.T) {
+	ctx := context.Background()
+	store := mock.NewStorer()
+
+	files := []*fEntry{
+		{
+			filename:    "a.txt",
+			contentType: "text/plain; charset=utf-8",
+			size:        swarm.ChunkSize,
+		},
+		{
+			filename:    "b.jpeg",
+			contentType: "image/jpeg; charset=utf-8",
+			size:        swarm.ChunkSize * 2,
+		},
+		{
+			dir:         "c",
+			filename:    "d.txt",
+			contentType: "text/plain; charset=utf-8",
+			size:        swarm.ChunkSize / 2,
+		},
+	}
+
+	oldReference, err := createDirOldFormat(ctx, store, "a.txt", "", files)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	newReference, err := repair.DirectoryRepair(ctx, oldReference, repair.WithStore(store))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	diffs, err := repair.Verify(ctx, oldReference, newReference, repair.WithStore(store))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(diffs) != 0 {
+		t.Fatalf("unexpected differences %v", diffs)
+	}
+
+	// a tampered manifest without a.txt, with another file at b.jpeg and an extra
+	// file. It is built anew, as removing a path from a loaded mantaray leaves the
+	// node with its old reference and the removal is not stored
+	ls := loadsave.New(store, storage.ModePutUpload, false)
+	m, err := manifest.NewDefaultManifest(ls, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tampered := map[string]swarm.Address{
+		"b.jpeg":    files[2].reference,
+		"c/d.txt":   files[2].reference,
+		"extra.txt": files[0].reference,
+	}
+	for path, ref := range tampered {
+		err = m.Add(ctx, path, manifest.NewEntry(ref, nil))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	tamperedReference, err := m.Store(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	diffs, err = repair.Verify(ctx, oldReference, tamperedReference, repair.WithStore(store))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := map[string]repair.Difference{
+		"a.txt": {
+			Path:         "a.txt",
+			OldReference: files[0].reference,
+			NewReference: swarm.ZeroAddress,
+		},
+		"b.jpeg": {
+			Path:         "b.jpeg",
+			OldReference: files[1].reference,
+			NewReference: files[2].reference,
+		},
+		"extra.txt": {
+			Path:         "extra.txt",
+			OldReference: swarm.ZeroAddress,
+			NewReference: files[0].reference,
+		},
+	}
+	if len(diffs) != len(expected) {
+		t.Fatalf("unexpected differences, Exp: %d Found: %v", len(expected), diffs)
+	}
+	for _, d := range diffs {
+		exp, found := expected[d.Path]
+		if !found {
+			t.Fatalf("unexpected difference %s", d)
+		}
+		if !d.OldReference.Equal(exp.OldReference) || !d.NewReference.Equal(exp.NewReference) {
+			t.Fatalf("unexpected difference, Exp: %s Found: %s", &exp, d)
+		}
+	}
+}
+
+func TestList(t *testing.T) {
+	ctx := context.Background()
+	store := mock.NewStorer()
+
+	files := []*fEntry{
+		{
+			filename:    "a.txt",
+			contentType: "text/plain; charset=utf-8",
+			size:        swarm.ChunkSize,
+		},
+		{
+			dir:         "c",
+			filename:    "d.jpeg",
+			contentType: "image/jpeg; charset=utf-8",
+			size:        swarm.ChunkSize*2 + 10,
+		},
+	}
+
+	oldReference, err := createDirOldFormat(ctx, store, "a.txt", "", files)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	entries, err := repair.List(ctx, oldReference, repair.WithStore(store))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != len(files) {
+		t.Fatalf("Invalid number of entries, Exp: %d Found: %d", len(files), len(entries))
+	}
+	for _, f := range files {
+		path := filepath.Join(f.dir, f.filename)
+		var found *repair.ListEntry
+		for _, e := range entries {
+			if e.Path == path {
+				found = e
+			}
+		}
+		if found == nil {
+			t.Fatalf("Entry %s not listed", path)
+		}
+		if !found.Reference.Equal(f.reference) {
+			t.Fatalf("Invalid reference of %s, Exp: %s Found: %s", path, f.reference, found.Reference)
+		}
+		if found.ContentType != f.contentType {
+			t.Fatalf("Invalid content type of %s, Exp: %s Found: %s", path, f.contentType, found.ContentType)
+		}
+		if found.Size != f.size {
+			t.Fatalf("Invalid size of %s, Exp: %d Found: %d", path, f.size, found.Size)
+		}
+	}
+}
+
+func TestInspect(t *testing.T) {
+	ctx := context.Background()
+	store := mock.NewStorer()
+
+	f := &fEntry{
+		filename:    "a.txt",
+		contentType: "text/plain; charset=utf-8",
+		size:        10,
+		mtdtSize:    10,
+	}
+	fileReference, err := createFileOldFormat(ctx, store, f)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	i, err := repair.Inspect(ctx, fileReference, repair.WithStore(store))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if i.Filename != f.filename || i.MimeType != f.contentType || i.Size != f.mtdtSize {
+		t.Fatalf("Invalid metadata, Exp: %s %s %d Found: %s %s %d", f.filename, f.contentType, f.mtdtSize, i.Filename, i.MimeType, i.Size)
+	}
+	var raw map[string]interface{}
+	if err := json.Unmarshal(i.RawMetadata, &raw); err != nil {
+		t.Fatal(err)
+	}
+	if raw["filename"] != f.filename {
+		t.Fatalf("Invalid raw metadata %s", i.RawMetadata)
+	}
+	if i.Reference.IsZero() || i.MetadataReference.IsZero() {
+		t.Fatalf("Missing references %s %s", i.Reference, i.MetadataReference)
+	}
+}
+
+func TestWalkOldDirectory(t *testing.T) {
+	ctx := context.Background()
+	store := mock.NewStorer()
+
+	files := []*fEntry{
+		{
+			filename:    "a.txt",
+			contentType: "text/plain; charset=utf-8",
+			size:        swarm.ChunkSize*2 + 10,
+		},
+		{
+			dir:         "c",
+			filename:    "empty.txt",
+			contentType: "text/plain; charset=utf-8",
+		},
+	}
+	feedReference := swarm.MustParseHexAddress("2387e8e7d8a48c2a9339c97c1dc3461a9a7aa07e994c5cb8b38fd7c1b3e6ea48")
+	oldReference, err := createDirOldFormatWithEntries(ctx, store, "a.txt", "", files, map[string]manifest.Entry{
+		"news": manifest.NewEntry(feedReference, map[string]string{
+			"swarm-feed-owner": "8d3766440f0d7b949a5e32995d09619a7f86e632",
+			"swarm-feed-topic": "746f706963",
+		}),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the store is only read, storing the empty file would fail the walk
+	walked := make(map[string]*repair.OldFile)
+	var paths []string
+	err = repair.WalkOldDirectory(ctx, store, oldReference, func(f *repair.OldFile) error {
+		walked[f.Path] = f
+		paths = append(paths, f.Path)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(walked) != 3 {
+		t.Fatalf("Invalid number of entries, Exp: 3 Found: %d", len(walked))
+	}
+	if !sort.StringsAreSorted(paths) {
+		t.Fatalf("Entries not walked in path order: %v", paths)
+	}
+	for _, f := range files {
+		path := filepath.Join(f.dir, f.filename)
+		found, ok := walked[path]
+		if !ok {
+			t.Fatalf("Entry %s not walked", path)
+		}
+		if !found.Reference.Equal(f.reference) {
+			t.Fatalf("Invalid reference of %s, Exp: %s Found: %s", path, f.reference, found.Reference)
+		}
+		if found.Filename != f.filename || found.MimeType != f.contentType || found.Size != f.size {
+			t.Fatalf("Invalid entry of %s: %+v", path, found)
+		}
+	}
+	if feed := walked["news"]; !feed.Feed || !feed.Reference.Equal(feedReference) {
+		t.Fatalf("Invalid feed entry: %+v", feed)
+	}
+
+	stop := errors.New("stop")
+	err = repair.WalkOldDirectory(ctx, store, oldReference, func(f *repair.OldFile) error {
+		return stop
+	})
+	if !errors.Is(err, stop) {
+		t.Fatalf("expected the walk to stop, got %v", err)
+	}
+}
+
+func TestSyncUpdater(t *testing.T) {
+	buf := bytes.NewBuffer(nil)
+	updater := repair.NewSyncUpdater(buf)
+
+	const (
+		workers  = 10
+		messages = 100
+	)
+
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			upd := updater.WithPrefix(fmt.Sprintf("worker-%d: ", i))
+			for j := 0; j < messages; j++ {
+				upd.Update(fmt.Sprintf("message %d", j))
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	counts := make(map[string]int)
+	for _, line := range strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n") {
+		var worker, message int
+		_, err := fmt.Sscanf(line, "worker-%d: message %d", &worker, &message)
+		if err != nil {
+			t.Fatalf("garbled line %q: %v", line, err)
+		}
+		counts[fmt.Sprintf("worker-%d", worker)]++
+	}
+	if len(counts) != workers {
+		t.Fatalf("unexpected workers, Exp: %d Found: %d", workers, len(counts))
+	}
+	for w, c := range counts {
+		if c != messages {
+			t.Fatalf("unexpected message count for %s, Exp: %d Found: %d", w, messages, c)
+		}
+	}
+}
+
+func TestFileLogUpdater(t *testing.T) {
+	fname := filepath.Join(t.TempDir(), "progress.log")
+
+	write := func(mode repair.LogFileMode, msg string) {
+		t.Helper()
+		updater, err := repair.NewFileLogUpdater(fname, mode)
+		if err != nil {
+			t.Fatal(err)
+		}
+		updater.WithPrefix("ref: ").Update(msg)
+		if err := updater.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	lines := func(fname string) []string {
+		t.Helper()
+		b, err := ioutil.ReadFile(fname)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var msgs []string
+		for _, line := range strings.Split(strings.TrimSuffix(string(b), "\n"), "\n") {
+			fields := strings.SplitN(line, " ", 2)
+			if _, err := time.Parse(time.RFC3339, fields[0]); err != nil || len(fields) != 2 {
+				t.Fatalf("line without timestamp %q", line)
+			}
+			msgs = append(msgs, fields[1])
+		}
+		return msgs
+	}
+
+	write(repair.LogAppend, "first")
+	write(repair.LogAppend, "second")
+	if got := lines(fname); !reflect.DeepEqual(got, []string{"ref: first", "ref: second"}) {
+		t.Fatalf("unexpected appended lines %v", got)
+	}
+	write(repair.LogRotate, "third")
+	if got := lines(fname + ".1"); len(got) != 2 {
+		t.Fatalf("unexpected rotated lines %v", got)
+	}
+	if got := lines(fname); !reflect.DeepEqual(got, []string{"ref: third"}) {
+		t.Fatalf("unexpected lines after rotation %v", got)
+	}
+	write(repair.LogTruncate, "fourth")
+	if got := lines(fname); !reflect.DeepEqual(got, []string{"ref: fourth"}) {
+		t.Fatalf("unexpected truncated lines %v", got)
+	}
+}
+
+func TestFindRoots(t *testing.T) {
+	ctx := context.Background()
+	store := mock.NewStorer()
+
+	dirFiles := []*fEntry{
+		{
+			filename:    "a.txt",
+			contentType: "text/plain; charset=utf-8",
+			size:        swarm.ChunkSize,
+		},
+		{
+			dir:         "b",
+			filename:    "c.jpeg",
+			contentType: "image/jpeg; charset=utf-8",
+			size:        swarm.ChunkSize * 2,
+		},
+	}
+	dirReference, err := createDirOldFormat(ctx, store, "", "", dirFiles)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f := &fEntry{
+		filename:    "d.txt",
+		contentType: "text/plain; charset=utf-8",
+		size:        swarm.ChunkSize / 2,
+	}
+	fileReference, err := createFileOldFormat(ctx, store, f)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, addr := range []swarm.Address{dirReference, fileReference, dirFiles[0].oldReference} {
+		ch, err := store.Get(ctx, storage.ModeGetRequest, addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !repair.IsEntryChunk(ch) {
+			t.Fatalf("expected %s to be an entry chunk", addr)
+		}
+	}
+	ch, err := store.Get(ctx, storage.ModeGetRequest, f.reference)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if repair.IsEntryChunk(ch) {
+		t.Fatal("unexpected file data entry chunk")
+	}
+
+	candidates := []swarm.Address{
+		dirFiles[0].oldReference,
+		dirReference,
+		f.reference,
+		fileReference,
+		dirFiles[1].oldReference,
+	}
+	roots, err := repair.FindRoots(ctx, candidates, repair.WithStore(store))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(roots) != 2 {
+		t.Fatalf("unexpected root count, Exp: 2 Found: %d", len(roots))
+	}
+	if !roots[0].Reference.Equal(dirReference) || !roots[0].Directory {
+		t.Fatalf("unexpected directory root %s", roots[0].Reference)
+	}
+	if !roots[1].Reference.Equal(fileReference) || roots[1].Directory {
+		t.Fatalf("unexpected file root %s", roots[1].Reference)
+	}
+}
+
+func TestDirectoryRepairLogging(t *testing.T) {
+	ctx := context.Background()
+	store := mock.NewStorer()
+
+	files := []*fEntry{
+		{
+			filename:    "a.txt",
+			contentType: "text/plain; charset=utf-8",
+			size:        swarm.ChunkSize,
+		},
+		{
+			filename:    "b.txt",
+			contentType: "text/plain; charset=utf-8",
+			size:        swarm.ChunkSize,
+		},
+	}
+	oldReference, err := createDirOldFormat(ctx, store, "", "", files)
+	if err != nil {
+		t.Fatal(err)
+	}
+	brokenStore := &failStore{Storer: store, missing: []swarm.Address{files[1].oldReference}}
+
+	buf := bytes.NewBuffer(nil)
+	newReference, err := repair.DirectoryRepair(
+		ctx,
+		oldReference,
+		repair.WithStore(brokenStore),
+		repair.WithSkipErrors(true),
+		repair.WithLogger(logging.New(buf, logrus.InfoLevel)),
+	)
+	var skipped *repair.SkippedError
+	if !errors.As(err, &skipped) {
+		t.Fatalf("expected skipped error, got %v", err)
+	}
+
+	logs := buf.String()
+	for _, msg := range []string{
+		"Repairing directory reference " + oldReference.String(),
+		"Repaired file a.txt with reference " + files[0].reference.String(),
+		"Skipping file b.txt",
+		"Created new directory manifest with reference " + newReference.String(),
+	} {
+		if !strings.Contains(logs, msg) {
+			t.Fatalf("missing log message %q in %q", msg, logs)
+		}
+	}
+	if strings.Contains(logs, "Read old file entry") {
+		t.Fatal("unexpected debug message at info level")
+	}
+}
+
+// putEntry creates a new file entry with the given reference.
+func createFileOldFormat(ctx context.Context, store storage.Putter, f *fEntry) (swarm.Address, error) {
+	// set up splitter to process the metadata
+	s := splitter.NewSimpleSplitter(store, storage.ModePutUpload)
+
+	// empty files are referenced by the zero address
+	fileBytesAddr := swarm.NewAddress(make([]byte, swarm.HashSize))
+	if f.size > 0 {
+		fdata := make([]byte, f.size)
+		_, err := rand.Read(fdata)
+		if err != nil {
+			return swarm.ZeroAddress, err
+		}
+		fileBuf := bytes.NewBuffer(fdata)
+		fileBytesReader := io.LimitReader(fileBuf, int64(len(fdata)))
+		fileBytesReadCloser := ioutil.NopCloser(fileBytesReader)
+		fileBytesAddr, err = s.Split(ctx, fileBytesReadCloser, int64(len(fdata)), f.encrypt)
+		if err != nil {
+			return swarm.ZeroAddress, err
+		}
+	}
+
+	metadata := entry.NewMetadata(f.filename)
+	metadata.MimeType = f.contentType
+	metadata.Size = f.mtdtSize
+
+	// serialize metadata and send it to splitter
+	metadataBytes, err := json.Marshal(metadata)
+	if err != nil {
+		return swarm.ZeroAddress, err
+	}
+	// logger.Debugf("metadata contents: %s", metadataBytes)
+
+	// first add metadata
+	metadataBuf := bytes.NewBuffer(metadataBytes)
+	metadataReader := io.LimitReader(metadataBuf, int64(len(metadataBytes)))
+	metadataReadCloser := ioutil.NopCloser(metadataReader)
+	metadataAddr, err := s.Split(ctx, metadataReadCloser, int64(len(metadataBytes)), f.encrypt)
+	if err != nil {
+		return swarm.ZeroAddress, err
+	}
+
+	// create entry from given reference and metadata,
+	// serialize and send to splitter
+	fileEntry := entry.New(fileBytesAddr, metadataAddr)
+	fileEntryBytes, err := fileEntry.MarshalBinary()
+	if err != nil {
+		return swarm.ZeroAddress, err
+	}
+	fileEntryBuf := bytes.NewBuffer(fileEntryBytes)
+	fileEntryReader := io.LimitReader(fileEntryBuf, int64(len(fileEntryBytes)))
+	fileEntryReadCloser := ioutil.NopCloser(fileEntryReader)
+	fileEntryAddr, err := s.Split(ctx, fileEntryReadCloser, int64(len(fileEntryBytes)), f.encrypt)
+	if err != nil {
+		return swarm.ZeroAddress, err
+	}
+
+	f.reference = fileBytesAddr
+	f.oldReference = fileEntryAddr
+	return fileEntryAddr, nil
+}
+
+func createDirOldFormat(
+	ctx context.Context,
+	store storage.Storer,
+	indexFile,
+	errorFile string,
+	files []*fEntry,
+) (swarm.Address, error) {
+	return createDirOldFormatWithEntries(ctx, store, indexFile, errorFile, files, nil)
+}
+
+// createDirOldFormatWithEntries is like createDirOldFormat, with the extra entries
+// added to the manifest as they are
+func createDirOldFormatWithEntries(
+	ctx context.Context,
+	store storage.Storer,
+	indexFile,
+	errorFile string,
+	files []*fEntry,
+	extra map[string]manifest.Entry,
+) (swarm.Address, error) {
+	return createOldDirectory(ctx, store, true, indexFile, errorFile, files, extra)
+}
+
+// createOldDirectory creates the directory of the old format, with the root path
+// entry only when rootEntry is set, as some old manifests do not have it
+func createOldDirectory(
+	ctx context.Context,
+	store storage.Storer,
+	rootEntry bool,
+	indexFile,
+	errorFile string,
+	files []*fEntry,
+	extra map[string]manifest.Entry,
+) (swarm.Address, error) {
+	m, err := manifest.NewDefaultManifest(
+		loadsave.New(store, storage.ModePutUpload, false),
+		false,
+	)
+	if err != nil {
+		return swarm.ZeroAddress, err
+	}
+
+	var rootMtdt map[string]string
+
+	if indexFile != "" || errorFile != "" {
+		rootMtdt = make(map[string]string)
+		if indexFile != "" {
+			rootMtdt[manifest.WebsiteIndexDocumentSuffixKey] = indexFile
+		}
+		if errorFile != "" {
+			rootMtdt[manifest.WebsiteErrorDocumentPathKey] = errorFile
+		}
+	}
+
+	if rootEntry {
+		err = m.Add(ctx, manifest.RootPath, manifest.NewEntry(swarm.ZeroAddress, rootMtdt))
+		if err != nil {
+			return swarm.ZeroAddress, err
+		}
+	}
+
+	for _, f := range files {
+		fileRef, err := createFileOldFormat(ctx, store, f)
+		if err != nil {
+			return swarm.ZeroAddress, err
+		}
+		err = m.Add(ctx, filepath.Join(f.dir, f.filename), manifest.NewEntry(fileRef, nil))
+		if err != nil {
+			return swarm.ZeroAddress, err
+		}
+	}
+	for path, e := range extra {
+		err = m.Add(ctx, path, e)
+		if err != nil {
+			return swarm.ZeroAddress, err
+		}
+	}
+	newManifest, err := m.Store(ctx)
+	if err != nil {
+		return swarm.ZeroAddress, err
+	}
+	// Create a new collection entry for holding the manifest
+	s := splitter.NewSimpleSplitter(store, storage.ModePutUpload)
+
+	mMtdt := entry.NewMetadata(newManifest.String())
+	mMtdt.MimeType = m.Type()
+	manifestMtdtBytes, err := json.Marshal(mMtdt)
+	if err != nil {
+		return swarm.ZeroAddress, err
+	}
+	metadataBuf := bytes.NewBuffer(manifestMtdtBytes)
+	metadataReader := io.LimitReader(metadataBuf, int64(len(manifestMtdtBytes)))
+	metadataReadCloser := ioutil.NopCloser(metadataReader)
+	metadataAddr, err := s.Split(ctx, metadataReadCloser, int64(len(manifestMtdtBytes)), false)
+	if err != nil {
+		return swarm.ZeroAddress, err
+	}
+
+	mEntry := entry.New(newManifest, metadataAddr)
+	manifestEntryBytes, err := mEntry.MarshalBinary()
+	if err != nil {
+		return swarm.ZeroAddress, err
+	}
+	entryBuf := bytes.NewBuffer(manifestEntryBytes)
+	entryReader := io.LimitReader(entryBuf, int64(len(manifestEntryBytes)))
+	entryReadCloser := ioutil.NopCloser(entryReader)
+	newEntryAddr, err := s.Split(ctx, entryReadCloser, int64(len(manifestEntryBytes)), false)
+	if err != nil {
+		return swarm.ZeroAddress, err
+	}
+
+	return newEntryAddr, nil
+}
+
+func TestDryRun(t *testing.T) {
+	ctx := context.Background()
+	store := mock.NewStorer()
+
+	files := []*fEntry{
+		{
+			filename:    "a.txt",
+			contentType: "text/plain; charset=utf-8",
+			size:        swarm.ChunkSize,
+		},
+		{
+			filename:    "b/c.txt",
+			contentType: "text/plain; charset=utf-8",
+			size:        10,
+		},
+	}
+	oldReference, err := createDirOldFormat(ctx, store, "a.txt", "", files)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fileReference, err := createFileOldFormat(ctx, store, files[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		name      string
+		addr      swarm.Address
+		directory bool
+		repairFn  func(context.Context, swarm.Address, ...repair.Option) (swarm.Address, error)
+	}{
+		{"file", fileReference, false, repair.FileRepair},
+		{"directory", oldReference, true, repair.DirectoryRepair},
+	} {
+		ms := &modeStore{Storer: store}
+		res, err := repair.DryRun(ctx, tc.addr, tc.directory, repair.WithStore(ms))
+		if err != nil {
+			t.Fatalf("%s: %v", tc.name, err)
+		}
+		if len(ms.modes) != 0 {
+			t.Fatalf("%s: dry run stored %d chunks", tc.name, len(ms.modes))
+		}
+		if res.Chunks == 0 || res.Bytes == 0 {
+			t.Fatalf("%s: no chunks counted", tc.name)
+		}
+
+		newReference, err := tc.repairFn(ctx, tc.addr, repair.WithStore(ms))
+		if err != nil {
+			t.Fatalf("%s: %v", tc.name, err)
+		}
+		if !res.Reference.Equal(newReference) {
+			t.Fatalf("%s: invalid reference, Exp: %s Found: %s", tc.name, newReference, res.Reference)
+		}
+		if res.Chunks > len(ms.modes) {
+			t.Fatalf("%s: counted %d chunks, the repair stored %d", tc.name, res.Chunks, len(ms.modes))
+		}
+	}
+}
+
+func TestDirectoryRepairDeterministic(t *testing.T) {
+	ctx := context.Background()
+	store := mock.NewStorer()
+
+	files := []*fEntry{
+		{
+			filename:    "index.html",
+			contentType: "text/html; charset=utf-8",
+			size:        swarm.ChunkSize,
+		},
+		{
+			dir:         "img",
+			filename:    "a.jpeg",
+			contentType: "image/jpeg; charset=utf-8",
+			size:        swarm.ChunkSize * 2,
+		},
+		{
+			dir:         "img",
+			filename:    "b.jpeg",
+			contentType: "image/jpeg; charset=utf-8",
+			size:        10,
+		},
+		{
+			dir:         "css",
+			filename:    "style.css",
+			contentType: "text/css; charset=utf-8",
+			size:        100,
+		},
+	}
+	oldReference, err := createDirOldFormat(ctx, store, "index.html", "", files)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	first, err := repair.DirectoryRepair(ctx, oldReference, repair.WithStore(store))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 5; i++ {
+		newReference, err := repair.DirectoryRepair(ctx, oldReference, repair.WithStore(store))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !newReference.Equal(first) {
+			t.Fatalf("repair %d: unexpected reference, Exp: %s Found: %s", i+2, first, newReference)
+		}
+	}
+}
+
+func TestDirectoryRepairSymlinks(t *testing.T) {
+	ctx := context.Background()
+	store := mock.NewStorer()
+
+	files := []*fEntry{
+		{
+			filename:    "index.html",
+			contentType: "text/html; charset=utf-8",
+			size:        swarm.ChunkSize,
+		},
+		{
+			dir:         "docs",
+			filename:    "a.txt",
+			contentType: "text/plain; charset=utf-8",
+			size:        10,
+		},
+	}
+	symlinks := map[string]manifest.Entry{
+		// relative to the directory of the symlink
+		"docs/latest.txt": manifest.NewEntry(swarm.ZeroAddress, map[string]string{"swarm-symlink-target": "a.txt"}),
+		// from the root, through the other symlink
+		"home.txt": manifest.NewEntry(swarm.ZeroAddress, map[string]string{"swarm-symlink-target": "/docs/latest.txt"}),
+	}
+	oldReference, err := createDirOldFormatWithEntries(ctx, store, "index.html", "", files, symlinks)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	lookup := func(t *testing.T, ref swarm.Address, path string) (manifest.Entry, error) {
+		t.Helper()
+		m, err := manifest.NewDefaultManifestReference(ref, loadsave.New(store, storage.ModePutUpload, false))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return m.Lookup(ctx, path)
+	}
+
+	t.Run("error", func(t *testing.T) {
+		_, err := repair.DirectoryRepair(ctx, oldReference, repair.WithStore(store))
+		if !errors.Is(err, repair.ErrSymlink) {
+			t.Fatalf("expected symlink error, found %v", err)
+		}
+
+		_, err = repair.DirectoryRepair(ctx, oldReference, repair.WithStore(store), repair.WithSkipErrors(true))
+		var skipped *repair.SkippedError
+		if !errors.As(err, &skipped) || len(skipped.Files) != len(symlinks) {
+			t.Fatalf("expected %d skipped symlinks, found %v", len(symlinks), err)
+		}
+	})
+
+	t.Run("skip", func(t *testing.T) {
+		newReference, err := repair.DirectoryRepair(ctx, oldReference, repair.WithStore(store), repair.WithSymlinkPolicy(repair.SymlinkSkip))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for path := range symlinks {
+			if _, err := lookup(t, newReference, path); !errors.Is(err, manifest.ErrNotFound) {
+				t.Fatalf("symlink %s: expected not found, found %v", path, err)
+			}
+		}
+		if _, err := lookup(t, newReference, "docs/a.txt"); err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	t.Run("resolve", func(t *testing.T) {
+		newReference, err := repair.DirectoryRepair(ctx, oldReference, repair.WithStore(store), repair.WithSymlinkPolicy(repair.SymlinkResolve))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for path := range symlinks {
+			e, err := lookup(t, newReference, path)
+			if err != nil {
+				t.Fatalf("symlink %s: %v", path, err)
+			}
+			if !e.Reference().Equal(files[1].reference) {
+				t.Fatalf("symlink %s: invalid reference, Exp: %s Found: %s", path, files[1].reference, e.Reference())
+			}
+		}
+	})
+
+	t.Run("unresolvable", func(t *testing.T) {
+		oldReference, err := createDirOldFormatWithEntries(ctx, store, "", "", files, map[string]manifest.Entry{
+			"loop.txt": manifest.NewEntry(swarm.ZeroAddress, map[string]string{"swarm-symlink-target": "loop.txt"}),
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = repair.DirectoryRepair(ctx, oldReference, repair.WithStore(store), repair.WithSymlinkPolicy(repair.SymlinkResolve))
+		if !errors.Is(err, repair.ErrSymlinkTarget) {
+			t.Fatalf("expected unresolvable symlink error, found %v", err)
+		}
+	})
+}
+
+func TestExportTar(t *testing.T) {
+	ctx := context.Background()
+	store := mock.NewStorer()
+
+	files := []*fEntry{
+		{
+			filename:    "index.html",
+			contentType: "text/html; charset=utf-8",
+			size:        swarm.ChunkSize,
+		},
+		{
+			dir:         "img",
+			filename:    "a.jpeg",
+			contentType: "image/jpeg; charset=utf-8",
+			size:        swarm.ChunkSize*2 + 10,
+		},
+		{
+			dir:         "img",
+			filename:    "empty.jpeg",
+			contentType: "image/jpeg; charset=utf-8",
+			size:        0,
+		},
+	}
+	oldReference, err := createDirOldFormat(ctx, store, "index.html", "", files)
+	if err != nil {
+		t.Fatal(err)
+	}
+	newReference, err := repair.DirectoryRepair(ctx, oldReference, repair.WithStore(store))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	buf := bytes.NewBuffer(nil)
+	if err := repair.ExportTar(ctx, newReference, buf, repair.WithStore(store)); err != nil {
+		t.Fatal(err)
+	}
+
+	exported := make(map[string][]byte)
+	tr := tar.NewReader(buf)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err := ioutil.ReadAll(tr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		exported[hdr.Name] = data
+	}
+	if len(exported) != len(files) {
+		t.Fatalf("unexpected exported files, Exp: %d Found: %d", len(files), len(exported))
+	}
+	for _, f := range files {
+		path := filepath.Join(f.dir, f.filename)
+		data, found := exported[path]
+		if !found {
+			t.Fatalf("file %s not exported", path)
+		}
+		if int64(len(data)) != f.size {
+			t.Fatalf("invalid size of %s, Exp: %d Found: %d", path, f.size, len(data))
+		}
+		if f.size == 0 {
+			continue
+		}
+		j, _, err := joiner.New(ctx, store, f.reference)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := bytes.NewBuffer(nil)
+		if _, err := file.JoinReadAll(ctx, j, want); err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(data, want.Bytes()) {
+			t.Fatalf("invalid content of %s", path)
+		}
+	}
+}
+
+func TestRepairWithoutUpdaters(t *testing.T) {
+	ctx := context.Background()
+	store := mock.NewStorer()
+
+	files := []*fEntry{
+		{
+			filename:    "a.txt",
+			contentType: "text/plain; charset=utf-8",
+			size:        swarm.ChunkSize,
+		},
+	}
+	oldReference, err := createDirOldFormat(ctx, store, "a.txt", "", files)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fileReference, err := createFileOldFormat(ctx, store, files[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// no progress options at all
+	if _, err := repair.FileRepair(ctx, fileReference, repair.WithStore(store)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := repair.DirectoryRepair(ctx, oldReference, repair.WithStore(store)); err != nil {
+		t.Fatal(err)
+	}
+
+	// nil updaters, also nil pointers of an updater type
+	var syncUpdater *repair.SyncUpdater
+	for _, updater := range []repair.ProgressUpdater{nil, syncUpdater, syncUpdater.WithPrefix("prefix: ")} {
+		opts := []repair.Option{
+			repair.WithStore(store),
+			repair.WithProgressUpdater(updater),
+			repair.WithEventUpdater(nil),
+			repair.WithCountingProgressUpdater(nil),
+			repair.WithLogger(nil),
+		}
+		if _, err := repair.FileRepair(ctx, fileReference, opts...); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := repair.DirectoryRepair(ctx, oldReference, opts...); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestDirectoryRepairFlushInterval(t *testing.T) {
+	ctx := context.Background()
+	store := mock.NewStorer()
+
+	var files []*fEntry
+	for i := 0; i < 10; i++ {
+		files = append(files, &fEntry{
+			dir:         fmt.Sprintf("dir%d", i%3),
+			filename:    fmt.Sprintf("file%d.txt", i),
+			contentType: "text/plain; charset=utf-8",
+			size:        int64(10 + i),
+		})
+	}
+	// paths which others continue and paths longer than a fork of the manifest
+	for _, name := range []string{"a.txt", "a.txt.tmp", strings.Repeat("long", 10) + ".txt"} {
+		files = append(files, &fEntry{
+			dir:         "dir1",
+			filename:    name,
+			contentType: "text/plain; charset=utf-8",
+			size:        10,
+		})
+	}
+	oldReference, err := createDirOldFormat(ctx, store, "", "", files)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, n := range []int{1, 2, 3, len(files)} {
+		newReference, err := repair.DirectoryRepair(ctx, oldReference, repair.WithStore(store), repair.WithManifestFlushInterval(n))
+		if err != nil {
+			t.Fatalf("interval %d: %v", n, err)
+		}
+		diffs, err := repair.Verify(ctx, oldReference, newReference, repair.WithStore(store))
+		if err != nil {
+			t.Fatalf("interval %d: %v", n, err)
+		}
+		if len(diffs) != 0 {
+			t.Fatalf("interval %d: unexpected differences %v", n, diffs)
+		}
+		// the flushes depend on the entries only
+		again, err := repair.DirectoryRepair(ctx, oldReference, repair.WithStore(store), repair.WithManifestFlushInterval(n))
+		if err != nil {
+			t.Fatalf("interval %d: %v", n, err)
+		}
+		if !again.Equal(newReference) {
+			t.Fatalf("interval %d: unexpected reference, Exp: %s Found: %s", n, newReference, again)
+		}
+	}
+}
+
+func TestManifestAdderFlushMemory(t *testing.T) {
+	const files = 10000
+	ctx := context.Background()
+
+	path := func(i int) string {
+		return fmt.Sprintf("dir%03d/file%05d.txt", i/100, i)
+	}
+
+	// the heap held by the manifest after adding the files, which is stored on
+	// disk so that only the nodes in memory are on the heap
+	ls := &fileLoadSaver{dir: t.TempDir()}
+	build := func(flushInterval int) (uint64, swarm.Address) {
+		m, err := manifest.NewDefaultManifest(ls, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		adder := repair.NewDirectoryAdder(m, flushInterval)
+
+		var before, after runtime.MemStats
+		runtime.GC()
+		runtime.ReadMemStats(&before)
+		for i := 0; i < files; i++ {
+			ref := sha256.Sum256([]byte(path(i)))
+			err := adder.Add(ctx, path(i), manifest.NewEntry(swarm.NewAddress(ref[:]), map[string]string{
+				manifest.EntryMetadataFilenameKey:    filepath.Base(path(i)),
+				manifest.EntryMetadataContentTypeKey: "text/plain; charset=utf-8",
+			}))
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+		runtime.GC()
+		runtime.ReadMemStats(&after)
+
+		newReference, err := adder.Store(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if after.HeapAlloc < before.HeapAlloc {
+			return 0, newReference
+		}
+		return after.HeapAlloc - before.HeapAlloc, newReference
+	}
+
+	held, want := build(0)
+	flushedHeld, newReference := build(100)
+	if flushedHeld > held/4 {
+		t.Fatalf("flushed manifest holds %d bytes, the manifest in memory %d bytes", flushedHeld, held)
+	}
+
+	wantManifest, err := manifest.NewDefaultManifestReference(want, ls)
+	if err != nil {
+		t.Fatal(err)
+	}
+	newManifest, err := manifest.NewDefaultManifestReference(newReference, ls)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < files; i++ {
+		wantEntry, err := wantManifest.Lookup(ctx, path(i))
+		if err != nil {
+			t.Fatal(err)
+		}
+		e, err := newManifest.Lookup(ctx, path(i))
+		if err != nil {
+			t.Fatalf("file %s: %v", path(i), err)
+		}
+		if !e.Reference().Equal(wantEntry.Reference()) || !reflect.DeepEqual(e.Metadata(), wantEntry.Metadata()) {
+			t.Fatalf("file %s: unexpected entry, Exp: %v Found: %v", path(i), wantEntry, e)
+		}
+	}
+}
+
+// TestMantarayReload records how the mantaray version of go.mod loads the nodes of
+// a stored manifest again, which the manifest adder flushes around. A change of
+// mantaray which fails it allows canFlush to flush more entries
+func TestMantarayReload(t *testing.T) {
+	ctx := context.Background()
+	ls := loadsave.New(mock.NewStorer(), storage.ModePutUpload, false)
+	entry := func(path string) manifest.Entry {
+		ref := sha256.Sum256([]byte(path))
+		return manifest.NewEntry(swarm.NewAddress(ref[:]), nil)
+	}
+	// build adds the paths and stores the manifest before the ones in flushes
+	build := func(paths []string, flushes map[string]bool) (swarm.Address, error) {
+		m, err := manifest.NewDefaultManifest(ls, false)
+		if err != nil {
+			return swarm.ZeroAddress, err
+		}
+		for _, path := range paths {
+			if flushes[path] {
+				if _, err := m.Store(ctx); err != nil {
+					return swarm.ZeroAddress, err
+				}
+			}
+			if err := m.Add(ctx, path, entry(path)); err != nil {
+				return swarm.ZeroAddress, err
+			}
+		}
+		return m.Store(ctx)
+	}
+
+	t.Run("continued path", func(t *testing.T) {
+		ref, err := build([]string{"a.txt", "a.txt.tmp", "a.txt.tmq"}, map[string]bool{"a.txt.tmq": true})
+		if err != nil {
+			t.Fatal(err)
+		}
+		m, err := manifest.NewDefaultManifestReference(ref, ls)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := m.Lookup(ctx, "a.txt"); !errors.Is(err, manifest.ErrNotFound) {
+			t.Fatalf("expected the entry of the reloaded value node to be lost, got %v", err)
+		}
+	})
+
+	t.Run("no references", func(t *testing.T) {
+		m, err := manifest.NewDefaultManifest(ls, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := m.Add(ctx, "docs/", manifest.NewEntry(swarm.ZeroAddress, map[string]string{"k": "v"})); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := m.Store(ctx); err != nil {
+			t.Fatal(err)
+		}
+		defer func() {
+			if recover() == nil {
+				t.Fatal("expected loading the nodes stored without the size of their references to panic")
+			}
+		}()
+		_ = m.Add(ctx, "docs/a", entry("docs/a"))
+	})
+
+	t.Run("path separators", func(t *testing.T) {
+		paths := []string{"dir0/file0", "dir0/file1", "dir0/file2"}
+		want, err := build(paths, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ref, err := build(paths, map[string]bool{"dir0/file2": true})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ref.Equal(want) {
+			t.Fatal("expected the reference to change with the path separator flags of the reloaded nodes")
+		}
+	})
+}
+
+// fileLoadSaver stores the chunks of a manifest in files named after their hash
+type fileLoadSaver struct {
+	dir string
+}
+
+func (s *fileLoadSaver) Load(_ context.Context, ref []byte) ([]byte, error) {
+	return ioutil.ReadFile(filepath.Join(s.dir, hex.EncodeToString(ref)))
+}
+
+func (s *fileLoadSaver) Save(_ context.Context, data []byte) ([]byte, error) {
+	ref := sha256.Sum256(data)
+	if err := ioutil.WriteFile(filepath.Join(s.dir, hex.EncodeToString(ref[:])), data, 0600); err != nil {
+		return nil, err
+	}
+	return ref[:], nil
+}
+
+func TestIndexRepair(t *testing.T) {
+	ctx := context.Background()
+	store := mock.NewStorer()
+
+	siteFiles := []*fEntry{
+		{
+			filename:    "index.html",
+			contentType: "text/html; charset=utf-8",
+			size:        swarm.ChunkSize,
+		},
+	}
+	siteReference, err := createDirOldFormat(ctx, store, "index.html", "", siteFiles)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fileReference, err := createFileOldFormat(ctx, store, &fEntry{
+		filename:    "about.txt",
+		contentType: "text/plain; charset=utf-8",
+		size:        100,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	feedReference := swarm.MustParseHexAddress("2387e8e7d8a48c2a9339c97c1dc3461a9a7aa07e994c5cb8b38fd7c1b3e6ea48")
+	feedMtdt := map[string]string{
+		"swarm-feed-owner": "8d3766440f0d7b949a5e32995d09619a7f86e632",
+		"swarm-feed-topic": "746f706963",
+	}
+
+	ls := loadsave.New(store, storage.ModePutUpload, false)
+	index, err := manifest.NewDefaultManifest(ls, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for path, e := range map[string]manifest.Entry{
+		"site":      manifest.NewEntry(siteReference, nil),
+		"about.txt": manifest.NewEntry(fileReference, map[string]string{"Content-Type": "text/plain"}),
+		"news":      manifest.NewEntry(feedReference, feedMtdt),
+	} {
+		if err := index.Add(ctx, path, e); err != nil {
+			t.Fatal(err)
+		}
+	}
+	indexReference, err := index.Store(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	idx, err := repair.ReadIndex(ctx, indexReference, repair.WithStore(store))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var paths []string
+	for _, e := range idx.Entries {
+		paths = append(paths, e.Path)
+	}
+	if !reflect.DeepEqual(paths, []string{"about.txt", "news", "site"}) {
+		t.Fatalf("unexpected index entries %v", paths)
+	}
+	about, news, site := idx.Entries[0], idx.Entries[1], idx.Entries[2]
+	if !about.OldFormat || about.Directory || news.OldFormat || !site.OldFormat || !site.Directory {
+		t.Fatalf("unexpected index entry types, about: %+v news: %+v site: %+v", about, news, site)
+	}
+
+	if site.NewReference, err = repair.DirectoryRepair(ctx, site.Reference, repair.WithStore(store)); err != nil {
+		t.Fatal(err)
+	}
+	if about.NewReference, err = repair.FileRepair(ctx, about.Reference, repair.WithStore(store)); err != nil {
+		t.Fatal(err)
+	}
+	newReference, err := repair.WriteIndex(ctx, idx, repair.WithStore(store))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m, err := manifest.NewDefaultManifestReference(newReference, ls)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, e := range idx.Entries {
+		want := e.NewReference
+		if !e.OldFormat {
+			want = e.Reference
+		}
+		found, err := m.Lookup(ctx, e.Path)
+		if err != nil {
+			t.Fatalf("%s: %v", e.Path, err)
+		}
+		if !found.Reference().Equal(want) {
+			t.Fatalf("%s: invalid reference, Exp: %s Found: %s", e.Path, want, found.Reference())
+		}
+		if (len(found.Metadata()) > 0 || len(e.Metadata) > 0) && !reflect.DeepEqual(found.Metadata(), e.Metadata) {
+			t.Fatalf("%s: invalid metadata, Exp: %v Found: %v", e.Path, e.Metadata, found.Metadata())
+		}
+	}
+
+	// every old entry needs its new reference
+	site.NewReference = swarm.ZeroAddress
+	if _, err := repair.WriteIndex(ctx, idx, repair.WithStore(store)); err == nil {
+		t.Fatal("expected error for an entry which was not repaired")
+	}
+}
+
+// corruptingStore returns the chunks stored through it with their data altered
+type corruptingStore struct {
+	storage.Storer
+	mtx    sync.Mutex
+	stored map[string]bool
+}
+
+func (s *corruptingStore) Put(ctx context.Context, mode storage.ModePut, chs ...swarm.Chunk) ([]bool, error) {
+	s.mtx.Lock()
+	for _, ch := range chs {
+		s.stored[ch.Address().String()] = true
+	}
+	s.mtx.Unlock()
+	return s.Storer.Put(ctx, mode, chs...)
+}
+
+func (s *corruptingStore) Get(ctx context.Context, mode storage.ModeGet, addr swarm.Address) (swarm.Chunk, error) {
+	ch, err := s.Storer.Get(ctx, mode, addr)
+	if err != nil {
+		return nil, err
+	}
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	if !s.stored[addr.String()] {
+		return ch, nil
+	}
+	data := append([]byte{}, ch.Data()...)
+	data[len(data)-1] ^= 0xff
+	return swarm.NewChunk(addr, data), nil
+}
+
+func TestRepairVerifyReference(t *testing.T) {
+	ctx := context.Background()
+	store := mock.NewStorer()
+
+	files := []*fEntry{
+		{
+			filename:    "a.txt",
+			contentType: "text/plain; charset=utf-8",
+			size:        swarm.ChunkSize,
+		},
+	}
+	oldReference, err := createDirOldFormat(ctx, store, "a.txt", "", files)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fileReference, err := createFileOldFormat(ctx, store, files[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for name, repairFn := range map[string]func(...repair.Option) (swarm.Address, error){
+		"file": func(opts ...repair.Option) (swarm.Address, error) {
+			return repair.FileRepair(ctx, fileReference, opts...)
+		},
+		"directory": func(opts ...repair.Option) (swarm.Address, error) {
+			return repair.DirectoryRepair(ctx, oldReference, opts...)
+		},
+	} {
+		if _, err := repairFn(repair.WithStore(store), repair.WithVerifyReference(true)); err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		cs := &corruptingStore{Storer: mock.NewStorer(), stored: make(map[string]bool)}
+		if _, err := repairFn(repair.WithStore(cs), repair.WithSourceStore(store), repair.WithVerifyReference(true)); !errors.Is(err, repair.ErrReferenceMismatch) {
+			t.Fatalf("%s: expected reference mismatch, found %v", name, err)
+		}
+	}
+}
+
+func TestDirectoryRepairExcludeGlobs(t *testing.T) {
+	ctx := context.Background()
+	store := mock.NewStorer()
+
+	files := []*fEntry{
+		{
+			filename:    "index.html",
+			contentType: "text/html; charset=utf-8",
+			size:        swarm.ChunkSize,
+		},
+		{
+			filename:    ".DS_Store",
+			contentType: "application/octet-stream",
+			size:        10,
+		},
+		{
+			dir:         "docs",
+			filename:    "a.txt",
+			contentType: "text/plain; charset=utf-8",
+			size:        10,
+		},
+		{
+			dir:         "docs",
+			filename:    "a.txt.tmp",
+			contentType: "text/plain; charset=utf-8",
+			size:        10,
+		},
+		{
+			dir:         "docs/cache",
+			filename:    "b.txt",
+			contentType: "text/plain; charset=utf-8",
+			size:        10,
+		},
+	}
+	oldReference, err := createDirOldFormat(ctx, store, "index.html", "", files)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	progress := &percentUpdater{t: t}
+	newReference, err := repair.DirectoryRepair(ctx, oldReference,
+		repair.WithStore(store),
+		repair.WithCountingProgressUpdater(progress),
+		repair.WithExcludeGlobs([]string{".DS_Store", "*.tmp", "docs/cache"}),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if progress.total != 2 {
+		t.Fatalf("expected 2 files to repair, found %d", progress.total)
+	}
+
+	m, err := manifest.NewDefaultManifestReference(newReference, loadsave.New(store, storage.ModePutUpload, false))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range files {
+		path := filepath.Join(f.dir, f.filename)
+		_, err := m.Lookup(ctx, path)
+		switch path {
+		case "index.html", "docs/a.txt":
+			if err != nil {
+				t.Fatalf("file %s: %v", path, err)
+			}
+		default:
+			if !errors.Is(err, manifest.ErrNotFound) {
+				t.Fatalf("excluded file %s: expected not found, found %v", path, err)
+			}
+		}
+	}
+
+	_, err = repair.DirectoryRepair(ctx, oldReference, repair.WithStore(store), repair.WithExcludeGlobs([]string{"["}))
+	if err == nil {
+		t.Fatal("expected error for malformed exclude pattern")
+	}
+}
+
+func TestDirectoryRepairPrefixPaths(t *testing.T) {
+	ctx := context.Background()
+	store := mock.NewStorer()
+
+	// a.txt is continued by the path of a.txt.tmp, so its node has a fork
+	files := []*fEntry{
+		{
+			filename:    "a.txt",
+			contentType: "text/plain; charset=utf-8",
+			size:        10,
+		},
+		{
+			filename:    "a.txt.tmp",
+			contentType: "text/plain; charset=utf-8",
+			size:        20,
+		},
+	}
+	oldReference, err := createDirOldFormat(ctx, store, "", "", files)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	newReference, err := repair.DirectoryRepair(ctx, oldReference, repair.WithStore(store))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ls := loadsave.New(store, storage.ModePutUpload, false)
+	for _, f := range files {
+		n, err := mantaray.NewNodeRef(newReference.Bytes()).LookupNode(ctx, []byte(f.filename), ls)
+		if err != nil {
+			t.Fatalf("file %s: %v", f.filename, err)
+		}
+		if !swarm.NewAddress(n.Entry()).Equal(f.reference) {
+			t.Fatalf("Invalid reference of %s, Exp: %s Found: %s", f.filename, f.reference, swarm.NewAddress(n.Entry()))
+		}
+	}
+
+	diffs, err := repair.Verify(ctx, oldReference, newReference, repair.WithStore(store))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(diffs) != 0 {
+		t.Fatalf("unexpected differences %v", diffs)
+	}
+}
+
+func TestSelfTest(t *testing.T) {
+	results := repair.SelfTest(context.Background())
+	if len(results) == 0 {
+		t.Fatal("no self-test steps")
+	}
+	for _, r := range results {
+		if r.Err != nil {
+			t.Fatalf("step %s: %v", r.Name, r.Err)
+		}
+	}
+}
+
+func TestFilesRepair(t *testing.T) {
+	ctx := context.Background()
+	store := mock.NewStorer()
+
+	files := []*fEntry{
+		{
+			filename:    "index.html",
+			contentType: "text/html; charset=utf-8",
+			size:        swarm.ChunkSize,
+		},
+		{
+			filename:    "style.css",
+			contentType: "text/css; charset=utf-8",
+			size:        10,
+		},
+	}
+	var addrs []swarm.Address
+	for _, f := range files {
+		addr, err := createFileOldFormat(ctx, store, f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		addrs = append(addrs, addr)
+	}
+
+	for _, indexDoc := range []string{"", "style.css"} {
+		newReference, err := repair.FilesRepair(ctx, addrs, repair.WithStore(store), repair.WithIndexDocument(indexDoc))
+		if err != nil {
+			t.Fatal(err)
+		}
+		m, err := manifest.NewDefaultManifestReference(newReference, loadsave.New(store, storage.ModePutUpload, false))
+		if err != nil {
+			t.Fatal(err)
+		}
+		rootEntry, err := m.Lookup(ctx, manifest.RootPath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		expIndex := indexDoc
+		if expIndex == "" {
+			expIndex = files[0].filename
+		}
+		if index := rootEntry.Metadata()[manifest.WebsiteIndexDocumentSuffixKey]; index != expIndex {
+			t.Fatalf("Invalid index document, Exp: %s Found: %s", expIndex, index)
+		}
+		for _, f := range files {
+			e, err := m.Lookup(ctx, f.filename)
+			if err != nil {
+				t.Fatalf("file %s: %v", f.filename, err)
+			}
+			if !e.Reference().Equal(f.reference) {
+				t.Fatalf("Invalid reference of %s, Exp: %s Found: %s", f.filename, f.reference, e.Reference())
+			}
+			if e.Metadata()[manifest.EntryMetadataContentTypeKey] != f.contentType {
+				t.Fatalf("Invalid content type of %s", f.filename)
+			}
+		}
+	}
+
+	_, err := repair.FilesRepair(ctx, []swarm.Address{addrs[0], addrs[0]}, repair.WithStore(store))
+	if !errors.Is(err, repair.ErrDuplicateFilename) {
+		t.Fatalf("expected duplicate filename error, found %v", err)
+	}
+}
+
+func TestDirectoryRepairWellKnown(t *testing.T) {
+	ctx := context.Background()
+	store := mock.NewStorer()
+
+	files := []*fEntry{
+		{
+			filename:    "index.html",
+			contentType: "text/html; charset=utf-8",
+			size:        swarm.ChunkSize,
+		},
+		{
+			dir:         ".well-known/acme-challenge",
+			filename:    "token",
+			contentType: "text/plain; charset=utf-8",
+			size:        10,
+		},
+		{
+			filename:    ".htaccess",
+			contentType: "text/plain; charset=utf-8",
+			size:        10,
+		},
+	}
+	oldReference, err := createDirOldFormat(ctx, store, "index.html", "", files)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	newReference, err := repair.DirectoryRepair(ctx, oldReference,
+		repair.WithStore(store),
+		repair.WithExcludeGlobs([]string{"*.tmp"}),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m, err := manifest.NewDefaultManifestReference(newReference, loadsave.New(store, storage.ModePutUpload, false))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range files {
+		path := filepath.Join(f.dir, f.filename)
+		e, err := m.Lookup(ctx, path)
+		if err != nil {
+			t.Fatalf("file %s: %v", path, err)
+		}
+		if !e.Reference().Equal(f.reference) {
+			t.Fatalf("Invalid reference of %s, Exp: %s Found: %s", path, f.reference, e.Reference())
+		}
+	}
+
+	entries, err := repair.List(ctx, oldReference, repair.WithStore(store))
+	if err != nil {
+		t.Fatal(err)
+	}
+	paths := make(map[string]bool)
+	for _, e := range entries {
+		paths[e.Path] = true
+	}
+	if !paths[".well-known/acme-challenge/token"] || !paths[".htaccess"] {
+		t.Fatalf("dot paths not listed as they are: %v", paths)
+	}
 }
@@ -0,0 +1,169 @@
+// Copyright 2021 The Swarm Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package repair
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"path"
+
+	"github.com/ethersphere/bee-repair/internal/collection/entry"
+	"github.com/ethersphere/bee/pkg/file"
+	"github.com/ethersphere/bee/pkg/file/loadsave"
+	"github.com/ethersphere/bee/pkg/manifest"
+	"github.com/ethersphere/bee/pkg/storage"
+	"github.com/ethersphere/bee/pkg/swarm"
+)
+
+// SelfTestResult is the outcome of a step of SelfTest, Err is nil when it passed
+type SelfTestResult struct {
+	Name string
+	Err  error
+}
+
+// selfTestFile is a file of the fixture of SelfTest
+type selfTestFile struct {
+	path     string
+	mimeType string
+	data     []byte
+	// reference is the reference of the content, set once the file is stored
+	reference swarm.Address
+}
+
+// SelfTest creates a file and a directory of the old format in a store held in
+// memory, repairs them and checks the new manifests, which runs the whole repair
+// without a node. The options are applied to the repairs, apart from the store
+func SelfTest(ctx context.Context, opts ...Option) []*SelfTestResult {
+	store := NewMemoryStore()
+	ls := loadsave.New(store, storage.ModePutUpload, false)
+	opts = append(opts, WithMemoryStore(store), WithVerifyReference(true))
+
+	files := []*selfTestFile{
+		{path: "index.html", mimeType: "text/html; charset=utf-8", data: bytes.Repeat([]byte("<p>himalaya</p>\n"), 1024)},
+		{path: "docs/a.txt", mimeType: "text/plain; charset=utf-8", data: []byte("self-test")},
+	}
+
+	return []*SelfTestResult{
+		{Name: "file", Err: selfTestFileRepair(ctx, ls, files[0], opts)},
+		{Name: "directory", Err: selfTestDirectoryRepair(ctx, ls, files, opts)},
+	}
+}
+
+// selfTestFileRepair repairs a file of the old format and checks its entry in the
+// new manifest
+func selfTestFileRepair(ctx context.Context, ls file.LoadSaver, f *selfTestFile, opts []Option) error {
+	oldRef, err := saveOldFile(ctx, ls, f)
+	if err != nil {
+		return err
+	}
+	newRef, err := FileRepair(ctx, oldRef, opts...)
+	if err != nil {
+		return err
+	}
+	m, err := manifest.NewDefaultManifestReference(newRef, ls)
+	if err != nil {
+		return err
+	}
+	e, err := m.Lookup(ctx, f.path)
+	if err != nil {
+		return err
+	}
+	if !e.Reference().Equal(f.reference) {
+		return fmt.Errorf("file %s: reference %s, expected %s", f.path, e.Reference(), f.reference)
+	}
+	if ct := e.Metadata()[manifest.EntryMetadataContentTypeKey]; ct != f.mimeType {
+		return fmt.Errorf("file %s: content type %q, expected %q", f.path, ct, f.mimeType)
+	}
+	return nil
+}
+
+// selfTestDirectoryRepair repairs a directory of the old format and compares the
+// new manifest with it
+func selfTestDirectoryRepair(ctx context.Context, ls file.LoadSaver, files []*selfTestFile, opts []Option) error {
+	oldRef, err := saveOldDirectory(ctx, ls, files)
+	if err != nil {
+		return err
+	}
+	newRef, err := DirectoryRepair(ctx, oldRef, opts...)
+	if err != nil {
+		return err
+	}
+	diffs, err := Verify(ctx, oldRef, newRef, opts...)
+	if err != nil {
+		return err
+	}
+	if len(diffs) > 0 {
+		return fmt.Errorf("%d differences, e.g. %s", len(diffs), diffs[0])
+	}
+	return nil
+}
+
+// saveOldFile stores the file as an entry of the old format and returns its
+// reference
+func saveOldFile(ctx context.Context, ls file.LoadSaver, f *selfTestFile) (swarm.Address, error) {
+	ref, err := ls.Save(ctx, f.data)
+	if err != nil {
+		return swarm.ZeroAddress, err
+	}
+	f.reference = swarm.NewAddress(ref)
+
+	mtdt := entry.NewMetadata(path.Base(f.path))
+	mtdt.MimeType = f.mimeType
+	return saveOldEntry(ctx, ls, f.reference, mtdt)
+}
+
+// saveOldDirectory stores the files in a directory of the old format, with the
+// first one as its index document, and returns its reference
+func saveOldDirectory(ctx context.Context, ls file.LoadSaver, files []*selfTestFile) (swarm.Address, error) {
+	m, err := manifest.NewDefaultManifest(ls, false)
+	if err != nil {
+		return swarm.ZeroAddress, err
+	}
+	rootMtdt := map[string]string{manifest.WebsiteIndexDocumentSuffixKey: files[0].path}
+	if err := m.Add(ctx, manifest.RootPath, manifest.NewEntry(swarm.ZeroAddress, rootMtdt)); err != nil {
+		return swarm.ZeroAddress, err
+	}
+	for _, f := range files {
+		ref, err := saveOldFile(ctx, ls, f)
+		if err != nil {
+			return swarm.ZeroAddress, err
+		}
+		if err := m.Add(ctx, f.path, manifest.NewEntry(ref, nil)); err != nil {
+			return swarm.ZeroAddress, err
+		}
+	}
+	ref, err := m.Store(ctx)
+	if err != nil {
+		return swarm.ZeroAddress, err
+	}
+
+	mtdt := entry.NewMetadata(ref.String())
+	mtdt.MimeType = m.Type()
+	return saveOldEntry(ctx, ls, ref, mtdt)
+}
+
+// saveOldEntry stores the metadata and the entry pointing to the reference, and
+// returns the reference of the entry
+func saveOldEntry(ctx context.Context, ls file.LoadSaver, ref swarm.Address, mtdt *entry.Metadata) (swarm.Address, error) {
+	mtdtBytes, err := json.Marshal(mtdt)
+	if err != nil {
+		return swarm.ZeroAddress, err
+	}
+	mtdtRef, err := ls.Save(ctx, mtdtBytes)
+	if err != nil {
+		return swarm.ZeroAddress, err
+	}
+	entryBytes, err := entry.New(ref, swarm.NewAddress(mtdtRef)).MarshalBinary()
+	if err != nil {
+		return swarm.ZeroAddress, err
+	}
+	entryRef, err := ls.Save(ctx, entryBytes)
+	if err != nil {
+		return swarm.ZeroAddress, err
+	}
+	return swarm.NewAddress(entryRef), nil
+}
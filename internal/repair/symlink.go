@@ -0,0 +1,87 @@
+// Copyright 2021 The Swarm Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package repair
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/ethersphere/bee/pkg/manifest"
+	"github.com/ethersphere/bee/pkg/manifest/mantaray"
+)
+
+// metadata key of the symlink entries of old manifests, with the path of the entry
+// they point to. The path is relative to the directory of the symlink, or to the
+// root of the manifest when it starts with a slash. Bee does not write such
+// entries, the key is a convention of this tool for the manifests built with it
+const symlinkMetadataTarget = "swarm-symlink-target"
+
+// maxSymlinkHops bounds the symlinks followed to resolve a single one
+const maxSymlinkHops = 8
+
+// SymlinkPolicy selects what happens to the symlink entries of old directories
+type SymlinkPolicy int
+
+const (
+	// SymlinkError fails the repair, or with WithSkipErrors leaves the symlink out
+	// as a file which cannot be read
+	SymlinkError SymlinkPolicy = iota
+	// SymlinkSkip leaves the symlinks out of the new manifest
+	SymlinkSkip
+	// SymlinkResolve adds the file the symlink points to at the path of the symlink
+	SymlinkResolve
+)
+
+var (
+	// ErrSymlink is returned for the symlink entries of an old directory with
+	// SymlinkError
+	ErrSymlink = errors.New("symlink entry")
+	// ErrSymlinkTarget is returned when the target of a symlink cannot be resolved
+	ErrSymlinkTarget = errors.New("unresolvable symlink")
+)
+
+// WithSymlinkPolicy is used to select how the symlink entries of old directories,
+// which point to another path of the manifest, are repaired. Symlinks are the
+// entries with the swarm-symlink-target metadata, a convention of this tool as
+// bee has no symlink entries. By default they fail the repair with ErrSymlink
+func WithSymlinkPolicy(p SymlinkPolicy) Option {
+	return func(c *Repairer) {
+		c.symlinks = p
+	}
+}
+
+// symlinkTarget returns the target of the symlink entry with the metadata
+func symlinkTarget(mtdt map[string]string) (string, bool) {
+	target, ok := mtdt[symlinkMetadataTarget]
+	return target, ok
+}
+
+// resolveSymlink returns the node of the old manifest the symlink at path points
+// to, following the symlinks it points to in turn
+func (r *Repairer) resolveSymlink(ctx context.Context, node *mantaray.Node, linkPath, target string) (*mantaray.Node, error) {
+	for hops := 0; hops < maxSymlinkHops; hops++ {
+		if !strings.HasPrefix(target, manifest.RootPath) {
+			target = path.Join(path.Dir(linkPath), target)
+		}
+		target = strings.TrimPrefix(path.Clean(manifest.RootPath+target), manifest.RootPath)
+		if target == "" {
+			return nil, fmt.Errorf("%w: %s points to the root", ErrSymlinkTarget, linkPath)
+		}
+		fnode, err := node.LookupNode(ctx, []byte(target), r.ls)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s points to %s: %v", ErrSymlinkTarget, linkPath, target, err)
+		}
+		next, ok := symlinkTarget(fnode.Metadata())
+		if !ok {
+			r.logger.Debugf("Resolved symlink %s to %s", linkPath, target)
+			return fnode, nil
+		}
+		linkPath, target = target, next
+	}
+	return nil, fmt.Errorf("%w: more than %d symlinks to follow", ErrSymlinkTarget, maxSymlinkHops)
+}
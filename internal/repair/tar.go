@@ -0,0 +1,66 @@
+// Copyright 2021 The Swarm Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package repair
+
+import (
+	"archive/tar"
+	"context"
+	"fmt"
+	"io"
+
+	"github.com/ethersphere/bee/pkg/file"
+	"github.com/ethersphere/bee/pkg/file/joiner"
+	"github.com/ethersphere/bee/pkg/manifest/mantaray"
+	"github.com/ethersphere/bee/pkg/swarm"
+)
+
+// ExportTar takes in a manifest of the new format, e.g. a repaired directory, and
+// writes the content of its files to w as a tar archive, each file at its path in
+// the manifest. The chunks are read from the configured store. Feeds and entries
+// without content, such as the root metadata, are left out
+func ExportTar(ctx context.Context, ref swarm.Address, w io.Writer, opts ...Option) error {
+	r, err := newWithOptions(opts...)
+	if err != nil {
+		return err
+	}
+	defer r.close()
+
+	tw := tar.NewWriter(w)
+	node := mantaray.NewNodeRef(ref.Bytes())
+	err = node.WalkNode(ctx, []byte{}, r.ls, func(path []byte, n *mantaray.Node, err error) error {
+		if err != nil {
+			return err
+		}
+		if !isValueNode(n) || isZeroReference(swarm.NewAddress(n.Entry())) || isFeedMetadata(n.Metadata()) {
+			return nil
+		}
+		return r.writeTarFile(ctx, tw, string(path), swarm.NewAddress(n.Entry()))
+	})
+	if err != nil {
+		return fmt.Errorf("exporting manifest %s: %w", ref, err)
+	}
+	return tw.Close()
+}
+
+// writeTarFile adds the joined content of the reference to the archive at path
+func (r *Repairer) writeTarFile(ctx context.Context, tw *tar.Writer, path string, ref swarm.Address) error {
+	j, size, err := joiner.New(ctx, r.store, ref)
+	if err != nil {
+		return &FileError{Path: path, Err: err}
+	}
+	if err := tw.WriteHeader(&tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     path,
+		Mode:     0644,
+		Size:     size,
+	}); err != nil {
+		return err
+	}
+	if _, err := file.JoinReadAll(ctx, j, tw); err != nil {
+		return &FileError{Path: path, Err: err}
+	}
+	r.logger.Debugf("Exported file %s of %d bytes", path, size)
+	return nil
+}
@@ -0,0 +1,143 @@
+// Copyright 2021 The Swarm Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package repair
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/ethersphere/bee/pkg/manifest/mantaray"
+	"github.com/ethersphere/bee/pkg/swarm"
+)
+
+// Difference describes a path which does not match between the old and the new
+// manifest. The reference of the side missing the path is swarm.ZeroAddress
+type Difference struct {
+	Path         string
+	OldReference swarm.Address
+	NewReference swarm.Address
+}
+
+func (d *Difference) String() string {
+	switch {
+	case d.NewReference.Equal(swarm.ZeroAddress):
+		return fmt.Sprintf("%s: missing in new manifest", d.Path)
+	case d.OldReference.Equal(swarm.ZeroAddress):
+		return fmt.Sprintf("%s: missing in old manifest", d.Path)
+	default:
+		return fmt.Sprintf("%s: reference %s differs from old reference %s",
+			d.Path, d.NewReference, d.OldReference)
+	}
+}
+
+// Verify takes in an older directory reference and the reference of its repaired
+// manifest and returns the paths which are present in only one of them or whose
+// file reference differs. No differences means the new manifest serves the same
+// files as the old one
+func Verify(ctx context.Context, oldAddr, newAddr swarm.Address, opts ...Option) ([]*Difference, error) {
+	r, err := newWithOptions(opts...)
+	if err != nil {
+		return nil, err
+	}
+	defer r.close()
+
+	// stops the walk of the old files on an early return
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	dir, err := r.getOldDirectoryEntry(ctx, oldAddr)
+	if err != nil {
+		return nil, err
+	}
+
+	newNode := mantaray.NewNodeRef(newAddr.Bytes())
+
+	var diffs []*Difference
+	oldPaths := make(map[string]struct{})
+
+loop:
+	for {
+		select {
+		case f, ok := <-dir.filesC:
+			if !ok {
+				break loop
+			}
+			if f.err != nil {
+				return nil, &FileError{Path: f.filepath, Err: f.err}
+			}
+			oldPaths[f.filepath] = struct{}{}
+
+			ref, err := r.lookupFile(ctx, newNode, f.filepath)
+			if errors.Is(err, mantaray.ErrNotFound) {
+				diffs = append(diffs, &Difference{
+					Path:         f.filepath,
+					OldReference: f.e.Reference(),
+					NewReference: swarm.ZeroAddress,
+				})
+				continue
+			}
+			if err != nil {
+				return nil, err
+			}
+			if !ref.Equal(f.e.Reference()) {
+				diffs = append(diffs, &Difference{
+					Path:         f.filepath,
+					OldReference: f.e.Reference(),
+					NewReference: ref,
+				})
+			}
+		case e, ok := <-dir.errC:
+			if !ok {
+				break loop
+			}
+			return nil, e
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+
+	err = walkFiles(ctx, newNode, r.ls, func(path []byte, isDir bool, err error) error {
+		if err != nil {
+			return err
+		}
+		if isDir {
+			return nil
+		}
+		if _, found := oldPaths[string(path)]; found {
+			return nil
+		}
+		ref, err := r.lookupFile(ctx, newNode, string(path))
+		if err != nil {
+			return err
+		}
+		diffs = append(diffs, &Difference{
+			Path:         string(path),
+			OldReference: swarm.ZeroAddress,
+			NewReference: ref,
+		})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	r.logger.Debugf("Verified manifest %s against %s: %d differences", newAddr, oldAddr, len(diffs))
+
+	return diffs, nil
+}
+
+// lookupFile returns the reference of the file at the path of the manifest, also
+// when other paths continue it
+func (r *Repairer) lookupFile(ctx context.Context, node *mantaray.Node, path string) (swarm.Address, error) {
+	n, err := node.LookupNode(ctx, []byte(path), r.ls)
+	if err != nil {
+		return swarm.ZeroAddress, err
+	}
+	if !isValueNode(n) {
+		return swarm.ZeroAddress, fmt.Errorf("entry on '%s': %w", path, mantaray.ErrNotFound)
+	}
+	return swarm.NewAddress(n.Entry()), nil
+}
@@ -0,0 +1,130 @@
+// Copyright 2021 The Swarm Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package repair
+
+import (
+	"bytes"
+	"context"
+	"errors"
+
+	"github.com/ethersphere/bee/pkg/manifest/mantaray"
+	"github.com/ethersphere/bee/pkg/storage"
+	"github.com/ethersphere/bee/pkg/swarm"
+)
+
+// errReadOnly is returned when a walk tries to store a chunk
+var errReadOnly = errors.New("read only store")
+
+// OldFile describes a file entry of a directory in the old format
+type OldFile struct {
+	Path      string
+	Reference swarm.Address
+	Filename  string
+	MimeType  string
+	Size      int64
+	// Feed is set for the feed entries, which have no filename, content type or
+	// size of their own
+	Feed bool
+	// Err is set when the entry cannot be read and WithSkipErrors is used,
+	// otherwise the walk fails
+	Err error
+}
+
+// WalkFunc is called for every file entry of the old directory. Returning an error
+// stops the walk
+type WalkFunc func(f *OldFile) error
+
+// WalkOldDirectory takes in an older directory reference and calls fn with every file
+// entry of it in path order, reading the chunks from the store. Nothing is repaired
+// or stored, and empty files are reported with the zero reference of the old format
+func WalkOldDirectory(ctx context.Context, store storage.Getter, addr swarm.Address, fn WalkFunc, opts ...Option) error {
+	opts = append(opts, WithStore(&readOnlyStore{store}))
+	r, err := newWithOptions(opts...)
+	if err != nil {
+		return err
+	}
+	defer r.close()
+
+	return r.walkOldDirectory(ctx, addr, fn)
+}
+
+// walkOldDirectory calls fn with every file entry of the old directory without
+// storing anything
+func (r *Repairer) walkOldDirectory(ctx context.Context, addr swarm.Address, fn WalkFunc) error {
+	r.readOnly = true
+	r.fileSizes = true
+
+	node, err := r.getOldManifest(ctx, addr)
+	if err != nil {
+		return err
+	}
+
+	return r.walkOldFiles(ctx, node, func(f *fileEntry) error {
+		of := &OldFile{Path: f.filepath, Err: f.err}
+		switch {
+		case f.err != nil:
+		case f.feed != nil:
+			of.Reference = f.e.Reference()
+			of.Filename = f.mtdt.Filename
+			of.Feed = true
+		default:
+			of.Reference = f.e.Reference()
+			of.Filename = f.mtdt.Filename
+			of.MimeType = f.mtdt.MimeType
+			of.Size = f.size
+		}
+		return fn(of)
+	})
+}
+
+// readOnlyStore fails the writes, for the walks which only read
+type readOnlyStore struct {
+	storage.Getter
+}
+
+func (s *readOnlyStore) Put(_ context.Context, _ storage.ModePut, _ ...swarm.Chunk) ([]bool, error) {
+	return nil, errReadOnly
+}
+
+// walkFiles walks the manifest like mantaray Walk, calling fn with the paths of the
+// directories and the files. A node with forks is typed only as an edge once it
+// is loaded, so Walk leaves out the files at paths which other paths continue,
+// e.g. a.txt next to a.txt.tmp. Such files are told by their entry here
+func walkFiles(ctx context.Context, node *mantaray.Node, l mantaray.Loader, fn mantaray.WalkFunc) error {
+	// the paths of the nodes from the root to the last one walked
+	var parents [][]byte
+	return node.WalkNode(ctx, []byte{}, l, func(path []byte, n *mantaray.Node, err error) error {
+		if err != nil {
+			return err
+		}
+		for len(parents) > 0 && !bytes.HasPrefix(path, parents[len(parents)-1]) {
+			parents = parents[:len(parents)-1]
+		}
+		// the directories are reported where the prefix of the node ends them
+		start := 0
+		if len(parents) > 0 {
+			start = len(parents[len(parents)-1])
+		}
+		parents = append(parents, path)
+		for i := start; i < len(path); i++ {
+			if path[i] != mantaray.PathSeparator {
+				continue
+			}
+			if err := fn(append([]byte{}, path[:i+1]...), true, nil); err != nil {
+				return err
+			}
+		}
+		if len(path) == 0 || path[len(path)-1] == mantaray.PathSeparator || !isValueNode(n) {
+			return nil
+		}
+		return fn(path, false, nil)
+	})
+}
+
+// isValueNode reports whether the node has an entry, also when it has forks and is
+// typed only as an edge since it was loaded
+func isValueNode(n *mantaray.Node) bool {
+	return n.IsValueType() || !isZeroReference(swarm.NewAddress(n.Entry()))
+}
@@ -7,11 +7,18 @@ package file_test
 import (
 	"bytes"
 	"context"
+	"crypto/x509"
+	"errors"
+	"fmt"
 	"io/ioutil"
+	"net"
+	"net/http"
 	"net/http/httptest"
 	"net/url"
 	"strconv"
+	"strings"
 	"testing"
+	"time"
 
 	cmdfile "github.com/ethersphere/bee-repair/pkg/file"
 	"github.com/ethersphere/bee/pkg/api"
@@ -53,6 +60,585 @@ func TestAPIStore(t *testing.T) {
 	if !ch.Equal(chResult) {
 		t.Fatal("chunk mismatch")
 	}
+
+	_, err = a.Get(ctx, storage.ModeGetRequest, testingc.GenerateTestRandomChunk().Address())
+	if !errors.Is(err, storage.ErrNotFound) {
+		t.Fatalf("expected not found error, got %v", err)
+	}
+}
+
+// TestAPIStoreTLS verifies that the api store trusts the configured certificate
+// authority and can skip the verification when asked to.
+func TestAPIStoreTLS(t *testing.T) {
+	storer := mock.NewStorer()
+	ctx := context.Background()
+	ts := newTLSTestServer(t, storer)
+
+	srvUrl, err := url.Parse(ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	host := srvUrl.Hostname()
+	port, err := strconv.Atoi(srvUrl.Port())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pool := x509.NewCertPool()
+	pool.AddCert(ts.Certificate())
+
+	ch := testingc.GenerateTestRandomChunk()
+
+	for _, tc := range []struct {
+		name    string
+		opts    []cmdfile.APIStoreOption
+		wantErr bool
+	}{
+		{
+			name:    "untrusted",
+			wantErr: true,
+		},
+		{
+			name: "root ca",
+			opts: []cmdfile.APIStoreOption{cmdfile.WithRootCAs(pool)},
+		},
+		{
+			name: "insecure",
+			opts: []cmdfile.APIStoreOption{cmdfile.WithInsecureSkipVerify(true)},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			a := cmdfile.NewAPIStore(host, port, true, tc.opts...)
+			_, err = a.Put(ctx, storage.ModePutUpload, ch)
+			if tc.wantErr {
+				if err == nil {
+					t.Fatal("expected certificate verification error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			chResult, err := a.Get(ctx, storage.ModeGetRequest, ch.Address())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !ch.Equal(chResult) {
+				t.Fatal("chunk mismatch")
+			}
+		})
+	}
+}
+
+// TestAPIStoreAuth verifies that the configured credentials are sent with every
+// request.
+func TestAPIStoreAuth(t *testing.T) {
+	storer := mock.NewStorer()
+	ctx := context.Background()
+
+	for _, tc := range []struct {
+		name string
+		opt  cmdfile.APIStoreOption
+		auth func(*http.Request) bool
+	}{
+		{
+			name: "bearer",
+			opt:  cmdfile.WithBearerToken("secret"),
+			auth: func(r *http.Request) bool {
+				return r.Header.Get("Authorization") == "Bearer secret"
+			},
+		},
+		{
+			name: "basic",
+			opt:  cmdfile.WithBasicAuth("user", "pass"),
+			auth: func(r *http.Request) bool {
+				user, pass, ok := r.BasicAuth()
+				return ok && user == "user" && pass == "pass"
+			},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			handler := newTestHandler(storer)
+			ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if !tc.auth(r) {
+					w.WriteHeader(http.StatusUnauthorized)
+					return
+				}
+				handler.ServeHTTP(w, r)
+			}))
+			defer ts.Close()
+
+			srvUrl, err := url.Parse(ts.URL)
+			if err != nil {
+				t.Fatal(err)
+			}
+			port, err := strconv.Atoi(srvUrl.Port())
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			ch := testingc.GenerateTestRandomChunk()
+
+			_, err = cmdfile.NewAPIStore(srvUrl.Hostname(), port, false).Put(ctx, storage.ModePutUpload, ch)
+			if err == nil {
+				t.Fatal("expected unauthorized error")
+			}
+
+			a := cmdfile.NewAPIStore(srvUrl.Hostname(), port, false, tc.opt)
+			_, err = a.Put(ctx, storage.ModePutUpload, ch)
+			if err != nil {
+				t.Fatal(err)
+			}
+			chResult, err := a.Get(ctx, storage.ModeGetRequest, ch.Address())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !ch.Equal(chResult) {
+				t.Fatal("chunk mismatch")
+			}
+		})
+	}
+}
+
+// TestAPIStorePin verifies that the chunks are uploaded with the pin header only
+// in the pinning mode.
+func TestAPIStorePin(t *testing.T) {
+	storer := mock.NewStorer()
+	ctx := context.Background()
+
+	var pinned []string
+	handler := newTestHandler(storer)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPost {
+			pinned = append(pinned, r.Header.Get("Swarm-Pin"))
+		}
+		handler.ServeHTTP(w, r)
+	}))
+	defer ts.Close()
+
+	srvUrl, err := url.Parse(ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	port, err := strconv.Atoi(srvUrl.Port())
+	if err != nil {
+		t.Fatal(err)
+	}
+	a := cmdfile.NewAPIStore(srvUrl.Hostname(), port, false)
+
+	_, err = a.Put(ctx, storage.ModePutUpload, testingc.GenerateTestRandomChunk())
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = a.Put(ctx, storage.ModePutUploadPin, testingc.GenerateTestRandomChunk())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(pinned) != 2 || pinned[0] != "" || pinned[1] != "true" {
+		t.Fatalf("unexpected pin headers %q", pinned)
+	}
+}
+
+// TestAPIStoreRateLimit verifies that the requests are spread over time and that
+// waiting for the limit stops with the context.
+func TestAPIStoreRateLimit(t *testing.T) {
+	storer := mock.NewStorer()
+	srvUrl := newTestServer(t, storer)
+
+	port, err := strconv.Atoi(srvUrl.Port())
+	if err != nil {
+		t.Fatal(err)
+	}
+	a := cmdfile.NewAPIStore(srvUrl.Hostname(), port, false, cmdfile.WithRateLimit(20))
+
+	ch := testingc.GenerateTestRandomChunk()
+	start := time.Now()
+	_, err = a.Put(context.Background(), storage.ModePutUpload, ch)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		_, err = a.Get(context.Background(), storage.ModeGetRequest, ch.Address())
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	// the first request is immediate, the other three wait 50ms each
+	if elapsed := time.Since(start); elapsed < 150*time.Millisecond {
+		t.Fatalf("requests not limited, took %v", elapsed)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = a.Get(ctx, storage.ModeGetRequest, ch.Address())
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected canceled error, got %v", err)
+	}
+}
+
+// TestAPIStorePing verifies that an unreachable api is reported.
+func TestAPIStorePing(t *testing.T) {
+	storer := mock.NewStorer()
+	ctx := context.Background()
+	ts := httptest.NewServer(newTestHandler(storer))
+
+	srvUrl, err := url.Parse(ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	port, err := strconv.Atoi(srvUrl.Port())
+	if err != nil {
+		t.Fatal(err)
+	}
+	a := cmdfile.NewAPIStore(srvUrl.Hostname(), port, false).(*cmdfile.APIStore)
+
+	if err := a.Ping(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	ts.Close()
+	err = a.Ping(ctx)
+	if err == nil || !strings.Contains(err.Error(), "cannot reach bee API at "+srvUrl.Host) {
+		t.Fatalf("expected unreachable error, got %v", err)
+	}
+}
+
+// TestAPIStoreNodeVersion verifies that the version reported by the health endpoint
+// is parsed, and that nodes which do not report one are told apart.
+func TestAPIStoreNodeVersion(t *testing.T) {
+	health := ""
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/health" || health == "" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		fmt.Fprint(w, health)
+	}))
+	defer ts.Close()
+
+	srvUrl, err := url.Parse(ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	port, err := strconv.Atoi(srvUrl.Port())
+	if err != nil {
+		t.Fatal(err)
+	}
+	a := cmdfile.NewAPIStore(srvUrl.Hostname(), port, false).(*cmdfile.APIStore)
+	ctx := context.Background()
+
+	for _, tc := range []struct {
+		health         string
+		wantErr        error
+		version        string
+		newFormat      bool
+		requirePostage bool
+	}{
+		{health: "", wantErr: cmdfile.ErrVersionUnknown},
+		{health: `{"status":"ok"}`, wantErr: cmdfile.ErrVersionUnknown},
+		{health: `{"status":"ok","version":"latest"}`, wantErr: cmdfile.ErrVersionUnknown},
+		{health: `{"status":"ok","version":"0.5.3-acbd0e2"}`, version: "0.5.3-acbd0e2"},
+		{health: `{"status":"ok","version":"v0.5.4"}`, version: "v0.5.4", newFormat: true},
+		{health: `{"status":"ok","version":"1.0.0-6e4c9b1a","apiVersion":"1.0.0"}`, version: "1.0.0-6e4c9b1a", newFormat: true, requirePostage: true},
+	} {
+		health = tc.health
+		v, err := a.NodeVersion(ctx)
+		if tc.wantErr != nil {
+			if !errors.Is(err, tc.wantErr) {
+				t.Fatalf("%q: expected error %v, got %v", tc.health, tc.wantErr, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("%q: %v", tc.health, err)
+		}
+		if v.Version != tc.version {
+			t.Fatalf("%q: got version %s, want %s", tc.health, v.Version, tc.version)
+		}
+		if v.ReadsNewFormat() != tc.newFormat || v.RequiresPostage() != tc.requirePostage {
+			t.Fatalf("%q: got new format %t postage %t", tc.health, v.ReadsNewFormat(), v.RequiresPostage())
+		}
+	}
+}
+
+// TestAPIStoreUploadRejected verifies that a chunk which is not accepted by the
+// api is reported as rejected.
+func TestAPIStoreUploadRejected(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusPaymentRequired)
+	}))
+	defer ts.Close()
+
+	srvUrl, err := url.Parse(ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	port, err := strconv.Atoi(srvUrl.Port())
+	if err != nil {
+		t.Fatal(err)
+	}
+	a := cmdfile.NewAPIStore(srvUrl.Hostname(), port, false)
+
+	_, err = a.Put(context.Background(), storage.ModePutUpload, testingc.GenerateTestRandomChunk())
+	if !errors.Is(err, cmdfile.ErrUploadRejected) {
+		t.Fatalf("expected upload rejected error, got %v", err)
+	}
+}
+
+// TestAPIStoreRequestTimeout verifies that a request which is not answered fails
+// once the request timeout elapses.
+func TestAPIStoreRequestTimeout(t *testing.T) {
+	// the context of a request whose body is not read is not cancelled when the
+	// client gives up, so the handlers are released once the test is done
+	done := make(chan struct{})
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-done:
+		}
+	}))
+	defer ts.Close()
+	defer close(done)
+
+	srvUrl, err := url.Parse(ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	port, err := strconv.Atoi(srvUrl.Port())
+	if err != nil {
+		t.Fatal(err)
+	}
+	a := cmdfile.NewAPIStore(srvUrl.Hostname(), port, false, cmdfile.WithRequestTimeout(50*time.Millisecond))
+
+	ch := testingc.GenerateTestRandomChunk()
+	_, err = a.Get(context.Background(), storage.ModeGetRequest, ch.Address())
+	if !errors.Is(err, cmdfile.ErrRequestTimeout) {
+		t.Fatalf("expected request timeout error, got %v", err)
+	}
+	_, err = a.Put(context.Background(), storage.ModePutUpload, ch)
+	if !errors.Is(err, cmdfile.ErrRequestTimeout) {
+		t.Fatalf("expected request timeout error, got %v", err)
+	}
+}
+
+// TestAPIStoreNotFoundRetries verifies that a chunk which is not found yet is
+// requested again, and that the not found error is returned once the retries run out.
+func TestAPIStoreNotFoundRetries(t *testing.T) {
+	ch := testingc.GenerateTestRandomChunk()
+	requests := 0
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if requests < 3 {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		_, _ = w.Write(ch.Data())
+	}))
+	defer ts.Close()
+
+	srvUrl, err := url.Parse(ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	port, err := strconv.Atoi(srvUrl.Port())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	a := cmdfile.NewAPIStore(srvUrl.Hostname(), port, false, cmdfile.WithNotFoundRetries(1, time.Millisecond))
+	_, err = a.Get(context.Background(), storage.ModeGetRequest, ch.Address())
+	if !errors.Is(err, storage.ErrNotFound) {
+		t.Fatalf("expected not found error, got %v", err)
+	}
+	if requests != 2 {
+		t.Fatalf("expected 2 requests, got %d", requests)
+	}
+
+	requests = 0
+	a = cmdfile.NewAPIStore(srvUrl.Hostname(), port, false, cmdfile.WithNotFoundRetries(3, time.Millisecond))
+	chResult, err := a.Get(context.Background(), storage.ModeGetRequest, ch.Address())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !ch.Equal(chResult) {
+		t.Fatal("chunk mismatch")
+	}
+	if requests != 3 {
+		t.Fatalf("expected 3 requests, got %d", requests)
+	}
+}
+
+// TestAPIStorePinChunk verifies that chunks are pinned through the pinning API.
+func TestAPIStorePinChunk(t *testing.T) {
+	ch := testingc.GenerateTestRandomChunk()
+	pinned := 0
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method != http.MethodPost:
+			w.WriteHeader(http.StatusMethodNotAllowed)
+		case r.URL.Path == "/pin/chunks/"+ch.Address().String():
+			pinned++
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer ts.Close()
+
+	srvUrl, err := url.Parse(ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	port, err := strconv.Atoi(srvUrl.Port())
+	if err != nil {
+		t.Fatal(err)
+	}
+	a := cmdfile.NewAPIStore(srvUrl.Hostname(), port, false).(*cmdfile.APIStore)
+
+	ctx := context.Background()
+	for i := 0; i < 2; i++ {
+		if err := a.Pin(ctx, ch.Address()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if pinned != 2 {
+		t.Fatalf("expected 2 pins, got %d", pinned)
+	}
+	err = a.Pin(ctx, testingc.GenerateTestRandomChunk().Address())
+	if !errors.Is(err, storage.ErrNotFound) {
+		t.Fatalf("expected not found error, got %v", err)
+	}
+}
+
+// TestAPIStorePostageBatch verifies that the uploaded chunks are stamped with the
+// batch and that the batch is checked against the stamps API.
+func TestAPIStorePostageBatch(t *testing.T) {
+	const (
+		usable   = "aa"
+		full     = "bb"
+		expired  = "cc"
+		syncing  = "dd"
+		unknown  = "ee"
+		response = `{"batchID":%q,"utilization":%d,"usable":%t,"depth":20,"bucketDepth":16,"batchTTL":%d,"exists":true}`
+	)
+	var stamped []string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPost && r.URL.Path == "/chunks" {
+			stamped = append(stamped, r.Header.Get("Swarm-Postage-Batch-Id"))
+			return
+		}
+		switch r.URL.Path {
+		case "/stamps/" + usable:
+			fmt.Fprintf(w, response, usable, 10, true, 3600)
+		case "/stamps/" + full:
+			fmt.Fprintf(w, response, full, 16, true, 3600)
+		case "/stamps/" + expired:
+			fmt.Fprintf(w, response, expired, 0, true, 0)
+		case "/stamps/" + syncing:
+			fmt.Fprintf(w, response, syncing, 0, false, 3600)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer ts.Close()
+
+	srvUrl, err := url.Parse(ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	port, err := strconv.Atoi(srvUrl.Port())
+	if err != nil {
+		t.Fatal(err)
+	}
+	a := cmdfile.NewAPIStore(srvUrl.Hostname(), port, false, cmdfile.WithPostageBatch(usable)).(*cmdfile.APIStore)
+
+	ctx := context.Background()
+	if _, err := a.Put(ctx, storage.ModePutUpload, testingc.GenerateTestRandomChunk()); err != nil {
+		t.Fatal(err)
+	}
+	if len(stamped) != 1 || stamped[0] != usable {
+		t.Fatalf("unexpected batch headers %q", stamped)
+	}
+
+	b, err := a.PostageBatch(ctx, usable)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 6 of the 16 slots of every one of the 2^16 buckets are left
+	if b.RemainingChunks() != 6<<16 || b.TTL != time.Hour {
+		t.Fatalf("unexpected batch state %+v", b)
+	}
+
+	for _, tc := range []struct {
+		id      string
+		chunks  int64
+		wantErr error
+	}{
+		{id: usable, chunks: 6 << 16},
+		{id: usable, chunks: 6<<16 + 1, wantErr: cmdfile.ErrBatchFull},
+		{id: full, chunks: 1, wantErr: cmdfile.ErrBatchFull},
+		{id: expired, chunks: 1, wantErr: cmdfile.ErrBatchExpired},
+		{id: syncing, chunks: 1, wantErr: cmdfile.ErrBatchUnusable},
+		{id: unknown, chunks: 1, wantErr: cmdfile.ErrBatchExpired},
+	} {
+		err := a.CheckPostageBatch(ctx, tc.id, tc.chunks)
+		if tc.wantErr == nil && err != nil {
+			t.Fatalf("batch %s: %v", tc.id, err)
+		}
+		if !errors.Is(err, tc.wantErr) {
+			t.Fatalf("batch %s: expected %v, got %v", tc.id, tc.wantErr, err)
+		}
+	}
+}
+
+type countingDialer struct {
+	dials int
+}
+
+func (d *countingDialer) Dial(network, addr string) (net.Conn, error) {
+	d.dials++
+	return net.Dial(network, addr)
+}
+
+// TestAPIStoreProxy verifies that the connections of the store go through the
+// proxy dialer.
+func TestAPIStoreProxy(t *testing.T) {
+	storer := mock.NewStorer()
+	ctx := context.Background()
+	srvUrl := newTestServer(t, storer)
+
+	port, err := strconv.Atoi(srvUrl.Port())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	d := &countingDialer{}
+	a := cmdfile.NewAPIStore(srvUrl.Hostname(), port, false, cmdfile.WithProxy(d))
+
+	ch := testingc.GenerateTestRandomChunk()
+	_, err = a.Put(ctx, storage.ModePutUpload, ch)
+	if err != nil {
+		t.Fatal(err)
+	}
+	chResult, err := a.Get(ctx, storage.ModeGetRequest, ch.Address())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !ch.Equal(chResult) {
+		t.Fatal("chunk mismatch")
+	}
+	if d.dials == 0 {
+		t.Fatal("expected connections through the proxy dialer")
+	}
+
+	for _, u := range []string{"http://127.0.0.1:1080", "socks5://%zz"} {
+		if _, err := cmdfile.ProxyDialer(u); err == nil {
+			t.Fatalf("expected error for proxy url %s", u)
+		}
+	}
+	if _, err := cmdfile.ProxyDialer("socks5://127.0.0.1:1080"); err != nil {
+		t.Fatal(err)
+	}
 }
 
 // TestLimitWriter verifies that writing will fail when capacity is exceeded.
@@ -80,13 +666,24 @@ func TestLimitWriter(t *testing.T) {
 // newTestServer creates an http server to serve the bee http api endpoints.
 func newTestServer(t *testing.T, storer storage.Storer) *url.URL {
 	t.Helper()
-	logger := logging.New(ioutil.Discard, 0)
-	store := statestore.NewStateStore()
-	s := api.New(tags.NewTags(store, logger), storer, nil, nil, nil, nil, logger, nil, api.Options{})
-	ts := httptest.NewServer(s)
+	ts := httptest.NewServer(newTestHandler(storer))
 	srvUrl, err := url.Parse(ts.URL)
 	if err != nil {
 		t.Fatal(err)
 	}
 	return srvUrl
 }
+
+// newTLSTestServer creates an https server to serve the bee http api endpoints.
+func newTLSTestServer(t *testing.T, storer storage.Storer) *httptest.Server {
+	t.Helper()
+	ts := httptest.NewTLSServer(newTestHandler(storer))
+	t.Cleanup(ts.Close)
+	return ts
+}
+
+func newTestHandler(storer storage.Storer) http.Handler {
+	logger := logging.New(ioutil.Discard, 0)
+	store := statestore.NewStateStore()
+	return api.New(tags.NewTags(store, logger), storer, nil, nil, nil, nil, logger, nil, api.Options{})
+}
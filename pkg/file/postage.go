@@ -0,0 +1,143 @@
+// Copyright 2021 The Swarm Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package file
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// swarmPostageBatchHeader is the header of the chunk API with the postage batch
+// the uploaded chunk is stamped with
+const swarmPostageBatchHeader = "Swarm-Postage-Batch-Id"
+
+var (
+	// ErrBatchUnusable is returned when the node cannot stamp chunks with the
+	// postage batch yet, e.g. because the batch was just bought
+	ErrBatchUnusable = errors.New("postage batch not usable")
+	// ErrBatchExpired is returned when the postage batch is not found on the node
+	// or its time to live is over
+	ErrBatchExpired = errors.New("postage batch expired")
+	// ErrBatchFull is returned when the postage batch has not enough capacity left
+	// for the chunks
+	ErrBatchFull = errors.New("postage batch capacity exceeded")
+)
+
+// WithPostageBatch sets the hex encoded id of the postage batch the uploaded
+// chunks are stamped with, which bee nodes with postage stamps require.
+func WithPostageBatch(id string) APIStoreOption {
+	return func(a *APIStore) {
+		a.batch = id
+	}
+}
+
+// PostageBatch is the state of a postage batch as reported by the node.
+type PostageBatch struct {
+	ID          string
+	Usable      bool
+	Utilization uint32
+	Depth       uint8
+	BucketDepth uint8
+	// TTL is the time the batch stays valid, zero when the node does not report it
+	TTL time.Duration
+	// Expired is set when the node reports the batch as run out
+	Expired bool
+}
+
+// stampResponse is the answer of the stamps API. The fields which not all bee
+// versions report are pointers
+type stampResponse struct {
+	BatchID     string `json:"batchID"`
+	Usable      *bool  `json:"usable"`
+	Utilization uint32 `json:"utilization"`
+	Depth       uint8  `json:"depth"`
+	BucketDepth uint8  `json:"bucketDepth"`
+	BatchTTL    *int64 `json:"batchTTL"`
+	Exists      *bool  `json:"exists"`
+}
+
+// RemainingChunks returns the number of chunks which can still be stamped with the
+// batch, assuming the buckets fill up evenly. The utilization is the fill of the
+// fullest bucket, so the estimate is on the safe side.
+func (b *PostageBatch) RemainingChunks() int64 {
+	if b.Depth < b.BucketDepth {
+		return 0
+	}
+	bucketSize := int64(1) << (b.Depth - b.BucketDepth)
+	left := bucketSize - int64(b.Utilization)
+	if left < 0 {
+		return 0
+	}
+	return left << b.BucketDepth
+}
+
+// PostageBatch returns the state of the postage batch with the hex encoded id.
+func (a *APIStore) PostageBatch(ctx context.Context, id string) (*PostageBatch, error) {
+	if err := a.wait(ctx); err != nil {
+		return nil, err
+	}
+	reqCtx, cancel := a.requestContext(ctx)
+	defer cancel()
+	url := strings.Join([]string{a.apiUrl, "stamps", id}, "/")
+	req, err := http.NewRequestWithContext(reqCtx, "GET", url, nil)
+	if err != nil {
+		return nil, err
+	}
+	a.setAuth(req)
+	res, err := a.Client.Do(req)
+	if err != nil {
+		if ctx.Err() == nil && errors.Is(reqCtx.Err(), context.DeadlineExceeded) {
+			return nil, fmt.Errorf("postage batch %s: %w after %s", id, ErrRequestTimeout, a.timeout)
+		}
+		return nil, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode == http.StatusNotFound {
+		return nil, fmt.Errorf("%w: batch %s not found on the node", ErrBatchExpired, id)
+	}
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("postage batch %s: unexpected status %s", id, res.Status)
+	}
+	var sr stampResponse
+	if err := json.NewDecoder(res.Body).Decode(&sr); err != nil {
+		return nil, fmt.Errorf("postage batch %s: invalid response: %w", id, err)
+	}
+	b := &PostageBatch{
+		ID:          sr.BatchID,
+		Usable:      sr.Usable == nil || *sr.Usable,
+		Utilization: sr.Utilization,
+		Depth:       sr.Depth,
+		BucketDepth: sr.BucketDepth,
+		Expired:     sr.Exists != nil && !*sr.Exists,
+	}
+	if sr.BatchTTL != nil {
+		b.TTL = time.Duration(*sr.BatchTTL) * time.Second
+		b.Expired = b.Expired || *sr.BatchTTL <= 0
+	}
+	return b, nil
+}
+
+// CheckPostageBatch checks that the postage batch with the hex encoded id can stamp
+// the number of chunks, so that an upload does not fail halfway.
+func (a *APIStore) CheckPostageBatch(ctx context.Context, id string, chunks int64) error {
+	b, err := a.PostageBatch(ctx, id)
+	if err != nil {
+		return err
+	}
+	switch {
+	case b.Expired:
+		return fmt.Errorf("%w: batch %s", ErrBatchExpired, id)
+	case !b.Usable:
+		return fmt.Errorf("%w: batch %s, wait for the node to sync it", ErrBatchUnusable, id)
+	case b.RemainingChunks() < chunks:
+		return fmt.Errorf("%w: batch %s has room for about %d chunks, %d needed", ErrBatchFull, id, b.RemainingChunks(), chunks)
+	}
+	return nil
+}
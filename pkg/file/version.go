@@ -0,0 +1,114 @@
+// Copyright 2021 The Swarm Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package file
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// ErrVersionUnknown is returned when the node does not report its version, e.g.
+// older bee versions or gateways which do not serve the health endpoint
+var ErrVersionUnknown = errors.New("node version unknown")
+
+// NodeVersion is the bee version reported by the node.
+type NodeVersion struct {
+	// Version is the version as reported, e.g. 1.0.0-6e4c9b1a
+	Version string
+	// APIVersion is the version of the API, empty when the node does not report it
+	APIVersion string
+
+	major, minor, patch int
+}
+
+// healthResponse is the answer of the health endpoint
+type healthResponse struct {
+	Status     string `json:"status"`
+	Version    string `json:"version"`
+	APIVersion string `json:"apiVersion"`
+}
+
+// ParseNodeVersion parses a bee version such as v0.6.2 or 1.0.0-6e4c9b1a.
+func ParseNodeVersion(v string) (*NodeVersion, error) {
+	core := strings.TrimPrefix(v, "v")
+	if i := strings.IndexAny(core, "-+"); i >= 0 {
+		core = core[:i]
+	}
+	parts := strings.Split(core, ".")
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("invalid node version %q", v)
+	}
+	var nums [3]int
+	for i, p := range parts {
+		n, err := strconv.Atoi(p)
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("invalid node version %q", v)
+		}
+		nums[i] = n
+	}
+	return &NodeVersion{Version: v, major: nums[0], minor: nums[1], patch: nums[2]}, nil
+}
+
+// AtLeast reports whether the node runs the version major.minor.patch or a newer one.
+func (v *NodeVersion) AtLeast(major, minor, patch int) bool {
+	if v.major != major {
+		return v.major > major
+	}
+	if v.minor != minor {
+		return v.minor > minor
+	}
+	return v.patch >= patch
+}
+
+// RequiresPostage reports whether the node only accepts chunks stamped with a
+// postage batch, which is the case from bee 0.6.0 on.
+func (v *NodeVersion) RequiresPostage() bool {
+	return v.AtLeast(0, 6, 0)
+}
+
+// ReadsNewFormat reports whether the node serves the manifests the repair creates,
+// which is the case from bee 0.5.4 on.
+func (v *NodeVersion) ReadsNewFormat() bool {
+	return v.AtLeast(0, 5, 4)
+}
+
+// NodeVersion returns the version of bee the node runs, read from its health
+// endpoint. ErrVersionUnknown is returned when the node does not report it.
+func (a *APIStore) NodeVersion(ctx context.Context) (*NodeVersion, error) {
+	if err := a.wait(ctx); err != nil {
+		return nil, err
+	}
+	reqCtx, cancel := a.requestContext(ctx)
+	defer cancel()
+	url := strings.Join([]string{a.apiUrl, "health"}, "/")
+	req, err := http.NewRequestWithContext(reqCtx, "GET", url, nil)
+	if err != nil {
+		return nil, err
+	}
+	a.setAuth(req)
+	res, err := a.Client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%w: health endpoint answered %s", ErrVersionUnknown, res.Status)
+	}
+	var hr healthResponse
+	if err := json.NewDecoder(res.Body).Decode(&hr); err != nil || hr.Version == "" {
+		return nil, fmt.Errorf("%w: no version in the health response", ErrVersionUnknown)
+	}
+	v, err := ParseNodeVersion(hr.Version)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrVersionUnknown, err)
+	}
+	v.APIVersion = hr.APIVersion
+	return v, nil
+}